@@ -0,0 +1,414 @@
+// Command ftcli is a command-line client for the fileTransfer server.
+//
+//	ftcli login [-server URL] [-scope "files:read files:write"]
+//	ftcli upload [-server URL] [-expires 24h] [-max-downloads N] FILE
+//	ftcli download [-server URL] [-o DIR] LINK
+//
+// login signs in through the browser with the device flow and stores the API
+// token it gets, which upload and download then send for that server. Without
+// it they work anonymously.
+//
+// Uploads are end-to-end encrypted: the key only ever appears in the printed
+// link's fragment, which browsers and HTTP clients never send to the server.
+// The one exception is a share email sent with includeKey, which hands the key
+// to the server to put in the emailed link.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fileTransfer/internal/e2e"
+	"fileTransfer/internal/utils"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultServer = "http://localhost:8080"
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "login":
+		err = runLogin(os.Args[2:])
+	case "upload":
+		err = runUpload(os.Args[2:])
+	case "download":
+		err = runDownload(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: ftcli login [-server URL] [-scope \"files:read files:write\"]")
+	fmt.Fprintln(os.Stderr, "       ftcli upload [-server URL] [-expires 24h] [-max-downloads N] FILE")
+	fmt.Fprintln(os.Stderr, "       ftcli download [-server URL] [-o DIR] LINK")
+	os.Exit(2)
+}
+
+func runUpload(args []string) error {
+	fs := flag.NewFlagSet("upload", flag.ExitOnError)
+	server := fs.String("server", defaultServer, "server base URL")
+	expires := fs.String("expires", "24h", "time until the file expires")
+	maxDownloads := fs.Int("max-downloads", 0, "maximum number of downloads, 0 for unlimited")
+	chunkSize := fs.Int("chunk-size", e2e.DefaultChunkSize, "encryption chunk size in bytes")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+
+	path := fs.Arg(0)
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	key, err := e2e.NewKey()
+	if err != nil {
+		return err
+	}
+	metadata, err := e2e.SealMetadata(key, e2e.Metadata{
+		Name:        filepath.Base(path),
+		ContentType: mime.TypeByExtension(filepath.Ext(path)),
+		Size:        info.Size(),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Encrypt straight into the request body so the file is never buffered
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		err := func() error {
+			fields := map[string]string{
+				"metadata":     metadata,
+				"expiresIn":    *expires,
+				"maxDownloads": strconv.Itoa(*maxDownloads),
+			}
+			for name, value := range fields {
+				if err := mw.WriteField(name, value); err != nil {
+					return err
+				}
+			}
+			part, err := mw.CreateFormFile("file", "blob")
+			if err != nil {
+				return err
+			}
+			if err := e2e.Encrypt(part, f, key, *chunkSize); err != nil {
+				return err
+			}
+			return mw.Close()
+		}()
+		pw.CloseWithError(err)
+	}()
+
+	req, err := newRequest(http.MethodPost, *server, "/file/e2e/upload", pr)
+	if err != nil {
+		pr.CloseWithError(err)
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Link      string `json:"link"`
+		ExpiresAt string `json:"expiresAt"`
+		Error     string `json:"error"`
+		Message   string `json:"message"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return fmt.Errorf("unexpected response (%s): %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("upload failed (%s): %s%s", resp.Status, out.Error, out.Message)
+	}
+
+	fmt.Println(e2e.LinkWithKey(out.Link, key))
+	fmt.Fprintf(os.Stderr, "expires at %s\n", out.ExpiresAt)
+	return nil
+}
+
+func runDownload(args []string) error {
+	fs := flag.NewFlagSet("download", flag.ExitOnError)
+	server := fs.String("server", defaultServer, "server base URL")
+	dir := fs.String("o", ".", "directory to write the file to")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+
+	link := fs.Arg(0)
+	key, err := e2e.KeyFromLink(link)
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return err
+	}
+	objectKey := u.Query().Get("key")
+	if objectKey == "" {
+		return errors.New("link has no file key")
+	}
+
+	resp, err := get(*server, "/file/e2e/info?key="+url.QueryEscape(objectKey))
+	if err != nil {
+		return err
+	}
+	var info struct {
+		Metadata string `json:"metadata"`
+		Error    string `json:"error"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("unexpected response (%s): %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("lookup failed (%s): %s", resp.Status, info.Error)
+	}
+
+	meta, err := e2e.OpenMetadata(key, info.Metadata)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(meta.Name)
+	if name == "." || name == string(filepath.Separator) {
+		name = "download"
+	}
+
+	resp, err = get(*server, "/file/download?key="+url.QueryEscape(objectKey))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download failed: %s", resp.Status)
+	}
+
+	// Decrypt into a temporary file so a tampered blob never leaves partial output behind
+	tmp, err := os.CreateTemp(*dir, ".ftcli-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	body := utils.NewHashingReader(resp.Body)
+	if err := e2e.Decrypt(tmp, body, key); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := verifyDigest(resp.Header.Get("Repr-Digest"), body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	target := filepath.Join(*dir, name)
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return err
+	}
+	fmt.Println(target)
+	return nil
+}
+
+// verifyDigest checks the downloaded ciphertext against the checksum recorded at upload
+func verifyDigest(header string, body *utils.HashingReader) error {
+	if header == "" {
+		return nil
+	}
+	want, _, ok := utils.DigestHeader(body.Checksum())
+	if !ok || want != header {
+		return fmt.Errorf("checksum mismatch: server reported %s", header)
+	}
+	return nil
+}
+
+const deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// runLogin runs the RFC 8628 device flow: the user approves the printed code in
+// a browser while we poll for the API token
+func runLogin(args []string) error {
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	server := fs.String("server", defaultServer, "server base URL")
+	scope := fs.String("scope", "files:read files:write", "space separated scopes to ask for")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		usage()
+	}
+
+	var auth struct {
+		DeviceCode              string `json:"device_code"`
+		UserCode                string `json:"user_code"`
+		VerificationURI         string `json:"verification_uri"`
+		VerificationURIComplete string `json:"verification_uri_complete"`
+		ExpiresIn               int    `json:"expires_in"`
+		Interval                int    `json:"interval"`
+		Error                   string `json:"error"`
+		ErrorDescription        string `json:"error_description"`
+	}
+	status, err := postForm(*server+"/auth/device/code", url.Values{"client_id": {"ftcli"}, "scope": {*scope}}, &auth)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("login failed: %s %s", auth.Error, auth.ErrorDescription)
+	}
+
+	fmt.Fprintf(os.Stderr, "Open %s and enter the code %s\n", auth.VerificationURI, auth.UserCode)
+	fmt.Fprintf(os.Stderr, "or go straight to %s\n", auth.VerificationURIComplete)
+
+	interval := time.Duration(auth.Interval) * time.Second
+	deadline := time.Now().Add(time.Duration(auth.ExpiresIn) * time.Second)
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+
+		var token struct {
+			AccessToken string `json:"access_token"`
+			Scope       string `json:"scope"`
+			ExpiresIn   int    `json:"expires_in"`
+			Error       string `json:"error"`
+		}
+		_, err := postForm(*server+"/auth/device/token", url.Values{"grant_type": {deviceCodeGrantType},
+			"device_code": {auth.DeviceCode}}, &token)
+		if err != nil {
+			return err
+		}
+		switch token.Error {
+		case "":
+			if err := saveToken(*server, token.AccessToken); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "logged in with scopes %s, the token expires in %s\n", token.Scope,
+				time.Duration(token.ExpiresIn)*time.Second)
+			return nil
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		case "access_denied":
+			return errors.New("login was denied")
+		case "expired_token":
+			return errors.New("the code expired, run login again")
+		default:
+			return fmt.Errorf("login failed: %s", token.Error)
+		}
+	}
+	return errors.New("the code expired, run login again")
+}
+
+func postForm(u string, form url.Values, out any) (int, error) {
+	resp, err := http.PostForm(u, form)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return 0, fmt.Errorf("unexpected response (%s): %w", resp.Status, err)
+	}
+	return resp.StatusCode, nil
+}
+
+// credentialsPath is where login keeps the API token of each server, readable
+// by the user only
+func credentialsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ftcli", "credentials.json"), nil
+}
+
+func loadTokens() (map[string]string, error) {
+	path, err := credentialsPath()
+	if err != nil {
+		return nil, err
+	}
+	tokens := make(map[string]string)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return tokens, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &tokens); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return tokens, nil
+}
+
+func saveToken(server string, token string) error {
+	tokens, err := loadTokens()
+	if err != nil {
+		return err
+	}
+	tokens[strings.TrimRight(server, "/")] = token
+
+	path, err := credentialsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o600)
+}
+
+// newRequest builds a request to the server, with the stored API token as a
+// bearer token when logged in
+func newRequest(method string, server string, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, server+path, body)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := loadTokens()
+	if err != nil {
+		return nil, err
+	}
+	if token := tokens[strings.TrimRight(server, "/")]; token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+func get(server string, path string) (*http.Response, error) {
+	req, err := newRequest(http.MethodGet, server, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fileTransfer/internal/config"
 	"fileTransfer/internal/handlers"
+	"fileTransfer/internal/models"
 	"fileTransfer/internal/repository"
 	"fileTransfer/internal/utils"
 	"fmt"
@@ -42,6 +44,15 @@ func main() {
 		}
 	}
 
+	// DATETIME columns are scanned into time.Time
+	if !strings.Contains(dsn, "parseTime=") {
+		if strings.Contains(dsn, "?") {
+			dsn += "&parseTime=true"
+		} else {
+			dsn += "?parseTime=true"
+		}
+	}
+
 	db := ConnectMySQL(dsn)
 
 	//Creating tables and inserting sample data
@@ -56,6 +67,61 @@ func main() {
 		log.Fatal("Error Creating File Table: ", err)
 	}
 
+	err = mySqlInit.CreateUploadRequestTableIfNotExist()
+	if err != nil {
+		log.Fatal("Error Creating Upload Request Table: ", err)
+	}
+
+	err = mySqlInit.CreateDownloadEventTableIfNotExist()
+	if err != nil {
+		log.Fatal("Error Creating Download Event Table: ", err)
+	}
+
+	err = mySqlInit.CreateWebhookTablesIfNotExist()
+	if err != nil {
+		log.Fatal("Error Creating Webhook Tables: ", err)
+	}
+
+	err = mySqlInit.CreateEmailOutboxTableIfNotExist()
+	if err != nil {
+		log.Fatal("Error Creating Email Outbox Table: ", err)
+	}
+
+	err = mySqlInit.CreateExpiryReminderTableIfNotExist()
+	if err != nil {
+		log.Fatal("Error Creating Expiry Reminder Table: ", err)
+	}
+
+	err = mySqlInit.CreateEmailAbuseTablesIfNotExist()
+	if err != nil {
+		log.Fatal("Error Creating Email Abuse Tables: ", err)
+	}
+
+	err = mySqlInit.CreateRefreshTokenTableIfNotExist()
+	if err != nil {
+		log.Fatal("Error Creating Refresh Token Table: ", err)
+	}
+
+	err = mySqlInit.CreateApiTokenTableIfNotExist()
+	if err != nil {
+		log.Fatal("Error Creating Api Token Table: ", err)
+	}
+
+	err = mySqlInit.CreateDeviceCodeTableIfNotExist()
+	if err != nil {
+		log.Fatal("Error Creating Device Code Table: ", err)
+	}
+
+	err = mySqlInit.CreateAdminActionTableIfNotExist()
+	if err != nil {
+		log.Fatal("Error Creating Admin Action Table: ", err)
+	}
+
+	err = mySqlInit.MigrateTables()
+	if err != nil {
+		log.Fatal("Error Migrating Tables: ", err)
+	}
+
 	err = mySqlInit.InsertSampleData()
 	if err != nil {
 		log.Fatal("Error Inserting Sample Data: ", err)
@@ -70,29 +136,148 @@ func main() {
 	//Initializing User Repo-MySQL
 	mysqlUserRepo := repository.NewMysqlUserRepo(db)
 	mysqlFileRepo := repository.NewMysqlFileRepo(db)
+	mysqlUploadRequestRepo := repository.NewMysqlUploadRequestRepo(db)
+	mysqlDownloadEventRepo := repository.NewMysqlDownloadEventRepo(db)
+	mysqlWebhookRepo := repository.NewMysqlWebhookRepo(db)
+	mysqlEmailOutboxRepo := repository.NewMysqlEmailOutboxRepo(db)
+	mysqlExpiryReminderRepo := repository.NewMysqlExpiryReminderRepo(db)
+	mysqlEmailAbuseRepo := repository.NewMysqlEmailAbuseRepo(db)
+	mysqlRefreshTokenRepo := repository.NewMysqlRefreshTokenRepo(db)
+	mysqlApiTokenRepo := repository.NewMysqlApiTokenRepo(db)
+	mysqlDeviceCodeRepo := repository.NewMysqlDeviceCodeRepo(db)
+	mysqlAdminRepo := repository.NewMysqlAdminRepo(db)
 
-	//Initializing Google Oauth2
-	handlers.InitGoogleAuth()
+	//Initializing the login providers, OIDC issuers are discovered here
+	authProviders, err := utils.NewAuthProviders(context.Background(), config.AuthProviders)
+	if err != nil {
+		log.Fatal("Error Initializing Auth Providers: ", err)
+	}
 
 	//Initializing JWT Service
-	jwt := utils.NewJWTService()
+	jwt, err := utils.NewJWTService(config.JWT)
+	if err != nil {
+		log.Fatal("Error Initializing JWT Service: ", err)
+	}
+
+	//Initializing the Session Service, loading the sessions revoked recently before serving any request
+	sessions := utils.NewSessionService(mysqlRefreshTokenRepo, mysqlUserRepo, jwt, config.Sessions)
+	sessions.ReloadDenyList()
+
+	//Initializing the Device Flow for logins from the command line
+	deviceFlow := utils.NewDeviceFlow(mysqlDeviceCodeRepo, config.DeviceFlow)
 
 	//Initializing AWS S3 Service
 	awsS3 := utils.NewAwsS3()
 
+	//Initializing the Mailer chosen by MAIL_PROVIDER
+	if config.Mail.TemplateDir != "" {
+		if err := utils.LoadEmailTemplates(config.Mail.TemplateDir); err != nil {
+			log.Fatal("Error Loading Email Templates: ", err)
+		}
+	}
+
+	mailer, err := utils.NewMailer(config.Mail)
+	if err != nil {
+		log.Fatal("Error Initializing Mailer: ", err)
+	}
+
+	//Initializing Webhook Service
+	webhooks := utils.NewWebhookService(mysqlWebhookRepo, config.Webhooks.AllowPrivate)
+
+	//Initializing the Email Outbox
+	emailOutbox := utils.NewEmailOutbox(mysqlEmailOutboxRepo, mailer, webhooks, config.Mail.MaxAttempts)
+	emailGuard, err := utils.NewEmailGuard(mysqlEmailAbuseRepo, config.MailLimits)
+	if err != nil {
+		log.Fatal("Error Initializing Email Guard: ", err)
+	}
+	expiryReminders := utils.NewExpiryReminders(mysqlFileRepo, mysqlUserRepo, mysqlExpiryReminderRepo, mysqlEmailOutboxRepo,
+		mysqlDownloadEventRepo, emailOutbox, emailGuard, jwt, config.Reminders)
+
 	//Initializing Handlers
-	h := handlers.NewHandlers(mysqlUserRepo, mysqlFileRepo, jwt, awsS3)
+	h := handlers.NewHandlers(mysqlUserRepo, mysqlFileRepo, mysqlUploadRequestRepo, mysqlDownloadEventRepo, mysqlWebhookRepo, mysqlEmailOutboxRepo, mysqlEmailAbuseRepo, mysqlApiTokenRepo, mysqlAdminRepo, jwt, awsS3,
+		emailOutbox, webhooks, emailGuard, authProviders, sessions, deviceFlow)
 
 	//Go Routine that deletes the expired AWS files
 	go func() {
 		for {
-			awsS3.DeleteExpiredFiles(mysqlFileRepo)
+			awsS3.DeleteExpiredFiles(mysqlFileRepo, webhooks)
 			time.Sleep(1 * time.Hour) // Run every hour
 		}
 	}()
 
+	//Go Routine that re-verifies the checksums of a sample of stored files
+	go func() {
+		for {
+			time.Sleep(config.Scrubber.Interval)
+			awsS3.VerifyStoredChecksums(mysqlFileRepo, config.Scrubber.SampleSize, config.Scrubber.MaxObjectSize)
+		}
+	}()
+
+	//Go Routine that creates thumbnails and previews for new uploads
+	go func() {
+		for {
+			awsS3.GenerateThumbnails(mysqlFileRepo, config.Thumbnails.BatchSize)
+			time.Sleep(config.Thumbnails.Interval)
+		}
+	}()
+
+	//Go Routine that sends the daily download digests
+	go func() {
+		for {
+			time.Sleep(config.DownloadDigestInterval)
+			utils.SendDownloadDigests(mysqlDownloadEventRepo, emailOutbox, emailGuard)
+		}
+	}()
+
+	//Go Routine that delivers queued webhooks
+	go func() {
+		for {
+			webhooks.DeliverPending(config.Webhooks.BatchSize)
+			time.Sleep(config.Webhooks.Interval)
+		}
+	}()
+
+	//Go Routine that sends queued emails
+	go func() {
+		for {
+			emailOutbox.SendPending(config.Mail.OutboxBatch)
+			time.Sleep(config.Mail.OutboxInterval)
+		}
+	}()
+
+	//Go Routine that reminds owners and recipients before files expire
+	go func() {
+		for {
+			expiryReminders.SendDue()
+			time.Sleep(config.Reminders.Interval)
+		}
+	}()
+
+	//Go Routine that picks up sessions revoked on other instances
+	go func() {
+		for {
+			time.Sleep(config.Sessions.DenyListInterval)
+			sessions.ReloadDenyList()
+		}
+	}()
+
+	//Go Routine that deletes expired refresh tokens and device codes
+	go func() {
+		for {
+			sessions.DeleteExpired()
+			deviceFlow.DeleteExpired()
+			time.Sleep(1 * time.Hour)
+		}
+	}()
+
 	//Creating Gin based Routes
-	r := gin.Default()
+	r := gin.New()
+	r.Use(gin.LoggerWithFormatter(handlers.LogFormatter), gin.Recovery())
+
+	// Only take client IPs from X-Forwarded-For when it is set by our own proxies
+	if err := r.SetTrustedProxies(config.TrustedProxies); err != nil {
+		log.Fatal("Error Setting Trusted Proxies: ", err)
+	}
 
 	// CORS configuration
 	allowedOrigins := []string{"http://localhost:5173", "https://your-frontend-url.vercel.app"}
@@ -103,8 +288,8 @@ func main() {
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     allowedOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Requested-With"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Requested-With", "Range", "X-CSRF-Token"},
+		ExposeHeaders:    []string{"Content-Length", "Digest", "Repr-Digest", "Content-Range", "Content-Disposition"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
@@ -113,18 +298,100 @@ func main() {
 		c.JSON(200, gin.H{"hello": "world"})
 	})
 
-	authRoutes := r.Group("/auth/google")
+	// Public keys for other services to verify our tokens with
+	r.GET("/.well-known/jwks.json", h.GetJWKS)
+
+	authRoutes := r.Group("/auth")
 	{
-		authRoutes.GET("/login", h.GoogleLogin)
-		authRoutes.GET("/callback", h.GoogleCallback)
+		authRoutes.GET("/providers", h.ListAuthProviders)
+		authRoutes.GET("/me", h.RequireAuth(), h.Me)
+		authRoutes.POST("/refresh", h.RefreshSession)
+		authRoutes.POST("/logout", h.Logout)
+		authRoutes.POST("/logoutAll", h.RequireAuth(), h.LogoutEverywhere)
+		authRoutes.POST("/device/code", h.StartDeviceLogin)
+		authRoutes.POST("/device/token", h.PollDeviceLogin)
+		authRoutes.GET("/device", h.RequireAuth(), h.GetDeviceLogin)
+		authRoutes.POST("/device/approve", h.RequireAuth(), h.DecideDeviceLogin)
+		authRoutes.GET("/:provider/login", h.Login)
+		authRoutes.GET("/:provider/callback", h.LoginCallback)
 	}
 
+	// File routes also take API tokens with the scope given. files:read does not gate download and preview: they
+	// take anonymous requests, as anyone with the link may fetch the file, and the scope only limits a token sent there.
 	fileRoutes := r.Group("/file")
 	{
-		fileRoutes.POST("/upload", h.UploadFileAndSaveInfo)
-		fileRoutes.GET("/download", h.DownloadFile)
-		fileRoutes.GET("/listFiles", h.ListFile)
-		fileRoutes.POST("/sendEmail", h.SendFileDownloadLink)
+		fileRoutes.POST("/upload", h.OptionalAuth(models.ScopeFilesWrite), h.UploadFileAndSaveInfo)
+		fileRoutes.GET("/download", h.OptionalAuth(models.ScopeFilesRead), h.DownloadFile)
+		fileRoutes.GET("/preview", h.OptionalAuth(models.ScopeFilesRead), h.PreviewFile)
+		fileRoutes.GET("/listFiles", h.RequireAuth(models.ScopeFilesRead), h.ListFile)
+		fileRoutes.POST("/sendEmail", h.RequireAuth(models.ScopeEmailSend), h.SendFileDownloadLink)
+		fileRoutes.GET("/sendEmail/:id", h.RequireAuth(models.ScopeEmailSend), h.GetEmailStatus)
+		fileRoutes.GET("/thumbnail", h.RequireAuth(models.ScopeFilesRead), h.GetThumbnail)
+		fileRoutes.GET("/events", h.RequireAuth(models.ScopeFilesRead), h.ListDownloadEvents)
+		fileRoutes.DELETE("", h.RequireAuth(models.ScopeFilesWrite), h.DeleteFile)
+	}
+
+	e2eRoutes := r.Group("/file/e2e")
+	{
+		e2eRoutes.POST("/upload", h.OptionalAuth(models.ScopeFilesWrite), h.UploadEncryptedFile)
+		e2eRoutes.GET("/info", h.OptionalAuth(models.ScopeFilesRead), h.GetEncryptedFileInfo)
+	}
+
+	userRoutes := r.Group("/user", h.RequireAuth())
+	{
+		userRoutes.GET("/preferences", h.GetPreferences)
+		userRoutes.PUT("/preferences", h.UpdatePreferences)
+		userRoutes.POST("/apiTokens", h.CreateApiToken)
+		userRoutes.GET("/apiTokens", h.ListApiTokens)
+		userRoutes.DELETE("/apiTokens/:id", h.RevokeApiToken)
+	}
+
+	uploadRequestRoutes := r.Group("/uploadRequests", h.RequireAuth())
+	{
+		uploadRequestRoutes.POST("", h.CreateUploadRequest)
+		uploadRequestRoutes.GET("", h.ListUploadRequests)
+		uploadRequestRoutes.DELETE("/:id", h.RevokeUploadRequest)
+	}
+
+	webhookRoutes := r.Group("/webhooks", h.RequireAuth())
+	{
+		webhookRoutes.POST("", h.CreateWebhook)
+		webhookRoutes.GET("", h.ListWebhooks)
+		webhookRoutes.DELETE("/:id", h.DeleteWebhook)
+		webhookRoutes.GET("/:id/deliveries", h.ListWebhookDeliveries)
+		webhookRoutes.GET("/deliveries/:id", h.GetWebhookDelivery)
+		webhookRoutes.POST("/deliveries/:id/redeliver", h.RedeliverWebhook)
+	}
+
+	emailRoutes := r.Group("/emails", h.RequireAuth())
+	{
+		emailRoutes.GET("/templates", h.ListEmailTemplates)
+		emailRoutes.GET("/templates/:name/preview", h.PreviewEmailTemplate)
+	}
+
+	// Auditors can read everything under /admin, only admins can change anything
+	adminRoutes := r.Group("/admin", h.RequireAuth(), h.RequireRole(models.RoleAdmin, models.RoleAuditor))
+	{
+		adminRoutes.GET("/downloadEvents/export", h.ExportDownloadEvents)
+		adminRoutes.GET("/emailSuppressions", h.ListEmailSuppressions)
+		adminRoutes.DELETE("/emailSuppressions/:address", h.RequireAdmin(), h.DeleteEmailSuppression)
+		adminRoutes.GET("/users", h.ListUsers)
+		adminRoutes.POST("/users/:id/disable", h.RequireAdmin(), h.DisableUser)
+		adminRoutes.POST("/users/:id/enable", h.RequireAdmin(), h.EnableUser)
+		adminRoutes.PUT("/users/:id/role", h.RequireAdmin(), h.SetUserRole)
+		adminRoutes.POST("/files/:id/expire", h.RequireAdmin(), h.ExpireFile)
+		adminRoutes.GET("/stats", h.GetStorageStats)
+		adminRoutes.GET("/actions", h.ListAdminActions)
+	}
+
+	// Signed by SendGrid instead of authenticated
+	r.POST("/inbound/sendgrid/events", h.HandleSendGridEvents)
+
+	publicRoutes := r.Group("/public")
+	{
+		publicRoutes.GET("/uploadRequests/:token", h.GetPublicUploadRequest)
+		publicRoutes.POST("/uploadRequests/:token/files", h.UploadToRequest)
+		publicRoutes.POST("/files/extend", h.ExtendExpiry)
 	}
 
 	//Starting the server
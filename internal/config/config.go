@@ -1,19 +1,186 @@
 package config
 
 import (
+	"crypto/rand"
 	"log"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
-type GoogleOAuthConfig struct {
+// AuthProviderConfig is a login provider. Type is "oidc" for any OpenID Connect issuer, which is set up through
+// discovery, or "github" for GitHub's OAuth apps.
+type AuthProviderConfig struct {
+	Name         string
+	Type         string
+	Issuer       string
 	ClientID     string
 	ClientSecret string
 	RedirectURL  string
+	Scopes       []string
 }
 
-var GoogleConfig GoogleOAuthConfig
+// AuthProviders are listed in AUTH_PROVIDERS, each configured by AUTH_<NAME>_* variables
+var AuthProviders []AuthProviderConfig
+
+// AuthConfig secures the login flow and the session cookie. CookieSecret signs the short-lived cookies the flow
+// keeps its state in, and RedirectAllowlist lists the origins, besides the frontend, users may be sent to after
+// logging in. CookieSameSite must be None when the frontend is served from another site than the API.
+type AuthConfig struct {
+	CookieSecret      []byte
+	SecureCookies     bool
+	CookieSameSite    http.SameSite
+	CookieDomain      string
+	RedirectAllowlist []string
+}
+
+var Auth AuthConfig
+
+// SessionConfig sets the token lifetimes. Access tokens can't be looked up, so a revoked login stays usable until
+// the deny list is next reloaded, at most DenyListInterval on instances other than the one that revoked it.
+type SessionConfig struct {
+	AccessTTL        time.Duration
+	RefreshTTL       time.Duration
+	DenyListInterval time.Duration
+}
+
+var Sessions SessionConfig
+
+// JWTConfig holds the token signing keys, each a PEM or the path of a PEM file. SigningKey is the Ed25519 or RSA
+// private key new tokens are signed with, VerificationKeys are retired keys whose tokens are still accepted while
+// they expire, so keys can be rotated without logging everyone out. EphemeralKey is for local development, it
+// allows starting without a signing key.
+type JWTConfig struct {
+	SigningKey       string
+	VerificationKeys []string
+	Issuer           string
+	Audience         string
+	EphemeralKey     bool
+}
+
+var JWT JWTConfig
+
+// DeviceFlowConfig configures logins from devices without a browser. TokenTTL is the lifetime of the API token the
+// device gets, IPHourly limits how many codes one client IP may request an hour, and ApproveAttempts how many wrong
+// user codes a user may enter while a code is valid.
+type DeviceFlowConfig struct {
+	CodeTTL         time.Duration
+	PollInterval    time.Duration
+	TokenTTL        time.Duration
+	IPHourly        int
+	ApproveAttempts int
+}
+
+var DeviceFlow DeviceFlowConfig
+
+// ScrubberConfig skips objects over MaxObjectSize, so one run never streams more than SampleSize * MaxObjectSize
+type ScrubberConfig struct {
+	Interval      time.Duration
+	SampleSize    int
+	MaxObjectSize int64
+}
+
+var Scrubber ScrubberConfig
+
+// StorageConfig bounds upload memory to roughly UploadPartSize * UploadConcurrency
+type StorageConfig struct {
+	UploadPartSize    int64
+	UploadConcurrency int
+}
+
+var Storage StorageConfig
+
+type ThumbnailConfig struct {
+	Interval  time.Duration
+	BatchSize int
+}
+
+var Thumbnails ThumbnailConfig
+
+type UploadRequestConfig struct {
+	MaxExpiry     time.Duration
+	FileRetention time.Duration
+	// IPHourly is how many files one client IP may upload to upload requests per hour, 0 for no limit
+	IPHourly int
+}
+
+var UploadRequests UploadRequestConfig
+
+var DownloadDigestInterval time.Duration
+
+// WebhookConfig controls the outbound webhook worker. AllowHTTP and AllowPrivate are for local development,
+// in production endpoints must be https and resolve to public addresses.
+type WebhookConfig struct {
+	Interval     time.Duration
+	BatchSize    int
+	AllowHTTP    bool
+	AllowPrivate bool
+}
+
+var Webhooks WebhookConfig
+
+// TrustedProxies are the CIDRs allowed to set X-Forwarded-For, client IPs from anyone else are taken from the connection
+var TrustedProxies []string
+
+// AdminEmails are permanent admins whatever their stored role, everyone else's access comes from their role
+var AdminEmails []string
+
+// MailConfig selects how emails are sent. Provider is "sendgrid", "smtp" or "file", the last one writes
+// .eml files to SinkDir instead of sending anything.
+type MailConfig struct {
+	Provider       string
+	From           string
+	FromName       string
+	SendGridAPIKey string
+	SMTPHost       string
+	SMTPPort       int
+	SMTPUsername   string
+	SMTPPassword   string
+	SMTPTLS        string // "starttls", "tls" or "none"
+	SMTPAuth       string // "plain", "login", "cram-md5" or "none"
+	SinkDir        string
+	TemplateDir    string // optional directory of templates overriding the built-in ones
+	MaxRecipients  int
+	MaxAttempts    int
+	OutboxInterval time.Duration
+	OutboxBatch    int
+}
+
+var Mail MailConfig
+
+// MailLimitConfig caps how many recipients a user or client IP can email per hour and per day, and how many
+// emails one address can receive from us. 0 turns a limit off. SendGridWebhookKey is the base64 public key
+// SendGrid signs its event webhook with.
+type MailLimitConfig struct {
+	UserHourly         int
+	UserDaily          int
+	IPHourly           int
+	IPDaily            int
+	RecipientHourly    int
+	RecipientDaily     int
+	SendGridWebhookKey string
+}
+
+var MailLimits MailLimitConfig
+
+// ReminderConfig controls the emails sent ahead of a file expiring. A file can be extended by ExtendBy at a time
+// from the reminder, but never past MaxLifetime after it was uploaded.
+type ReminderConfig struct {
+	LeadTimes        []time.Duration
+	NotifyRecipients bool
+	Interval         time.Duration
+	ExtendBy         time.Duration
+	MaxLifetime      time.Duration
+}
+
+var Reminders ReminderConfig
+
+// FrontendURL is the base of the links we email and redirect to
+var FrontendURL string
 
 func LoadEnv() {
 	err := godotenv.Load("../.env")
@@ -22,28 +189,235 @@ func LoadEnv() {
 		// Continue execution even if .env file is not found
 	}
 
-	// Log the environment variables (without sensitive data)
-	log.Printf("Loading Google OAuth configuration...")
-	log.Printf("GOOGLE_REDIRECT_URL: %s", os.Getenv("GOOGLE_REDIRECT_URL"))
-	log.Printf("GOOGLE_CLIENT_ID exists: %v", os.Getenv("GOOGLE_CLIENT_ID") != "")
-	log.Printf("GOOGLE_CLIENT_SECRET exists: %v", os.Getenv("GOOGLE_CLIENT_SECRET") != "")
+	AuthProviders = loadAuthProviders()
 
-	GoogleConfig = GoogleOAuthConfig{
-		ClientID:     os.Getenv("GOOGLE_CLIENT_ID"),
-		ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
-		RedirectURL:  os.Getenv("GOOGLE_REDIRECT_URL"),
+	Auth = AuthConfig{
+		CookieSecret:      []byte(os.Getenv("AUTH_COOKIE_SECRET")),
+		SecureCookies:     os.Getenv("AUTH_COOKIE_SECURE") != "false",
+		CookieDomain:      os.Getenv("AUTH_COOKIE_DOMAIN"),
+		RedirectAllowlist: getEnvList("AUTH_REDIRECT_ALLOWLIST"),
+	}
+	switch strings.ToLower(getEnvString("AUTH_COOKIE_SAMESITE", "lax")) {
+	case "lax":
+		Auth.CookieSameSite = http.SameSiteLaxMode
+	case "strict":
+		Auth.CookieSameSite = http.SameSiteStrictMode
+	case "none":
+		Auth.CookieSameSite = http.SameSiteNoneMode
+		if !Auth.SecureCookies {
+			log.Fatal("AUTH_COOKIE_SAMESITE=none requires secure cookies")
+		}
+	default:
+		log.Fatal("AUTH_COOKIE_SAMESITE must be lax, strict or none")
+	}
+	if len(Auth.CookieSecret) == 0 {
+		// Logins in progress fail across restarts and between instances with a per-process secret
+		log.Printf("Warning: AUTH_COOKIE_SECRET is not set, using a random one")
+		Auth.CookieSecret = make([]byte, 32)
+		if _, err := rand.Read(Auth.CookieSecret); err != nil {
+			log.Fatal("Failed to generate cookie secret: ", err)
+		}
 	}
 
-	// Validate the configuration
-	if GoogleConfig.ClientID == "" {
-		log.Fatal("GOOGLE_CLIENT_ID is not set")
+	Sessions = SessionConfig{
+		AccessTTL:        getEnvDuration("AUTH_ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTTL:       getEnvDuration("AUTH_REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		DenyListInterval: getEnvDuration("AUTH_DENY_LIST_INTERVAL", 30*time.Second),
 	}
-	if GoogleConfig.ClientSecret == "" {
-		log.Fatal("GOOGLE_CLIENT_SECRET is not set")
+
+	JWT = JWTConfig{
+		SigningKey:       os.Getenv("JWT_SIGNING_KEY"),
+		VerificationKeys: getEnvList("JWT_VERIFICATION_KEYS"),
+		Issuer:           getEnvString("JWT_ISSUER", "fileTransfer"),
+		Audience:         getEnvString("JWT_AUDIENCE", "fileTransfer"),
+		EphemeralKey:     os.Getenv("JWT_ALLOW_EPHEMERAL_KEY") == "true",
 	}
-	if GoogleConfig.RedirectURL == "" {
-		log.Fatal("GOOGLE_REDIRECT_URL is not set")
+
+	DeviceFlow = DeviceFlowConfig{
+		CodeTTL:         getEnvDuration("DEVICE_CODE_TTL", 10*time.Minute),
+		PollInterval:    getEnvDuration("DEVICE_POLL_INTERVAL", 5*time.Second),
+		TokenTTL:        getEnvDuration("DEVICE_TOKEN_TTL", 90*24*time.Hour),
+		IPHourly:        getEnvInt("DEVICE_CODE_IP_HOURLY_LIMIT", 20),
+		ApproveAttempts: getEnvInt("DEVICE_APPROVE_ATTEMPTS", 10),
 	}
 
-	log.Printf("Google OAuth configuration loaded successfully")
+	Scrubber = ScrubberConfig{
+		Interval:      getEnvDuration("SCRUB_INTERVAL", 6*time.Hour),
+		SampleSize:    getEnvInt("SCRUB_SAMPLE_SIZE", 20),
+		MaxObjectSize: int64(getEnvInt("SCRUB_MAX_OBJECT_MB", 512)) * 1024 * 1024,
+	}
+
+	Storage = StorageConfig{
+		UploadPartSize:    int64(getEnvInt("S3_UPLOAD_PART_SIZE_MB", 8)) * 1024 * 1024,
+		UploadConcurrency: getEnvInt("S3_UPLOAD_CONCURRENCY", 4),
+	}
+
+	Thumbnails = ThumbnailConfig{
+		Interval:  getEnvDuration("THUMBNAIL_INTERVAL", time.Minute),
+		BatchSize: getEnvInt("THUMBNAIL_BATCH_SIZE", 20),
+	}
+
+	UploadRequests = UploadRequestConfig{
+		MaxExpiry:     getEnvDuration("UPLOAD_REQUEST_MAX_EXPIRY", 30*24*time.Hour),
+		FileRetention: getEnvDuration("UPLOAD_REQUEST_FILE_RETENTION", 7*24*time.Hour),
+		IPHourly:      getEnvInt("UPLOAD_REQUEST_IP_HOURLY_LIMIT", 30),
+	}
+
+	DownloadDigestInterval = getEnvDuration("DOWNLOAD_DIGEST_INTERVAL", 24*time.Hour)
+
+	Mail = MailConfig{
+		Provider:       getEnvString("MAIL_PROVIDER", "sendgrid"),
+		From:           getEnvString("MAIL_FROM", os.Getenv("SENDGRID_FROM")),
+		FromName:       getEnvString("MAIL_FROM_NAME", "File Transfer"),
+		SendGridAPIKey: os.Getenv("SENDGRID_API_KEY"),
+		SMTPHost:       os.Getenv("SMTP_HOST"),
+		SMTPPort:       getEnvInt("SMTP_PORT", 587),
+		SMTPUsername:   os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:   os.Getenv("SMTP_PASSWORD"),
+		SMTPTLS:        getEnvString("SMTP_TLS", "starttls"),
+		SMTPAuth:       getEnvString("SMTP_AUTH", "plain"),
+		SinkDir:        getEnvString("MAIL_SINK_DIR", "mail-sink"),
+		TemplateDir:    os.Getenv("MAIL_TEMPLATE_DIR"),
+		MaxRecipients:  getEnvInt("MAIL_MAX_RECIPIENTS", 20),
+		MaxAttempts:    getEnvInt("MAIL_MAX_ATTEMPTS", 8),
+		OutboxInterval: getEnvDuration("MAIL_OUTBOX_INTERVAL", 15*time.Second),
+		OutboxBatch:    getEnvInt("MAIL_OUTBOX_BATCH_SIZE", 50),
+	}
+
+	MailLimits = MailLimitConfig{
+		UserHourly:         getEnvInt("MAIL_USER_HOURLY_LIMIT", 50),
+		UserDaily:          getEnvInt("MAIL_USER_DAILY_LIMIT", 200),
+		IPHourly:           getEnvInt("MAIL_IP_HOURLY_LIMIT", 20),
+		IPDaily:            getEnvInt("MAIL_IP_DAILY_LIMIT", 100),
+		RecipientHourly:    getEnvInt("MAIL_RECIPIENT_HOURLY_LIMIT", 3),
+		RecipientDaily:     getEnvInt("MAIL_RECIPIENT_DAILY_LIMIT", 10),
+		SendGridWebhookKey: os.Getenv("SENDGRID_WEBHOOK_PUBLIC_KEY"),
+	}
+
+	Reminders = ReminderConfig{
+		LeadTimes:        getEnvDurations("EXPIRY_REMINDER_LEAD_TIMES", []time.Duration{24 * time.Hour, time.Hour}),
+		NotifyRecipients: os.Getenv("EXPIRY_REMINDER_RECIPIENTS") == "true",
+		Interval:         getEnvDuration("EXPIRY_REMINDER_INTERVAL", 5*time.Minute),
+		ExtendBy:         getEnvDuration("EXPIRY_EXTEND_BY", 24*time.Hour),
+		MaxLifetime:      getEnvDuration("EXPIRY_MAX_LIFETIME", 7*24*time.Hour),
+	}
+
+	FrontendURL = getEnvString("FRONTEND_URL", "http://localhost:5173")
+
+	Webhooks = WebhookConfig{
+		Interval:     getEnvDuration("WEBHOOK_INTERVAL", 10*time.Second),
+		BatchSize:    getEnvInt("WEBHOOK_BATCH_SIZE", 50),
+		AllowHTTP:    os.Getenv("WEBHOOK_ALLOW_HTTP") == "true",
+		AllowPrivate: os.Getenv("WEBHOOK_ALLOW_PRIVATE") == "true",
+	}
+
+	TrustedProxies = getEnvList("TRUSTED_PROXIES")
+	AdminEmails = getEnvList("ADMIN_EMAILS")
+}
+
+func getEnvDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("Warning: invalid %s %q, using %s", name, v, def)
+		return def
+	}
+	return d
+}
+
+// loadAuthProviders reads the login providers, Google is the default and still reads the GOOGLE_* variables
+func loadAuthProviders() []AuthProviderConfig {
+	names := getEnvList("AUTH_PROVIDERS")
+	if len(names) == 0 {
+		names = []string{"google"}
+	}
+
+	var providers []AuthProviderConfig
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if strings.ContainsFunc(name, func(r rune) bool { return (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' }) {
+			log.Fatalf("Invalid auth provider name %q", name)
+		}
+		prefix := "AUTH_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+
+		p := AuthProviderConfig{
+			Name:         name,
+			Type:         getEnvString(prefix+"TYPE", "oidc"),
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:       getEnvList(prefix + "SCOPES"),
+		}
+		switch name {
+		case "google":
+			p.Issuer = getEnvString(prefix+"ISSUER", "https://accounts.google.com")
+			p.ClientID = getEnvString(prefix+"CLIENT_ID", os.Getenv("GOOGLE_CLIENT_ID"))
+			p.ClientSecret = getEnvString(prefix+"CLIENT_SECRET", os.Getenv("GOOGLE_CLIENT_SECRET"))
+			p.RedirectURL = getEnvString(prefix+"REDIRECT_URL", os.Getenv("GOOGLE_REDIRECT_URL"))
+		case "github":
+			p.Type = getEnvString(prefix+"TYPE", "github")
+		}
+
+		log.Printf("Auth provider %s (%s), redirect URL: %s, client id set: %v, client secret set: %v",
+			p.Name, p.Type, p.RedirectURL, p.ClientID != "", p.ClientSecret != "")
+		if p.ClientID == "" || p.ClientSecret == "" || p.RedirectURL == "" {
+			log.Fatalf("Auth provider %s needs %sCLIENT_ID, %sCLIENT_SECRET and %sREDIRECT_URL", name, prefix, prefix, prefix)
+		}
+		if p.Type == "oidc" && p.Issuer == "" {
+			log.Fatalf("Auth provider %s needs %sISSUER", name, prefix)
+		}
+		providers = append(providers, p)
+	}
+	return providers
+}
+
+// getEnvDurations reads a comma separated list of durations, such as "24h,1h"
+func getEnvDurations(name string, def []time.Duration) []time.Duration {
+	var list []time.Duration
+	for _, v := range getEnvList(name) {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Printf("Warning: invalid %s %q, using %v", name, os.Getenv(name), def)
+			return def
+		}
+		list = append(list, d)
+	}
+	if len(list) == 0 {
+		return def
+	}
+	return list
+}
+
+func getEnvString(name string, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+func getEnvList(name string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func getEnvInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Printf("Warning: invalid %s %q, using %d", name, v, def)
+		return def
+	}
+	return n
 }
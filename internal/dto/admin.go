@@ -0,0 +1,11 @@
+package dto
+
+// AdminActionBody is the reason an admin gives for an action, it is kept in the admin log
+type AdminActionBody struct {
+	Reason string `json:"reason"`
+}
+
+type AdminRoleBody struct {
+	Role   string `json:"role"`
+	Reason string `json:"reason"`
+}
@@ -0,0 +1,7 @@
+package dto
+
+type ApiTokenBody struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	ExpiresIn string   `json:"expiresIn"`
+}
@@ -0,0 +1,6 @@
+package dto
+
+type DeviceApprovalBody struct {
+	UserCode string `json:"userCode"`
+	Approve  bool   `json:"approve"`
+}
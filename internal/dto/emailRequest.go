@@ -1,9 +1,47 @@
 package dto
 
+import (
+	"encoding/json"
+	"errors"
+)
+
+// EmailRequestBody shares a file the caller owns, identified by FileId or Key. The server builds the link itself,
+// DownloadLink is only read to refuse clients that still send one.
+//
+// IncludeKey with LinkKey puts the key of an end-to-end encrypted file in the emailed link. The key then reaches
+// the server, so the file is no longer zero-knowledge, but the email is sent at once and the key is not stored.
 type EmailRequestBody struct {
-	To           string `json:"to"`
-	Bcc          string `json:"bcc"`
-	Cc           string `json:"cc"`
-	DownloadLink string `json:"link"`
-	LinkValidity string `json:"linkValidity"`
+	To           AddressList `json:"to"`
+	Bcc          AddressList `json:"bcc"`
+	Cc           AddressList `json:"cc"`
+	FileId       string      `json:"fileId"`
+	Key          string      `json:"key"`
+	DownloadLink string      `json:"link"`
+	IncludeKey   bool        `json:"includeKey"`
+	LinkKey      string      `json:"linkKey"`
+	Timezone     string      `json:"timezone"`
+	Locale       string      `json:"locale"`
+	Subject      string      `json:"subject"`
+	Message      string      `json:"message"`
+}
+
+// AddressList accepts a list of addresses or, as older clients send, a single string of comma separated addresses
+type AddressList []string
+
+func (a *AddressList) UnmarshalJSON(b []byte) error {
+	var list []string
+	if err := json.Unmarshal(b, &list); err == nil {
+		*a = list
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.New("addresses must be a string or a list of strings")
+	}
+	*a = nil
+	if s != "" {
+		*a = AddressList{s}
+	}
+	return nil
 }
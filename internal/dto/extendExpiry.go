@@ -0,0 +1,5 @@
+package dto
+
+type ExtendExpiryBody struct {
+	Token string `json:"token"`
+}
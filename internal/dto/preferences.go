@@ -0,0 +1,5 @@
+package dto
+
+type PreferencesBody struct {
+	DownloadNotify string `json:"downloadNotify"`
+}
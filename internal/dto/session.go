@@ -0,0 +1,6 @@
+package dto
+
+// RefreshTokenBody is how API clients send their refresh token, browsers send it in a cookie instead
+type RefreshTokenBody struct {
+	RefreshToken string `json:"refreshToken"`
+}
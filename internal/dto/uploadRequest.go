@@ -0,0 +1,9 @@
+package dto
+
+type UploadRequestBody struct {
+	Label        string   `json:"label"`
+	MaxFileSize  int64    `json:"maxFileSize"`
+	MaxFiles     int      `json:"maxFiles"`
+	AllowedTypes []string `json:"allowedTypes"`
+	ExpiresIn    string   `json:"expiresIn"`
+}
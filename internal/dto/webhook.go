@@ -0,0 +1,7 @@
+package dto
+
+type WebhookBody struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Global bool     `json:"global"`
+}
@@ -0,0 +1,289 @@
+// Package e2e implements the client side of the zero-knowledge transfer mode.
+//
+// Content is encrypted with AES-256-GCM in fixed-size chunks. The blob starts
+// with a 16 byte header:
+//
+//	magic "FTE2" | version (1 byte) | chunk size (uint32 BE) | nonce prefix (7 bytes)
+//
+// followed by the sealed chunks. Each chunk nonce is the prefix, a uint32 BE
+// counter and a final-chunk flag byte, and the header is passed as additional
+// data, so reordering, truncation and header tampering are all detected.
+//
+// Metadata (file name, content type, size) is sealed separately with its own
+// derived key and travels to the server as an opaque base64url string. The
+// master key never reaches the server: it is carried in the share link's URL
+// fragment as "#k=<base64url key>".
+package e2e
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+const (
+	KeySize          = 32
+	DefaultChunkSize = 64 * 1024
+	MaxChunkSize     = 4 * 1024 * 1024
+
+	headerSize  = 16
+	prefixSize  = 7
+	version     = 1
+	fragmentKey = "k"
+)
+
+var magic = []byte("FTE2")
+
+var (
+	ErrInvalidHeader = errors.New("e2e: invalid header")
+	ErrTruncated     = errors.New("e2e: ciphertext truncated")
+	ErrDecrypt       = errors.New("e2e: message authentication failed")
+)
+
+// Metadata is the information about a file that is hidden from the server.
+type Metadata struct {
+	Name        string `json:"name"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+}
+
+// NewKey returns a fresh random master key.
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// EncodeKey encodes a key for use in a URL fragment.
+func EncodeKey(key []byte) string {
+	return base64.RawURLEncoding.EncodeToString(key)
+}
+
+// DecodeKey is the inverse of EncodeKey.
+func DecodeKey(s string) ([]byte, error) {
+	key, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("e2e: invalid key encoding: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("e2e: key must be %d bytes", KeySize)
+	}
+	return key, nil
+}
+
+// LinkWithKey appends the key to a share link as a URL fragment.
+func LinkWithKey(link string, key []byte) string {
+	return link + "#" + fragmentKey + "=" + EncodeKey(key)
+}
+
+// KeyFromLink extracts the key from the fragment of a share link.
+func KeyFromLink(link string) ([]byte, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	values, err := url.ParseQuery(u.Fragment)
+	if err != nil || values.Get(fragmentKey) == "" {
+		return nil, errors.New("e2e: link has no key fragment")
+	}
+	return DecodeKey(values.Get(fragmentKey))
+}
+
+// deriveKey is a single-block HKDF-Expand with the master key as PRK.
+func deriveKey(key []byte, info string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(info))
+	mac.Write([]byte{1})
+	return mac.Sum(nil)
+}
+
+func newGCM(key []byte, info string) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("e2e: key must be %d bytes", KeySize)
+	}
+	block, err := aes.NewCipher(deriveKey(key, info))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// SealMetadata encrypts metadata into an opaque string for the server.
+func SealMetadata(key []byte, meta Metadata) (string, error) {
+	aead, err := newGCM(key, "fileTransfer e2e metadata")
+	if err != nil {
+		return "", err
+	}
+	plain, err := json.Marshal(meta)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, plain, nil)), nil
+}
+
+// OpenMetadata decrypts a string produced by SealMetadata.
+func OpenMetadata(key []byte, sealed string) (*Metadata, error) {
+	aead, err := newGCM(key, "fileTransfer e2e metadata")
+	if err != nil {
+		return nil, err
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil || len(raw) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	plain, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	var meta Metadata
+	if err := json.Unmarshal(plain, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// EncryptedSize returns the blob size for a plaintext of the given size.
+func EncryptedSize(plainSize int64, chunkSize int) int64 {
+	chunks := plainSize / int64(chunkSize)
+	if plainSize%int64(chunkSize) != 0 || plainSize == 0 {
+		chunks++
+	}
+	return headerSize + plainSize + chunks*16
+}
+
+func chunkNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[prefixSize:], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// readChunk fills buf and reports whether it was the last chunk in r.
+func readChunk(r *bufio.Reader, buf []byte) (int, bool, error) {
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return n, true, nil
+	}
+	if err != nil {
+		return n, false, err
+	}
+	if _, err := r.Peek(1); err == io.EOF {
+		return n, true, nil
+	} else if err != nil {
+		return n, false, err
+	}
+	return n, false, nil
+}
+
+// Encrypt reads plaintext from src and writes the encrypted blob to dst.
+func Encrypt(dst io.Writer, src io.Reader, key []byte, chunkSize int) error {
+	if chunkSize <= 0 || chunkSize > MaxChunkSize {
+		return fmt.Errorf("e2e: chunk size must be between 1 and %d", MaxChunkSize)
+	}
+	aead, err := newGCM(key, "fileTransfer e2e content")
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	header[4] = version
+	binary.BigEndian.PutUint32(header[5:9], uint32(chunkSize))
+	if _, err := rand.Read(header[9:]); err != nil {
+		return err
+	}
+	if _, err := dst.Write(header); err != nil {
+		return err
+	}
+
+	r := bufio.NewReaderSize(src, chunkSize)
+	buf := make([]byte, chunkSize)
+	out := make([]byte, 0, chunkSize+aead.Overhead())
+	for counter := uint32(0); ; counter++ {
+		n, last, err := readChunk(r, buf)
+		if err != nil {
+			return err
+		}
+		out = aead.Seal(out[:0], chunkNonce(header[9:], counter, last), buf[:n], header)
+		if _, err := dst.Write(out); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		if counter == ^uint32(0) {
+			return errors.New("e2e: too many chunks")
+		}
+	}
+}
+
+// Decrypt reads an encrypted blob from src and writes the plaintext to dst.
+func Decrypt(dst io.Writer, src io.Reader, key []byte) error {
+	aead, err := newGCM(key, "fileTransfer e2e content")
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return ErrInvalidHeader
+	}
+	if !strings.HasPrefix(string(header), string(magic)) || header[4] != version {
+		return ErrInvalidHeader
+	}
+	chunkSize := int(binary.BigEndian.Uint32(header[5:9]))
+	if chunkSize <= 0 || chunkSize > MaxChunkSize {
+		return ErrInvalidHeader
+	}
+
+	r := bufio.NewReaderSize(src, chunkSize+aead.Overhead())
+	buf := make([]byte, chunkSize+aead.Overhead())
+	out := make([]byte, 0, chunkSize)
+	for counter := uint32(0); ; counter++ {
+		n, last, err := readChunk(r, buf)
+		if err != nil {
+			return err
+		}
+		if n < aead.Overhead() {
+			return ErrTruncated
+		}
+		out, err = aead.Open(out[:0], chunkNonce(header[9:], counter, last), buf[:n], header)
+		if err != nil {
+			if !last {
+				return ErrDecrypt
+			}
+			// A valid non-final chunk at the end of the stream means the
+			// final chunk was cut off.
+			if _, err := aead.Open(nil, chunkNonce(header[9:], counter, false), buf[:n], header); err == nil {
+				return ErrTruncated
+			}
+			return ErrDecrypt
+		}
+		if _, err := dst.Write(out); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
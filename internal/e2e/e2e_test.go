@@ -0,0 +1,165 @@
+package e2e
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func mustKey(t *testing.T) []byte {
+	t.Helper()
+	key, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func encrypt(t *testing.T, plain []byte, key []byte, chunkSize int) []byte {
+	t.Helper()
+	var blob bytes.Buffer
+	if err := Encrypt(&blob, bytes.NewReader(plain), key, chunkSize); err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	return blob.Bytes()
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	key := mustKey(t)
+	tests := []struct {
+		name      string
+		size      int
+		chunkSize int
+	}{
+		{"empty", 0, 16},
+		{"one byte", 1, 16},
+		{"less than a chunk", 15, 16},
+		{"exactly one chunk", 16, 16},
+		{"one byte over a chunk", 17, 16},
+		{"several full chunks", 64, 16},
+		{"default chunk size", 200_000, DefaultChunkSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain := make([]byte, tt.size)
+			rand.Read(plain)
+
+			blob := encrypt(t, plain, key, tt.chunkSize)
+			if got, want := int64(len(blob)), EncryptedSize(int64(tt.size), tt.chunkSize); got != want {
+				t.Errorf("blob is %d bytes, EncryptedSize says %d", got, want)
+			}
+
+			var out bytes.Buffer
+			if err := Decrypt(&out, bytes.NewReader(blob), key); err != nil {
+				t.Fatalf("Decrypt: %v", err)
+			}
+			if !bytes.Equal(out.Bytes(), plain) {
+				t.Error("decrypted content differs from the plaintext")
+			}
+		})
+	}
+}
+
+func TestEncryptRejectsBadChunkSize(t *testing.T) {
+	for _, chunkSize := range []int{0, -1, MaxChunkSize + 1} {
+		if err := Encrypt(&bytes.Buffer{}, bytes.NewReader(nil), mustKey(t), chunkSize); err == nil {
+			t.Errorf("chunk size %d was accepted", chunkSize)
+		}
+	}
+}
+
+func TestDecryptDetectsTampering(t *testing.T) {
+	key := mustKey(t)
+	const chunkSize = 16
+	plain := make([]byte, 3*chunkSize+5)
+	rand.Read(plain)
+	blob := encrypt(t, plain, key, chunkSize)
+	sealedChunk := chunkSize + 16
+
+	flip := func(i int) []byte {
+		b := bytes.Clone(blob)
+		b[i] ^= 1
+		return b
+	}
+	swapped := bytes.Clone(blob)
+	first := swapped[headerSize : headerSize+sealedChunk]
+	second := swapped[headerSize+sealedChunk : headerSize+2*sealedChunk]
+	tmp := bytes.Clone(first)
+	copy(first, second)
+	copy(second, tmp)
+
+	tests := []struct {
+		name string
+		blob []byte
+		key  []byte
+		want error
+	}{
+		{"wrong key", blob, mustKey(t), ErrDecrypt},
+		{"flipped magic", flip(0), key, ErrInvalidHeader},
+		{"flipped version", flip(4), key, ErrInvalidHeader},
+		{"flipped nonce prefix", flip(10), key, ErrDecrypt},
+		{"flipped ciphertext", flip(headerSize + 3), key, ErrDecrypt},
+		{"flipped tag of the last chunk", flip(len(blob) - 1), key, ErrDecrypt},
+		{"swapped chunks", swapped, key, ErrDecrypt},
+		{"final chunk cut off", blob[:headerSize+3*sealedChunk], key, ErrTruncated},
+		{"cut inside a chunk", blob[:headerSize+sealedChunk+20], key, ErrDecrypt},
+		{"shorter than a tag", blob[:headerSize+5], key, ErrTruncated},
+		{"no header", blob[:headerSize-1], key, ErrInvalidHeader},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Decrypt(&bytes.Buffer{}, bytes.NewReader(tt.blob), tt.key)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetadataRoundTrip(t *testing.T) {
+	key := mustKey(t)
+	meta := Metadata{Name: "report.pdf", ContentType: "application/pdf", Size: 1234}
+	sealed, err := SealMetadata(key, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := OpenMetadata(key, sealed)
+	if err != nil {
+		t.Fatalf("OpenMetadata: %v", err)
+	}
+	if *got != meta {
+		t.Errorf("got %+v, want %+v", *got, meta)
+	}
+
+	if _, err := OpenMetadata(mustKey(t), sealed); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("wrong key: got %v, want %v", err, ErrDecrypt)
+	}
+	if _, err := OpenMetadata(key, sealed[:len(sealed)-2]); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("truncated: got %v, want %v", err, ErrDecrypt)
+	}
+}
+
+func TestKeyFromLink(t *testing.T) {
+	key := mustKey(t)
+	link := LinkWithKey("https://files.example.com/download?key=abc", key)
+
+	got, err := KeyFromLink(link)
+	if err != nil {
+		t.Fatalf("KeyFromLink: %v", err)
+	}
+	if !bytes.Equal(got, key) {
+		t.Error("key from link differs")
+	}
+
+	for _, link := range []string{
+		"https://files.example.com/download?key=abc",
+		"https://files.example.com/download?key=abc#k=",
+		"https://files.example.com/download?key=abc#k=tooshort",
+	} {
+		if _, err := KeyFromLink(link); err == nil {
+			t.Errorf("%s: no error", link)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fileTransfer/internal/dto"
+	"fileTransfer/internal/models"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const maxAdminReasonLength = 1000
+
+// ListUsers pages through the users with the files they store, optionally those whose email or name contains q
+func (h *Handlers) ListUsers(c *gin.Context) {
+	page, pageSize, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	users, total, err := h.AdminDbRepo.ListUsersWithUsage(strings.TrimSpace(c.Query("q")), time.Now().UTC(), pageSize,
+		(page-1)*pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range users {
+		users[i].Role = userRole(&users[i].GoogleUser)
+	}
+	c.JSON(http.StatusOK, gin.H{"users": users, "page": page, "pageSize": pageSize, "total": total})
+}
+
+// DisableUser stops a user from logging in and makes their files and upload requests unavailable. Their sessions
+// are revoked right away, and their API tokens stop working while the account is disabled.
+func (h *Handlers) DisableUser(c *gin.Context) {
+	h.setUserDisabled(c, true)
+}
+
+// EnableUser lets a disabled user log in again and brings back their files that haven't expired
+func (h *Handlers) EnableUser(c *gin.Context) {
+	h.setUserDisabled(c, false)
+}
+
+func (h *Handlers) setUserDisabled(c *gin.Context, disabled bool) {
+	reason, ok := bindAdminReason(c)
+	if !ok {
+		return
+	}
+	user, ok := h.adminTargetUser(c)
+	if !ok {
+		return
+	}
+
+	action, message := models.AdminEnableUser, "User enabled"
+	if disabled {
+		action, message = models.AdminDisableUser, "User disabled"
+	}
+	entry, err := newAdminAction(c, action, "user", user.ID, reason, nil)
+	if err == nil {
+		err = h.AdminDbRepo.SetUserDisabled(user.ID, disabled, entry)
+	}
+	if !adminActionApplied(c, entry, err, "User not found") {
+		return
+	}
+	if disabled {
+		if err := h.Sessions.RevokeUser(user.ID); err != nil {
+			log.Printf("Failed to revoke sessions of disabled user %s: %v", user.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+// SetUserRole makes a user a plain user, an auditor or an admin
+func (h *Handlers) SetUserRole(c *gin.Context) {
+	var body dto.AdminRoleBody
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if !slices.Contains(models.Roles, body.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be one of " + strings.Join(models.Roles, ", ")})
+		return
+	}
+	reason, ok := validAdminReason(c, body.Reason)
+	if !ok {
+		return
+	}
+	user, ok := h.adminTargetUser(c)
+	if !ok {
+		return
+	}
+
+	// The stored role of an account listed in ADMIN_EMAILS has no effect, so changing it would be misleading
+	if isListedAdmin(user) {
+		c.JSON(http.StatusConflict, gin.H{"error": "This account is an admin through ADMIN_EMAILS, remove it there first"})
+		return
+	}
+
+	entry, err := newAdminAction(c, models.AdminSetRole, "user", user.ID, reason,
+		gin.H{"from": userRole(user), "to": body.Role})
+	if err == nil {
+		err = h.AdminDbRepo.SetUserRole(user.ID, body.Role, entry)
+	}
+	if !adminActionApplied(c, entry, err, "User not found") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role updated", "role": body.Role})
+}
+
+// adminTargetUser finds the user in the path. Admins can't act on their own account, so they can't lock
+// themselves out.
+func (h *Handlers) adminTargetUser(c *gin.Context) (*models.GoogleUser, bool) {
+	user, err := h.UserDbRepo.FindUserByID(c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if user.ID == currentUser(c).ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You can't change your own account"})
+		return nil, false
+	}
+	return user, true
+}
+
+// ExpireFile makes any file expire now. Its link stops working at once and the cleanup deletes it.
+func (h *Handlers) ExpireFile(c *gin.Context) {
+	reason, ok := bindAdminReason(c)
+	if !ok {
+		return
+	}
+	file, err := h.FileDbRepo.GetFileByID(c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if isExpired(file) {
+		c.JSON(http.StatusConflict, gin.H{"error": "File has already expired"})
+		return
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	entry, err := newAdminAction(c, models.AdminExpireFile, "file", file.ID, reason,
+		gin.H{"owner": file.UserId, "name": file.Name, "expiresAt": file.ExpirationDate})
+	if err == nil {
+		err = h.AdminDbRepo.ExpireFile(file.ID, now, entry)
+	}
+	if !adminActionApplied(c, entry, err, "File not found") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "File expired", "expiresAt": now})
+}
+
+// GetStorageStats reports the system-wide user, file and storage totals
+func (h *Handlers) GetStorageStats(c *gin.Context) {
+	stats, err := h.AdminDbRepo.GetStorageStats(time.Now().UTC())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// ListAdminActions pages through the admin log, newest first
+func (h *Handlers) ListAdminActions(c *gin.Context) {
+	page, pageSize, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	actions, total, err := h.AdminDbRepo.ListAdminActions(pageSize, (page-1)*pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"actions": actions, "page": page, "pageSize": pageSize, "total": total})
+}
+
+// newAdminAction is the admin log entry of a change by the caller, the repository saves it along with the change
+func newAdminAction(c *gin.Context, action string, targetType string, targetId string, reason string, detail any) (*models.AdminAction, error) {
+	var raw json.RawMessage
+	if detail != nil {
+		var err error
+		if raw, err = json.Marshal(detail); err != nil {
+			return nil, err
+		}
+	}
+	actor := currentUser(c)
+	return models.NewAdminAction(uuid.New().String(), actor.ID, actor.Email, action, targetType, targetId, reason, raw,
+		time.Now().UTC()), nil
+}
+
+// adminActionApplied writes the error response of a failed admin change, which was then neither made nor logged
+func adminActionApplied(c *gin.Context, entry *models.AdminAction, err error, notFound string) bool {
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": notFound})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	log.Printf("Admin %s did %s on %s %s: %s", entry.ActorId, entry.Action, entry.TargetType, entry.TargetId, entry.Reason)
+	return true
+}
+
+// bindAdminReason reads the reason every admin change must be given
+func bindAdminReason(c *gin.Context) (string, bool) {
+	var body dto.AdminActionBody
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return "", false
+	}
+	return validAdminReason(c, body.Reason)
+}
+
+func validAdminReason(c *gin.Context, reason string) (string, bool) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required"})
+		return "", false
+	}
+	if len(reason) > maxAdminReasonLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reason is too long"})
+		return "", false
+	}
+	return reason, true
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fileTransfer/internal/config"
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/repository"
+	"fileTransfer/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminUsers finds copies of its users
+type adminUsers struct {
+	repository.UserDbRepo
+	users map[string]*models.GoogleUser
+}
+
+func (a *adminUsers) FindUserByID(id string) (*models.GoogleUser, error) {
+	if u, ok := a.users[id]; ok {
+		copied := *u
+		return &copied, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+// adminLog changes the users and records the admin action along with every change
+type adminLog struct {
+	repository.AdminDbRepo
+	users   *adminUsers
+	actions []*models.AdminAction
+}
+
+func (a *adminLog) SetUserDisabled(userId string, disabled bool, action *models.AdminAction) error {
+	user, ok := a.users.users[userId]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	user.Disabled = disabled
+	a.actions = append(a.actions, action)
+	return nil
+}
+
+func (a *adminLog) SetUserRole(userId string, role string, action *models.AdminAction) error {
+	user, ok := a.users.users[userId]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	user.Role = role
+	a.actions = append(a.actions, action)
+	return nil
+}
+
+// userSessions has one session for every user, named after them
+type userSessions struct {
+	repository.RefreshTokenDbRepo
+}
+
+func (userSessions) RevokeUserFamilies(userId string, at time.Time) ([]string, error) {
+	return []string{"session of " + userId}, nil
+}
+
+func TestRequireRole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config.AdminEmails = []string{"Root@example.com"}
+	t.Cleanup(func() { config.AdminEmails = nil })
+	h := &Handlers{}
+
+	tests := []struct {
+		name       string
+		user       *models.GoogleUser
+		method     string
+		wantStatus int
+	}{
+		{"anonymous", nil, http.MethodGet, http.StatusForbidden},
+		{"user reads", &models.GoogleUser{Role: models.RoleUser}, http.MethodGet, http.StatusForbidden},
+		{"user without a stored role", &models.GoogleUser{}, http.MethodGet, http.StatusForbidden},
+		{"auditor reads", &models.GoogleUser{Role: models.RoleAuditor}, http.MethodGet, http.StatusOK},
+		{"auditor changes", &models.GoogleUser{Role: models.RoleAuditor}, http.MethodPost, http.StatusForbidden},
+		{"admin reads", &models.GoogleUser{Role: models.RoleAdmin}, http.MethodGet, http.StatusOK},
+		{"admin changes", &models.GoogleUser{Role: models.RoleAdmin}, http.MethodPost, http.StatusOK},
+		{"ADMIN_EMAILS wins over the stored role", &models.GoogleUser{Email: "root@example.com", Role: models.RoleUser},
+			http.MethodPost, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The same split as the /admin routes: auditors read, only admins change anything
+			router := gin.New()
+			admin := router.Group("/admin", func(c *gin.Context) {
+				if tt.user != nil {
+					c.Set(userContextKey, tt.user)
+				}
+			}, h.RequireRole(models.RoleAdmin, models.RoleAuditor))
+			ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+			admin.GET("/users", ok)
+			admin.POST("/users/:id/disable", h.RequireAdmin(), ok)
+
+			path := "/admin/users"
+			if tt.method == http.MethodPost {
+				path += "/someone/disable"
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(tt.method, path, nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestAdminUserActions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config.AdminEmails = []string{"listed@example.com"}
+	t.Cleanup(func() { config.AdminEmails = nil })
+	admin := &models.GoogleUser{ID: "admin", Email: "admin@example.com", Role: models.RoleAdmin}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		// wantRole and wantDisabled are the target user afterwards
+		wantRole     string
+		wantDisabled bool
+		wantAction   string
+	}{
+		{name: "disable", method: http.MethodPost, path: "/admin/users/target/disable", body: `{"reason":"spam"}`,
+			wantStatus: http.StatusOK, wantRole: models.RoleUser, wantDisabled: true, wantAction: models.AdminDisableUser},
+		{name: "disable without a reason", method: http.MethodPost, path: "/admin/users/target/disable",
+			body: `{"reason":"  "}`, wantStatus: http.StatusBadRequest, wantRole: models.RoleUser},
+		{name: "disable yourself", method: http.MethodPost, path: "/admin/users/admin/disable", body: `{"reason":"oops"}`,
+			wantStatus: http.StatusBadRequest, wantRole: models.RoleUser},
+		{name: "disable an unknown user", method: http.MethodPost, path: "/admin/users/missing/disable",
+			body: `{"reason":"spam"}`, wantStatus: http.StatusNotFound, wantRole: models.RoleUser},
+		{name: "set role", method: http.MethodPut, path: "/admin/users/target/role",
+			body: `{"role":"auditor","reason":"compliance"}`, wantStatus: http.StatusOK, wantRole: models.RoleAuditor,
+			wantAction: models.AdminSetRole},
+		{name: "set an unknown role", method: http.MethodPut, path: "/admin/users/target/role",
+			body: `{"role":"root","reason":"compliance"}`, wantStatus: http.StatusBadRequest, wantRole: models.RoleUser},
+		{name: "set your own role", method: http.MethodPut, path: "/admin/users/admin/role",
+			body: `{"role":"user","reason":"stepping down"}`, wantStatus: http.StatusBadRequest, wantRole: models.RoleUser},
+		// The stored role of an ADMIN_EMAILS account has no effect, so it is not changed either
+		{name: "set the role of an ADMIN_EMAILS account", method: http.MethodPut, path: "/admin/users/listed/role",
+			body: `{"role":"auditor","reason":"compliance"}`, wantStatus: http.StatusConflict, wantRole: models.RoleUser},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &adminUsers{users: map[string]*models.GoogleUser{
+				"admin":  admin,
+				"target": {ID: "target", Email: "target@example.com", Role: models.RoleUser},
+				"listed": {ID: "listed", Email: "Listed@example.com", Role: models.RoleUser},
+			}}
+			log := &adminLog{users: users}
+			sessions := utils.NewSessionService(userSessions{}, users, nil, config.SessionConfig{})
+			h := &Handlers{UserDbRepo: users, AdminDbRepo: log, Sessions: sessions}
+			router := gin.New()
+			routes := router.Group("/admin", func(c *gin.Context) { c.Set(userContextKey, admin) })
+			routes.POST("/users/:id/disable", h.DisableUser)
+			routes.PUT("/users/:id/role", h.SetUserRole)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			target := users.users["target"]
+			if target.Role != tt.wantRole || target.Disabled != tt.wantDisabled {
+				t.Errorf("target is %s, disabled %v", target.Role, target.Disabled)
+			}
+			if admin.Role != models.RoleAdmin || admin.Disabled {
+				t.Errorf("admin changed their own account")
+			}
+			if listed := users.users["listed"]; listed.Role != models.RoleUser {
+				t.Errorf("ADMIN_EMAILS account got role %s", listed.Role)
+			}
+			// A disabled user's sessions end at once
+			if sessions.IsRevoked("session of target") != tt.wantDisabled {
+				t.Errorf("sessions revoked: %v, want %v", sessions.IsRevoked("session of target"), tt.wantDisabled)
+			}
+
+			if tt.wantAction == "" {
+				if len(log.actions) != 0 {
+					t.Errorf("logged %+v for a refused change", log.actions[0])
+				}
+				return
+			}
+			if len(log.actions) != 1 {
+				t.Fatalf("logged %d actions", len(log.actions))
+			}
+			entry := log.actions[0]
+			var body struct{ Reason string }
+			if err := json.Unmarshal([]byte(tt.body), &body); err != nil {
+				t.Fatal(err)
+			}
+			if entry.Action != tt.wantAction || entry.ActorId != "admin" || entry.ActorEmail != admin.Email ||
+				entry.TargetType != "user" || entry.TargetId != "target" || entry.Reason != body.Reason {
+				t.Errorf("logged %+v", entry)
+			}
+		})
+	}
+}
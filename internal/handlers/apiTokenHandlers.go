@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fileTransfer/internal/dto"
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/utils"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateApiToken creates a token for scripts and pipelines, limited to the scopes asked for
+func (h *Handlers) CreateApiToken(c *gin.Context) {
+	var body dto.ApiTokenBody
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" || len(body.Name) > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required and must be at most 255 characters"})
+		return
+	}
+	if len(body.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one scope is required"})
+		return
+	}
+	for _, scope := range body.Scopes {
+		if !slices.Contains(models.ApiTokenScopes, scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown scope %q, must be one of %s", scope,
+				strings.Join(models.ApiTokenScopes, ", "))})
+			return
+		}
+	}
+	scopes := slices.Clone(body.Scopes)
+	slices.Sort(scopes)
+	scopes = slices.Compact(scopes)
+
+	now := time.Now().UTC()
+	var expiresAt *time.Time
+	if body.ExpiresIn != "" {
+		d, err := time.ParseDuration(body.ExpiresIn)
+		if err != nil || d <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expiresIn, must be a positive duration"})
+			return
+		}
+		expiry := now.Add(d)
+		expiresAt = &expiry
+	}
+
+	token, apiToken, err := utils.NewApiToken(currentUser(c).ID, body.Name, scopes, expiresAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.ApiTokenDbRepo.AddApiToken(apiToken); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The token is only ever shown here, the db keeps its hash
+	c.JSON(http.StatusOK, gin.H{"apiToken": apiToken, "token": token})
+}
+
+func (h *Handlers) ListApiTokens(c *gin.Context) {
+	tokens, err := h.ApiTokenDbRepo.ListApiTokensByUser(currentUser(c).ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"apiTokens": tokens})
+}
+
+func (h *Handlers) RevokeApiToken(c *gin.Context) {
+	err := h.ApiTokenDbRepo.RevokeApiToken(c.Param("id"), currentUser(c).ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API token not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API token revoked"})
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fileTransfer/internal/models"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultEventPageSize = 50
+	maxEventPageSize     = 500
+)
+
+// ListDownloadEvents pages through the download events of a file owned by the caller
+func (h *Handlers) ListDownloadEvents(c *gin.Context) {
+	key := c.Query("key")
+	if key == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing file key"})
+		return
+	}
+
+	page, pageSize, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	file, err := h.FileDbRepo.GetFileByKey(key)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && file.UserId != currentUser(c).ID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	events, total, err := h.DownloadEventDbRepo.ListDownloadEventsByFile(file.ID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"events": events, "page": page, "pageSize": pageSize, "total": total})
+}
+
+// pageParams reads the page and pageSize query parameters
+func pageParams(c *gin.Context) (int, int, bool) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page"})
+		return 0, 0, false
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", strconv.Itoa(defaultEventPageSize)))
+	if err != nil || pageSize < 1 || pageSize > maxEventPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pageSize"})
+		return 0, 0, false
+	}
+	return page, pageSize, true
+}
+
+// ExportDownloadEvents streams all download events in a date range as CSV or JSON lines
+func (h *Handlers) ExportDownloadEvents(c *gin.Context) {
+	from, err := parseDateParam(c.Query("from"), time.Time{})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from, use YYYY-MM-DD or RFC 3339"})
+		return
+	}
+	to, err := parseDateParam(c.Query("to"), time.Now().UTC())
+	if err != nil || !to.After(from) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to, use YYYY-MM-DD or RFC 3339 after from"})
+		return
+	}
+	// A bare end date includes that whole day
+	if len(c.Query("to")) == len(time.DateOnly) {
+		to = to.AddDate(0, 0, 1)
+	}
+
+	filename := "download-events-" + from.Format(time.DateOnly) + "-" + to.Format(time.DateOnly)
+	switch c.DefaultQuery("format", "csv") {
+	case "csv":
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", dispositionHeader("attachment", filename+".csv"))
+		w := csv.NewWriter(c.Writer)
+		w.Write([]string{"id", "file_id", "file_key", "link", "downloaded_at", "client_ip", "user_agent",
+			"downloaded_by", "bytes_sent", "completed"})
+		err = h.DownloadEventDbRepo.ExportDownloadEvents(from, to, func(e *models.DownloadEvent) error {
+			return w.Write([]string{e.ID, e.FileId, csvCell(e.FileKey), csvCell(e.Link), e.DownloadedAt.Format(time.RFC3339),
+				csvCell(e.ClientIP), csvCell(e.UserAgent), csvCell(e.DownloadedBy), strconv.FormatInt(e.BytesSent, 10),
+				strconv.FormatBool(e.Completed)})
+		})
+		w.Flush()
+	case "json":
+		// One JSON object per line so exports of any size stream with constant memory
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Content-Disposition", dispositionHeader("attachment", filename+".jsonl"))
+		err = h.DownloadEventDbRepo.ExportDownloadEvents(from, to, func(e *models.DownloadEvent) error {
+			return writeJSONLine(c, e)
+		})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or json"})
+		return
+	}
+
+	if err != nil {
+		// Headers are already sent, so the truncated export is all the client gets
+		c.Error(err)
+	}
+}
+
+func parseDateParam(value string, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// csvCell keeps a value sent by a client from running as a formula when the export is opened in a spreadsheet
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
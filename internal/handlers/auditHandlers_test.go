@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fileTransfer/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestListDownloadEvents(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	owner := &models.GoogleUser{ID: "owner", Email: "owner@example.com"}
+	files := &memFiles{files: []*models.File{
+		{ID: "mine", S3Key: "uploads/mine", UserId: "owner"},
+		{ID: "theirs", S3Key: "uploads/theirs", UserId: "someone else"},
+	}}
+	events := &memDownloadEvents{}
+	for i := 0; i < 5; i++ {
+		events.events = append(events.events, &models.DownloadEvent{ID: string(rune('a' + i)), FileId: "mine"})
+	}
+	events.events = append(events.events, &models.DownloadEvent{ID: "other", FileId: "theirs"})
+	h := &Handlers{FileDbRepo: files, DownloadEventDbRepo: events}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantIDs    []string
+		wantTotal  int
+	}{
+		{"first page", "key=uploads/mine&pageSize=2", http.StatusOK, []string{"a", "b"}, 5},
+		{"last page", "key=uploads/mine&pageSize=2&page=3", http.StatusOK, []string{"e"}, 5},
+		{"past the end", "key=uploads/mine&page=9", http.StatusOK, nil, 5},
+		{"someone else's file", "key=uploads/theirs", http.StatusNotFound, nil, 0},
+		{"unknown file", "key=uploads/missing", http.StatusNotFound, nil, 0},
+		{"no key", "", http.StatusBadRequest, nil, 0},
+		{"page zero", "key=uploads/mine&page=0", http.StatusBadRequest, nil, 0},
+		{"page size too large", "key=uploads/mine&pageSize=501", http.StatusBadRequest, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/file/events", func(c *gin.Context) { c.Set(userContextKey, owner) }, h.ListDownloadEvents)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/file/events?"+tt.query, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Events []models.DownloadEvent `json:"events"`
+				Total  int                    `json:"total"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, e := range resp.Events {
+				ids = append(ids, e.ID)
+			}
+			if strings.Join(ids, ",") != strings.Join(tt.wantIDs, ",") || resp.Total != tt.wantTotal {
+				t.Errorf("got %v of %d, want %v of %d", ids, resp.Total, tt.wantIDs, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestExportDownloadEvents(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	day := func(d, hour int) time.Time { return time.Date(2026, 3, d, hour, 0, 0, 0, time.UTC) }
+	events := &memDownloadEvents{events: []*models.DownloadEvent{
+		{ID: "before", FileId: "f", DownloadedAt: day(1, 23)},
+		{ID: "start", FileId: "f", FileKey: "uploads/a", Link: "/file/download?key=uploads/a", DownloadedAt: day(2, 0),
+			ClientIP: "203.0.113.7", UserAgent: "curl/8.0, beta", DownloadedBy: "a@example.com", BytesSent: 10, Completed: true},
+		{ID: "last day", FileId: "f", UserAgent: "=cmd|' /C calc'!A0", DownloadedAt: day(3, 23)},
+		{ID: "after", FileId: "f", DownloadedAt: day(4, 0)},
+	}}
+	h := &Handlers{DownloadEventDbRepo: events}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantType   string
+		wantIDs    []string
+	}{
+		{"csv includes the whole end day", "from=2026-03-02&to=2026-03-03", http.StatusOK, "text/csv; charset=utf-8",
+			[]string{"start", "last day"}},
+		{"json lines", "from=2026-03-02&to=2026-03-03&format=json", http.StatusOK, "application/x-ndjson",
+			[]string{"start", "last day"}},
+		{"RFC 3339 end is exact", "from=2026-03-02&to=2026-03-03T00:00:00Z&format=json", http.StatusOK,
+			"application/x-ndjson", []string{"start"}},
+		{"unknown format", "from=2026-03-02&format=xml", http.StatusBadRequest, "", nil},
+		{"invalid from", "from=March", http.StatusBadRequest, "", nil},
+		{"end before start", "from=2026-03-03&to=2026-03-02T00:00:00Z", http.StatusBadRequest, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/admin/downloadEvents", h.ExportDownloadEvents)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/downloadEvents?"+tt.query, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("got Content-Type %q, want %q", got, tt.wantType)
+			}
+			if !strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment") {
+				t.Errorf("got Content-Disposition %q", w.Header().Get("Content-Disposition"))
+			}
+
+			var ids []string
+			if tt.wantType == "application/x-ndjson" {
+				lines := bufio.NewScanner(w.Body)
+				for lines.Scan() {
+					var e models.DownloadEvent
+					if err := json.Unmarshal(lines.Bytes(), &e); err != nil {
+						t.Fatalf("line %q: %v", lines.Text(), err)
+					}
+					ids = append(ids, e.ID)
+					// JSON is not opened as a spreadsheet, so it keeps the values as they are
+					if e.ID == "last day" && e.UserAgent != "=cmd|' /C calc'!A0" {
+						t.Errorf("got user agent %q", e.UserAgent)
+					}
+				}
+			} else {
+				records, err := csv.NewReader(w.Body).ReadAll()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if records[0][0] != "id" || len(records[0]) != 10 {
+					t.Errorf("got header %v", records[0])
+				}
+				if want := []string{"start", "f", "uploads/a", "/file/download?key=uploads/a", "2026-03-02T00:00:00Z",
+					"203.0.113.7", "curl/8.0, beta", "a@example.com", "10", "true"}; strings.Join(records[1], "|") != strings.Join(want, "|") {
+					t.Errorf("got row %v, want %v", records[1], want)
+				}
+				for _, r := range records[1:] {
+					ids = append(ids, r[0])
+				}
+				if last := records[len(records)-1]; last[0] == "last day" && last[6] != "'=cmd|' /C calc'!A0" {
+					t.Errorf("got user agent %q", last[6])
+				}
+			}
+			if strings.Join(ids, ",") != strings.Join(tt.wantIDs, ",") {
+				t.Errorf("exported %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestCsvCell(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"=HYPERLINK(\"https://evil.example.com\")", "'=HYPERLINK(\"https://evil.example.com\")"},
+		{"+1+1", "'+1+1"},
+		{"-2+3", "'-2+3"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\t=1", "'\t=1"},
+		{"\r=1", "'\r=1"},
+		{"curl/8.0", "curl/8.0"},
+		{"a=b", "a=b"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := csvCell(tt.value); got != tt.want {
+			t.Errorf("csvCell(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
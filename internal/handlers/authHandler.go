@@ -2,110 +2,201 @@ package handlers
 
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
 	"fileTransfer/internal/config"
 	"fileTransfer/internal/models"
+	"fileTransfer/internal/repository"
+	"fileTransfer/internal/utils"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 	"log"
 )
 
-var googleOauthConfig *oauth2.Config
-
-func InitGoogleAuth() {
-	log.Printf("Initializing Google OAuth configuration...")
-	log.Printf("Redirect URL: %s", config.GoogleConfig.RedirectURL)
-	
-	googleOauthConfig = &oauth2.Config{
-		RedirectURL:  config.GoogleConfig.RedirectURL,
-		ClientID:     config.GoogleConfig.ClientID,
-		ClientSecret: config.GoogleConfig.ClientSecret,
-		Scopes: []string{
-			"https://www.googleapis.com/auth/userinfo.profile",
-			"https://www.googleapis.com/auth/userinfo.email",
-		},
-		Endpoint: google.Endpoint,
-	}
-	
-	log.Printf("Google OAuth configuration initialized successfully")
+const (
+	oauthFlowCookie = "oauth_flow"
+	oauthFlowTTL    = 10 * time.Minute
+)
+
+// oauthFlow is kept in a signed cookie while the user is at the provider, tying the callback to the browser that
+// started the login
+type oauthFlow struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+	Redirect string `json:"redirect,omitempty"`
 }
 
-func (h *Handlers) GoogleLogin(c *gin.Context) {
-	log.Printf("Starting Google login process...")
-	url := googleOauthConfig.AuthCodeURL("random") // In production, generate a secure state value
-	log.Printf("Redirecting to Google auth URL: %s", url)
-	c.Redirect(http.StatusTemporaryRedirect, url)
+// ListAuthProviders lists the providers users can log in with
+func (h *Handlers) ListAuthProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"providers": h.AuthProviders.Names()})
 }
 
-func (h *Handlers) GoogleCallback(c *gin.Context) {
-	log.Printf("Received Google callback request")
-	
-	state := c.Query("state")
-	log.Printf("State parameter: %s", state)
-	
-	if state != "random" {
-		log.Printf("Invalid state parameter: %s", state)
+// Login starts the login with a random state, PKCE and a nonce, remembered in a short-lived signed cookie.
+// An optional redirect query parameter is where the frontend sends the user once logged in.
+func (h *Handlers) Login(c *gin.Context) {
+	provider, ok := h.AuthProviders[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown login provider"})
+		return
+	}
+	redirect, ok := allowedRedirect(c.Query("redirect"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Redirect target is not allowed"})
+		return
+	}
+
+	state, _, err := utils.NewToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+	nonce, _, err := utils.NewToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+	flow := oauthFlow{Provider: provider.Name(), State: state, Verifier: oauth2.GenerateVerifier(), Nonce: nonce,
+		Redirect: redirect}
+
+	sealed, err := utils.SealValue(config.Auth.CookieSecret, flow, oauthFlowTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+	// Lax, since the provider sends the user back with a top-level GET
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oauthFlowCookie, sealed, int(oauthFlowTTL.Seconds()), "/auth", "", config.Auth.SecureCookies, true)
+
+	log.Printf("Starting %s login process...", provider.Name())
+	c.Redirect(http.StatusTemporaryRedirect, provider.AuthCodeURL(state, flow.Verifier, nonce))
+}
+
+// LoginCallback finishes the login. The code, tokens and state are never logged.
+func (h *Handlers) LoginCallback(c *gin.Context) {
+	log.Printf("Received %s callback request", c.Param("provider"))
+
+	var flow oauthFlow
+	sealed, err := c.Cookie(oauthFlowCookie)
+	if err == nil {
+		err = utils.OpenValue(config.Auth.CookieSecret, sealed, &flow)
+	}
+	// The flow is single use, whatever the outcome
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oauthFlowCookie, "", -1, "/auth", "", config.Auth.SecureCookies, true)
+	if err != nil || flow.Provider != c.Param("provider") {
+		log.Printf("Login callback without a valid login cookie")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Login session expired, please try again"})
+		return
+	}
+	provider, ok := h.AuthProviders[flow.Provider]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown login provider"})
+		return
+	}
+
+	if c.Query("error") != "" {
+		log.Printf("%s login was not completed: %s", flow.Provider, c.Query("error"))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Login was not completed"})
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(c.Query("state")), []byte(flow.State)) != 1 {
+		log.Printf("Invalid state parameter")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state"})
 		return
 	}
 
 	code := c.Query("code")
-	log.Printf("Received auth code: %s", code)
-	
 	if code == "" {
 		log.Printf("No code provided in callback")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No code provided"})
 		return
 	}
 
-	token, err := googleOauthConfig.Exchange(context.Background(), code)
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+	identity, err := provider.Exchange(ctx, code, flow.Verifier, flow.Nonce)
 	if err != nil {
-		log.Printf("Token exchange failed: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token exchange failed"})
+		log.Printf("%s login failed: %v", flow.Provider, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Login failed"})
 		return
 	}
-	log.Printf("Successfully exchanged code for token")
-
-	client := googleOauthConfig.Client(context.Background(), token)
-	resp, err := client.Get("https://www.googleapis.com/oauth2/v2/userinfo")
-	if err != nil {
-		log.Printf("Failed to get user info: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user info"})
+	if identity.Email == "" || !identity.EmailVerified {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your account needs a verified email address"})
 		return
 	}
-	defer resp.Body.Close()
-	log.Printf("Successfully retrieved user info")
+	log.Printf("Successfully logged in %s user: %s", flow.Provider, identity.Email)
 
-	user, err := models.ParseGoogleUser(resp.Body)
-	if err != nil {
-		log.Printf("Failed to parse user: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse user"})
+	user := &models.GoogleUser{Email: identity.Email, IsEmailVerified: true, Name: identity.Name, Avatar: identity.Avatar,
+		AuthProvider: identity.Provider, AuthSubject: identity.Subject}
+	dbUser, err := h.UserDbRepo.FindOrCreateUser(user)
+	if errors.Is(err, repository.ErrIdentityConflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": "An account with this email signs in with another provider"})
 		return
 	}
-	log.Printf("Successfully parsed user info for: %s", user.Email)
-
-	dbUser, err := h.UserDbRepo.FindOrCreateUser(user)
 	if err != nil {
 		log.Printf("Database error: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
 	log.Printf("Successfully found/created user in database")
+	if dbUser.Disabled {
+		log.Printf("Refusing login of disabled user %s", dbUser.ID)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your account is disabled"})
+		return
+	}
 
-	jwtToken, err := h.JWT.GenerateToken(dbUser.Email)
+	tokens, err := h.Sessions.Start(dbUser)
 	if err != nil {
-		log.Printf("Failed to generate JWT token: %v", err)
+		log.Printf("Failed to start session: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
 		return
 	}
-	log.Printf("Successfully generated JWT token")
+	csrfToken, _, err := utils.NewToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+	setSessionCookies(c, tokens)
+	setCSRFCookie(c, csrfToken)
+	log.Printf("Successfully started session")
+
+	// The token only travels in the cookie, never in the URL, so it stays out of history, logs and Referer headers
+	target := frontendURL() + "/auth/callback"
+	if flow.Redirect != "" {
+		target += "?" + url.Values{"redirect": {flow.Redirect}}.Encode()
+	}
+	c.Redirect(http.StatusTemporaryRedirect, target)
+}
+
+// allowedRedirect accepts a path on the frontend, or a URL on the frontend or an origin in AUTH_REDIRECT_ALLOWLIST
+func allowedRedirect(target string) (string, bool) {
+	if target == "" {
+		return "", true
+	}
+	if strings.ContainsFunc(target, func(r rune) bool { return r < 0x20 || r == '\\' }) {
+		return "", false
+	}
+	if strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "//") {
+		return frontendURL() + target, true
+	}
 
-	// Redirect to frontend with token
-	frontendURL := os.Getenv("FRONTEND_URL")
-	if frontendURL == "" {
-		frontendURL = "http://localhost:5173"
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.User != nil {
+		return "", false
+	}
+	origin := strings.ToLower(u.Scheme + "://" + u.Host)
+	for _, allowed := range append([]string{frontendURL()}, config.Auth.RedirectAllowlist...) {
+		if a, err := url.Parse(allowed); err == nil && strings.ToLower(a.Scheme+"://"+a.Host) == origin {
+			return target, true
+		}
 	}
-	c.Redirect(http.StatusTemporaryRedirect, fmt.Sprintf("%s/auth/callback?token=%s", frontendURL, jwtToken))
+	return "", false
 }
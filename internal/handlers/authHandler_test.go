@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fileTransfer/internal/config"
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/repository"
+	"fileTransfer/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type fakeAuthProvider struct {
+	identity *utils.Identity
+	err      error
+}
+
+func (p *fakeAuthProvider) Name() string { return "test" }
+
+func (p *fakeAuthProvider) AuthCodeURL(state string, verifier string, nonce string) string { return "" }
+
+func (p *fakeAuthProvider) Exchange(ctx context.Context, code string, verifier string, nonce string) (*utils.Identity, error) {
+	if nonce != "nonce" || verifier != "verifier" {
+		return nil, errors.New("flow not passed on")
+	}
+	return p.identity, p.err
+}
+
+// loginUserRepo records the identity the callback logs in, every other method is unused
+type loginUserRepo struct {
+	repository.UserDbRepo
+	login *models.GoogleUser
+	user  *models.GoogleUser
+	err   error
+}
+
+func (r *loginUserRepo) FindOrCreateUser(user *models.GoogleUser) (*models.GoogleUser, error) {
+	r.login = user
+	return r.user, r.err
+}
+
+func TestLoginCallback(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config.Auth.CookieSecret = []byte("test cookie secret")
+	flow, err := utils.SealValue(config.Auth.CookieSecret,
+		oauthFlow{Provider: "test", State: "state", Verifier: "verifier", Nonce: "nonce"}, oauthFlowTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherProvider, err := utils.SealValue(config.Auth.CookieSecret,
+		oauthFlow{Provider: "other", State: "state", Verifier: "verifier", Nonce: "nonce"}, oauthFlowTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verified := &utils.Identity{Provider: "test", Subject: "subject", Email: "user@example.com", EmailVerified: true}
+
+	tests := []struct {
+		name       string
+		cookie     string
+		query      string
+		identity   *utils.Identity
+		exchange   error
+		user       *models.GoogleUser
+		findErr    error
+		wantStatus int
+		wantLogin  bool
+	}{
+		{name: "no flow cookie", query: "state=state&code=code", wantStatus: http.StatusBadRequest},
+		{name: "forged flow cookie", cookie: flow + "x", query: "state=state&code=code", wantStatus: http.StatusBadRequest},
+		{name: "flow of another provider", cookie: otherProvider, query: "state=state&code=code", wantStatus: http.StatusBadRequest},
+		{name: "wrong state", cookie: flow, query: "state=other&code=code", wantStatus: http.StatusBadRequest},
+		{name: "no code", cookie: flow, query: "state=state", wantStatus: http.StatusBadRequest},
+		{name: "exchange failed", cookie: flow, query: "state=state&code=code", exchange: errors.New("invalid ID token: nonce mismatch"),
+			wantStatus: http.StatusBadGateway},
+		{name: "unverified email", cookie: flow, query: "state=state&code=code",
+			identity: &utils.Identity{Provider: "test", Subject: "subject", Email: "user@example.com"}, wantStatus: http.StatusForbidden},
+		{name: "no email", cookie: flow, query: "state=state&code=code",
+			identity: &utils.Identity{Provider: "test", Subject: "subject", EmailVerified: true}, wantStatus: http.StatusForbidden},
+		{name: "email with another identity", cookie: flow, query: "state=state&code=code", identity: verified,
+			findErr: repository.ErrIdentityConflict, wantStatus: http.StatusConflict, wantLogin: true},
+		{name: "disabled user", cookie: flow, query: "state=state&code=code", identity: verified,
+			user: &models.GoogleUser{ID: "user", Disabled: true}, wantStatus: http.StatusForbidden, wantLogin: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &loginUserRepo{user: tt.user, err: tt.findErr}
+			h := &Handlers{UserDbRepo: users,
+				AuthProviders: utils.AuthProviders{"test": &fakeAuthProvider{identity: tt.identity, err: tt.exchange}}}
+			router := gin.New()
+			router.GET("/auth/:provider/callback", h.LoginCallback)
+
+			req := httptest.NewRequest(http.MethodGet, "/auth/test/callback?"+tt.query, nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: oauthFlowCookie, Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if (users.login != nil) != tt.wantLogin {
+				t.Fatalf("user lookup ran: %v, want %v", users.login != nil, tt.wantLogin)
+			}
+			if users.login != nil && (users.login.AuthProvider != "test" || users.login.AuthSubject != "subject") {
+				t.Errorf("looked up %s %s, want the identity of the provider", users.login.AuthProvider,
+					users.login.AuthSubject)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"errors"
+	"fileTransfer/internal/config"
+	"fileTransfer/internal/dto"
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/utils"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// StartDeviceLogin is the RFC 8628 device authorization endpoint. The device sends an optional client_id, naming
+// the client, and the space separated scopes it needs, files:read when none are asked for. The request and
+// response are in the RFC's format so off-the-shelf clients work.
+func (h *Handlers) StartDeviceLogin(c *gin.Context) {
+	scopes := strings.Fields(c.PostForm("scope"))
+	if len(scopes) == 0 {
+		scopes = []string{models.ScopeFilesRead}
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(models.ApiTokenScopes, scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_scope", "error_description": "unknown scope " + scope})
+			return
+		}
+	}
+	slices.Sort(scopes)
+	scopes = slices.Compact(scopes)
+
+	clientName := strings.TrimSpace(c.PostForm("client_id"))
+	if len(clientName) > 255 {
+		clientName = clientName[:255]
+	}
+
+	auth, err := h.DeviceFlow.Start(clientName, scopes, c.ClientIP())
+	if errors.Is(err, utils.ErrDeviceCodeQuota) {
+		c.Header("Retry-After", "3600")
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "slow_down", "error_description": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to start device login: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	verificationURI := frontendURL() + "/device"
+	c.JSON(http.StatusOK, gin.H{
+		"device_code":               auth.DeviceCode,
+		"user_code":                 auth.UserCode,
+		"verification_uri":          verificationURI,
+		"verification_uri_complete": verificationURI + "?" + url.Values{"user_code": {auth.UserCode}}.Encode(),
+		"expires_in":                int(auth.ExpiresIn.Seconds()),
+		"interval":                  int(auth.Interval.Seconds()),
+	})
+}
+
+// PollDeviceLogin is the token endpoint the device polls until the user approved or denied its login
+func (h *Handlers) PollDeviceLogin(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	if c.PostForm("grant_type") != deviceCodeGrantType {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported_grant_type"})
+		return
+	}
+	deviceCode := c.PostForm("device_code")
+	if deviceCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "device_code is required"})
+		return
+	}
+
+	token, apiToken, err := h.DeviceFlow.Poll(deviceCode)
+	var flowErr *utils.DeviceFlowError
+	if errors.As(err, &flowErr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": flowErr.Code})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to poll device login: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(time.Until(*apiToken.ExpiresAt).Round(time.Second).Seconds()),
+		"scope":        strings.Join(apiToken.Scopes, " "),
+	})
+}
+
+// GetDeviceLogin describes the login of a user code, for the user to check before approving it
+func (h *Handlers) GetDeviceLogin(c *gin.Context) {
+	code, err := h.DeviceFlow.Lookup(currentUser(c).ID, c.Query("userCode"))
+	if h.deviceLoginError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"userCode":   utils.FormatUserCode(code.UserCode),
+		"clientName": code.ClientName,
+		"scopes":     code.Scopes,
+		"expiresAt":  code.ExpiresAt,
+	})
+}
+
+// DecideDeviceLogin approves or denies the login of a user code
+func (h *Handlers) DecideDeviceLogin(c *gin.Context) {
+	var body dto.DeviceApprovalBody
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	code, err := h.DeviceFlow.Decide(currentUser(c).ID, body.UserCode, body.Approve)
+	if h.deviceLoginError(c, err) {
+		return
+	}
+	log.Printf("User %s %s device login %s", currentUser(c).ID, code.Status, code.ID)
+
+	c.JSON(http.StatusOK, gin.H{"status": code.Status})
+}
+
+func (h *Handlers) deviceLoginError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, utils.ErrTooManyUserCodes):
+		c.Header("Retry-After", strconv.Itoa(int(config.DeviceFlow.CodeTTL.Seconds())))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many wrong codes, please try again later"})
+	case errors.Is(err, utils.ErrUnknownUserCode):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown or expired code"})
+	case errors.Is(err, utils.ErrUserCodeDecided):
+		c.JSON(http.StatusConflict, gin.H{"error": "This code was already used"})
+	default:
+		log.Printf("Failed to look up device login: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up code"})
+	}
+	return true
+}
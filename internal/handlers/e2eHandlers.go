@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	maxEncryptedMetadataLen = 8 * 1024
+	defaultE2EExpiry        = 24 * time.Hour
+	maxE2EExpiry            = 7 * 24 * time.Hour
+)
+
+// UploadEncryptedFile stores a client-encrypted blob and its sealed metadata.
+// The server never sees the key, the file name or the plaintext. The metadata,
+// maxDownloads and expiresIn fields must precede the file part.
+func (h *Handlers) UploadEncryptedFile(c *gin.Context) {
+	fields, part, err := readUploadParts(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	metadata := fields["metadata"]
+	if metadata == "" || len(metadata) > maxEncryptedMetadataLen {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Encrypted metadata is required and must be at most 8KB"})
+		return
+	}
+
+	maxDownloads, err := strconv.Atoi(fieldOrDefault(fields, "maxDownloads", "0"))
+	if err != nil || maxDownloads < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid maxDownloads"})
+		return
+	}
+
+	expiresIn, err := time.ParseDuration(fieldOrDefault(fields, "expiresIn", defaultE2EExpiry.String()))
+	if err != nil || expiresIn <= 0 || expiresIn > maxE2EExpiry {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expiresIn, must be a duration of at most 168h"})
+		return
+	}
+
+	// The object key must not reveal anything about the file
+	id := uuid.New().String()
+	key := "e2e/" + id
+	res, err := h.AwsS3.UploadObject(key, part, "application/octet-stream")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	expiry := time.Now().UTC().Add(expiresIn)
+	userId := currentUserId(c)
+	newFile := models.NewFile(id, key, "", res.Size, expiry, userId, res.Location, time.Now().UTC(), 0)
+	newFile.Checksum = res.Checksum
+	newFile.MaxDownloads = maxDownloads
+	newFile.Encrypted = true
+	newFile.EncryptedMetadata = metadata
+
+	err = h.FileDbRepo.AddFile(newFile)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	h.Webhooks.Publish(models.EventFileUploaded, userId, utils.FileEventData(newFile))
+
+	// Clients append "#k=<key>" to this link before sharing it
+	link := utils.ShareLink(newFile)
+	c.JSON(http.StatusOK, gin.H{"message": "Uploaded successfully", "id": id, "key": key, "link": link, "expiresAt": expiry,
+		"checksum_sha256": res.Checksum})
+}
+
+// GetEncryptedFileInfo returns the sealed metadata needed by a client to decrypt a download.
+func (h *Handlers) GetEncryptedFileInfo(c *gin.Context) {
+	key := c.Query("key")
+	if key == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing file key"})
+		return
+	}
+
+	file, err := h.FileDbRepo.GetFileByKey(key)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !file.Encrypted) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if isExpired(file) {
+		c.JSON(http.StatusGone, gin.H{"error": "File has expired"})
+		return
+	}
+	if file.OwnerDisabled {
+		c.JSON(http.StatusGone, gin.H{"error": "File is no longer available"})
+		return
+	}
+
+	remaining := -1
+	if file.MaxDownloads > 0 {
+		remaining = max(file.MaxDownloads-file.DownloadCount, 0)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"key":                key,
+		"metadata":           file.EncryptedMetadata,
+		"size":               file.Size,
+		"checksum_sha256":    file.Checksum,
+		"expiresAt":          file.ExpirationDate,
+		"downloadsRemaining": remaining,
+	})
+}
+
+func fieldOrDefault(fields map[string]string, name, def string) string {
+	if v, ok := fields[name]; ok && v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/sendgrid/sendgrid-go/helpers/eventwebhook"
+	"io"
+	"log"
+	"net/http"
+)
+
+const maxEventWebhookBody = 5 << 20
+
+// HandleSendGridEvents receives SendGrid's signed event webhook and suppresses the addresses that bounced,
+// reported spam or unsubscribed
+func (h *Handlers) HandleSendGridEvents(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxEventWebhookBody+1))
+	if err != nil || len(body) > maxEventWebhookBody {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	err = h.EmailGuard.VerifySendGridEvents(body, c.GetHeader(eventwebhook.VerificationHTTPHeader),
+		c.GetHeader(eventwebhook.TimestampHTTPHeader))
+	if errors.Is(err, utils.ErrEventWebhookDisabled) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	var events []utils.SendGridEvent
+	if err := json.Unmarshal(body, &events); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	// A failure makes SendGrid retry the whole batch, suppressing an address twice is harmless
+	suppressed, err := h.EmailGuard.ApplySendGridEvents(events)
+	if err != nil {
+		log.Printf("Failed to apply SendGrid events: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply events"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"received": len(events), "suppressed": suppressed})
+}
+
+// ListEmailSuppressions lists the addresses we no longer send to, newest first
+func (h *Handlers) ListEmailSuppressions(c *gin.Context) {
+	page, pageSize, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	suppressions, err := h.EmailAbuseDbRepo.ListSuppressions(pageSize, (page-1)*pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"suppressions": suppressions, "page": page, "pageSize": pageSize})
+}
+
+// DeleteEmailSuppression lets us email an address again, such as after a mailbox that bounced was fixed. The
+// reason is sent as a query parameter.
+func (h *Handlers) DeleteEmailSuppression(c *gin.Context) {
+	reason, ok := validAdminReason(c, c.Query("reason"))
+	if !ok {
+		return
+	}
+	entry, err := newAdminAction(c, models.AdminDeleteSuppression, "email_suppression", c.Param("address"), reason, nil)
+	if err == nil {
+		err = h.AdminDbRepo.DeleteEmailSuppression(c.Param("address"), entry)
+	}
+	if !adminActionApplied(c, entry, err, "Address is not suppressed") {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Suppression removed"})
+}
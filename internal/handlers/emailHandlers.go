@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fileTransfer/internal/config"
+	"fileTransfer/internal/dto"
+	"fileTransfer/internal/e2e"
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/utils"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SendFileDownloadLink emails the link of a file the caller owns. The link is always built here, so the
+// endpoint can't be used to send arbitrary URLs from our domain.
+func (h *Handlers) SendFileDownloadLink(c *gin.Context) {
+	var body dto.EmailRequestBody
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if body.DownloadLink != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Links are generated by the server, send the fileId or key of the file instead"})
+		return
+	}
+
+	file, ok := h.ownedFile(c, body.FileId, body.Key)
+	if !ok {
+		return
+	}
+	if isExpired(file) {
+		c.JSON(http.StatusGone, gin.H{"error": "File has expired"})
+		return
+	}
+	if file.OwnerDisabled {
+		c.JSON(http.StatusGone, gin.H{"error": "File is no longer available"})
+		return
+	}
+
+	recipients := utils.ParseRecipients(body.To, body.Cc, body.Bcc)
+	if len(recipients.To) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one valid 'to' address is required",
+			"recipients": recipients.Statuses})
+		return
+	}
+	if recipients.Count() > config.Mail.MaxRecipients {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d recipients are allowed", config.Mail.MaxRecipients)})
+		return
+	}
+
+	if err := h.EmailGuard.Filter(recipients); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(recipients.To) == 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "None of the 'to' addresses can be emailed right now",
+			"recipients": recipients.Statuses})
+		return
+	}
+	sender := currentUser(c)
+	var quotaErr *utils.QuotaError
+	if err := h.EmailGuard.CheckQuota(sender.ID, c.ClientIP(), recipients.Count()); errors.As(err, &quotaErr) {
+		c.Header("Retry-After", strconv.Itoa(int(quotaErr.Window.Seconds())))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": quotaErr.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Keys for end-to-end encrypted files live in the link fragment and are only emailed on request. That gives up
+	// zero-knowledge for the file, so such emails are sent right away and the key is never stored.
+	withKey := file.Encrypted && body.IncludeKey
+	downloadLink := utils.ShareLink(file)
+	if withKey {
+		key, err := e2e.DecodeKey(body.LinkKey)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "includeKey needs the file's key in linkKey"})
+			return
+		}
+		downloadLink = e2e.LinkWithKey(downloadLink, key)
+	}
+
+	subject, personalMessage, err := validateEmailText(body.Subject, body.Message)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	data := utils.EmailData{
+		DownloadLink:    downloadLink,
+		LinkValidity:    utils.ValidityText(time.Until(file.ExpirationDate)),
+		ExpiresAt:       utils.FormatExpiry(file.ExpirationDate, requestLocale(c, body.Locale), body.Timezone),
+		KeyOmitted:      file.Encrypted && !body.IncludeKey,
+		FileName:        utils.FileDisplayName(file.Name, file.Encrypted),
+		FileSize:        utils.FormatSize(file.Size),
+		PersonalMessage: personalMessage,
+	}
+
+	msg := &utils.Message{To: recipients.To, Cc: recipients.Cc, Bcc: recipients.Bcc}
+	// Replies go to the sender, the address we send from stays our own so the email passes SPF and DKIM
+	data.SenderName = sender.Name
+	if data.SenderName == "" {
+		data.SenderName = sender.Email
+	}
+	data.SenderEmail = sender.Email
+	msg.From = &mail.Address{Name: data.SenderName + " via " + config.Mail.FromName}
+	msg.ReplyTo = &mail.Address{Name: data.SenderName, Address: sender.Email}
+
+	// The text template picks the default subject when no custom one was given
+	data.Subject = subject
+	rendered, err := utils.RenderShareEmail(requestLocale(c, body.Locale), data)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	msg.Subject = rendered.Subject
+	msg.Text = rendered.Text
+	msg.HTML = rendered.HTML
+
+	var email *models.OutboxEmail
+	if withKey {
+		email, err = h.EmailOutbox.SendNow(sender.ID, file.ID, msg, recipients)
+	} else {
+		email, err = h.EmailOutbox.Enqueue(sender.ID, file.ID, msg, recipients)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue email", "details": err.Error()})
+		return
+	}
+	if err := h.EmailGuard.Record(email.ID, sender.ID, c.ClientIP(), recipients); err != nil {
+		log.Printf("Failed to record email %s against the send quotas: %v", email.ID, err)
+	}
+
+	if withKey {
+		if email.Status == models.EmailFailed {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to send email", "details": email.LastError,
+				"id": email.ID, "recipients": email.Recipients})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Email sent", "id": email.ID, "status": email.Status,
+			"statusUrl": "/file/sendEmail/" + email.ID, "recipients": email.Recipients})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Email queued", "id": email.ID, "status": email.Status,
+		"statusUrl": "/file/sendEmail/" + email.ID, "recipients": recipients.Statuses})
+}
+
+// GetEmailStatus reports whether a queued email was sent, along with the provider's response
+func (h *Handlers) GetEmailStatus(c *gin.Context) {
+	email, err := h.EmailOutboxDbRepo.GetEmail(c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && email.UserId != currentUserId(c)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Email not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"email": email})
+}
+
+// ListEmailTemplates lists the email templates along with the locales they are translated to
+func (h *Handlers) ListEmailTemplates(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"templates": utils.EmailTemplateLocales()})
+}
+
+// PreviewEmailTemplate renders a template with sample data, as HTML or with format=text as plain text
+func (h *Handlers) PreviewEmailTemplate(c *gin.Context) {
+	rendered, err := utils.PreviewEmail(c.Param("name"), c.Query("locale"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Query("format") == "text" {
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.String(http.StatusOK, "Subject: %s\n\n%s", rendered.Subject, rendered.Text)
+		return
+	}
+	setPreviewSecurityHeaders(c)
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(rendered.HTML))
+}
+
+const (
+	maxEmailSubjectLength = 200
+	maxEmailMessageLength = 2000
+)
+
+// validateEmailText trims the custom subject and personal message, refusing control characters that could
+// inject headers into the subject
+func validateEmailText(subject, message string) (string, string, error) {
+	subject = strings.TrimSpace(subject)
+	if utf8.RuneCountInString(subject) > maxEmailSubjectLength || strings.ContainsFunc(subject, unicode.IsControl) {
+		return "", "", fmt.Errorf("subject must be a single line of at most %d characters", maxEmailSubjectLength)
+	}
+
+	message = strings.TrimSpace(strings.ReplaceAll(message, "\r\n", "\n"))
+	if utf8.RuneCountInString(message) > maxEmailMessageLength ||
+		strings.ContainsFunc(message, func(r rune) bool { return unicode.IsControl(r) && r != '\n' && r != '\t' }) {
+		return "", "", fmt.Errorf("message must be at most %d characters of text", maxEmailMessageLength)
+	}
+	return subject, message, nil
+}
+
+// requestLocale is the locale emails are written in, the one asked for or else the sender's preferred language
+func requestLocale(c *gin.Context, locale string) string {
+	if locale != "" {
+		return locale
+	}
+	tag, _, _ := strings.Cut(c.GetHeader("Accept-Language"), ",")
+	tag, _, _ = strings.Cut(tag, ";")
+	return strings.TrimSpace(tag)
+}
+
+// ownedFile looks up a file of the caller by id or key, writing the error response if there is none
+func (h *Handlers) ownedFile(c *gin.Context, id, key string) (*models.File, bool) {
+	var file *models.File
+	var err error
+	switch {
+	case id != "":
+		file, err = h.FileDbRepo.GetFileByID(id)
+	case key != "":
+		file, err = h.FileDbRepo.GetFileByKey(key)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing fileId or key"})
+		return nil, false
+	}
+
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && file.UserId != currentUserId(c)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return file, true
+}
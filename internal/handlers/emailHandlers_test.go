@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fileTransfer/internal/config"
+	"fileTransfer/internal/e2e"
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSendFileDownloadLink(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config.FrontendURL = "https://files.example.com"
+	config.Mail.MaxRecipients = 3
+
+	owner := &models.GoogleUser{ID: "owner", Email: "owner@example.com"}
+	later := time.Now().Add(72 * time.Hour)
+	files := &memFiles{files: []*models.File{
+		{ID: "plain", S3Key: "uploads/report.pdf", UserId: "owner", Name: "report.pdf", ExpirationDate: later},
+		{ID: "sealed", S3Key: "e2e/abc", UserId: "owner", Encrypted: true, ExpirationDate: later},
+		{ID: "expired", S3Key: "uploads/old.pdf", UserId: "owner", ExpirationDate: time.Now().Add(-time.Hour)},
+		{ID: "theirs", S3Key: "uploads/theirs.pdf", UserId: "someone else", ExpirationDate: later},
+	}}
+	key, err := e2e.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		// wantLink must appear in the email, wantNoText must not
+		wantLink   string
+		wantNoText string
+		limits     config.MailLimitConfig
+		// sentNow emails are sent right away and stored without their message
+		sentNow bool
+	}{
+		{name: "by id", body: `{"fileId":"plain","to":"a@example.com"}`, wantStatus: http.StatusAccepted,
+			wantLink: "https://files.example.com/download?key=uploads%2Freport.pdf"},
+		{name: "by key", body: `{"key":"uploads/report.pdf","to":["a@example.com"]}`, wantStatus: http.StatusAccepted,
+			wantLink: "https://files.example.com/download?key=uploads%2Freport.pdf"},
+		{name: "client supplied link", body: `{"fileId":"plain","to":"a@example.com","link":"https://evil.example.com"}`,
+			wantStatus: http.StatusBadRequest},
+		{name: "someone else's file", body: `{"fileId":"theirs","to":"a@example.com"}`, wantStatus: http.StatusNotFound},
+		{name: "unknown file", body: `{"fileId":"missing","to":"a@example.com"}`, wantStatus: http.StatusNotFound},
+		{name: "no file", body: `{"to":"a@example.com"}`, wantStatus: http.StatusBadRequest},
+		{name: "expired file", body: `{"fileId":"expired","to":"a@example.com"}`, wantStatus: http.StatusGone},
+		{name: "no valid recipient", body: `{"fileId":"plain","to":"not an address","cc":"b@example.com"}`,
+			wantStatus: http.StatusBadRequest},
+		{name: "too many recipients", body: `{"fileId":"plain","to":"a@example.com, b@example.com","cc":["c@example.com","d@example.com"]}`,
+			wantStatus: http.StatusBadRequest},
+		{name: "encrypted file without its key", body: `{"fileId":"sealed","to":"a@example.com"}`,
+			wantStatus: http.StatusAccepted, wantLink: "https://files.example.com/e2e?key=e2e%2Fabc", wantNoText: "#"},
+		{name: "encrypted file with its key", body: `{"fileId":"sealed","to":"a@example.com","includeKey":true,"linkKey":"` +
+			e2e.EncodeKey(key) + `"}`, wantStatus: http.StatusOK,
+			wantLink: e2e.LinkWithKey("https://files.example.com/e2e?key=e2e%2Fabc", key), sentNow: true},
+		{name: "includeKey without the key", body: `{"fileId":"sealed","to":"a@example.com","includeKey":true}`,
+			wantStatus: http.StatusBadRequest},
+		{name: "suppressed recipient is left out", body: `{"fileId":"plain","to":["a@example.com","bounced@example.com"]}`,
+			wantStatus: http.StatusAccepted, wantLink: "https://files.example.com/download?key=uploads%2Freport.pdf"},
+		{name: "only suppressed recipients", body: `{"fileId":"plain","to":"bounced@example.com"}`,
+			wantStatus: http.StatusUnprocessableEntity},
+		{name: "over the send quota", body: `{"fileId":"plain","to":"a@example.com","cc":"b@example.com"}`,
+			limits: config.MailLimitConfig{UserHourly: 1}, wantStatus: http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outbox := &memOutbox{}
+			mailer := &fakeMailer{}
+			abuse := &memEmailAbuse{suppressed: []string{"bounced@example.com"}}
+			guard, err := utils.NewEmailGuard(abuse, tt.limits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := &Handlers{FileDbRepo: files, EmailOutboxDbRepo: outbox,
+				EmailOutbox: utils.NewEmailOutbox(outbox, mailer, utils.NewWebhookService(&memWebhooks{}, false), 3),
+				EmailGuard:  guard}
+			router := gin.New()
+			router.POST("/file/sendEmail", func(c *gin.Context) { c.Set(userContextKey, owner) }, h.SendFileDownloadLink)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/file/sendEmail", strings.NewReader(tt.body)))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "3600" {
+				t.Errorf("got Retry-After %q", w.Header().Get("Retry-After"))
+			}
+			if w.Code >= http.StatusBadRequest {
+				if len(outbox.emails) != 0 || len(abuse.sends) != 0 || len(mailer.sent) != 0 {
+					t.Errorf("queued an email for a refused request")
+				}
+				return
+			}
+			if len(outbox.emails) != 1 {
+				t.Fatalf("queued %d emails", len(outbox.emails))
+			}
+			// Only the recipients actually emailed count against the quotas
+			if len(abuse.sends) != 1 || abuse.sends[0].Recipient != "a@example.com" || abuse.sends[0].UserId != "owner" ||
+				abuse.sends[0].EmailId != outbox.emails[0].ID {
+				t.Errorf("recorded %+v", abuse.sends)
+			}
+			email := outbox.emails[0]
+			var msg utils.Message
+			if tt.sentNow {
+				// The key must never be stored, so only the mailer saw the message
+				if email.Message != "" || email.Status != models.EmailSent || len(mailer.sent) != 1 {
+					t.Fatalf("stored %+v after %d sends", email, len(mailer.sent))
+				}
+				msg = *mailer.sent[0]
+			} else if err := json.Unmarshal([]byte(email.Message), &msg); err != nil {
+				t.Fatal(err)
+			}
+			if email.UserId != "owner" || msg.To[0].Address != "a@example.com" {
+				t.Errorf("queued %+v for %v", email, msg.To)
+			}
+			if !strings.Contains(msg.Text, tt.wantLink+"\n") || !strings.Contains(msg.HTML, "valid for") {
+				t.Errorf("email lacks %q:\n%s", tt.wantLink, msg.Text)
+			}
+			if tt.wantNoText != "" && strings.Contains(msg.Text, tt.wantNoText) {
+				t.Errorf("email contains %q:\n%s", tt.wantNoText, msg.Text)
+			}
+		})
+	}
+}
+
+func TestGetEmailStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	outbox := &memOutbox{emails: []*models.OutboxEmail{
+		{ID: "mine", UserId: "owner", Status: models.EmailSent, Message: "secret"},
+		{ID: "theirs", UserId: "someone else", Status: models.EmailSent},
+	}}
+	h := &Handlers{EmailOutboxDbRepo: outbox}
+	owner := &models.GoogleUser{ID: "owner"}
+
+	for id, want := range map[string]int{"mine": http.StatusOK, "theirs": http.StatusNotFound, "missing": http.StatusNotFound} {
+		router := gin.New()
+		router.GET("/file/sendEmail/:id", func(c *gin.Context) { c.Set(userContextKey, owner) }, h.GetEmailStatus)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/file/sendEmail/"+id, nil))
+		if w.Code != want {
+			t.Errorf("%s: got status %d, want %d", id, w.Code, want)
+		}
+		if strings.Contains(w.Body.String(), "secret") {
+			t.Errorf("%s: status exposes the message: %s", id, w.Body)
+		}
+	}
+}
+
+func TestShareEmailContent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config.Mail.MaxRecipients = 3
+	config.Mail.FromName = "File Transfer"
+
+	files := &memFiles{files: []*models.File{{ID: "plain", S3Key: "uploads/report.pdf", UserId: "owner", Name: "report.pdf",
+		Size: 2048, ExpirationDate: time.Now().Add(24 * time.Hour)}}}
+	tests := []struct {
+		name        string
+		sender      *models.GoogleUser
+		body        string
+		language    string
+		wantStatus  int
+		wantSubject string
+		wantFrom    string
+		wantReplyTo string
+		wantText    []string
+		wantHTML    []string
+	}{
+		{name: "named sender", sender: &models.GoogleUser{ID: "owner", Name: "Jane Doe", Email: "jane@example.com"},
+			body: `{"fileId":"plain","to":"a@example.com"}`, wantStatus: http.StatusAccepted,
+			wantSubject: "Jane Doe shared report.pdf with you", wantFrom: `"Jane Doe via File Transfer" <@>`,
+			wantReplyTo: `"Jane Doe" <jane@example.com>`,
+			wantText:    []string{"Jane Doe (jane@example.com) shared a file with you.", "File:    report.pdf", "Size:    2.0 KB"}},
+		{name: "sender without a name", sender: &models.GoogleUser{ID: "owner", Email: "jane@example.com"},
+			body: `{"fileId":"plain","to":"a@example.com"}`, wantStatus: http.StatusAccepted,
+			wantSubject: "jane@example.com shared report.pdf with you", wantFrom: `"jane@example.com via File Transfer" <@>`,
+			wantReplyTo: `"jane@example.com" <jane@example.com>`},
+		{name: "custom subject and message", sender: &models.GoogleUser{ID: "owner", Name: "Jane", Email: "jane@example.com"},
+			body:       `{"fileId":"plain","to":"a@example.com","subject":"  Q3 report ","message":"See <b>page 4</b>\r\nThanks"}`,
+			wantStatus: http.StatusAccepted, wantSubject: "Q3 report", wantFrom: `"Jane via File Transfer" <@>`,
+			wantReplyTo: `"Jane" <jane@example.com>`, wantText: []string{"See <b>page 4</b>\nThanks"},
+			wantHTML: []string{"See &lt;b&gt;page 4&lt;/b&gt;"}},
+		{name: "sender's language", sender: &models.GoogleUser{ID: "owner", Name: "Jane", Email: "jane@example.com"},
+			body: `{"fileId":"plain","to":"a@example.com"}`, language: "de-AT,de;q=0.9,en;q=0.5", wantStatus: http.StatusAccepted,
+			wantSubject: "Jane hat report.pdf mit Ihnen geteilt", wantFrom: `"Jane via File Transfer" <@>`,
+			wantReplyTo: `"Jane" <jane@example.com>`, wantText: []string{"Datei:    report.pdf"}},
+		{name: "requested locale wins", sender: &models.GoogleUser{ID: "owner", Name: "Jane", Email: "jane@example.com"},
+			body: `{"fileId":"plain","to":"a@example.com","locale":"en"}`, language: "de-DE", wantStatus: http.StatusAccepted,
+			wantSubject: "Jane shared report.pdf with you", wantFrom: `"Jane via File Transfer" <@>`,
+			wantReplyTo: `"Jane" <jane@example.com>`},
+		{name: "subject with a line break", sender: &models.GoogleUser{ID: "owner", Email: "jane@example.com"},
+			body: `{"fileId":"plain","to":"a@example.com","subject":"Hi\r\nBcc: victim@example.com"}`, wantStatus: http.StatusBadRequest},
+		{name: "message too long", sender: &models.GoogleUser{ID: "owner", Email: "jane@example.com"},
+			body: `{"fileId":"plain","to":"a@example.com","message":"` + strings.Repeat("x", 2001) + `"}`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outbox := &memOutbox{}
+			guard, err := utils.NewEmailGuard(&memEmailAbuse{}, config.MailLimitConfig{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := &Handlers{FileDbRepo: files, EmailOutbox: utils.NewEmailOutbox(outbox, &fakeMailer{}, nil, 3),
+				EmailGuard: guard}
+			router := gin.New()
+			router.POST("/file/sendEmail", func(c *gin.Context) { c.Set(userContextKey, tt.sender) }, h.SendFileDownloadLink)
+			req := httptest.NewRequest(http.MethodPost, "/file/sendEmail", strings.NewReader(tt.body))
+			if tt.language != "" {
+				req.Header.Set("Accept-Language", tt.language)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if w.Code != http.StatusAccepted {
+				return
+			}
+			var msg utils.Message
+			if err := json.Unmarshal([]byte(outbox.emails[0].Message), &msg); err != nil {
+				t.Fatal(err)
+			}
+			// The address is left for the mailer to fill in with our own
+			if msg.Subject != tt.wantSubject || msg.From.String() != tt.wantFrom || msg.ReplyTo.String() != tt.wantReplyTo {
+				t.Errorf("got subject %q from %s replying to %s", msg.Subject, msg.From, msg.ReplyTo)
+			}
+			for _, want := range tt.wantText {
+				if !strings.Contains(msg.Text, want) {
+					t.Errorf("text lacks %q:\n%s", want, msg.Text)
+				}
+			}
+			for _, want := range tt.wantHTML {
+				if !strings.Contains(msg.HTML, want) {
+					t.Errorf("HTML lacks %q", want)
+				}
+			}
+			if strings.Contains(msg.HTML, "<b>page") {
+				t.Errorf("personal message is not escaped in the HTML")
+			}
+		})
+	}
+}
+
+func TestPreviewEmailTemplate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := &Handlers{}
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantType   string
+		wantBody   string
+	}{
+		{"html", "/emails/templates/share/preview", http.StatusOK, "text/html; charset=utf-8", "Jane Doe"},
+		{"text in a locale", "/emails/templates/share/preview?locale=de&format=text", http.StatusOK,
+			"text/plain; charset=utf-8", "Subject: Jane Doe hat report.pdf mit Ihnen geteilt"},
+		{"unknown template", "/emails/templates/missing/preview", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/emails/templates/:name/preview", h.PreviewEmailTemplate)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("got Content-Type %q, want %q", got, tt.wantType)
+			}
+			if w.Header().Get("X-Content-Type-Options") != "nosniff" {
+				t.Error("preview may be sniffed")
+			}
+			if tt.wantType == "text/html; charset=utf-8" && w.Header().Get("Content-Security-Policy") != previewCSP {
+				t.Errorf("got CSP %q", w.Header().Get("Content-Security-Policy"))
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body lacks %q", tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fileTransfer/internal/config"
+	"fileTransfer/internal/dto"
+	"fileTransfer/internal/utils"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+// ExtendExpiry extends a file from the link in its expiry reminder. The link only works while the file still
+// has the expiry it was emailed for, so using it twice extends the file once.
+func (h *Handlers) ExtendExpiry(c *gin.Context) {
+	var body dto.ExtendExpiryBody
+	if err := c.BindJSON(&body); err != nil || body.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	claims, err := h.JWT.ParseExtendToken(body.Token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This link is invalid or has expired"})
+		return
+	}
+
+	file, err := h.FileDbRepo.GetFileByID(claims.FileId)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if file.OwnerDisabled {
+		c.JSON(http.StatusGone, gin.H{"error": "File is no longer available"})
+		return
+	}
+	if !file.ExpirationDate.Equal(time.Unix(claims.FileExpiry, 0)) {
+		c.JSON(http.StatusConflict, gin.H{"error": "This link was already used", "expiresAt": file.ExpirationDate})
+		return
+	}
+
+	expiry, ok := utils.ExtendedExpiry(file, config.Reminders)
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "This file can't be kept any longer", "expiresAt": file.ExpirationDate})
+		return
+	}
+	extended, err := h.FileDbRepo.ExtendExpiry(file.ID, file.ExpirationDate, expiry)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !extended {
+		c.JSON(http.StatusConflict, gin.H{"error": "This link was already used"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Expiry extended", "expiresAt": expiry})
+}
@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"database/sql"
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/repository"
+	"fileTransfer/internal/utils"
+	"slices"
+	"sync"
+	"time"
+)
+
+// memFiles is an in-memory FileDbRepo, methods the tests don't use panic through the nil embedded interface
+type memFiles struct {
+	repository.FileDbRepo
+	mu    sync.Mutex
+	files []*models.File
+}
+
+func (m *memFiles) GetFileByKey(key string) (*models.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, f := range m.files {
+		if f.S3Key == key {
+			copied := *f
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *memFiles) ListFilesByUser(userId string) ([]models.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var files []models.File
+	for _, f := range m.files {
+		if f.UserId == userId {
+			files = append(files, *f)
+		}
+	}
+	return files, nil
+}
+
+func (m *memFiles) AddFile(file *models.File) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	copied := *file
+	m.files = append(m.files, &copied)
+	return nil
+}
+
+// memUploadRequests is an in-memory UploadRequestDbRepo
+type memUploadRequests struct {
+	repository.UploadRequestDbRepo
+	mu       sync.Mutex
+	requests []*models.UploadRequest
+	releases int
+}
+
+func (m *memUploadRequests) find(id string) *models.UploadRequest {
+	for _, r := range m.requests {
+		if r.ID == id {
+			return r
+		}
+	}
+	return nil
+}
+
+func (m *memUploadRequests) GetUploadRequestByTokenHash(tokenHash string) (*models.UploadRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range m.requests {
+		if r.TokenHash == tokenHash {
+			copied := *r
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *memUploadRequests) ClaimUploadSlot(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := m.find(id)
+	if r == nil {
+		return sql.ErrNoRows
+	}
+	if r.MaxFiles > 0 && r.UploadCount >= r.MaxFiles {
+		return repository.ErrUploadRequestFull
+	}
+	r.UploadCount++
+	return nil
+}
+
+func (m *memUploadRequests) ReleaseUploadSlot(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if r := m.find(id); r != nil {
+		r.UploadCount--
+	}
+	m.releases++
+	return nil
+}
+
+// memUsers finds users by id and email, every other method is unused
+type memUsers struct {
+	repository.UserDbRepo
+	users []*models.GoogleUser
+}
+
+func (m *memUsers) FindUserByID(id string) (*models.GoogleUser, error) {
+	for _, u := range m.users {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *memUsers) FindUserByEmail(email string) (*models.GoogleUser, error) {
+	for _, u := range m.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// memDownloadEvents records download events and which of them were reported
+type memDownloadEvents struct {
+	repository.DownloadEventDbRepo
+	mu       sync.Mutex
+	events   []*models.DownloadEvent
+	notified []string
+}
+
+func (m *memDownloadEvents) AddDownloadEvent(event *models.DownloadEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, event)
+	return nil
+}
+
+func (m *memDownloadEvents) MarkEventsNotified(ids []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notified = append(m.notified, ids...)
+	return nil
+}
+
+func (m *memDownloadEvents) ListDownloadEventsByFile(fileId string, limit int, offset int) ([]models.DownloadEvent, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var matched []models.DownloadEvent
+	for _, e := range m.events {
+		if e.FileId == fileId {
+			matched = append(matched, *e)
+		}
+	}
+	page := matched[min(offset, len(matched)):min(offset+limit, len(matched))]
+	return page, len(matched), nil
+}
+
+func (m *memDownloadEvents) ExportDownloadEvents(from time.Time, to time.Time, fn func(event *models.DownloadEvent) error) error {
+	m.mu.Lock()
+	events := append([]*models.DownloadEvent(nil), m.events...)
+	m.mu.Unlock()
+	for _, e := range events {
+		if e.DownloadedAt.Before(from) || !e.DownloadedAt.Before(to) {
+			continue
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// memWebhooks subscribes one endpoint to every event and keeps the queued deliveries
+type memWebhooks struct {
+	repository.WebhookDbRepo
+	mu         sync.Mutex
+	deliveries []*models.WebhookDelivery
+}
+
+func (m *memWebhooks) GetSubscribedEndpoints(userId string, eventType string) ([]models.WebhookEndpoint, error) {
+	return []models.WebhookEndpoint{{ID: "endpoint", UserId: userId}}, nil
+}
+
+func (m *memWebhooks) AddDelivery(delivery *models.WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deliveries = append(m.deliveries, delivery)
+	return nil
+}
+
+// fakeMailer keeps every message instead of sending it, or fails with err
+type fakeMailer struct {
+	mu   sync.Mutex
+	sent []*utils.Message
+	err  error
+}
+
+func (m *fakeMailer) Send(msg *utils.Message) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return "", m.err
+	}
+	m.sent = append(m.sent, msg)
+	return "250 queued", nil
+}
+
+func (m *memFiles) GetFileByID(id string) (*models.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, f := range m.files {
+		if f.ID == id {
+			copied := *f
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// memOutbox is an in-memory EmailOutboxDbRepo, AddEmail fails with err when it is set
+type memOutbox struct {
+	repository.EmailOutboxDbRepo
+	mu     sync.Mutex
+	emails []*models.OutboxEmail
+	err    error
+}
+
+func (m *memOutbox) AddEmail(email *models.OutboxEmail) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	copied := *email
+	m.emails = append(m.emails, &copied)
+	return nil
+}
+
+func (m *memOutbox) UpdateEmail(email *models.OutboxEmail) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, e := range m.emails {
+		if e.ID == email.ID {
+			copied := *email
+			m.emails[i] = &copied
+		}
+	}
+	return nil
+}
+
+func (m *memOutbox) GetEmail(id string) (*models.OutboxEmail, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.emails {
+		if e.ID == id {
+			copied := *e
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// memEmailAbuse counts sends by user and IP and suppresses the addresses listed in suppressed
+type memEmailAbuse struct {
+	repository.EmailAbuseDbRepo
+	sends      []*models.EmailSend
+	suppressed []string
+}
+
+func (m *memEmailAbuse) AddSends(sends []*models.EmailSend) error {
+	m.sends = append(m.sends, sends...)
+	return nil
+}
+
+func (m *memEmailAbuse) CountSendsByUser(userId string, since time.Time) (int, error) {
+	n := 0
+	for _, s := range m.sends {
+		if s.UserId == userId {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memEmailAbuse) CountSendsByIP(clientIP string, since time.Time) (int, error) {
+	n := 0
+	for _, s := range m.sends {
+		if s.ClientIP == clientIP {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memEmailAbuse) CountSendsToRecipient(recipient string, since time.Time) (int, error) {
+	return 0, nil
+}
+
+func (m *memEmailAbuse) GetSuppressions(addresses []string) ([]models.EmailSuppression, error) {
+	var found []models.EmailSuppression
+	for _, a := range addresses {
+		if slices.Contains(m.suppressed, a) {
+			found = append(found, models.EmailSuppression{Address: a, Reason: models.SuppressBounce})
+		}
+	}
+	return found, nil
+}
@@ -1,41 +1,42 @@
 package handlers
 
 import (
-	"fileTransfer/internal/dto"
+	"database/sql"
+	"errors"
 	"fileTransfer/internal/models"
+	"fileTransfer/internal/repository"
 	"fileTransfer/internal/utils"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"io"
+	"log"
+	"mime/multipart"
 	"net/http"
+	"net/url"
+	"path/filepath"
 	"time"
 )
 
 func (h *Handlers) UploadFileAndSaveInfo(c *gin.Context) {
-	//Logic to upload file on AWS
-	file, err := c.FormFile("file")
+	//Logic to stream the file to AWS without spooling it to memory or disk
+	_, part, err := readUploadParts(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "File is required"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	filename := filepath.Base(part.FileName())
 
-	res, err := h.AwsS3.UploadFile(file)
+	expiry := time.Now().UTC().Add(2 * time.Minute)
+	expiryDuration := time.Until(expiry)
+
+	newFile, err := h.storeFile(filename, part, currentUserId(c), expiry, nil)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	//Logic for wrapping file info in the file struct
-	f, _ := file.Open()
-	defer f.Close()
-	size, _ := f.Seek(0, io.SeekEnd)
-	f.Seek(0, 0) // reset
-
-	expiry := time.Now().UTC().Add(2 * time.Minute)
-	expiryDuration := time.Until(expiry)
-
-	signedURL, err := h.AwsS3.GenerateSignedURL(*res.Key, expiryDuration)
+	signedURL, err := h.AwsS3.GenerateSignedURL(newFile.S3Key, expiryDuration)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"message": err.Error(),
@@ -43,19 +44,29 @@ func (h *Handlers) UploadFileAndSaveInfo(c *gin.Context) {
 		return
 	}
 
-	userId := " ee6d4c16-eaf3-482c-9271-b9236175b57c"
-	newFile := models.NewFile(uuid.New().String(), *res.Key, file.Filename, size, expiry, userId, res.Location, time.Now().UTC(), 0)
+	c.JSON(http.StatusOK, gin.H{"message": "Uploaded successfully", "id": newFile.ID, "key": newFile.S3Key, "URL": signedURL, "valid For": "2 minutes", "checksum_sha256": newFile.Checksum})
+}
 
-	//Saving file in the db
-	err = h.FileDbRepo.AddFile(newFile)
+// storeFile streams an upload to AWS and saves its info in the db, prepare can adjust the row before it is saved
+func (h *Handlers) storeFile(filename string, body io.Reader, userId string, expiry time.Time, prepare func(*models.File)) (*models.File, error) {
+	res, err := h.AwsS3.UploadFile(filename, body)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": err.Error(),
-		})
-		return
+		return nil, err
+	}
+
+	newFile := models.NewFile(uuid.New().String(), res.Key, filename, res.Size, expiry, userId, res.Location, time.Now().UTC(), 0)
+	newFile.Checksum = res.Checksum
+	if prepare != nil {
+		prepare(newFile)
+	}
+
+	//Saving file in the db
+	if err := h.FileDbRepo.AddFile(newFile); err != nil {
+		return nil, err
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Uploaded successfully", "key": res.Key, "URL": signedURL, "valid For": "2 minutes"})
+	h.Webhooks.Publish(models.EventFileUploaded, userId, utils.FileEventData(newFile))
+	return newFile, nil
 }
 
 func (h *Handlers) DownloadFile(c *gin.Context) {
@@ -65,6 +76,24 @@ func (h *Handlers) DownloadFile(c *gin.Context) {
 		return
 	}
 
+	fileInfo, err := h.FileDbRepo.GetFileByKey(key)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Download failed", "details": err.Error()})
+		return
+	}
+	if isExpired(fileInfo) {
+		c.JSON(http.StatusGone, gin.H{"error": "File has expired"})
+		return
+	}
+	if fileInfo.OwnerDisabled {
+		c.JSON(http.StatusGone, gin.H{"error": "File is no longer available"})
+		return
+	}
+
 	// Download file from AWS
 	resp, err := h.AwsS3.DownloadFile(key)
 	if err != nil {
@@ -75,63 +104,157 @@ func (h *Handlers) DownloadFile(c *gin.Context) {
 
 	// Update download count in the db
 	err = h.FileDbRepo.IncreaseDownloadCount(key)
+	if errors.Is(err, repository.ErrDownloadLimitReached) {
+		c.JSON(http.StatusGone, gin.H{"error": "Download limit reached"})
+		return
+	}
 	if err != nil {
 		// Log the error but continue with the download
 		fmt.Printf("Failed to update download count: %v\n", err)
 	}
 
-	// Set appropriate headers for file download
+	// Set appropriate headers for file download, never letting the browser render it
+	c.Header("Content-Disposition", dispositionHeader("attachment", fileInfo.Name))
+	c.Header("X-Content-Type-Options", "nosniff")
+	if reprDigest, digest, ok := utils.DigestHeader(fileInfo.Checksum); ok {
+		c.Header("Repr-Digest", reprDigest)
+		c.Header("Digest", digest)
+	}
 	c.Header("Content-Type", *resp.ContentType)
 	c.Header("Content-Length", fmt.Sprintf("%d", *resp.ContentLength))
 
 	// Stream the file to the client
 	c.DataFromReader(http.StatusOK, *resp.ContentLength, *resp.ContentType, resp.Body, nil)
+
+	bytesSent := int64(max(c.Writer.Size(), 0))
+	h.recordDownload(c, fileInfo, bytesSent, bytesSent == *resp.ContentLength)
 }
 
+// ListFile lists the caller's own files with their checksums and thumbnails
 func (h *Handlers) ListFile(c *gin.Context) {
-	//Logic to List files from AWS
-	resp, err := h.AwsS3.ListFiles()
+	files, err := h.FileDbRepo.ListFilesByUser(currentUser(c).ID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not list objects", "details": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not list files", "details": err.Error()})
 		return
 	}
 
-	var keys []string
-	for _, item := range resp.Contents {
-		keys = append(keys, *item.Key)
+	keys := []string{}
+	checksums := make(map[string]string)
+	thumbnails := make(map[string]string)
+	for _, f := range files {
+		keys = append(keys, f.S3Key)
+		if f.Checksum != "" {
+			checksums[f.S3Key] = f.Checksum
+		}
+		if f.ThumbnailKey != "" {
+			thumbnails[f.S3Key] = "/file/thumbnail?key=" + url.QueryEscape(f.S3Key)
+		}
 	}
 
-	c.JSON(http.StatusOK, gin.H{"files": keys})
+	c.JSON(http.StatusOK, gin.H{"files": keys, "checksums": checksums, "thumbnails": thumbnails})
 }
 
-func (h *Handlers) SendFileDownloadLink(c *gin.Context) {
-	var body dto.EmailRequestBody
-	if err := c.BindJSON(&body); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+// GetThumbnail serves the thumbnail of a file owned by the caller
+func (h *Handlers) GetThumbnail(c *gin.Context) {
+	key := c.Query("key")
+	if key == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing file key"})
 		return
 	}
 
-	if body.To == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing 'to' email"})
+	file, err := h.FileDbRepo.GetFileByKey(key)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && (file.UserId != currentUserId(c) || file.ThumbnailKey == "")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Thumbnail not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	if body.DownloadLink == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing download link"})
+	resp, err := h.AwsS3.DownloadFile(file.ThumbnailKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load thumbnail", "details": err.Error()})
 		return
 	}
+	defer resp.Body.Close()
 
-	emailBody := "Here is your download link: \n\n" + body.DownloadLink + "\nvalid for: " + body.LinkValidity
-	htmlBody, err := utils.RenderEmailHTML(body.DownloadLink, body.LinkValidity)
+	c.Header("Cache-Control", "private, max-age=3600")
+	c.DataFromReader(http.StatusOK, *resp.ContentLength, "image/jpeg", resp.Body, nil)
+}
+
+// DeleteFile removes a file owned by the caller before it expires
+func (h *Handlers) DeleteFile(c *gin.Context) {
+	key := c.Query("key")
+	if key == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing file key"})
+		return
+	}
+
+	file, err := h.FileDbRepo.GetFileByKey(key)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && file.UserId != currentUserId(c)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	if err := utils.SendEmailWithSendGrid(body.To, body.Bcc, body.Cc, emailBody, htmlBody); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send email", "details": err.Error()})
+	if err := h.AwsS3.DeleteObject(file.S3Key); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete file", "details": err.Error()})
+		return
+	}
+	if file.ThumbnailKey != "" {
+		if err := h.AwsS3.DeleteObject(file.ThumbnailKey); err != nil {
+			log.Printf("Failed to delete thumbnail from S3: %v", err)
+		}
+	}
+	if err := h.FileDbRepo.DeleteFileByID(file.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Email sent successfully"})
+	h.Webhooks.Publish(models.EventFileDeleted, file.UserId, utils.FileEventData(file))
+	c.JSON(http.StatusOK, gin.H{"message": "File deleted"})
+}
+
+func isExpired(file *models.File) bool {
+	return !file.ExpirationDate.IsZero() && time.Now().After(file.ExpirationDate)
+}
+
+const maxUploadFieldSize = 64 * 1024
+
+// readUploadParts reads the form fields preceding the "file" part and returns them along with the
+// file part itself, which the caller streams from. Fields sent after the file are not seen.
+func readUploadParts(c *gin.Context) (map[string]string, *multipart.Part, error) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, nil, errors.New("multipart form data is required")
+	}
+
+	fields := make(map[string]string)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, nil, errors.New("File is required")
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid multipart body: %w", err)
+		}
+
+		if part.FormName() == "file" && part.FileName() != "" {
+			return fields, part, nil
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, maxUploadFieldSize+1))
+		part.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid multipart body: %w", err)
+		}
+		if len(value) > maxUploadFieldSize {
+			return nil, nil, fmt.Errorf("field %q is too large", part.FormName())
+		}
+		fields[part.FormName()] = string(value)
+	}
 }
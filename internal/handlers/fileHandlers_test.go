@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fileTransfer/internal/models"
+	"io"
+	"maps"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// multipartPart is a form field, or a file when filename is set
+type multipartPart struct {
+	name     string
+	filename string
+	value    string
+}
+
+func newMultipartRequest(t *testing.T, parts ...multipartPart) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, p := range parts {
+		var w io.Writer
+		var err error
+		if p.filename != "" {
+			w, err = mw.CreateFormFile(p.name, p.filename)
+		} else {
+			w, err = mw.CreateFormField(p.name)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, p.value)
+	}
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestReadUploadParts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name       string
+		req        func(t *testing.T) *http.Request
+		wantErr    string
+		wantFields map[string]string
+		wantFile   string
+	}{
+		{
+			name: "fields before the file",
+			req: func(t *testing.T) *http.Request {
+				return newMultipartRequest(t, multipartPart{name: "note", value: "hi"},
+					multipartPart{name: "file", filename: "a.txt", value: "content"})
+			},
+			wantFields: map[string]string{"note": "hi"},
+			wantFile:   "content",
+		},
+		{
+			name: "fields after the file are not read",
+			req: func(t *testing.T) *http.Request {
+				return newMultipartRequest(t, multipartPart{name: "file", filename: "a.txt", value: "content"},
+					multipartPart{name: "note", value: "hi"})
+			},
+			wantFields: map[string]string{},
+			wantFile:   "content",
+		},
+		{
+			name: "no file",
+			req: func(t *testing.T) *http.Request {
+				return newMultipartRequest(t, multipartPart{name: "note", value: "hi"})
+			},
+			wantErr: "File is required",
+		},
+		{
+			name: "file field without a filename",
+			req: func(t *testing.T) *http.Request {
+				return newMultipartRequest(t, multipartPart{name: "file", value: "content"})
+			},
+			wantErr: "File is required",
+		},
+		{
+			name: "field too large",
+			req: func(t *testing.T) *http.Request {
+				return newMultipartRequest(t, multipartPart{name: "note", value: strings.Repeat("x", maxUploadFieldSize+1)},
+					multipartPart{name: "file", filename: "a.txt", value: "content"})
+			},
+			wantErr: `field "note" is too large`,
+		},
+		{
+			name: "not multipart",
+			req: func(t *testing.T) *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(`{"file":"a.txt"}`))
+				req.Header.Set("Content-Type", "application/json")
+				return req
+			},
+			wantErr: "multipart form data is required",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = tt.req(t)
+
+			fields, part, err := readUploadParts(c)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readUploadParts: %v", err)
+			}
+			if len(fields) != len(tt.wantFields) {
+				t.Errorf("got fields %v, want %v", fields, tt.wantFields)
+			}
+			for k, v := range tt.wantFields {
+				if fields[k] != v {
+					t.Errorf("field %s is %q, want %q", k, fields[k], v)
+				}
+			}
+			content, err := io.ReadAll(part)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.wantFile {
+				t.Errorf("got file %q, want %q", content, tt.wantFile)
+			}
+		})
+	}
+}
+
+func TestListFile(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	files := &memFiles{files: []*models.File{
+		{ID: "report", S3Key: "uploads/report.pdf", UserId: "owner", Checksum: "abc", ThumbnailKey: "thumbnails/report"},
+		{ID: "notes", S3Key: "uploads/notes.txt", UserId: "owner"},
+		{ID: "theirs", S3Key: "uploads/theirs.pdf", UserId: "someone else", Checksum: "def", ThumbnailKey: "thumbnails/theirs"},
+	}}
+	h := &Handlers{FileDbRepo: files}
+
+	tests := []struct {
+		name           string
+		user           *models.GoogleUser
+		wantFiles      []string
+		wantChecksums  map[string]string
+		wantThumbnails map[string]string
+	}{
+		{"owner", &models.GoogleUser{ID: "owner"}, []string{"uploads/report.pdf", "uploads/notes.txt"},
+			map[string]string{"uploads/report.pdf": "abc"},
+			map[string]string{"uploads/report.pdf": "/file/thumbnail?key=uploads%2Freport.pdf"}},
+		{"user without files", &models.GoogleUser{ID: "new"}, []string{}, map[string]string{}, map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/file/listFiles", func(c *gin.Context) { c.Set(userContextKey, tt.user) }, h.ListFile)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/file/listFiles", nil))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body)
+			}
+			var resp struct {
+				Files      []string          `json:"files"`
+				Checksums  map[string]string `json:"checksums"`
+				Thumbnails map[string]string `json:"thumbnails"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(resp.Files, tt.wantFiles) || !maps.Equal(resp.Checksums, tt.wantChecksums) ||
+				!maps.Equal(resp.Thumbnails, tt.wantThumbnails) {
+				t.Errorf("got %+v", resp)
+			}
+		})
+	}
+}
@@ -1,22 +1,68 @@
 package handlers
 
 import (
+	"encoding/json"
+	"fileTransfer/internal/config"
 	"fileTransfer/internal/repository"
 	"fileTransfer/internal/utils"
+	"github.com/gin-gonic/gin"
+	"time"
 )
 
 type Handlers struct {
-	UserDbRepo repository.UserDbRepo
-	FileDbRepo repository.FileDbRepo
-	JWT        *utils.JWTService
-	AwsS3      *utils.AwsS3
+	UserDbRepo          repository.UserDbRepo
+	FileDbRepo          repository.FileDbRepo
+	UploadRequestDbRepo repository.UploadRequestDbRepo
+	DownloadEventDbRepo repository.DownloadEventDbRepo
+	WebhookDbRepo       repository.WebhookDbRepo
+	EmailOutboxDbRepo   repository.EmailOutboxDbRepo
+	EmailAbuseDbRepo    repository.EmailAbuseDbRepo
+	ApiTokenDbRepo      repository.ApiTokenDbRepo
+	AdminDbRepo         repository.AdminDbRepo
+	JWT                 *utils.JWTService
+	AwsS3               *utils.AwsS3
+	EmailOutbox         *utils.EmailOutbox
+	Webhooks            *utils.WebhookService
+	EmailGuard          *utils.EmailGuard
+	AuthProviders       utils.AuthProviders
+	Sessions            *utils.SessionService
+	DeviceFlow          *utils.DeviceFlow
+	// UploadLimiter limits the uploads a client IP makes to upload requests
+	UploadLimiter *utils.RateLimiter
 }
 
-func NewHandlers(mysqlUserRepo repository.UserDbRepo, FileDbRepo repository.FileDbRepo, jwt *utils.JWTService, awsS3 *utils.AwsS3) *Handlers {
+func NewHandlers(mysqlUserRepo repository.UserDbRepo, FileDbRepo repository.FileDbRepo, uploadRequestRepo repository.UploadRequestDbRepo, downloadEventRepo repository.DownloadEventDbRepo, webhookRepo repository.WebhookDbRepo, emailOutboxRepo repository.EmailOutboxDbRepo, emailAbuseRepo repository.EmailAbuseDbRepo, apiTokenRepo repository.ApiTokenDbRepo, adminRepo repository.AdminDbRepo, jwt *utils.JWTService, awsS3 *utils.AwsS3, emailOutbox *utils.EmailOutbox, webhooks *utils.WebhookService, emailGuard *utils.EmailGuard, authProviders utils.AuthProviders, sessions *utils.SessionService, deviceFlow *utils.DeviceFlow) *Handlers {
 	return &Handlers{
-		UserDbRepo: mysqlUserRepo,
-		FileDbRepo: FileDbRepo,
-		JWT:        jwt,
-		AwsS3:      awsS3,
+		UserDbRepo:          mysqlUserRepo,
+		FileDbRepo:          FileDbRepo,
+		UploadRequestDbRepo: uploadRequestRepo,
+		DownloadEventDbRepo: downloadEventRepo,
+		WebhookDbRepo:       webhookRepo,
+		EmailOutboxDbRepo:   emailOutboxRepo,
+		EmailAbuseDbRepo:    emailAbuseRepo,
+		ApiTokenDbRepo:      apiTokenRepo,
+		AdminDbRepo:         adminRepo,
+		JWT:                 jwt,
+		AwsS3:               awsS3,
+		EmailOutbox:         emailOutbox,
+		Webhooks:            webhooks,
+		EmailGuard:          emailGuard,
+		AuthProviders:       authProviders,
+		Sessions:            sessions,
+		DeviceFlow:          deviceFlow,
+		UploadLimiter:       utils.NewRateLimiter(config.UploadRequests.IPHourly, time.Hour),
 	}
 }
+
+func frontendURL() string {
+	return config.FrontendURL
+}
+
+func writeJSONLine(c *gin.Context, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = c.Writer.Write(append(b, '\n'))
+	return err
+}
@@ -0,0 +1,14 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetJWKS serves the public keys tokens are signed with. Keys change rarely, but a retired key is dropped from
+// the set on the next deploy, so caches are kept short.
+func (h *Handlers) GetJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.JWT.JWKS())
+}
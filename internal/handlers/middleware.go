@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fileTransfer/internal/config"
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/utils"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const userContextKey = "user"
+
+// apiTokenTouchInterval is how often the last used time of an API token is written, not on every request
+const apiTokenTouchInterval = time.Minute
+
+var (
+	errNoToken  = errors.New("no bearer token or session cookie")
+	errCSRF     = errors.New("invalid CSRF token")
+	errDisabled = errors.New("account is disabled")
+)
+
+// scopeError is returned for an API token used on a route it has no scope for. An empty scope means the route
+// doesn't take API tokens at all.
+type scopeError struct {
+	scope string
+}
+
+func (e *scopeError) Error() string {
+	if e.scope == "" {
+		return "API tokens can't be used here"
+	}
+	return "API token lacks the " + e.scope + " scope"
+}
+
+// RequireAuth rejects requests without a valid bearer token or session cookie and stores the user in the context.
+// API tokens are only accepted when scopes are given, and must have all of them.
+func (h *Handlers) RequireAuth(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := h.authenticate(c, scopes)
+		if abortForbidden(c, err) {
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		c.Set(userContextKey, user)
+		c.Next()
+	}
+}
+
+// OptionalAuth stores the user in the context when a token is sent, but lets anonymous requests through.
+// Scopes work as in RequireAuth.
+func (h *Handlers) OptionalAuth(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := h.authenticate(c, scopes)
+		if errors.Is(err, errNoToken) {
+			c.Next()
+			return
+		}
+		if abortForbidden(c, err) {
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		c.Set(userContextKey, user)
+		c.Next()
+	}
+}
+
+// abortForbidden answers 403 for a failed CSRF or scope check, or a disabled account
+func abortForbidden(c *gin.Context, err error) bool {
+	var scopeErr *scopeError
+	switch {
+	case errors.Is(err, errCSRF):
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Invalid CSRF token"})
+	case errors.Is(err, errDisabled):
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Your account is disabled"})
+	case errors.As(err, &scopeErr):
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": scopeErr.Error()})
+	default:
+		return false
+	}
+	return true
+}
+
+// authenticate reads the bearer token API clients send, an API token or an access token, or else the session
+// cookie browsers send. Disabled users are turned away whatever they authenticate with.
+func (h *Handlers) authenticate(c *gin.Context, scopes []string) (*models.GoogleUser, error) {
+	user, err := h.authenticateToken(c, scopes)
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled {
+		return nil, errDisabled
+	}
+	return user, nil
+}
+
+func (h *Handlers) authenticateToken(c *gin.Context, scopes []string) (*models.GoogleUser, error) {
+	header := c.GetHeader("Authorization")
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if ok && strings.HasPrefix(token, models.ApiTokenPrefix) {
+		return h.authenticateApiToken(token, scopes)
+	}
+	if !ok || token == "" {
+		session, err := c.Cookie(sessionCookie)
+		if err != nil || session == "" {
+			return nil, errNoToken
+		}
+		// Browsers attach cookies to requests from any site, so state-changing ones must echo the CSRF cookie
+		if !isSafeMethod(c.Request.Method) && !validCSRF(c) {
+			return nil, errCSRF
+		}
+		token = session
+	}
+
+	claims, err := h.parseAccessToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.UserDbRepo.FindUserByEmail(claims.Email)
+}
+
+// authenticateApiToken checks the token is live and has the scopes, recording when it was last used
+func (h *Handlers) authenticateApiToken(token string, scopes []string) (*models.GoogleUser, error) {
+	apiToken, err := h.ApiTokenDbRepo.GetApiTokenByTokenHash(utils.HashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if apiToken.Revoked || (apiToken.ExpiresAt != nil && now.After(*apiToken.ExpiresAt)) {
+		return nil, errors.New("api token is revoked or expired")
+	}
+	if len(scopes) == 0 {
+		return nil, &scopeError{}
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(apiToken.Scopes, scope) {
+			return nil, &scopeError{scope: scope}
+		}
+	}
+
+	if apiToken.LastUsedAt == nil || now.Sub(*apiToken.LastUsedAt) > apiTokenTouchInterval {
+		if err := h.ApiTokenDbRepo.TouchApiToken(apiToken.ID, now); err != nil {
+			log.Printf("Failed to record use of api token %s: %v", apiToken.ID, err)
+		}
+	}
+	return h.UserDbRepo.FindUserByID(apiToken.UserId)
+}
+
+// bearerClaims parses the bearer token only, ignoring the session cookie
+func (h *Handlers) bearerClaims(c *gin.Context) (*utils.Claims, error) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, errNoToken
+	}
+	return h.parseAccessToken(token)
+}
+
+// parseAccessToken checks the token's signature and expiry, and that its session wasn't revoked. The deny list is
+// kept in memory, so this needs no database round trip.
+func (h *Handlers) parseAccessToken(token string) (*utils.Claims, error) {
+	claims, err := h.JWT.ParseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if claims == nil || claims.SessionId == "" {
+		return nil, errors.New("invalid token")
+	}
+	if h.Sessions.IsRevoked(claims.SessionId) {
+		return nil, utils.ErrSessionRevoked
+	}
+	return claims, nil
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// validCSRF checks the double-submitted CSRF token, the header must match the cookie
+func validCSRF(c *gin.Context) bool {
+	cookie, err := c.Cookie(csrfCookie)
+	header := c.GetHeader(csrfHeader)
+	return err == nil && cookie != "" && subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) == 1
+}
+
+// RequireRole only lets through authenticated users with one of the roles, it must run after RequireAuth
+func (h *Handlers) RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if user := currentUser(c); user == nil || !slices.Contains(roles, userRole(user)) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireAdmin only lets through admins, it must run after RequireAuth
+func (h *Handlers) RequireAdmin() gin.HandlerFunc {
+	return h.RequireRole(models.RoleAdmin)
+}
+
+// userRole is the role stored on the user, except that accounts listed in ADMIN_EMAILS are always admins, so
+// there is a way to appoint the first one
+func userRole(user *models.GoogleUser) string {
+	if isListedAdmin(user) {
+		return models.RoleAdmin
+	}
+	if user.Role == "" {
+		return models.RoleUser
+	}
+	return user.Role
+}
+
+func isListedAdmin(user *models.GoogleUser) bool {
+	return slices.ContainsFunc(config.AdminEmails, func(e string) bool { return strings.EqualFold(e, user.Email) })
+}
+
+func isAdmin(user *models.GoogleUser) bool {
+	return user != nil && userRole(user) == models.RoleAdmin
+}
+
+// currentUser returns the authenticated user, or nil for anonymous requests
+func currentUser(c *gin.Context) *models.GoogleUser {
+	if v, ok := c.Get(userContextKey); ok {
+		return v.(*models.GoogleUser)
+	}
+	return nil
+}
+
+// currentUserId returns the id files are attributed to for this request
+func currentUserId(c *gin.Context) string {
+	if user := currentUser(c); user != nil {
+		return user.ID
+	}
+	return models.AnonymousUserId
+}
+
+// redactedQueryParams are never written to the request log
+var redactedQueryParams = []string{"code", "state", "token", "id_token", "access_token", "refresh_token"}
+
+// redactedPathPrefixes are followed by a path segment that works as a bearer token, such as an upload request's
+var redactedPathPrefixes = []string{"/public/uploadRequests/"}
+
+// LogFormatter is gin's request log line with OAuth codes and tokens in the query and path replaced
+func LogFormatter(param gin.LogFormatterParams) string {
+	path := redactPath(param.Path)
+	if p, rawQuery, ok := strings.Cut(path, "?"); ok {
+		if query, err := url.ParseQuery(rawQuery); err == nil {
+			for _, name := range redactedQueryParams {
+				if query.Has(name) {
+					query.Set(name, "REDACTED")
+				}
+			}
+			path = p + "?" + query.Encode()
+		} else {
+			path = p
+		}
+	}
+
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"), param.StatusCode, param.Latency, param.ClientIP,
+		param.Method, path, param.ErrorMessage)
+}
+
+// redactPath replaces the token segment after a redacted prefix, keeping the rest of the path
+func redactPath(path string) string {
+	for _, prefix := range redactedPathPrefixes {
+		rest, ok := strings.CutPrefix(path, prefix)
+		if !ok {
+			continue
+		}
+		end := strings.IndexAny(rest, "/?")
+		if end < 0 {
+			end = len(rest)
+		}
+		return prefix + "REDACTED" + rest[end:]
+	}
+	return path
+}
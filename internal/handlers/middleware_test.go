@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"database/sql"
+	"fileTransfer/internal/config"
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/repository"
+	"fileTransfer/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authUsers finds users by email and id, every other method is unused
+type authUsers struct {
+	repository.UserDbRepo
+	users []*models.GoogleUser
+}
+
+func (r *authUsers) FindUserByEmail(email string) (*models.GoogleUser, error) {
+	for _, u := range r.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (r *authUsers) FindUserByID(id string) (*models.GoogleUser, error) {
+	for _, u := range r.users {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// revokingRefreshTokens only revokes sessions, every other method is unused
+type revokingRefreshTokens struct {
+	repository.RefreshTokenDbRepo
+}
+
+func (revokingRefreshTokens) RevokeFamily(familyId string, at time.Time) error {
+	return nil
+}
+
+func newAuthTestHandlers(t *testing.T, users ...*models.GoogleUser) *Handlers {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	jwt, err := utils.NewJWTService(config.JWTConfig{Issuer: "fileTransfer", Audience: "fileTransfer", EphemeralKey: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Handlers{UserDbRepo: &authUsers{users: users}, JWT: jwt,
+		Sessions: utils.NewSessionService(revokingRefreshTokens{}, nil, jwt, config.SessionConfig{AccessTTL: time.Minute})}
+}
+
+// serveAuth runs a request through the middleware to a handler answering 200
+func serveAuth(h *Handlers, middleware gin.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.Any("/test", middleware, func(c *gin.Context) { c.Status(http.StatusOK) })
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRequireAuthCSRF(t *testing.T) {
+	user := &models.GoogleUser{ID: "user", Email: "user@example.com"}
+	disabled := &models.GoogleUser{ID: "disabled", Email: "disabled@example.com", Disabled: true}
+	h := newAuthTestHandlers(t, user, disabled)
+	token := func(email, sessionId string) string {
+		token, err := h.JWT.GenerateToken(email, sessionId, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	session := token(user.Email, "session")
+	if err := h.Sessions.Revoke("revoked"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		session    string
+		csrfCookie string
+		csrfHeader string
+		bearer     string
+		wantStatus int
+	}{
+		{name: "no credentials", method: http.MethodGet, wantStatus: http.StatusUnauthorized},
+		{name: "GET needs no CSRF token", method: http.MethodGet, session: session, wantStatus: http.StatusOK},
+		{name: "HEAD needs no CSRF token", method: http.MethodHead, session: session, wantStatus: http.StatusOK},
+		{name: "POST with matching CSRF token", method: http.MethodPost, session: session, csrfCookie: "csrf",
+			csrfHeader: "csrf", wantStatus: http.StatusOK},
+		{name: "POST without CSRF header", method: http.MethodPost, session: session, csrfCookie: "csrf",
+			wantStatus: http.StatusForbidden},
+		{name: "POST without CSRF cookie", method: http.MethodPost, session: session, csrfHeader: "csrf",
+			wantStatus: http.StatusForbidden},
+		{name: "POST with empty CSRF tokens", method: http.MethodPost, session: session, wantStatus: http.StatusForbidden},
+		{name: "DELETE with another CSRF token", method: http.MethodDelete, session: session, csrfCookie: "csrf",
+			csrfHeader: "other", wantStatus: http.StatusForbidden},
+		{name: "bearer token needs no CSRF token", method: http.MethodPost, bearer: session, wantStatus: http.StatusOK},
+		{name: "bearer token wins over the cookie", method: http.MethodPost, session: "garbage", bearer: session,
+			wantStatus: http.StatusOK},
+		{name: "invalid session cookie", method: http.MethodGet, session: "garbage", wantStatus: http.StatusUnauthorized},
+		{name: "CSRF is checked before the session", method: http.MethodPost, session: "garbage",
+			wantStatus: http.StatusForbidden},
+		{name: "revoked session", method: http.MethodGet, session: token(user.Email, "revoked"),
+			wantStatus: http.StatusUnauthorized},
+		{name: "token without a session", method: http.MethodGet, session: token(user.Email, ""),
+			wantStatus: http.StatusUnauthorized},
+		{name: "unknown user", method: http.MethodGet, session: token("nobody@example.com", "session"),
+			wantStatus: http.StatusUnauthorized},
+		{name: "disabled user", method: http.MethodGet, session: token(disabled.Email, "session"),
+			wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/test", nil)
+			if tt.session != "" {
+				req.AddCookie(&http.Cookie{Name: sessionCookie, Value: tt.session})
+			}
+			if tt.csrfCookie != "" {
+				req.AddCookie(&http.Cookie{Name: csrfCookie, Value: tt.csrfCookie})
+			}
+			if tt.csrfHeader != "" {
+				req.Header.Set(csrfHeader, tt.csrfHeader)
+			}
+			if tt.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+
+			if w := serveAuth(h, h.RequireAuth(), req); w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+// memApiTokens is an in-memory ApiTokenDbRepo that records touches
+type memApiTokens struct {
+	repository.ApiTokenDbRepo
+	tokens  []*models.ApiToken
+	touched []string
+}
+
+func (r *memApiTokens) GetApiTokenByTokenHash(tokenHash string) (*models.ApiToken, error) {
+	for _, t := range r.tokens {
+		if t.TokenHash == tokenHash {
+			return t, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (r *memApiTokens) TouchApiToken(id string, at time.Time) error {
+	r.touched = append(r.touched, id)
+	return nil
+}
+
+func TestRequireAuthApiTokenScopes(t *testing.T) {
+	user := &models.GoogleUser{ID: "user", Email: "user@example.com"}
+	disabled := &models.GoogleUser{ID: "disabled", Email: "disabled@example.com", Disabled: true}
+	past, future, recent := time.Now().Add(-time.Hour), time.Now().Add(time.Hour), time.Now()
+	tokens := &memApiTokens{}
+	apiToken := func(userId string, scopes []string, expiresAt *time.Time, revoked bool, lastUsedAt *time.Time) string {
+		token, _, err := utils.NewToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		token = models.ApiTokenPrefix + token
+		tokens.tokens = append(tokens.tokens, &models.ApiToken{ID: token, UserId: userId, TokenHash: utils.HashToken(token),
+			Scopes: scopes, ExpiresAt: expiresAt, Revoked: revoked, LastUsedAt: lastUsedAt})
+		return token
+	}
+	read := []string{models.ScopeFilesRead}
+	readWrite := []string{models.ScopeFilesRead, models.ScopeFilesWrite}
+
+	tests := []struct {
+		name       string
+		token      string
+		scopes     []string
+		wantStatus int
+		wantTouch  bool
+	}{
+		{"has the scope", apiToken(user.ID, read, nil, false, nil), read, http.StatusOK, true},
+		{"has every scope", apiToken(user.ID, readWrite, nil, false, nil), readWrite, http.StatusOK, true},
+		{"lacks a scope", apiToken(user.ID, read, nil, false, nil), readWrite, http.StatusForbidden, false},
+		{"lacks the scope", apiToken(user.ID, []string{models.ScopeEmailSend}, nil, false, nil), read,
+			http.StatusForbidden, false},
+		{"route takes no API tokens", apiToken(user.ID, readWrite, nil, false, nil), nil, http.StatusForbidden, false},
+		{"not expired yet", apiToken(user.ID, read, &future, false, nil), read, http.StatusOK, true},
+		{"expired", apiToken(user.ID, read, &past, false, nil), read, http.StatusUnauthorized, false},
+		{"revoked", apiToken(user.ID, read, nil, true, nil), read, http.StatusUnauthorized, false},
+		{"unknown token", models.ApiTokenPrefix + "unknown", read, http.StatusUnauthorized, false},
+		{"used within the touch interval", apiToken(user.ID, read, nil, false, &recent), read, http.StatusOK, false},
+		{"used before the touch interval", apiToken(user.ID, read, nil, false, &past), read, http.StatusOK, true},
+		{"disabled user", apiToken(disabled.ID, read, nil, false, nil), read, http.StatusForbidden, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newAuthTestHandlers(t, user, disabled)
+			h.ApiTokenDbRepo = tokens
+			tokens.touched = nil
+
+			// API tokens are sent as bearer tokens, so state-changing requests need no CSRF token
+			req := httptest.NewRequest(http.MethodPost, "/test", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			if w := serveAuth(h, h.RequireAuth(tt.scopes...), req); w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if touched := len(tokens.touched) > 0; touched != tt.wantTouch {
+				t.Errorf("token use recorded: %v, want %v", touched, tt.wantTouch)
+			}
+		})
+	}
+}
+
+func TestLogFormatter(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"plain path", "/file/listFiles?page=2", `"/file/listFiles?page=2"`},
+		{"OAuth callback", "/auth/google/callback?code=abc&state=xyz", `"/auth/google/callback?code=REDACTED&state=REDACTED"`},
+		{"upload request token", "/public/uploadRequests/secret-token/files", `"/public/uploadRequests/REDACTED/files"`},
+		{"upload request token alone", "/public/uploadRequests/secret-token", `"/public/uploadRequests/REDACTED"`},
+		{"upload request token and query", "/public/uploadRequests/secret-token?token=again",
+			`"/public/uploadRequests/REDACTED?token=REDACTED"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line := LogFormatter(gin.LogFormatterParams{Method: http.MethodGet, Path: tt.path, StatusCode: http.StatusOK})
+			if !strings.Contains(line, tt.want) || strings.Contains(line, "secret-token") {
+				t.Errorf("got %q, want it to contain %s", line, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileRouteScopes(t *testing.T) {
+	user := &models.GoogleUser{ID: "user", Email: "user@example.com"}
+	tokens := &memApiTokens{}
+	apiToken := func(scopes ...string) string {
+		token, _, err := utils.NewToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		token = models.ApiTokenPrefix + token
+		tokens.tokens = append(tokens.tokens, &models.ApiToken{ID: token, UserId: user.ID, TokenHash: utils.HashToken(token),
+			Scopes: scopes})
+		return token
+	}
+	h := newAuthTestHandlers(t, user)
+	h.ApiTokenDbRepo = tokens
+	h.FileDbRepo = &memFiles{files: []*models.File{{ID: "file", S3Key: "uploads/report.pdf", UserId: user.ID}}}
+
+	// The same middleware as the /file routes, download stands in for everything behind OptionalAuth
+	router := gin.New()
+	router.GET("/file/listFiles", h.RequireAuth(models.ScopeFilesRead), h.ListFile)
+	router.GET("/file/download", h.OptionalAuth(models.ScopeFilesRead), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		name       string
+		path       string
+		token      string
+		wantStatus int
+	}{
+		{"list anonymously", "/file/listFiles", "", http.StatusUnauthorized},
+		{"list with files:read", "/file/listFiles", apiToken(models.ScopeFilesRead), http.StatusOK},
+		{"list with files:write only", "/file/listFiles", apiToken(models.ScopeFilesWrite), http.StatusForbidden},
+		{"list with email:send only", "/file/listFiles", apiToken(models.ScopeEmailSend), http.StatusForbidden},
+		// files:read doesn't gate downloads, which work without a login, it only limits the tokens sent there
+		{"download anonymously", "/file/download", "", http.StatusOK},
+		{"download with files:read", "/file/download", apiToken(models.ScopeFilesRead), http.StatusOK},
+		{"download with email:send only", "/file/download", apiToken(models.ScopeEmailSend), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if w.Code == http.StatusOK && tt.path == "/file/listFiles" && !strings.Contains(w.Body.String(), "uploads/report.pdf") {
+				t.Errorf("listing lacks the user's file: %s", w.Body)
+			}
+		})
+	}
+}
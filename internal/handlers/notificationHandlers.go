@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"fileTransfer/internal/dto"
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/utils"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"time"
+)
+
+func (h *Handlers) GetPreferences(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"downloadNotify": currentUser(c).DownloadNotify})
+}
+
+func (h *Handlers) UpdatePreferences(c *gin.Context) {
+	var body dto.PreferencesBody
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	switch body.DownloadNotify {
+	case models.DownloadNotifyOff, models.DownloadNotifyFirst, models.DownloadNotifyEvery, models.DownloadNotifyDigest:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "downloadNotify must be one of off, first, every, digest"})
+		return
+	}
+
+	if err := h.UserDbRepo.SetDownloadNotify(currentUser(c).ID, body.DownloadNotify); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"downloadNotify": body.DownloadNotify})
+}
+
+// recordDownload stores a download event and, for completed transfers, notifies the owner according to their preference
+func (h *Handlers) recordDownload(c *gin.Context, file *models.File, bytesSent int64, completed bool) {
+	downloadedBy := ""
+	if user := currentUser(c); user != nil {
+		downloadedBy = user.Email
+	}
+
+	event := models.NewDownloadEvent(uuid.New().String(), file.ID, c.Request.URL.RequestURI(), time.Now().UTC(),
+		c.ClientIP(), c.Request.UserAgent(), downloadedBy, bytesSent, completed)
+	if err := h.DownloadEventDbRepo.AddDownloadEvent(event); err != nil {
+		log.Printf("Failed to record download event: %v", err)
+		return
+	}
+
+	h.Webhooks.Publish(models.EventFileDownloaded, file.UserId, gin.H{"file": utils.FileEventData(file),
+		"downloadedAt": event.DownloadedAt, "bytesSent": bytesSent, "completed": completed})
+
+	if !completed {
+		h.markNotified(event)
+		return
+	}
+	go h.notifyDownload(file, event, file.DownloadCount == 0)
+}
+
+func (h *Handlers) notifyDownload(file *models.File, event *models.DownloadEvent, first bool) {
+	owner, err := h.UserDbRepo.FindUserByID(file.UserId)
+	if err != nil {
+		// Anonymous uploads have no owner to notify
+		return
+	}
+
+	switch owner.DownloadNotify {
+	case models.DownloadNotifyDigest:
+		return
+	case models.DownloadNotifyEvery:
+	case models.DownloadNotifyFirst:
+		if !first {
+			h.markNotified(event)
+			return
+		}
+	default:
+		h.markNotified(event)
+		return
+	}
+
+	name := utils.FileDisplayName(file.Name, file.Encrypted)
+	line := fmt.Sprintf("Your file %s was downloaded by %s at %s.", name, utils.DownloaderLabel(event),
+		event.DownloadedAt.Format(time.RFC1123))
+	rendered, err := utils.RenderNotification(utils.NotificationData{Title: "Your file was downloaded", Lines: []string{line}})
+	if err != nil {
+		log.Printf("Failed to render download notification: %v", err)
+		return
+	}
+
+	// A suppressed owner is marked notified all the same, so the download isn't retried
+	subject := "Your file " + name + " was downloaded"
+	if err := utils.SendNotification(h.EmailOutbox, h.EmailGuard, owner.ID, owner.Email, subject, rendered); err != nil {
+		log.Printf("Failed to queue download notification: %v", err)
+		return
+	}
+	h.markNotified(event)
+}
+
+func (h *Handlers) markNotified(event *models.DownloadEvent) {
+	if err := h.DownloadEventDbRepo.MarkEventsNotified([]string{event.ID}); err != nil {
+		log.Printf("Failed to mark download event notified: %v", err)
+	}
+}
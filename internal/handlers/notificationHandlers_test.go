@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fileTransfer/internal/config"
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRecordDownload(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	owner := &models.GoogleUser{ID: "owner", Email: "owner@example.com", DownloadNotify: models.DownloadNotifyDigest}
+	file := &models.File{ID: "file", UserId: owner.ID, Name: "report.pdf"}
+
+	tests := []struct {
+		name         string
+		user         *models.GoogleUser
+		completed    bool
+		wantBy       string
+		wantNotified bool
+	}{
+		{"signed in recipient", &models.GoogleUser{ID: "recipient", Email: "recipient@example.com"}, true, "recipient@example.com", false},
+		{"anonymous recipient", nil, true, "", false},
+		// An aborted transfer is never reported to the owner
+		{"aborted transfer", nil, false, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := &memDownloadEvents{}
+			webhooks := &memWebhooks{}
+			h := &Handlers{DownloadEventDbRepo: events, UserDbRepo: &memUsers{users: []*models.GoogleUser{owner}},
+				Webhooks: utils.NewWebhookService(webhooks, false)}
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/file/download?key=uploads/report.pdf", nil)
+			c.Request.RemoteAddr = "203.0.113.7:1234"
+			c.Request.Header.Set("User-Agent", "curl/8.0")
+			if tt.user != nil {
+				c.Set(userContextKey, tt.user)
+			}
+
+			h.recordDownload(c, file, 42, tt.completed)
+
+			if len(events.events) != 1 {
+				t.Fatalf("recorded %d events", len(events.events))
+			}
+			e := events.events[0]
+			if e.FileId != "file" || e.Link != "/file/download?key=uploads/report.pdf" || e.ClientIP != "203.0.113.7" ||
+				e.UserAgent != "curl/8.0" || e.DownloadedBy != tt.wantBy || e.BytesSent != 42 || e.Completed != tt.completed {
+				t.Errorf("recorded %+v", e)
+			}
+			if len(webhooks.deliveries) != 1 || webhooks.deliveries[0].EventType != models.EventFileDownloaded {
+				t.Errorf("queued webhooks %+v", webhooks.deliveries)
+			}
+			if notified := len(events.notified) == 1; notified != tt.wantNotified {
+				t.Errorf("notified %v, want %v", events.notified, tt.wantNotified)
+			}
+			if e.ID == "" || time.Since(e.DownloadedAt) > time.Minute {
+				t.Errorf("event has no id or time: %+v", e)
+			}
+		})
+	}
+}
+
+func TestNotifyDownload(t *testing.T) {
+	tests := []struct {
+		name         string
+		notify       string
+		noOwner      bool
+		first        bool
+		queueErr     error
+		suppressed   bool
+		wantQueued   bool
+		wantNotified bool
+	}{
+		{name: "every download", notify: models.DownloadNotifyEvery, wantQueued: true, wantNotified: true},
+		{name: "first download", notify: models.DownloadNotifyFirst, first: true, wantQueued: true, wantNotified: true},
+		// Nothing will ever report these, so they are marked at once
+		{name: "first mode, later download", notify: models.DownloadNotifyFirst, wantNotified: true},
+		{name: "off", notify: models.DownloadNotifyOff, first: true, wantNotified: true},
+		// Left for the daily digest
+		{name: "digest", notify: models.DownloadNotifyDigest, first: true},
+		{name: "anonymous upload", noOwner: true, first: true},
+		// A bounced owner is not emailed again, the download counts as reported
+		{name: "suppressed owner", notify: models.DownloadNotifyEvery, suppressed: true, wantNotified: true},
+		{name: "unqueued email stays unreported", notify: models.DownloadNotifyEvery, queueErr: errors.New("db down")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &memUsers{}
+			if !tt.noOwner {
+				users.users = []*models.GoogleUser{{ID: "owner", Email: "owner@example.com", DownloadNotify: tt.notify}}
+			}
+			events := &memDownloadEvents{}
+			outbox := &memOutbox{err: tt.queueErr}
+			abuse := &memEmailAbuse{}
+			if tt.suppressed {
+				abuse.suppressed = []string{"owner@example.com"}
+			}
+			guard, err := utils.NewEmailGuard(abuse, config.MailLimitConfig{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := &Handlers{DownloadEventDbRepo: events, UserDbRepo: users, EmailGuard: guard,
+				EmailOutbox: utils.NewEmailOutbox(outbox, &fakeMailer{}, nil, 3)}
+			event := &models.DownloadEvent{ID: "event", FileId: "file", DownloadedAt: time.Now(), ClientIP: "203.0.113.7"}
+
+			h.notifyDownload(&models.File{ID: "file", UserId: "owner", Name: "report.pdf"}, event, tt.first)
+
+			if queued := len(outbox.emails) == 1; queued != tt.wantQueued {
+				t.Fatalf("queued %d emails, want queued %v", len(outbox.emails), tt.wantQueued)
+			}
+			if tt.wantQueued {
+				var msg utils.Message
+				if err := json.Unmarshal([]byte(outbox.emails[0].Message), &msg); err != nil {
+					t.Fatal(err)
+				}
+				if outbox.emails[0].UserId != "owner" || msg.To[0].Address != "owner@example.com" ||
+					!strings.Contains(msg.Subject, "report.pdf") || !strings.Contains(msg.Text, "anonymous recipient (203.0.113.7)") {
+					t.Errorf("queued %+v", msg)
+				}
+			}
+			if notified := len(events.notified) == 1 && events.notified[0] == "event"; notified != tt.wantNotified {
+				t.Errorf("notified %v, want %v", events.notified, tt.wantNotified)
+			}
+		})
+	}
+}
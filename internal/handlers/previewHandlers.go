@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fileTransfer/internal/utils"
+	"github.com/aws/smithy-go"
+	"github.com/gin-gonic/gin"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+const maxTextPreviewSize = 1024 * 1024
+
+// previewCSP keeps anything served inline from running scripts or loading other resources
+const previewCSP = "default-src 'none'; img-src 'self'; media-src 'self'; style-src 'unsafe-inline'; sandbox"
+
+// inlineMediaTypes is the allowlist of media that may be rendered by the browser
+var inlineMediaTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".mp3":  "audio/mpeg",
+	".wav":  "audio/wav",
+	".ogg":  "audio/ogg",
+	".oga":  "audio/ogg",
+	".m4a":  "audio/mp4",
+	".flac": "audio/flac",
+	".mp4":  "video/mp4",
+	".webm": "video/webm",
+	".ogv":  "video/ogg",
+}
+
+// PreviewFile renders allowlisted files inline and forces everything else to download
+func (h *Handlers) PreviewFile(c *gin.Context) {
+	key := c.Query("key")
+	if key == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing file key"})
+		return
+	}
+
+	file, err := h.FileDbRepo.GetFileByKey(key)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if isExpired(file) {
+		c.JSON(http.StatusGone, gin.H{"error": "File has expired"})
+		return
+	}
+	if file.OwnerDisabled {
+		c.JSON(http.StatusGone, gin.H{"error": "File is no longer available"})
+		return
+	}
+	// Previews don't count as downloads, so they would let anyone bypass the limit
+	if file.MaxDownloads > 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Preview is not available for files with a download limit"})
+		return
+	}
+
+	setPreviewSecurityHeaders(c)
+	ext := strings.ToLower(filepath.Ext(file.Name))
+
+	switch {
+	case file.Encrypted:
+		h.streamPreview(c, file.S3Key, "attachment", "download", "application/octet-stream")
+	case inlineMediaTypes[ext] != "":
+		h.streamPreview(c, file.S3Key, "inline", file.Name, inlineMediaTypes[ext])
+	case utils.IsPreviewableText(file.Name) && file.Size <= maxTextPreviewSize:
+		h.renderTextPreview(c, file.S3Key, file.Name)
+	default:
+		h.streamPreview(c, file.S3Key, "attachment", file.Name, "application/octet-stream")
+	}
+}
+
+func setPreviewSecurityHeaders(c *gin.Context) {
+	c.Header("Content-Security-Policy", previewCSP)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("X-Frame-Options", "SAMEORIGIN")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Header("Cross-Origin-Resource-Policy", "same-origin")
+}
+
+// streamPreview streams the object, passing Range requests through to S3 so media can seek
+func (h *Handlers) streamPreview(c *gin.Context, key, disposition, filename, contentType string) {
+	rangeHeader := ""
+	if disposition == "inline" {
+		rangeHeader = c.GetHeader("Range")
+	}
+
+	resp, err := h.AwsS3.DownloadFileRange(key, rangeHeader)
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidRange" {
+		c.JSON(http.StatusRequestedRangeNotSatisfiable, gin.H{"error": "Invalid range"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load file", "details": err.Error()})
+		return
+	}
+	defer resp.Body.Close()
+
+	status := http.StatusOK
+	if resp.ContentRange != nil {
+		status = http.StatusPartialContent
+		c.Header("Content-Range", *resp.ContentRange)
+	}
+	c.Header("Accept-Ranges", "bytes")
+	c.Header("Content-Disposition", dispositionHeader(disposition, filename))
+
+	c.DataFromReader(status, *resp.ContentLength, contentType, resp.Body, nil)
+}
+
+func (h *Handlers) renderTextPreview(c *gin.Context, key, name string) {
+	resp, err := h.AwsS3.DownloadFile(key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load file", "details": err.Error()})
+		return
+	}
+	defer resp.Body.Close()
+
+	src, err := io.ReadAll(io.LimitReader(resp.Body, maxTextPreviewSize))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load file", "details": err.Error()})
+		return
+	}
+
+	page, err := utils.RenderPreviewHTML(name, src)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", dispositionHeader("inline", name))
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(page))
+}
+
+// dispositionHeader builds a Content-Disposition value, falling back to a generic name the header can't carry
+func dispositionHeader(disposition, name string) string {
+	if name == "" {
+		return disposition
+	}
+	if v := mime.FormatMediaType(disposition, map[string]string{"filename": name}); v != "" {
+		return v
+	}
+	return mime.FormatMediaType(disposition, map[string]string{"filename": "download" + filepath.Ext(name)})
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"fileTransfer/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPreviewFile(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, awsS3 := newFakeS3(t)
+	later := time.Now().Add(time.Hour)
+	files := &memFiles{}
+	add := func(name string, data string, edit func(f *models.File)) string {
+		key := "uploads/" + name
+		f := &models.File{S3Key: key, Name: name, Size: int64(len(data)), ExpirationDate: later}
+		if edit != nil {
+			edit(f)
+		}
+		files.files = append(files.files, f)
+		store.put(key, []byte(data))
+		return key
+	}
+	image := add("photo.png", "0123456789", nil)
+	code := add("main.go", `package main // <script>alert("x")</script>`, nil)
+	archive := add("archive.zip", "0123456789", nil)
+	encrypted := add("secret.png", "ciphertext", func(f *models.File) { f.Encrypted = true })
+	limited := add("limited.png", "0123456789", func(f *models.File) { f.MaxDownloads = 3 })
+	expired := add("old.png", "0123456789", func(f *models.File) { f.ExpirationDate = time.Now().Add(-time.Hour) })
+	h := &Handlers{FileDbRepo: files, AwsS3: awsS3}
+
+	tests := []struct {
+		name            string
+		key             string
+		rangeHeader     string
+		wantStatus      int
+		wantType        string
+		wantDisposition string
+		wantBody        string
+		wantRange       string
+	}{
+		{name: "image inline", key: image, wantStatus: http.StatusOK, wantType: "image/png",
+			wantDisposition: `inline; filename=photo.png`, wantBody: "0123456789"},
+		{name: "image range", key: image, rangeHeader: "bytes=2-5", wantStatus: http.StatusPartialContent,
+			wantType: "image/png", wantDisposition: `inline; filename=photo.png`, wantBody: "2345", wantRange: "bytes 2-5/10"},
+		{name: "open range", key: image, rangeHeader: "bytes=7-", wantStatus: http.StatusPartialContent,
+			wantType: "image/png", wantBody: "789", wantRange: "bytes 7-9/10"},
+		{name: "range past the end", key: image, rangeHeader: "bytes=20-30", wantStatus: http.StatusRequestedRangeNotSatisfiable},
+		{name: "downloads ignore ranges", key: archive, rangeHeader: "bytes=2-5", wantStatus: http.StatusOK,
+			wantType: "application/octet-stream", wantDisposition: `attachment; filename=archive.zip`, wantBody: "0123456789"},
+		{name: "code is rendered escaped", key: code, wantStatus: http.StatusOK, wantType: "text/html; charset=utf-8",
+			wantDisposition: `inline; filename=main.go`, wantBody: `&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;`},
+		{name: "encrypted files are downloaded", key: encrypted, wantStatus: http.StatusOK,
+			wantType: "application/octet-stream", wantDisposition: `attachment; filename=download`, wantBody: "ciphertext"},
+		{name: "download limit", key: limited, wantStatus: http.StatusForbidden},
+		{name: "expired", key: expired, wantStatus: http.StatusGone},
+		{name: "unknown key", key: "uploads/missing", wantStatus: http.StatusNotFound},
+		{name: "no key", key: "", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/file/preview", h.PreviewFile)
+			req := httptest.NewRequest(http.MethodGet, "/file/preview?key="+tt.key, nil)
+			if tt.rangeHeader != "" {
+				req.Header.Set("Range", tt.rangeHeader)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if w.Code >= 300 {
+				return
+			}
+			// Everything served from here is sandboxed and must not be sniffed into something else
+			if got := w.Header().Get("Content-Security-Policy"); got != previewCSP {
+				t.Errorf("got CSP %q", got)
+			}
+			if got := w.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+				t.Errorf("got X-Content-Type-Options %q", got)
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("got Content-Type %q, want %q", got, tt.wantType)
+			}
+			if tt.wantDisposition != "" && w.Header().Get("Content-Disposition") != tt.wantDisposition {
+				t.Errorf("got Content-Disposition %q, want %q", w.Header().Get("Content-Disposition"), tt.wantDisposition)
+			}
+			if got := w.Header().Get("Content-Range"); got != tt.wantRange {
+				t.Errorf("got Content-Range %q, want %q", got, tt.wantRange)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body %q lacks %q", w.Body, tt.wantBody)
+			}
+			if strings.Contains(w.Body.String(), "<script>") {
+				t.Errorf("body contains unescaped markup: %s", w.Body)
+			}
+		})
+	}
+}
+
+func TestDispositionHeader(t *testing.T) {
+	tests := []struct {
+		disposition, name, want string
+	}{
+		{"inline", "photo.png", "inline; filename=photo.png"},
+		{"attachment", "my report.pdf", `attachment; filename="my report.pdf"`},
+		{"attachment", "résumé.pdf", `attachment; filename*=utf-8''r%C3%A9sum%C3%A9.pdf`},
+		{"attachment", "", "attachment"},
+	}
+	for _, tt := range tests {
+		if got := dispositionHeader(tt.disposition, tt.name); got != tt.want {
+			t.Errorf("dispositionHeader(%q, %q) = %q, want %q", tt.disposition, tt.name, got, tt.want)
+		}
+	}
+}
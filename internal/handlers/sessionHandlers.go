@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"errors"
+	"fileTransfer/internal/config"
+	"fileTransfer/internal/dto"
+	"fileTransfer/internal/utils"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// sessionCookie holds the access token and refreshCookie the refresh token, both out of reach of scripts.
+	// csrfCookie is readable by the frontend, which echoes it in the X-CSRF-Token header to prove a request
+	// didn't come from another site.
+	sessionCookie = "session"
+	refreshCookie = "refresh_token"
+	csrfCookie    = "csrf_token"
+	csrfHeader    = "X-CSRF-Token"
+)
+
+// Me returns the logged in user, and for cookie sessions the CSRF token to send with state-changing requests,
+// since a frontend on another origin can't read the CSRF cookie itself
+func (h *Handlers) Me(c *gin.Context) {
+	user := currentUser(c)
+	response := gin.H{"user": user, "role": userRole(user), "admin": isAdmin(user)}
+	if session, err := c.Cookie(sessionCookie); err == nil && session != "" {
+		csrfToken, err := c.Cookie(csrfCookie)
+		if err != nil || csrfToken == "" {
+			if csrfToken, _, err = utils.NewToken(); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create CSRF token"})
+				return
+			}
+			setCSRFCookie(c, csrfToken)
+		}
+		response["csrfToken"] = csrfToken
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// RefreshSession swaps a refresh token for a new access and refresh token. Browsers send the refresh token in its
+// cookie and get the new tokens in cookies, API clients send it in the body and get them in the response.
+func (h *Handlers) RefreshSession(c *gin.Context) {
+	refreshToken, fromCookie, ok := readRefreshToken(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid CSRF token"})
+		return
+	}
+	if refreshToken == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "No refresh token"})
+		return
+	}
+
+	tokens, err := h.Sessions.Refresh(refreshToken)
+	if errors.Is(err, utils.ErrInvalidRefreshToken) || errors.Is(err, utils.ErrRefreshTokenReused) ||
+		errors.Is(err, utils.ErrSessionRevoked) {
+		if fromCookie {
+			clearSessionCookies(c)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired, please log in again"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to refresh session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh session"})
+		return
+	}
+
+	expiresIn := int(config.Sessions.AccessTTL.Seconds())
+	if fromCookie {
+		setSessionCookies(c, tokens)
+		// Keep the CSRF token the frontend already has, only extending its cookie
+		csrfToken, _ := c.Cookie(csrfCookie)
+		setCSRFCookie(c, csrfToken)
+		c.JSON(http.StatusOK, gin.H{"expiresIn": expiresIn})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"accessToken": tokens.AccessToken, "refreshToken": tokens.RefreshToken,
+		"tokenType": "Bearer", "expiresIn": expiresIn})
+}
+
+// Logout ends the session of the refresh token, or else of the bearer token. The cookies are cleared either way.
+func (h *Handlers) Logout(c *gin.Context) {
+	refreshToken, _, ok := readRefreshToken(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid CSRF token"})
+		return
+	}
+
+	var sessionId string
+	if refreshToken != "" {
+		sessionId, _ = h.Sessions.SessionOf(refreshToken)
+	} else if claims, err := h.bearerClaims(c); err == nil {
+		sessionId = claims.SessionId
+	}
+	if sessionId != "" {
+		if err := h.Sessions.Revoke(sessionId); err != nil {
+			log.Printf("Failed to revoke session %s: %v", sessionId, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+			return
+		}
+	}
+
+	clearSessionCookies(c)
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// LogoutEverywhere ends every session of the user, on all devices
+func (h *Handlers) LogoutEverywhere(c *gin.Context) {
+	user := currentUser(c)
+	if err := h.Sessions.RevokeUser(user.ID); err != nil {
+		log.Printf("Failed to revoke sessions of user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+
+	clearSessionCookies(c)
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out everywhere"})
+}
+
+// readRefreshToken takes the refresh token from the body, or else from its cookie, in which case the request must
+// carry the CSRF token. ok is false when the CSRF check fails.
+func readRefreshToken(c *gin.Context) (token string, fromCookie bool, ok bool) {
+	var body dto.RefreshTokenBody
+	if c.Request.ContentLength != 0 {
+		_ = c.ShouldBindJSON(&body)
+	}
+	if body.RefreshToken != "" {
+		return body.RefreshToken, false, true
+	}
+
+	token, _ = c.Cookie(refreshCookie)
+	if token == "" {
+		return "", false, true
+	}
+	return token, true, validCSRF(c)
+}
+
+// setSessionCookies stores the access and refresh tokens in HttpOnly cookies. The refresh token is only sent to
+// the /auth routes.
+func setSessionCookies(c *gin.Context, tokens *utils.SessionTokens) {
+	c.SetSameSite(config.Auth.CookieSameSite)
+	c.SetCookie(sessionCookie, tokens.AccessToken, int(config.Sessions.AccessTTL.Seconds()), "/",
+		config.Auth.CookieDomain, config.Auth.SecureCookies, true)
+	c.SetCookie(refreshCookie, tokens.RefreshToken, int(config.Sessions.RefreshTTL.Seconds()), "/auth",
+		config.Auth.CookieDomain, config.Auth.SecureCookies, true)
+}
+
+func setCSRFCookie(c *gin.Context, csrfToken string) {
+	c.SetSameSite(config.Auth.CookieSameSite)
+	c.SetCookie(csrfCookie, csrfToken, int(config.Sessions.RefreshTTL.Seconds()), "/", config.Auth.CookieDomain,
+		config.Auth.SecureCookies, false)
+}
+
+func clearSessionCookies(c *gin.Context) {
+	c.SetSameSite(config.Auth.CookieSameSite)
+	c.SetCookie(sessionCookie, "", -1, "/", config.Auth.CookieDomain, config.Auth.SecureCookies, true)
+	c.SetCookie(refreshCookie, "", -1, "/auth", config.Auth.CookieDomain, config.Auth.SecureCookies, true)
+	c.SetCookie(csrfCookie, "", -1, "/", config.Auth.CookieDomain, config.Auth.SecureCookies, false)
+}
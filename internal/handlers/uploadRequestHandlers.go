@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fileTransfer/internal/config"
+	"fileTransfer/internal/dto"
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/repository"
+	"fileTransfer/internal/utils"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultUploadRequestExpiry = 7 * 24 * time.Hour
+
+var errFileTooLarge = errors.New("file exceeds the size limit")
+
+// CreateUploadRequest creates a link that lets anyone upload files into the caller's space
+func (h *Handlers) CreateUploadRequest(c *gin.Context) {
+	var body dto.UploadRequestBody
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	body.Label = strings.TrimSpace(body.Label)
+	if body.Label == "" || len(body.Label) > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Label is required and must be at most 255 characters"})
+		return
+	}
+	if body.MaxFileSize < 0 || body.MaxFiles < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Limits must not be negative"})
+		return
+	}
+
+	expiresIn := defaultUploadRequestExpiry
+	if body.ExpiresIn != "" {
+		d, err := time.ParseDuration(body.ExpiresIn)
+		if err != nil || d <= 0 || d > config.UploadRequests.MaxExpiry {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid expiresIn, must be a duration of at most %s", config.UploadRequests.MaxExpiry)})
+			return
+		}
+		expiresIn = d
+	}
+
+	allowedTypes, err := normalizeAllowedTypes(body.AllowedTypes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, tokenHash, err := utils.NewToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now().UTC()
+	request := models.NewUploadRequest(uuid.New().String(), tokenHash, currentUser(c).ID, body.Label, body.MaxFileSize,
+		body.MaxFiles, allowedTypes, now.Add(expiresIn), now)
+	if err := h.UploadRequestDbRepo.AddUploadRequest(request); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The token is only ever shown here, the db keeps its hash
+	c.JSON(http.StatusOK, gin.H{
+		"request":   request,
+		"link":      frontendURL() + "/upload-request/" + token,
+		"uploadUrl": "/public/uploadRequests/" + token + "/files",
+	})
+}
+
+func (h *Handlers) ListUploadRequests(c *gin.Context) {
+	requests, err := h.UploadRequestDbRepo.ListUploadRequestsByUser(currentUser(c).ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"requests": requests})
+}
+
+func (h *Handlers) RevokeUploadRequest(c *gin.Context) {
+	err := h.UploadRequestDbRepo.RevokeUploadRequest(c.Param("id"), currentUser(c).ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload request not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Upload request revoked"})
+}
+
+// GetPublicUploadRequest describes an upload request to the person holding its link
+func (h *Handlers) GetPublicUploadRequest(c *gin.Context) {
+	request, ok := h.activeUploadRequest(c)
+	if !ok {
+		return
+	}
+
+	remaining := -1
+	if request.MaxFiles > 0 {
+		remaining = max(request.MaxFiles-request.UploadCount, 0)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"label":          request.Label,
+		"maxFileSize":    request.MaxFileSize,
+		"allowedTypes":   request.AllowedTypes,
+		"remainingFiles": remaining,
+		"expiresAt":      request.ExpiresAt,
+	})
+}
+
+// UploadToRequest accepts an unauthenticated upload into the requester's space
+func (h *Handlers) UploadToRequest(c *gin.Context) {
+	if !h.UploadLimiter.Allow(c.ClientIP()) {
+		c.Header("Retry-After", "3600")
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many uploads, please try again later"})
+		return
+	}
+	request, ok := h.activeUploadRequest(c)
+	if !ok {
+		return
+	}
+
+	if request.MaxFileSize > 0 && c.Request.ContentLength > request.MaxFileSize+maxUploadFieldSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": errFileTooLarge.Error()})
+		return
+	}
+
+	_, part, err := readUploadParts(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filename := filepath.Base(part.FileName())
+	if !typeAllowed(request.AllowedTypes, filename) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "File type is not accepted by this request"})
+		return
+	}
+
+	err = h.UploadRequestDbRepo.ClaimUploadSlot(request.ID)
+	if errors.Is(err, repository.ErrUploadRequestFull) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	body := &sizeLimitedReader{r: part, remaining: request.MaxFileSize, limited: request.MaxFileSize > 0}
+	expiry := time.Now().UTC().Add(config.UploadRequests.FileRetention)
+	newFile, err := h.storeFile(filename, body, request.UserId, expiry, func(f *models.File) {
+		f.UploadRequestId = request.ID
+	})
+	if err != nil {
+		if releaseErr := h.UploadRequestDbRepo.ReleaseUploadSlot(request.ID); releaseErr != nil {
+			log.Printf("Failed to release upload slot: %v", releaseErr)
+		}
+		if body.exceeded {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": errFileTooLarge.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.notifyUploadRequestOwner(request, newFile)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Uploaded successfully", "name": newFile.Name, "size": newFile.Size,
+		"checksum_sha256": newFile.Checksum})
+}
+
+// activeUploadRequest looks up the request for the token in the path, writing the error response if it can't be used
+func (h *Handlers) activeUploadRequest(c *gin.Context) (*models.UploadRequest, bool) {
+	request, err := h.UploadRequestDbRepo.GetUploadRequestByTokenHash(utils.HashToken(c.Param("token")))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload request not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if request.Revoked || time.Now().After(request.ExpiresAt) {
+		c.JSON(http.StatusGone, gin.H{"error": "Upload request is no longer accepting files"})
+		return nil, false
+	}
+	owner, err := h.UserDbRepo.FindUserByID(request.UserId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if owner.Disabled {
+		c.JSON(http.StatusGone, gin.H{"error": "Upload request is no longer accepting files"})
+		return nil, false
+	}
+	return request, true
+}
+
+func (h *Handlers) notifyUploadRequestOwner(request *models.UploadRequest, file *models.File) {
+	owner, err := h.UserDbRepo.FindUserByID(request.UserId)
+	if err != nil {
+		log.Printf("Failed to find owner of upload request %s: %v", request.ID, err)
+		return
+	}
+
+	subject := fmt.Sprintf("New file received for \"%s\"", request.Label)
+	lines := []string{
+		fmt.Sprintf("%s (%d bytes) was uploaded to your request \"%s\".", file.Name, file.Size, request.Label),
+		fmt.Sprintf("It will be kept until %s.", file.ExpirationDate.Format(time.RFC1123)),
+	}
+	rendered, err := utils.RenderNotification(utils.NotificationData{Title: "New file received", Lines: lines,
+		Link: frontendURL(), LinkText: "View your files"})
+	if err != nil {
+		log.Printf("Failed to render upload request notification: %v", err)
+		return
+	}
+
+	if err := utils.SendNotification(h.EmailOutbox, h.EmailGuard, owner.ID, owner.Email, subject, rendered); err != nil {
+		log.Printf("Failed to queue upload request notification: %v", err)
+	}
+}
+
+// normalizeAllowedTypes accepts extensions (".log") and MIME types ("text/plain", "image/*")
+func normalizeAllowedTypes(types []string) ([]string, error) {
+	var out []string
+	for _, t := range types {
+		t = strings.ToLower(strings.TrimSpace(t))
+		switch {
+		case t == "":
+			continue
+		case strings.HasPrefix(t, ".") && !strings.ContainsAny(t, ",/ "):
+		case strings.Count(t, "/") == 1 && !strings.ContainsAny(t, ", "):
+		default:
+			return nil, fmt.Errorf("invalid allowed type %q", t)
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+// typeAllowed matches the file extension, or the MIME type it maps to, against the allowed types
+func typeAllowed(allowed []string, filename string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	ext := strings.ToLower(filepath.Ext(filename))
+	mediaType, _, _ := mime.ParseMediaType(mime.TypeByExtension(ext))
+	for _, t := range allowed {
+		switch {
+		case strings.HasPrefix(t, "."):
+			if t == ext {
+				return true
+			}
+		case strings.HasSuffix(t, "/*"):
+			if mediaType != "" && strings.HasPrefix(mediaType, strings.TrimSuffix(t, "*")) {
+				return true
+			}
+		case t == mediaType:
+			return true
+		}
+	}
+	return false
+}
+
+// sizeLimitedReader fails the upload as soon as more than the allowed number of bytes arrive
+type sizeLimitedReader struct {
+	r         io.Reader
+	remaining int64
+	limited   bool
+	exceeded  bool
+}
+
+func (l *sizeLimitedReader) Read(p []byte) (int, error) {
+	if !l.limited {
+		return l.r.Read(p)
+	}
+	if l.remaining < int64(len(p)) {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		l.exceeded = true
+		return 0, errFileTooLarge
+	}
+	return n, err
+}
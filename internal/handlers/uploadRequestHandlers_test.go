@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fileTransfer/internal/config"
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/utils"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestUploadToRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	later := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		request *models.UploadRequest
+		// token is sent instead of the request's own token when set
+		token    string
+		filename string
+		content  string
+		s3Down   bool
+		ownerOff bool
+		// limited clients already used up their hourly uploads
+		limited     bool
+		wantStatus  int
+		wantStored  bool
+		wantRelease bool
+	}{
+		{name: "accepted", request: &models.UploadRequest{ExpiresAt: later}, filename: "report.pdf", content: "pdf",
+			wantStatus: http.StatusOK, wantStored: true},
+		{name: "unknown token", request: &models.UploadRequest{ExpiresAt: later}, token: "unknown",
+			filename: "report.pdf", content: "pdf", wantStatus: http.StatusNotFound},
+		{name: "revoked", request: &models.UploadRequest{ExpiresAt: later, Revoked: true}, filename: "report.pdf",
+			content: "pdf", wantStatus: http.StatusGone},
+		{name: "expired", request: &models.UploadRequest{ExpiresAt: time.Now().Add(-time.Minute)},
+			filename: "report.pdf", content: "pdf", wantStatus: http.StatusGone},
+		{name: "owner disabled", request: &models.UploadRequest{ExpiresAt: later}, ownerOff: true, filename: "report.pdf",
+			content: "pdf", wantStatus: http.StatusGone},
+		{name: "file count reached", request: &models.UploadRequest{ExpiresAt: later, MaxFiles: 2, UploadCount: 2},
+			filename: "report.pdf", content: "pdf", wantStatus: http.StatusConflict},
+		{name: "last file", request: &models.UploadRequest{ExpiresAt: later, MaxFiles: 2, UploadCount: 1},
+			filename: "report.pdf", content: "pdf", wantStatus: http.StatusOK, wantStored: true},
+		{name: "allowed type", request: &models.UploadRequest{ExpiresAt: later, AllowedTypes: []string{"image/*"}},
+			filename: "photo.PNG", content: "png", wantStatus: http.StatusOK, wantStored: true},
+		{name: "disallowed type", request: &models.UploadRequest{ExpiresAt: later, AllowedTypes: []string{".pdf", "image/*"}},
+			filename: "run.exe", content: "exe", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "body larger than the limit", request: &models.UploadRequest{ExpiresAt: later, MaxFileSize: 10},
+			filename: "big.bin", content: strings.Repeat("x", maxUploadFieldSize+100), wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "file larger than the limit", request: &models.UploadRequest{ExpiresAt: later, MaxFileSize: 10},
+			filename: "big.bin", content: strings.Repeat("x", 11), wantStatus: http.StatusRequestEntityTooLarge,
+			wantRelease: true},
+		{name: "file at the limit", request: &models.UploadRequest{ExpiresAt: later, MaxFileSize: 10},
+			filename: "ok.bin", content: strings.Repeat("x", 10), wantStatus: http.StatusOK, wantStored: true},
+		{name: "client over its upload limit", request: &models.UploadRequest{ExpiresAt: later}, limited: true,
+			filename: "report.pdf", content: "pdf", wantStatus: http.StatusTooManyRequests},
+		{name: "storage failure releases the slot", request: &models.UploadRequest{ExpiresAt: later, MaxFiles: 1},
+			filename: "report.pdf", content: "pdf", s3Down: true, wantStatus: http.StatusInternalServerError,
+			wantRelease: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, tokenHash, err := utils.NewToken()
+			if err != nil {
+				t.Fatal(err)
+			}
+			request := *tt.request
+			request.ID, request.TokenHash, request.UserId = "request", tokenHash, "owner"
+			requests := &memUploadRequests{requests: []*models.UploadRequest{&request}}
+			files := &memFiles{}
+			store, awsS3 := newFakeS3(t)
+			if tt.s3Down {
+				awsS3.BucketName = "missing"
+			}
+			webhooks := &memWebhooks{}
+			outbox := &memOutbox{}
+			owner := &models.GoogleUser{ID: "owner", Email: "owner@example.com", Disabled: tt.ownerOff}
+			guard, err := utils.NewEmailGuard(&memEmailAbuse{}, config.MailLimitConfig{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := &Handlers{UploadRequestDbRepo: requests, FileDbRepo: files, AwsS3: awsS3,
+				UserDbRepo: &memUsers{users: []*models.GoogleUser{owner}},
+				Webhooks:   utils.NewWebhookService(webhooks, false), EmailGuard: guard,
+				EmailOutbox: utils.NewEmailOutbox(outbox, &fakeMailer{}, nil, 3), UploadLimiter: utils.NewRateLimiter(1, time.Hour)}
+			if tt.limited {
+				h.UploadLimiter.Allow("192.0.2.1")
+			}
+			if tt.token != "" {
+				token = tt.token
+			}
+
+			router := gin.New()
+			router.POST("/public/uploadRequests/:token/files", h.UploadToRequest)
+			req := newMultipartRequest(t, multipartPart{name: "file", filename: tt.filename, value: tt.content})
+			req.URL.Path = "/public/uploadRequests/" + token + "/files"
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if stored := len(files.files) > 0; stored != tt.wantStored {
+				t.Fatalf("file stored: %v, want %v", stored, tt.wantStored)
+			}
+			if tt.wantStored {
+				f := files.files[0]
+				if f.UserId != "owner" || f.UploadRequestId != "request" || f.Name != tt.filename {
+					t.Errorf("stored %+v", f)
+				}
+				if data, _ := store.get(f.S3Key); string(data) != tt.content {
+					t.Errorf("stored object holds %q, want %q", data, tt.content)
+				}
+				if len(webhooks.deliveries) != 1 || webhooks.deliveries[0].EventType != models.EventFileUploaded {
+					t.Errorf("queued webhooks %+v", webhooks.deliveries)
+				}
+			}
+			// The owner is told about every stored file through the outbox, and about nothing else
+			if queued := len(outbox.emails) == 1; queued != tt.wantStored {
+				t.Fatalf("queued %d owner notifications", len(outbox.emails))
+			}
+			if tt.wantStored {
+				var msg utils.Message
+				if err := json.Unmarshal([]byte(outbox.emails[0].Message), &msg); err != nil {
+					t.Fatal(err)
+				}
+				if outbox.emails[0].UserId != "owner" || msg.To[0].Address != "owner@example.com" ||
+					!strings.Contains(msg.Text, tt.filename) {
+					t.Errorf("queued %+v", msg)
+				}
+			}
+			if released := requests.releases > 0; released != tt.wantRelease {
+				t.Errorf("slot released: %v, want %v", released, tt.wantRelease)
+			}
+			// A failed upload gives its slot back
+			wantCount := tt.request.UploadCount
+			if tt.wantStored {
+				wantCount++
+			}
+			if request.UploadCount != wantCount {
+				t.Errorf("upload count is %d, want %d", request.UploadCount, wantCount)
+			}
+		})
+	}
+}
+
+func TestNormalizeAllowedTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		types   []string
+		want    []string
+		wantErr bool
+	}{
+		{"extensions and MIME types", []string{" .PDF ", "image/*", "text/plain", ""}, []string{".pdf", "image/*", "text/plain"}, false},
+		{"none", nil, nil, false},
+		{"list in one entry", []string{".pdf,.doc"}, nil, true},
+		{"bare word", []string{"pdf"}, nil, true},
+		{"too many slashes", []string{"a/b/c"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeAllowedTypes(tt.types)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTypeAllowed(t *testing.T) {
+	tests := []struct {
+		allowed  []string
+		filename string
+		want     bool
+	}{
+		{nil, "anything.exe", true},
+		{[]string{".pdf"}, "report.PDF", true},
+		{[]string{".pdf"}, "report.pdf.exe", false},
+		{[]string{"image/*"}, "photo.jpg", true},
+		{[]string{"image/*"}, "notes.txt", false},
+		{[]string{"text/plain"}, "notes.txt", true},
+		{[]string{"image/*"}, "noextension", false},
+	}
+	for _, tt := range tests {
+		if got := typeAllowed(tt.allowed, tt.filename); got != tt.want {
+			t.Errorf("typeAllowed(%v, %q) = %v, want %v", tt.allowed, tt.filename, got, tt.want)
+		}
+	}
+}
+
+func TestSizeLimitedReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		limit    int64
+		limited  bool
+		wantErr  error
+		exceeded bool
+	}{
+		{"unlimited", strings.Repeat("x", 100), 0, false, nil, false},
+		{"under the limit", "abc", 5, true, nil, false},
+		{"at the limit", "abcde", 5, true, nil, false},
+		{"one byte over", "abcdef", 5, true, errFileTooLarge, true},
+		{"far over", strings.Repeat("x", 100), 5, true, errFileTooLarge, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Small reads, so the limit is crossed inside a read as well as between reads
+			l := &sizeLimitedReader{r: iotest.HalfReader(strings.NewReader(tt.content)), remaining: tt.limit, limited: tt.limited}
+			data, err := io.ReadAll(l)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if l.exceeded != tt.exceeded {
+				t.Errorf("exceeded is %v, want %v", l.exceeded, tt.exceeded)
+			}
+			if tt.limited && int64(len(data)) > tt.limit {
+				t.Errorf("read %d bytes past a limit of %d", len(data), tt.limit)
+			}
+		})
+	}
+}
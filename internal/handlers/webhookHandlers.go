@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fileTransfer/internal/config"
+	"fileTransfer/internal/dto"
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/utils"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	maxWebhookURLLength    = 2048
+	webhookDeliveryHistory = 50
+)
+
+// CreateWebhook registers an endpoint for the caller's events, admins may register global endpoints that get every user's events
+func (h *Handlers) CreateWebhook(c *gin.Context) {
+	var body dto.WebhookBody
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	user := currentUser(c)
+	if body.Global && !isAdmin(user) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can create global webhooks"})
+		return
+	}
+	if err := validateWebhookURL(body.URL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	events := body.Events
+	if len(events) == 0 {
+		events = slices.Clone(models.WebhookEventTypes)
+	}
+	for _, e := range events {
+		if !slices.Contains(models.WebhookEventTypes, e) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown event %q, must be one of %s", e,
+				strings.Join(models.WebhookEventTypes, ", "))})
+			return
+		}
+	}
+	slices.Sort(events)
+	events = slices.Compact(events)
+
+	secret, _, err := utils.NewToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	endpoint := models.NewWebhookEndpoint(uuid.New().String(), user.ID, body.URL, secret, events, body.Global, time.Now().UTC())
+	if err := h.WebhookDbRepo.AddEndpoint(endpoint); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The secret is only ever shown here, receivers use it to verify X-Webhook-Signature
+	c.JSON(http.StatusOK, gin.H{"webhook": endpoint, "secret": secret})
+}
+
+func (h *Handlers) ListWebhooks(c *gin.Context) {
+	endpoints, err := h.WebhookDbRepo.ListEndpointsByUser(currentUser(c).ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhooks": endpoints})
+}
+
+func (h *Handlers) DeleteWebhook(c *gin.Context) {
+	err := h.WebhookDbRepo.DeleteEndpoint(c.Param("id"), currentUser(c).ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted"})
+}
+
+// ListWebhookDeliveries returns the most recent deliveries to one of the caller's endpoints
+func (h *Handlers) ListWebhookDeliveries(c *gin.Context) {
+	endpoint, ok := h.ownedWebhook(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	deliveries, err := h.WebhookDbRepo.ListDeliveriesByEndpoint(endpoint.ID, webhookDeliveryHistory)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
+
+// GetWebhookDelivery returns a delivery with the status code and error of every attempt
+func (h *Handlers) GetWebhookDelivery(c *gin.Context) {
+	delivery, ok := h.ownedDelivery(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"delivery": delivery})
+}
+
+// RedeliverWebhook queues a delivery to be sent again, whatever its current status
+func (h *Handlers) RedeliverWebhook(c *gin.Context) {
+	delivery, ok := h.ownedDelivery(c)
+	if !ok {
+		return
+	}
+
+	if err := h.WebhookDbRepo.Redeliver(delivery.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Delivery queued"})
+}
+
+// ownedWebhook looks up an endpoint of the caller, writing the error response if there is none
+func (h *Handlers) ownedWebhook(c *gin.Context, id string) (*models.WebhookEndpoint, bool) {
+	endpoint, err := h.WebhookDbRepo.GetEndpoint(id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && endpoint.UserId != currentUser(c).ID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return endpoint, true
+}
+
+func (h *Handlers) ownedDelivery(c *gin.Context) (*models.WebhookDelivery, bool) {
+	delivery, err := h.WebhookDbRepo.GetDelivery(c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Delivery not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if _, ok := h.ownedWebhook(c, delivery.EndpointId); !ok {
+		return nil, false
+	}
+	return delivery, true
+}
+
+func validateWebhookURL(raw string) error {
+	if raw == "" || len(raw) > maxWebhookURLLength {
+		return errors.New("url is required and must be at most 2048 characters")
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || u.User != nil {
+		return errors.New("url must be an absolute URL without credentials")
+	}
+	if u.Scheme != "https" && !(u.Scheme == "http" && config.Webhooks.AllowHTTP) {
+		return errors.New("url must use https")
+	}
+	return nil
+}
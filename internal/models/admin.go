@@ -0,0 +1,65 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AdminAction is an entry of the admin audit log, recording who changed what and why
+type AdminAction struct {
+	ID         string          `json:"id"`
+	ActorId    string          `json:"actor_id"`
+	ActorEmail string          `json:"actor_email"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetId   string          `json:"target_id"`
+	Reason     string          `json:"reason"`
+	Detail     json.RawMessage `json:"detail,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// Admin actions
+const (
+	AdminDisableUser       = "user.disable"
+	AdminEnableUser        = "user.enable"
+	AdminSetRole           = "user.set_role"
+	AdminExpireFile        = "file.expire"
+	AdminDeleteSuppression = "email_suppression.delete"
+)
+
+func NewAdminAction(id string, actorId string, actorEmail string, action string, targetType string, targetId string, reason string, detail json.RawMessage, createdAt time.Time) *AdminAction {
+	return &AdminAction{
+		ID:         id,
+		ActorId:    actorId,
+		ActorEmail: actorEmail,
+		Action:     action,
+		TargetType: targetType,
+		TargetId:   targetId,
+		Reason:     reason,
+		Detail:     detail,
+		CreatedAt:  createdAt,
+	}
+}
+
+// UserUsage is a user with what they store, counting files that haven't expired
+type UserUsage struct {
+	GoogleUser
+	FileCount    int        `json:"file_count"`
+	StorageBytes int64      `json:"storage_bytes"`
+	LastUploadAt *time.Time `json:"last_upload_at"`
+}
+
+// StorageStats are the system-wide totals. Expired files are counted until the cleanup deletes them.
+type StorageStats struct {
+	Users          int   `json:"users"`
+	DisabledUsers  int   `json:"disabled_users"`
+	Files          int   `json:"files"`
+	ActiveFiles    int   `json:"active_files"`
+	ExpiredFiles   int   `json:"expired_files"`
+	EncryptedFiles int   `json:"encrypted_files"`
+	StorageBytes   int64 `json:"storage_bytes"`
+	ActiveBytes    int64 `json:"active_bytes"`
+	UploadsLastDay int   `json:"uploads_last_day"`
+	BytesLastDay   int64 `json:"bytes_last_day"`
+	Downloads      int64 `json:"downloads"`
+}
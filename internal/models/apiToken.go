@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+)
+
+// Scopes an API token can be given
+const (
+	ScopeFilesRead  = "files:read"
+	ScopeFilesWrite = "files:write"
+	ScopeEmailSend  = "email:send"
+)
+
+var ApiTokenScopes = []string{ScopeFilesRead, ScopeFilesWrite, ScopeEmailSend}
+
+// ApiTokenPrefix starts every API token, telling them apart from session JWTs and making leaked ones easy to find
+const ApiTokenPrefix = "ft_"
+
+// ApiToken lets scripts and pipelines act as a user within its scopes. Only the token's hash is kept, Prefix is the
+// start of the token so users can tell their tokens apart.
+type ApiToken struct {
+	ID         string     `json:"id"`
+	UserId     string     `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	TokenHash  string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Revoked    bool       `json:"revoked"`
+}
+
+func NewApiToken(id string, userId string, name string, prefix string, tokenHash string, scopes []string, createdAt time.Time, expiresAt *time.Time) *ApiToken {
+	return &ApiToken{
+		ID:        id,
+		UserId:    userId,
+		Name:      name,
+		Prefix:    prefix,
+		TokenHash: tokenHash,
+		Scopes:    scopes,
+		CreatedAt: createdAt,
+		ExpiresAt: expiresAt,
+	}
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+)
+
+// Device code statuses
+const (
+	DeviceCodePending  = "pending"
+	DeviceCodeApproved = "approved"
+	DeviceCodeDenied   = "denied"
+	DeviceCodeConsumed = "consumed"
+)
+
+// DeviceCode is a login started on a device without a browser (RFC 8628). The device polls with the device code,
+// which is only stored hashed, while the user approves the short user code in a browser.
+type DeviceCode struct {
+	ID             string     `json:"id"`
+	DeviceCodeHash string     `json:"-"`
+	UserCode       string     `json:"user_code"`
+	ClientName     string     `json:"client_name"`
+	Scopes         []string   `json:"scopes"`
+	ClientIP       string     `json:"-"`
+	Status         string     `json:"status"`
+	UserId         string     `json:"user_id,omitempty"`
+	PollInterval   int        `json:"poll_interval"`
+	LastPolledAt   *time.Time `json:"-"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+}
+
+func NewDeviceCode(id string, deviceCodeHash string, userCode string, clientName string, scopes []string, clientIP string, pollInterval int, createdAt time.Time, expiresAt time.Time) *DeviceCode {
+	return &DeviceCode{
+		ID:             id,
+		DeviceCodeHash: deviceCodeHash,
+		UserCode:       userCode,
+		ClientName:     clientName,
+		Scopes:         scopes,
+		ClientIP:       clientIP,
+		Status:         DeviceCodePending,
+		PollInterval:   pollInterval,
+		CreatedAt:      createdAt,
+		ExpiresAt:      expiresAt,
+	}
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+)
+
+type DownloadEvent struct {
+	ID           string     `json:"id"`
+	FileId       string     `json:"file_id"`
+	FileKey      string     `json:"file_key,omitempty"`
+	Link         string     `json:"link"`
+	DownloadedAt time.Time  `json:"downloaded_at"`
+	ClientIP     string     `json:"client_ip"`
+	UserAgent    string     `json:"user_agent"`
+	DownloadedBy string     `json:"downloaded_by,omitempty"`
+	BytesSent    int64      `json:"bytes_sent"`
+	Completed    bool       `json:"completed"`
+	NotifiedAt   *time.Time `json:"notified_at,omitempty"`
+}
+
+func NewDownloadEvent(id string, fileId string, link string, downloadedAt time.Time, clientIP string, userAgent string, downloadedBy string, bytesSent int64, completed bool) *DownloadEvent {
+	return &DownloadEvent{
+		ID:           id,
+		FileId:       fileId,
+		Link:         link,
+		DownloadedAt: downloadedAt,
+		ClientIP:     clientIP,
+		UserAgent:    userAgent,
+		DownloadedBy: downloadedBy,
+		BytesSent:    bytesSent,
+		Completed:    completed,
+	}
+}
+
+// DownloadDigestEntry is a download waiting to be reported in its owner's daily digest
+type DownloadDigestEntry struct {
+	Event      DownloadEvent
+	FileName   string
+	OwnerId    string
+	OwnerEmail string
+}
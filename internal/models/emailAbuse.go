@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+)
+
+// Suppression reasons, taken from the provider's event types
+const (
+	SuppressBounce      = "bounce"
+	SuppressSpamReport  = "spamreport"
+	SuppressUnsubscribe = "unsubscribe"
+)
+
+// EmailSuppression is an address we no longer send to
+type EmailSuppression struct {
+	Address   string    `json:"address"`
+	Reason    string    `json:"reason"`
+	Detail    string    `json:"detail,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func NewEmailSuppression(address string, reason string, detail string, createdAt time.Time) *EmailSuppression {
+	return &EmailSuppression{
+		Address:   address,
+		Reason:    reason,
+		Detail:    detail,
+		CreatedAt: createdAt,
+	}
+}
+
+// EmailSend is one recipient of a queued email, counted against the send quotas
+type EmailSend struct {
+	ID        string    `json:"id"`
+	EmailId   string    `json:"email_id"`
+	UserId    string    `json:"user_id"`
+	ClientIP  string    `json:"client_ip"`
+	Recipient string    `json:"recipient"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func NewEmailSend(id string, emailId string, userId string, clientIP string, recipient string, createdAt time.Time) *EmailSend {
+	return &EmailSend{
+		ID:        id,
+		EmailId:   emailId,
+		UserId:    userId,
+		ClientIP:  clientIP,
+		Recipient: recipient,
+		CreatedAt: createdAt,
+	}
+}
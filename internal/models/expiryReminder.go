@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+)
+
+// ExpiryReminder records a reminder sent to one address ahead of a file's expiry, so it goes out only once.
+// ExpiresAt is the expiry the reminder was about, an extended file is reminded again before its new expiry.
+type ExpiryReminder struct {
+	ID        string    `json:"id"`
+	FileId    string    `json:"file_id"`
+	Recipient string    `json:"recipient"`
+	LeadTime  int       `json:"lead_time_minutes"`
+	ExpiresAt time.Time `json:"expires_at"`
+	SentAt    time.Time `json:"sent_at"`
+}
+
+func NewExpiryReminder(id string, fileId string, recipient string, leadTime int, expiresAt time.Time, sentAt time.Time) *ExpiryReminder {
+	return &ExpiryReminder{
+		ID:        id,
+		FileId:    fileId,
+		Recipient: recipient,
+		LeadTime:  leadTime,
+		ExpiresAt: expiresAt,
+		SentAt:    sentAt,
+	}
+}
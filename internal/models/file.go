@@ -14,6 +14,21 @@ type File struct {
 	DownloadLink   string    `json:"download_link"`
 	UploadedAt     time.Time `json:"uploaded_at"`
 	DownloadCount  int       `json:"download_count"`
+	MaxDownloads   int       `json:"max_downloads"`
+	// Checksum is the hex encoded SHA-256 of the stored object
+	Checksum           string     `json:"checksum_sha256"`
+	ChecksumVerifiedAt *time.Time `json:"checksum_verified_at,omitempty"`
+	ChecksumMismatch   bool       `json:"checksum_mismatch"`
+	// ThumbnailKey points at the JPEG thumbnail stored next to the file
+	ThumbnailKey    string `json:"thumbnail_key,omitempty"`
+	ThumbnailStatus string `json:"thumbnail_status"`
+	UploadRequestId string `json:"upload_request_id,omitempty"`
+	// Encrypted files are opaque to the server, EncryptedMetadata holds the
+	// client-sealed name, content type and size.
+	Encrypted         bool   `json:"encrypted"`
+	EncryptedMetadata string `json:"encrypted_metadata,omitempty"`
+	// OwnerDisabled is set when the uploader's account is disabled, which makes the file unavailable
+	OwnerDisabled bool `json:"-"`
 }
 
 func NewFile(id string, s3Key string, name string, size int64, expiry time.Time, userId string, downloadLink string, uploadTime time.Time, downloadCount int) *File {
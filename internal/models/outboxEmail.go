@@ -0,0 +1,41 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Outbox email statuses, failed emails are dead-lettered and not retried
+const (
+	EmailQueued = "queued"
+	EmailSent   = "sent"
+	EmailFailed = "failed"
+)
+
+type OutboxEmail struct {
+	ID               string          `json:"id"`
+	UserId           string          `json:"user_id"`
+	FileId           string          `json:"file_id,omitempty"`
+	Message          string          `json:"-"`
+	Recipients       json.RawMessage `json:"recipients"`
+	Status           string          `json:"status"`
+	Attempts         int             `json:"attempts"`
+	NextAttemptAt    time.Time       `json:"next_attempt_at"`
+	LastError        string          `json:"last_error,omitempty"`
+	ProviderResponse string          `json:"provider_response,omitempty"`
+	CreatedAt        time.Time       `json:"created_at"`
+	SentAt           *time.Time      `json:"sent_at,omitempty"`
+}
+
+func NewOutboxEmail(id string, userId string, fileId string, message string, recipients json.RawMessage, createdAt time.Time) *OutboxEmail {
+	return &OutboxEmail{
+		ID:            id,
+		UserId:        userId,
+		FileId:        fileId,
+		Message:       message,
+		Recipients:    recipients,
+		Status:        EmailQueued,
+		NextAttemptAt: createdAt,
+		CreatedAt:     createdAt,
+	}
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+)
+
+// RefreshToken is one token in a login's rotation chain. Every refresh uses the token up and issues the next one
+// in the same family, so a token used twice means it was stolen and the whole family is revoked.
+type RefreshToken struct {
+	ID        string     `json:"id"`
+	FamilyId  string     `json:"family_id"`
+	UserId    string     `json:"user_id"`
+	TokenHash string     `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+func NewRefreshToken(id string, familyId string, userId string, tokenHash string, createdAt time.Time, expiresAt time.Time) *RefreshToken {
+	return &RefreshToken{
+		ID:        id,
+		FamilyId:  familyId,
+		UserId:    userId,
+		TokenHash: tokenHash,
+		CreatedAt: createdAt,
+		ExpiresAt: expiresAt,
+	}
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+)
+
+// UploadRequest lets people without an account upload files into the requester's space
+type UploadRequest struct {
+	ID           string    `json:"id"`
+	TokenHash    string    `json:"-"`
+	UserId       string    `json:"user_id"`
+	Label        string    `json:"label"`
+	MaxFileSize  int64     `json:"max_file_size"`
+	MaxFiles     int       `json:"max_files"`
+	AllowedTypes []string  `json:"allowed_types"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
+	UploadCount  int       `json:"upload_count"`
+	Revoked      bool      `json:"revoked"`
+}
+
+func NewUploadRequest(id string, tokenHash string, userId string, label string, maxFileSize int64, maxFiles int, allowedTypes []string, expiresAt time.Time, createdAt time.Time) *UploadRequest {
+	return &UploadRequest{
+		ID:           id,
+		TokenHash:    tokenHash,
+		UserId:       userId,
+		Label:        label,
+		MaxFileSize:  maxFileSize,
+		MaxFiles:     maxFiles,
+		AllowedTypes: allowedTypes,
+		ExpiresAt:    expiresAt,
+		CreatedAt:    createdAt,
+	}
+}
@@ -11,8 +11,35 @@ type GoogleUser struct {
 	IsEmailVerified bool   `json:"verified_email"`
 	Name            string `json:"name"`
 	Avatar          string `json:"picture"`
+	DownloadNotify  string `json:"download_notify"`
+	// AuthProvider and AuthSubject identify the account at the provider the user logs in with
+	AuthProvider string `json:"auth_provider"`
+	AuthSubject  string `json:"-"`
+	Role         string `json:"role"`
+	// Disabled users can't log in, and their files and upload requests can't be used
+	Disabled bool `json:"disabled"`
 }
 
+// AnonymousUserId owns files uploaded without a session, there is no user with this id
+const AnonymousUserId = " ee6d4c16-eaf3-482c-9271-b9236175b57c"
+
+// Roles. Auditors can see everything admins see but change nothing.
+const (
+	RoleUser    = "user"
+	RoleAdmin   = "admin"
+	RoleAuditor = "auditor"
+)
+
+var Roles = []string{RoleUser, RoleAdmin, RoleAuditor}
+
+// Download notification preferences
+const (
+	DownloadNotifyOff    = "off"
+	DownloadNotifyFirst  = "first"
+	DownloadNotifyEvery  = "every"
+	DownloadNotifyDigest = "digest"
+)
+
 func ParseGoogleUser(body io.Reader) (*GoogleUser, error) {
 	var u GoogleUser
 	err := json.NewDecoder(body).Decode(&u)
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"time"
+)
+
+// Webhook event types
+const (
+	EventFileUploaded   = "file.uploaded"
+	EventFileDownloaded = "file.downloaded"
+	EventFileExpired    = "file.expired"
+	EventFileDeleted    = "file.deleted"
+	EventEmailSent      = "email.sent"
+)
+
+var WebhookEventTypes = []string{EventFileUploaded, EventFileDownloaded, EventFileExpired, EventFileDeleted, EventEmailSent}
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+type WebhookEndpoint struct {
+	ID        string    `json:"id"`
+	UserId    string    `json:"user_id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"`
+	Events    []string  `json:"events"`
+	Global    bool      `json:"global"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func NewWebhookEndpoint(id string, userId string, url string, secret string, events []string, global bool, createdAt time.Time) *WebhookEndpoint {
+	return &WebhookEndpoint{
+		ID:        id,
+		UserId:    userId,
+		URL:       url,
+		Secret:    secret,
+		Events:    events,
+		Global:    global,
+		CreatedAt: createdAt,
+	}
+}
+
+// WebhookDelivery is an outbox row, one per event and subscribed endpoint
+type WebhookDelivery struct {
+	ID            string           `json:"id"`
+	EndpointId    string           `json:"endpoint_id"`
+	EventId       string           `json:"event_id"`
+	EventType     string           `json:"event_type"`
+	Payload       string           `json:"payload"`
+	Status        string           `json:"status"`
+	Attempts      int              `json:"attempts"`
+	NextAttemptAt time.Time        `json:"next_attempt_at"`
+	CreatedAt     time.Time        `json:"created_at"`
+	DeliveredAt   *time.Time       `json:"delivered_at,omitempty"`
+	AttemptLog    []WebhookAttempt `json:"attempt_log,omitempty"`
+}
+
+func NewWebhookDelivery(id string, endpointId string, eventId string, eventType string, payload string, createdAt time.Time) *WebhookDelivery {
+	return &WebhookDelivery{
+		ID:            id,
+		EndpointId:    endpointId,
+		EventId:       eventId,
+		EventType:     eventType,
+		Payload:       payload,
+		Status:        DeliveryPending,
+		NextAttemptAt: createdAt,
+		CreatedAt:     createdAt,
+	}
+}
+
+type WebhookAttempt struct {
+	ID          string    `json:"id"`
+	DeliveryId  string    `json:"delivery_id"`
+	AttemptedAt time.Time `json:"attempted_at"`
+	StatusCode  int       `json:"status_code"`
+	Error       string    `json:"error,omitempty"`
+	DurationMs  int64     `json:"duration_ms"`
+}
@@ -0,0 +1,18 @@
+package repository
+
+import (
+	"fileTransfer/internal/models"
+	"time"
+)
+
+type AdminDbRepo interface {
+	// The changes are made in one transaction with their admin action, so no change goes unrecorded.
+	// A missing target is sql.ErrNoRows.
+	SetUserDisabled(userId string, disabled bool, action *models.AdminAction) error
+	SetUserRole(userId string, role string, action *models.AdminAction) error
+	ExpireFile(fileId string, at time.Time, action *models.AdminAction) error
+	DeleteEmailSuppression(address string, action *models.AdminAction) error
+	ListAdminActions(limit int, offset int) ([]models.AdminAction, int, error)
+	ListUsersWithUsage(search string, now time.Time, limit int, offset int) ([]models.UserUsage, int, error)
+	GetStorageStats(now time.Time) (*models.StorageStats, error)
+}
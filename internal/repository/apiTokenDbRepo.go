@@ -0,0 +1,14 @@
+package repository
+
+import (
+	"fileTransfer/internal/models"
+	"time"
+)
+
+type ApiTokenDbRepo interface {
+	AddApiToken(token *models.ApiToken) error
+	GetApiTokenByTokenHash(tokenHash string) (*models.ApiToken, error)
+	ListApiTokensByUser(userId string) ([]models.ApiToken, error)
+	RevokeApiToken(id string, userId string) error
+	TouchApiToken(id string, at time.Time) error
+}
@@ -0,0 +1,17 @@
+package repository
+
+import (
+	"fileTransfer/internal/models"
+	"time"
+)
+
+type DeviceCodeDbRepo interface {
+	AddDeviceCode(code *models.DeviceCode) error
+	GetDeviceCodeByHash(deviceCodeHash string) (*models.DeviceCode, error)
+	GetDeviceCodeByUserCode(userCode string) (*models.DeviceCode, error)
+	CountDeviceCodesByIP(clientIP string, since time.Time) (int, error)
+	PollDeviceCode(id string, at time.Time, slowDownBy int) (bool, error)
+	DecideDeviceCode(id string, userId string, status string, at time.Time) (bool, error)
+	ConsumeDeviceCode(id string, token *models.ApiToken) (bool, error)
+	DeleteExpiredDeviceCodes(before time.Time) (int64, error)
+}
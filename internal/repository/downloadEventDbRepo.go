@@ -0,0 +1,15 @@
+package repository
+
+import (
+	"fileTransfer/internal/models"
+	"time"
+)
+
+type DownloadEventDbRepo interface {
+	AddDownloadEvent(event *models.DownloadEvent) error
+	MarkEventsNotified(ids []string) error
+	GetPendingDigestEvents() ([]models.DownloadDigestEntry, error)
+	ListDownloadEventsByFile(fileId string, limit int, offset int) ([]models.DownloadEvent, int, error)
+	GetFileDownloaders(fileId string) ([]string, error)
+	ExportDownloadEvents(from time.Time, to time.Time, fn func(event *models.DownloadEvent) error) error
+}
@@ -0,0 +1,16 @@
+package repository
+
+import (
+	"fileTransfer/internal/models"
+	"time"
+)
+
+type EmailAbuseDbRepo interface {
+	AddSends(sends []*models.EmailSend) error
+	CountSendsByUser(userId string, since time.Time) (int, error)
+	CountSendsByIP(clientIP string, since time.Time) (int, error)
+	CountSendsToRecipient(recipient string, since time.Time) (int, error)
+	AddSuppression(suppression *models.EmailSuppression) error
+	GetSuppressions(addresses []string) ([]models.EmailSuppression, error)
+	ListSuppressions(limit int, offset int) ([]models.EmailSuppression, error)
+}
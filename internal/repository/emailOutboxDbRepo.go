@@ -0,0 +1,15 @@
+package repository
+
+import (
+	"fileTransfer/internal/models"
+	"time"
+)
+
+type EmailOutboxDbRepo interface {
+	AddEmail(email *models.OutboxEmail) error
+	GetEmail(id string) (*models.OutboxEmail, error)
+	GetSentEmailsByFile(fileId string) ([]models.OutboxEmail, error)
+	GetDueEmails(now time.Time, limit int) ([]models.OutboxEmail, error)
+	ClaimEmail(id string, now time.Time, leaseUntil time.Time) (bool, error)
+	UpdateEmail(email *models.OutboxEmail) error
+}
@@ -0,0 +1,10 @@
+package repository
+
+import (
+	"fileTransfer/internal/models"
+)
+
+type ExpiryReminderDbRepo interface {
+	ClaimReminder(reminder *models.ExpiryReminder) (bool, error)
+	ReleaseReminder(id string) error
+}
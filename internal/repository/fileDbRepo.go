@@ -1,13 +1,25 @@
 package repository
 
 import (
+	"errors"
 	"fileTransfer/internal/models"
 	"time"
 )
 
+var ErrDownloadLimitReached = errors.New("download limit reached")
+
 type FileDbRepo interface {
 	AddFile(file *models.File) error
 	GetExpiredFiles(time time.Time) ([]models.File, error)
+	GetFilesExpiringBetween(from time.Time, to time.Time) ([]models.File, error)
+	ExtendExpiry(id string, current time.Time, expiry time.Time) (bool, error)
 	DeleteFileByID(id string) error
 	IncreaseDownloadCount(key string) error
+	GetFileByKey(key string) (*models.File, error)
+	GetFileByID(id string) (*models.File, error)
+	ListFilesByUser(userId string) ([]models.File, error)
+	GetFilesForChecksumVerification(limit int, maxSize int64) ([]models.File, error)
+	RecordChecksumVerification(id string, ok bool) error
+	GetFilesPendingThumbnail(limit int) ([]models.File, error)
+	SetThumbnail(id string, thumbnailKey string, status string) error
 }
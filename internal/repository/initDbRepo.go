@@ -3,6 +3,17 @@ package repository
 type InitDbRepo interface {
 	CreateUserTableIfNotExist() error
 	CreateFileTableIfNotExist() error
+	CreateUploadRequestTableIfNotExist() error
+	CreateDownloadEventTableIfNotExist() error
+	CreateWebhookTablesIfNotExist() error
+	CreateEmailOutboxTableIfNotExist() error
+	CreateExpiryReminderTableIfNotExist() error
+	CreateEmailAbuseTablesIfNotExist() error
+	CreateRefreshTokenTableIfNotExist() error
+	CreateApiTokenTableIfNotExist() error
+	CreateDeviceCodeTableIfNotExist() error
+	CreateAdminActionTableIfNotExist() error
+	MigrateTables() error
 	InsertSampleData() error
 	TruncateAllTables() error
 }
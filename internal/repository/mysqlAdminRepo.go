@@ -0,0 +1,176 @@
+package repository
+
+import (
+	"database/sql"
+	"fileTransfer/internal/models"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type MysqlAdminRepo struct {
+	db *sql.DB
+}
+
+func (m *MysqlAdminRepo) SetUserDisabled(userId string, disabled bool, action *models.AdminAction) error {
+	return m.apply(action, "SELECT COUNT(*) FROM user WHERE Id = ?", userId,
+		"UPDATE user SET Disabled = ? WHERE Id = ?", disabled, userId)
+}
+
+func (m *MysqlAdminRepo) SetUserRole(userId string, role string, action *models.AdminAction) error {
+	return m.apply(action, "SELECT COUNT(*) FROM user WHERE Id = ?", userId,
+		"UPDATE user SET Role = ? WHERE Id = ?", role, userId)
+}
+
+// ExpireFile makes a file expire at the given time, the expired file cleanup then deletes it
+func (m *MysqlAdminRepo) ExpireFile(fileId string, at time.Time, action *models.AdminAction) error {
+	return m.apply(action, "SELECT COUNT(*) FROM file WHERE Id = ?", fileId,
+		"UPDATE file SET ExpirationDate = ? WHERE Id = ?", at, fileId)
+}
+
+func (m *MysqlAdminRepo) DeleteEmailSuppression(address string, action *models.AdminAction) error {
+	address = strings.ToLower(address)
+	return m.apply(action, "SELECT COUNT(*) FROM email_suppression WHERE Address = ?", address,
+		"DELETE FROM email_suppression WHERE Address = ?", address)
+}
+
+// apply checks the target exists, runs the change and records the action, all in one transaction
+func (m *MysqlAdminRepo) apply(action *models.AdminAction, exists string, target string, change string, args ...any) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Setting a value the target already has affects no rows, so existence is checked on its own
+	var n int
+	if err := tx.QueryRow(exists+" FOR UPDATE", target).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.Exec(change, args...); err != nil {
+		return fmt.Errorf("failed to apply admin action: %w", err)
+	}
+	if err := addAdminAction(tx, action); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func addAdminAction(tx *sql.Tx, a *models.AdminAction) error {
+	var detail any
+	if len(a.Detail) > 0 {
+		detail = []byte(a.Detail)
+	}
+	_, err := tx.Exec(`INSERT INTO admin_action (Id, ActorId, ActorEmail, Action, TargetType, TargetId, Reason, Detail,
+		CreatedAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, a.ID, a.ActorId, a.ActorEmail, a.Action, a.TargetType, a.TargetId,
+		a.Reason, detail, a.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record admin action: %w", err)
+	}
+	return nil
+}
+
+// ListAdminActions pages through the audit log, newest first
+func (m *MysqlAdminRepo) ListAdminActions(limit int, offset int) ([]models.AdminAction, int, error) {
+	var total int
+	if err := m.db.QueryRow("SELECT COUNT(*) FROM admin_action").Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := m.db.Query(`SELECT Id, ActorId, ActorEmail, Action, TargetType, TargetId, Reason, Detail, CreatedAt
+		FROM admin_action ORDER BY CreatedAt DESC LIMIT ? OFFSET ?`, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	actions := []models.AdminAction{}
+	for rows.Next() {
+		var a models.AdminAction
+		var detail []byte
+		err := rows.Scan(&a.ID, &a.ActorId, &a.ActorEmail, &a.Action, &a.TargetType, &a.TargetId, &a.Reason, &detail,
+			&a.CreatedAt)
+		if err != nil {
+			return nil, 0, err
+		}
+		a.Detail = detail
+		actions = append(actions, a)
+	}
+	return actions, total, rows.Err()
+}
+
+// ListUsersWithUsage pages through the users, optionally those whose email or name contains search, with the
+// files they store that haven't expired yet
+func (m *MysqlAdminRepo) ListUsersWithUsage(search string, now time.Time, limit int, offset int) ([]models.UserUsage, int, error) {
+	where, args := "", []any{}
+	if search != "" {
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(search) + "%"
+		where, args = "WHERE u.Email LIKE ? OR u.Name LIKE ?", []any{pattern, pattern}
+	}
+
+	var total int
+	if err := m.db.QueryRow("SELECT COUNT(*) FROM user u "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	q := `SELECT u.Id, u.Email, u.Name, u.Avatar, u.IsEmailVerified, u.DownloadNotify, u.AuthProvider, u.Role, u.Disabled,
+		COUNT(f.Id), COALESCE(SUM(f.Size), 0), MAX(f.UploadedAt)
+		FROM user u LEFT JOIN file f ON f.UserId = u.Id AND (f.ExpirationDate IS NULL OR f.ExpirationDate > ?)
+		` + where + `
+		GROUP BY u.Id ORDER BY u.Email LIMIT ? OFFSET ?`
+	rows, err := m.db.Query(q, append(append([]any{now}, args...), limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []models.UserUsage{}
+	for rows.Next() {
+		var u models.UserUsage
+		var name, avatar, provider sql.NullString
+		var lastUpload sql.NullTime
+		err := rows.Scan(&u.ID, &u.Email, &name, &avatar, &u.IsEmailVerified, &u.DownloadNotify, &provider, &u.Role,
+			&u.Disabled, &u.FileCount, &u.StorageBytes, &lastUpload)
+		if err != nil {
+			return nil, 0, err
+		}
+		u.Name, u.Avatar, u.AuthProvider = name.String, avatar.String, provider.String
+		if lastUpload.Valid {
+			u.LastUploadAt = &lastUpload.Time
+		}
+		users = append(users, u)
+	}
+	return users, total, rows.Err()
+}
+
+func (m *MysqlAdminRepo) GetStorageStats(now time.Time) (*models.StorageStats, error) {
+	var s models.StorageStats
+	err := m.db.QueryRow("SELECT COUNT(*), COALESCE(SUM(Disabled), 0) FROM user").Scan(&s.Users, &s.DisabledUsers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count users: %w", err)
+	}
+
+	active := "(ExpirationDate IS NULL OR ExpirationDate > ?)"
+	err = m.db.QueryRow(`SELECT COUNT(*),
+		COALESCE(SUM(`+active+`), 0),
+		COALESCE(SUM(Encrypted), 0),
+		COALESCE(SUM(Size), 0),
+		COALESCE(SUM(CASE WHEN `+active+` THEN Size ELSE 0 END), 0),
+		COALESCE(SUM(UploadedAt > ?), 0),
+		COALESCE(SUM(CASE WHEN UploadedAt > ? THEN Size ELSE 0 END), 0),
+		COALESCE(SUM(DownloadCount), 0)
+		FROM file`, now, now, now.Add(-24*time.Hour), now.Add(-24*time.Hour)).Scan(&s.Files, &s.ActiveFiles,
+		&s.EncryptedFiles, &s.StorageBytes, &s.ActiveBytes, &s.UploadsLastDay, &s.BytesLastDay, &s.Downloads)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum files: %w", err)
+	}
+	s.ExpiredFiles = s.Files - s.ActiveFiles
+	return &s, nil
+}
+
+func NewMysqlAdminRepo(db *sql.DB) AdminDbRepo {
+	return &MysqlAdminRepo{db: db}
+}
@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"database/sql"
+	"fileTransfer/internal/models"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type MysqlApiTokenRepo struct {
+	db *sql.DB
+}
+
+const apiTokenSelectColumns = "Id, UserId, Name, Prefix, TokenHash, Scopes, CreatedAt, ExpiresAt, LastUsedAt, Revoked"
+
+func scanApiToken(row rowScanner) (*models.ApiToken, error) {
+	var t models.ApiToken
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+	err := row.Scan(&t.ID, &t.UserId, &t.Name, &t.Prefix, &t.TokenHash, &scopes, &t.CreatedAt, &expiresAt,
+		&lastUsedAt, &t.Revoked)
+	if err != nil {
+		return nil, err
+	}
+	if scopes != "" {
+		t.Scopes = strings.Split(scopes, ",")
+	}
+	if expiresAt.Valid {
+		t.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		t.LastUsedAt = &lastUsedAt.Time
+	}
+	return &t, nil
+}
+
+func (m *MysqlApiTokenRepo) AddApiToken(token *models.ApiToken) error {
+	return addApiToken(m.db.Exec, token)
+}
+
+// addApiToken inserts through exec, so the insert can be part of another repo's transaction
+func addApiToken(exec func(query string, args ...any) (sql.Result, error), token *models.ApiToken) error {
+	_, err := exec(`INSERT INTO api_token (Id, UserId, Name, Prefix, TokenHash, Scopes, CreatedAt, ExpiresAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, token.ID, token.UserId, token.Name, token.Prefix, token.TokenHash,
+		strings.Join(token.Scopes, ","), token.CreatedAt, token.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert api token: %w", err)
+	}
+	return nil
+}
+
+func (m *MysqlApiTokenRepo) GetApiTokenByTokenHash(tokenHash string) (*models.ApiToken, error) {
+	q := "SELECT " + apiTokenSelectColumns + " FROM api_token WHERE TokenHash = ?"
+	return scanApiToken(m.db.QueryRow(q, tokenHash))
+}
+
+func (m *MysqlApiTokenRepo) ListApiTokensByUser(userId string) ([]models.ApiToken, error) {
+	q := "SELECT " + apiTokenSelectColumns + " FROM api_token WHERE UserId = ? ORDER BY CreatedAt DESC"
+	rows, err := m.db.Query(q, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []models.ApiToken
+	for rows.Next() {
+		t, err := scanApiToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+func (m *MysqlApiTokenRepo) RevokeApiToken(id string, userId string) error {
+	res, err := m.db.Exec("UPDATE api_token SET Revoked = TRUE WHERE Id = ? AND UserId = ?", id, userId)
+	if err != nil {
+		return fmt.Errorf("failed to revoke api token: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (m *MysqlApiTokenRepo) TouchApiToken(id string, at time.Time) error {
+	_, err := m.db.Exec("UPDATE api_token SET LastUsedAt = ? WHERE Id = ?", at, id)
+	if err != nil {
+		return fmt.Errorf("failed to update api token: %w", err)
+	}
+	return nil
+}
+
+func NewMysqlApiTokenRepo(db *sql.DB) ApiTokenDbRepo {
+	return &MysqlApiTokenRepo{db: db}
+}
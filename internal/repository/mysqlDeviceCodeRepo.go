@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"database/sql"
+	"fileTransfer/internal/models"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type MysqlDeviceCodeRepo struct {
+	db *sql.DB
+}
+
+const deviceCodeSelectColumns = `Id, DeviceCodeHash, UserCode, ClientName, Scopes, ClientIP, Status, UserId, PollInterval,
+	LastPolledAt, CreatedAt, ExpiresAt`
+
+func scanDeviceCode(row rowScanner) (*models.DeviceCode, error) {
+	var d models.DeviceCode
+	var scopes string
+	var userId sql.NullString
+	var lastPolledAt sql.NullTime
+	err := row.Scan(&d.ID, &d.DeviceCodeHash, &d.UserCode, &d.ClientName, &scopes, &d.ClientIP, &d.Status, &userId,
+		&d.PollInterval, &lastPolledAt, &d.CreatedAt, &d.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if scopes != "" {
+		d.Scopes = strings.Split(scopes, ",")
+	}
+	d.UserId = userId.String
+	if lastPolledAt.Valid {
+		d.LastPolledAt = &lastPolledAt.Time
+	}
+	return &d, nil
+}
+
+func (m *MysqlDeviceCodeRepo) AddDeviceCode(code *models.DeviceCode) error {
+	_, err := m.db.Exec(`INSERT INTO device_code (Id, DeviceCodeHash, UserCode, ClientName, Scopes, ClientIP, Status,
+		PollInterval, CreatedAt, ExpiresAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, code.ID, code.DeviceCodeHash,
+		code.UserCode, code.ClientName, strings.Join(code.Scopes, ","), code.ClientIP, code.Status, code.PollInterval,
+		code.CreatedAt, code.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert device code: %w", err)
+	}
+	return nil
+}
+
+func (m *MysqlDeviceCodeRepo) GetDeviceCodeByHash(deviceCodeHash string) (*models.DeviceCode, error) {
+	q := "SELECT " + deviceCodeSelectColumns + " FROM device_code WHERE DeviceCodeHash = ?"
+	return scanDeviceCode(m.db.QueryRow(q, deviceCodeHash))
+}
+
+func (m *MysqlDeviceCodeRepo) GetDeviceCodeByUserCode(userCode string) (*models.DeviceCode, error) {
+	q := "SELECT " + deviceCodeSelectColumns + " FROM device_code WHERE UserCode = ?"
+	return scanDeviceCode(m.db.QueryRow(q, userCode))
+}
+
+func (m *MysqlDeviceCodeRepo) CountDeviceCodesByIP(clientIP string, since time.Time) (int, error) {
+	var n int
+	err := m.db.QueryRow("SELECT COUNT(*) FROM device_code WHERE ClientIP = ? AND CreatedAt > ?", clientIP, since).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count device codes: %w", err)
+	}
+	return n, nil
+}
+
+// PollDeviceCode records a poll, returning false when the device polled before its interval was up. A device
+// polling too fast has its interval raised by slowDownBy seconds, as RFC 8628 asks. LastPolledAt is stored to
+// the second and MySQL rounds, so a poll up to a second early is allowed, or a device polling exactly at its
+// interval could be told to slow down.
+func (m *MysqlDeviceCodeRepo) PollDeviceCode(id string, at time.Time, slowDownBy int) (bool, error) {
+	res, err := m.db.Exec(`UPDATE device_code SET LastPolledAt = ?
+		WHERE Id = ? AND (LastPolledAt IS NULL OR LastPolledAt <= DATE_SUB(?, INTERVAL PollInterval - 1 SECOND))`,
+		at, id, at)
+	if err != nil {
+		return false, fmt.Errorf("failed to poll device code: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to poll device code: %w", err)
+	}
+	if n == 1 {
+		return true, nil
+	}
+
+	_, err = m.db.Exec("UPDATE device_code SET LastPolledAt = ?, PollInterval = PollInterval + ? WHERE Id = ?", at, slowDownBy, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to poll device code: %w", err)
+	}
+	return false, nil
+}
+
+// DecideDeviceCode approves or denies a pending code, returning false when it was already decided or has expired
+func (m *MysqlDeviceCodeRepo) DecideDeviceCode(id string, userId string, status string, at time.Time) (bool, error) {
+	res, err := m.db.Exec(`UPDATE device_code SET Status = ?, UserId = ? WHERE Id = ? AND Status = ? AND ExpiresAt > ?`,
+		status, userId, id, models.DeviceCodePending, at)
+	if err != nil {
+		return false, fmt.Errorf("failed to decide device code: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to decide device code: %w", err)
+	}
+	return n == 1, nil
+}
+
+// ConsumeDeviceCode uses up an approved code and stores its API token in one transaction, so of two concurrent polls
+// only one gets a token, and a failed insert leaves the code approved for the next poll
+func (m *MysqlDeviceCodeRepo) ConsumeDeviceCode(id string, token *models.ApiToken) (bool, error) {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE device_code SET Status = ? WHERE Id = ? AND Status = ?", models.DeviceCodeConsumed,
+		id, models.DeviceCodeApproved)
+	if err != nil {
+		return false, fmt.Errorf("failed to consume device code: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to consume device code: %w", err)
+	}
+	if n != 1 {
+		return false, nil
+	}
+	if err := addApiToken(tx.Exec, token); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (m *MysqlDeviceCodeRepo) DeleteExpiredDeviceCodes(before time.Time) (int64, error) {
+	res, err := m.db.Exec("DELETE FROM device_code WHERE ExpiresAt < ?", before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired device codes: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+func NewMysqlDeviceCodeRepo(db *sql.DB) DeviceCodeDbRepo {
+	return &MysqlDeviceCodeRepo{db: db}
+}
@@ -0,0 +1,177 @@
+package repository
+
+import (
+	"database/sql"
+	"fileTransfer/internal/models"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type MysqlDownloadEventRepo struct {
+	db *sql.DB
+}
+
+func (m *MysqlDownloadEventRepo) AddDownloadEvent(event *models.DownloadEvent) error {
+	q := `
+		INSERT INTO download_event (Id, FileId, Link, DownloadedAt, ClientIP, UserAgent, DownloadedBy, BytesSent, Completed)
+		VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?, ?)
+	`
+
+	_, err := m.db.Exec(q, event.ID, event.FileId, event.Link, event.DownloadedAt, event.ClientIP, event.UserAgent,
+		event.DownloadedBy, event.BytesSent, event.Completed)
+	if err != nil {
+		return fmt.Errorf("failed to insert download event: %w", err)
+	}
+
+	return nil
+}
+
+func (m *MysqlDownloadEventRepo) MarkEventsNotified(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	args := []any{time.Now().UTC()}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+
+	_, err := m.db.Exec("UPDATE download_event SET NotifiedAt = ? WHERE Id IN ("+placeholders+")", args...)
+	if err != nil {
+		return fmt.Errorf("failed to mark download events notified: %w", err)
+	}
+
+	return nil
+}
+
+// GetPendingDigestEvents returns unreported downloads of files whose owners asked for a daily digest, made since they
+// asked for it, so turning the digest on doesn't report the whole download history
+func (m *MysqlDownloadEventRepo) GetPendingDigestEvents() ([]models.DownloadDigestEntry, error) {
+	q := `
+		SELECT e.Id, e.FileId, e.DownloadedAt, e.ClientIP, e.UserAgent, e.DownloadedBy, f.Name, u.Id, u.Email
+		FROM download_event e
+		JOIN file f ON f.Id = e.FileId
+		JOIN user u ON u.Id = f.UserId
+		WHERE e.NotifiedAt IS NULL AND u.DownloadNotify = 'digest' AND e.DownloadedAt >= u.DownloadNotifySetAt
+		ORDER BY u.Email, e.DownloadedAt
+	`
+
+	rows, err := m.db.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.DownloadDigestEntry
+	for rows.Next() {
+		var d models.DownloadDigestEntry
+		var clientIP, userAgent, downloadedBy sql.NullString
+		err := rows.Scan(&d.Event.ID, &d.Event.FileId, &d.Event.DownloadedAt, &clientIP, &userAgent, &downloadedBy,
+			&d.FileName, &d.OwnerId, &d.OwnerEmail)
+		if err != nil {
+			return nil, err
+		}
+		d.Event.ClientIP = clientIP.String
+		d.Event.UserAgent = userAgent.String
+		d.Event.DownloadedBy = downloadedBy.String
+		entries = append(entries, d)
+	}
+	return entries, rows.Err()
+}
+
+const downloadEventSelectColumns = `e.Id, e.FileId, f.S3Key, e.Link, e.DownloadedAt, e.ClientIP, e.UserAgent,
+	e.DownloadedBy, e.BytesSent, e.Completed, e.NotifiedAt`
+
+func scanDownloadEvent(row rowScanner) (*models.DownloadEvent, error) {
+	var e models.DownloadEvent
+	var fileKey, link, clientIP, userAgent, downloadedBy sql.NullString
+	var notifiedAt sql.NullTime
+	err := row.Scan(&e.ID, &e.FileId, &fileKey, &link, &e.DownloadedAt, &clientIP, &userAgent, &downloadedBy,
+		&e.BytesSent, &e.Completed, &notifiedAt)
+	if err != nil {
+		return nil, err
+	}
+	e.FileKey = fileKey.String
+	e.Link = link.String
+	e.ClientIP = clientIP.String
+	e.UserAgent = userAgent.String
+	e.DownloadedBy = downloadedBy.String
+	if notifiedAt.Valid {
+		e.NotifiedAt = &notifiedAt.Time
+	}
+	return &e, nil
+}
+
+// ListDownloadEventsByFile returns a page of a file's download events, newest first, and the total number of events
+func (m *MysqlDownloadEventRepo) ListDownloadEventsByFile(fileId string, limit int, offset int) ([]models.DownloadEvent, int, error) {
+	var total int
+	if err := m.db.QueryRow("SELECT COUNT(*) FROM download_event WHERE FileId = ?", fileId).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	q := "SELECT " + downloadEventSelectColumns + ` FROM download_event e LEFT JOIN file f ON f.Id = e.FileId
+		WHERE e.FileId = ? ORDER BY e.DownloadedAt DESC, e.Id LIMIT ? OFFSET ?`
+	rows, err := m.db.Query(q, fileId, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var events []models.DownloadEvent
+	for rows.Next() {
+		e, err := scanDownloadEvent(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		events = append(events, *e)
+	}
+	return events, total, rows.Err()
+}
+
+// GetFileDownloaders returns the lower-cased emails of the signed-in users who completed a download of a file
+func (m *MysqlDownloadEventRepo) GetFileDownloaders(fileId string) ([]string, error) {
+	rows, err := m.db.Query(`SELECT DISTINCT LOWER(DownloadedBy) FROM download_event
+		WHERE FileId = ? AND Completed AND DownloadedBy IS NOT NULL`, fileId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var downloaders []string
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return nil, err
+		}
+		downloaders = append(downloaders, email)
+	}
+	return downloaders, rows.Err()
+}
+
+// ExportDownloadEvents streams every event in [from, to) to fn without loading them all into memory
+func (m *MysqlDownloadEventRepo) ExportDownloadEvents(from time.Time, to time.Time, fn func(event *models.DownloadEvent) error) error {
+	q := "SELECT " + downloadEventSelectColumns + ` FROM download_event e LEFT JOIN file f ON f.Id = e.FileId
+		WHERE e.DownloadedAt >= ? AND e.DownloadedAt < ? ORDER BY e.DownloadedAt, e.Id`
+	rows, err := m.db.Query(q, from, to)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanDownloadEvent(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func NewMysqlDownloadEventRepo(db *sql.DB) DownloadEventDbRepo {
+	return &MysqlDownloadEventRepo{db: db}
+}
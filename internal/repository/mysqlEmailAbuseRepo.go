@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"database/sql"
+	"fileTransfer/internal/models"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type MysqlEmailAbuseRepo struct {
+	db *sql.DB
+}
+
+func (m *MysqlEmailAbuseRepo) AddSends(sends []*models.EmailSend) error {
+	if len(sends) == 0 {
+		return nil
+	}
+	placeholders := make([]string, len(sends))
+	args := make([]any, 0, len(sends)*6)
+	for i, s := range sends {
+		placeholders[i] = "(?, ?, NULLIF(?, ''), ?, ?, ?)"
+		args = append(args, s.ID, s.EmailId, s.UserId, s.ClientIP, strings.ToLower(s.Recipient), s.CreatedAt)
+	}
+
+	q := "INSERT INTO email_send (Id, EmailId, UserId, ClientIP, Recipient, CreatedAt) VALUES " + strings.Join(placeholders, ", ")
+	if _, err := m.db.Exec(q, args...); err != nil {
+		return fmt.Errorf("failed to record email sends: %w", err)
+	}
+	return nil
+}
+
+func (m *MysqlEmailAbuseRepo) CountSendsByUser(userId string, since time.Time) (int, error) {
+	return m.count("SELECT COUNT(*) FROM email_send WHERE UserId = ? AND CreatedAt > ?", userId, since)
+}
+
+func (m *MysqlEmailAbuseRepo) CountSendsByIP(clientIP string, since time.Time) (int, error) {
+	return m.count("SELECT COUNT(*) FROM email_send WHERE ClientIP = ? AND CreatedAt > ?", clientIP, since)
+}
+
+func (m *MysqlEmailAbuseRepo) CountSendsToRecipient(recipient string, since time.Time) (int, error) {
+	return m.count("SELECT COUNT(*) FROM email_send WHERE Recipient = ? AND CreatedAt > ?", strings.ToLower(recipient), since)
+}
+
+func (m *MysqlEmailAbuseRepo) count(q string, args ...any) (int, error) {
+	var n int
+	if err := m.db.QueryRow(q, args...).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count email sends: %w", err)
+	}
+	return n, nil
+}
+
+// AddSuppression stops sends to an address, a later event for the same address replaces the reason
+func (m *MysqlEmailAbuseRepo) AddSuppression(s *models.EmailSuppression) error {
+	_, err := m.db.Exec(`INSERT INTO email_suppression (Address, Reason, Detail, CreatedAt) VALUES (?, ?, NULLIF(?, ''), ?)
+		ON DUPLICATE KEY UPDATE Reason = VALUES(Reason), Detail = VALUES(Detail), CreatedAt = VALUES(CreatedAt)`,
+		strings.ToLower(s.Address), s.Reason, s.Detail, s.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to add suppression: %w", err)
+	}
+	return nil
+}
+
+// GetSuppressions returns the suppressions among addresses
+func (m *MysqlEmailAbuseRepo) GetSuppressions(addresses []string) ([]models.EmailSuppression, error) {
+	if len(addresses) == 0 {
+		return nil, nil
+	}
+	args := make([]any, len(addresses))
+	for i, a := range addresses {
+		args[i] = strings.ToLower(a)
+	}
+	q := "SELECT Address, Reason, Detail, CreatedAt FROM email_suppression WHERE Address IN (?" +
+		strings.Repeat(", ?", len(addresses)-1) + ")"
+	return m.querySuppressions(q, args...)
+}
+
+func (m *MysqlEmailAbuseRepo) ListSuppressions(limit int, offset int) ([]models.EmailSuppression, error) {
+	return m.querySuppressions("SELECT Address, Reason, Detail, CreatedAt FROM email_suppression ORDER BY CreatedAt DESC LIMIT ? OFFSET ?",
+		limit, offset)
+}
+
+func (m *MysqlEmailAbuseRepo) querySuppressions(q string, args ...any) ([]models.EmailSuppression, error) {
+	rows, err := m.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []models.EmailSuppression
+	for rows.Next() {
+		var s models.EmailSuppression
+		var detail sql.NullString
+		if err := rows.Scan(&s.Address, &s.Reason, &detail, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		s.Detail = detail.String
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
+
+func NewMysqlEmailAbuseRepo(db *sql.DB) EmailAbuseDbRepo {
+	return &MysqlEmailAbuseRepo{db: db}
+}
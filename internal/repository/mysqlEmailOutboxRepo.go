@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"database/sql"
+	"fileTransfer/internal/models"
+	"fmt"
+	"time"
+)
+
+type MysqlEmailOutboxRepo struct {
+	db *sql.DB
+}
+
+const outboxEmailSelectColumns = `Id, UserId, FileId, Message, Recipients, Status, Attempts, NextAttemptAt, LastError,
+	ProviderResponse, CreatedAt, SentAt`
+
+func scanOutboxEmail(row rowScanner) (*models.OutboxEmail, error) {
+	var e models.OutboxEmail
+	var recipients string
+	var fileId, lastError, providerResponse sql.NullString
+	var sentAt sql.NullTime
+	err := row.Scan(&e.ID, &e.UserId, &fileId, &e.Message, &recipients, &e.Status, &e.Attempts, &e.NextAttemptAt, &lastError,
+		&providerResponse, &e.CreatedAt, &sentAt)
+	if err != nil {
+		return nil, err
+	}
+	e.FileId = fileId.String
+	e.Recipients = []byte(recipients)
+	e.LastError = lastError.String
+	e.ProviderResponse = providerResponse.String
+	if sentAt.Valid {
+		e.SentAt = &sentAt.Time
+	}
+	return &e, nil
+}
+
+func (m *MysqlEmailOutboxRepo) AddEmail(email *models.OutboxEmail) error {
+	q := `
+		INSERT INTO email_outbox (Id, UserId, FileId, Message, Recipients, Status, Attempts, NextAttemptAt, CreatedAt)
+		VALUES (?, ?, NULLIF(?, ''), ?, ?, ?, 0, ?, ?)
+	`
+
+	_, err := m.db.Exec(q, email.ID, email.UserId, email.FileId, email.Message, string(email.Recipients), email.Status,
+		email.NextAttemptAt, email.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert outbox email: %w", err)
+	}
+	return nil
+}
+
+func (m *MysqlEmailOutboxRepo) GetEmail(id string) (*models.OutboxEmail, error) {
+	return scanOutboxEmail(m.db.QueryRow("SELECT "+outboxEmailSelectColumns+" FROM email_outbox WHERE Id = ?", id))
+}
+
+// GetSentEmailsByFile returns the emails that shared a file and went out to at least someone
+func (m *MysqlEmailOutboxRepo) GetSentEmailsByFile(fileId string) ([]models.OutboxEmail, error) {
+	return m.queryEmails("SELECT "+outboxEmailSelectColumns+" FROM email_outbox WHERE FileId = ? AND Status = 'sent'", fileId)
+}
+
+func (m *MysqlEmailOutboxRepo) GetDueEmails(now time.Time, limit int) ([]models.OutboxEmail, error) {
+	return m.queryEmails("SELECT "+outboxEmailSelectColumns+` FROM email_outbox
+		WHERE Status = 'queued' AND NextAttemptAt <= ? ORDER BY NextAttemptAt LIMIT ?`, now, limit)
+}
+
+func (m *MysqlEmailOutboxRepo) queryEmails(q string, args ...any) ([]models.OutboxEmail, error) {
+	rows, err := m.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var emails []models.OutboxEmail
+	for rows.Next() {
+		e, err := scanOutboxEmail(rows)
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, *e)
+	}
+	return emails, rows.Err()
+}
+
+// ClaimEmail pushes NextAttemptAt out to leaseUntil so no other worker sends the email meanwhile
+func (m *MysqlEmailOutboxRepo) ClaimEmail(id string, now time.Time, leaseUntil time.Time) (bool, error) {
+	res, err := m.db.Exec(`UPDATE email_outbox SET NextAttemptAt = ?
+		WHERE Id = ? AND Status = 'queued' AND NextAttemptAt <= ?`, leaseUntil, id, now)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim outbox email: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim outbox email: %w", err)
+	}
+	return n == 1, nil
+}
+
+// UpdateEmail saves the outcome of a send attempt
+func (m *MysqlEmailOutboxRepo) UpdateEmail(email *models.OutboxEmail) error {
+	_, err := m.db.Exec(`UPDATE email_outbox SET Message = ?, Recipients = ?, Status = ?, Attempts = ?, NextAttemptAt = ?,
+		LastError = NULLIF(?, ''), ProviderResponse = NULLIF(?, ''), SentAt = ? WHERE Id = ?`,
+		email.Message, string(email.Recipients), email.Status, email.Attempts, email.NextAttemptAt, email.LastError,
+		email.ProviderResponse, email.SentAt, email.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update outbox email: %w", err)
+	}
+	return nil
+}
+
+func NewMysqlEmailOutboxRepo(db *sql.DB) EmailOutboxDbRepo {
+	return &MysqlEmailOutboxRepo{db: db}
+}
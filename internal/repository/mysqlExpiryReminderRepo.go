@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"database/sql"
+	"fileTransfer/internal/models"
+	"fmt"
+)
+
+type MysqlExpiryReminderRepo struct {
+	db *sql.DB
+}
+
+// ClaimReminder records a reminder before it is sent, returning false when it was already sent.
+// The unique key on file, recipient, lead time and expiry keeps concurrent workers from both sending it.
+func (m *MysqlExpiryReminderRepo) ClaimReminder(reminder *models.ExpiryReminder) (bool, error) {
+	res, err := m.db.Exec(`INSERT IGNORE INTO expiry_reminder (Id, FileId, Recipient, LeadTime, ExpiresAt, SentAt)
+		VALUES (?, ?, ?, ?, ?, ?)`, reminder.ID, reminder.FileId, reminder.Recipient, reminder.LeadTime,
+		reminder.ExpiresAt, reminder.SentAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim expiry reminder: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim expiry reminder: %w", err)
+	}
+	return n == 1, nil
+}
+
+// ReleaseReminder forgets a claimed reminder that could not be sent, so the next run tries again
+func (m *MysqlExpiryReminderRepo) ReleaseReminder(id string) error {
+	_, err := m.db.Exec("DELETE FROM expiry_reminder WHERE Id = ?", id)
+	return err
+}
+
+func NewMysqlExpiryReminderRepo(db *sql.DB) ExpiryReminderDbRepo {
+	return &MysqlExpiryReminderRepo{db: db}
+}
@@ -17,26 +17,131 @@ func (m *MysqlFileRepo) DeleteFileByID(id string) error {
 	return err
 }
 
+// IncreaseDownloadCount claims a download slot, failing with ErrDownloadLimitReached once MaxDownloads is used up
 func (m *MysqlFileRepo) IncreaseDownloadCount(key string) error {
-	query := `UPDATE file SET DownloadCount = DownloadCount + 1 WHERE S3Key = ?`
+	query := `UPDATE file SET DownloadCount = DownloadCount + 1
+		WHERE S3Key = ? AND (MaxDownloads = 0 OR DownloadCount < MaxDownloads)`
 
-	_, err := m.db.Exec(query, key)
+	res, err := m.db.Exec(query, key)
 	if err != nil {
 		return fmt.Errorf("failed to increase download count: %w", err)
 	}
 
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to increase download count: %w", err)
+	}
+	if n == 0 {
+		return ErrDownloadLimitReached
+	}
+
+	return nil
+}
+
+const fileSelectColumns = `Id, S3Key, Name, Size, ExpirationDate, UserId, DownloadLink, UploadedAt, DownloadCount,
+	MaxDownloads, Encrypted, EncryptedMetadata, Checksum, ChecksumVerifiedAt, ChecksumMismatch,
+	ThumbnailKey, ThumbnailStatus,
+	EXISTS (SELECT 1 FROM user WHERE user.Id = file.UserId AND user.Disabled) AS OwnerDisabled`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanFile(row rowScanner) (*models.File, error) {
+	var f models.File
+	var expiry, verifiedAt sql.NullTime
+	var userId, metadata, checksum, thumbnailKey sql.NullString
+	err := row.Scan(&f.ID, &f.S3Key, &f.Name, &f.Size, &expiry, &userId, &f.DownloadLink,
+		&f.UploadedAt, &f.DownloadCount, &f.MaxDownloads, &f.Encrypted, &metadata,
+		&checksum, &verifiedAt, &f.ChecksumMismatch, &thumbnailKey, &f.ThumbnailStatus, &f.OwnerDisabled)
+	if err != nil {
+		return nil, err
+	}
+	f.ExpirationDate = expiry.Time
+	f.UserId = userId.String
+	f.EncryptedMetadata = metadata.String
+	f.Checksum = checksum.String
+	f.ThumbnailKey = thumbnailKey.String
+	if verifiedAt.Valid {
+		f.ChecksumVerifiedAt = &verifiedAt.Time
+	}
+
+	return &f, nil
+}
+
+func (m *MysqlFileRepo) queryFiles(q string, args ...any) ([]models.File, error) {
+	rows, err := m.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []models.File
+	for rows.Next() {
+		f, err := scanFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, *f)
+	}
+	return files, rows.Err()
+}
+
+func (m *MysqlFileRepo) GetFileByKey(key string) (*models.File, error) {
+	return scanFile(m.db.QueryRow("SELECT "+fileSelectColumns+" FROM file WHERE S3Key = ?", key))
+}
+
+func (m *MysqlFileRepo) GetFileByID(id string) (*models.File, error) {
+	return scanFile(m.db.QueryRow("SELECT "+fileSelectColumns+" FROM file WHERE Id = ?", id))
+}
+
+func (m *MysqlFileRepo) ListFilesByUser(userId string) ([]models.File, error) {
+	return m.queryFiles("SELECT "+fileSelectColumns+" FROM file WHERE UserId = ? ORDER BY UploadedAt DESC", userId)
+}
+
+// GetFilesForChecksumVerification returns a random sample of files up to maxSize bytes that have a recorded checksum
+func (m *MysqlFileRepo) GetFilesForChecksumVerification(limit int, maxSize int64) ([]models.File, error) {
+	q := "SELECT " + fileSelectColumns + " FROM file WHERE Checksum IS NOT NULL AND Checksum <> '' AND Size <= ? " +
+		"ORDER BY RAND() LIMIT ?"
+	return m.queryFiles(q, maxSize, limit)
+}
+
+func (m *MysqlFileRepo) GetFilesPendingThumbnail(limit int) ([]models.File, error) {
+	q := "SELECT " + fileSelectColumns + " FROM file WHERE ThumbnailStatus = 'pending' ORDER BY UploadedAt LIMIT ?"
+	return m.queryFiles(q, limit)
+}
+
+func (m *MysqlFileRepo) SetThumbnail(id string, thumbnailKey string, status string) error {
+	_, err := m.db.Exec(`UPDATE file SET ThumbnailKey = NULLIF(?, ''), ThumbnailStatus = ? WHERE Id = ?`,
+		thumbnailKey, status, id)
+	if err != nil {
+		return fmt.Errorf("failed to set thumbnail: %w", err)
+	}
+	return nil
+}
+
+func (m *MysqlFileRepo) RecordChecksumVerification(id string, ok bool) error {
+	_, err := m.db.Exec(`UPDATE file SET ChecksumVerifiedAt = ?, ChecksumMismatch = ? WHERE Id = ?`,
+		time.Now().UTC(), !ok, id)
+	if err != nil {
+		return fmt.Errorf("failed to record checksum verification: %w", err)
+	}
 	return nil
 }
 
 func (m *MysqlFileRepo) AddFile(file *models.File) error {
-	id := uuid.New()
+	if file.ID == "" {
+		file.ID = uuid.New().String()
+	}
 	q := `
-		INSERT INTO file (Id, S3Key, Name, Size, ExpirationDate, UserId, DownloadLink, UploadedAt)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO file (Id, S3Key, Name, Size, ExpirationDate, UserId, DownloadLink, UploadedAt,
+			MaxDownloads, Encrypted, EncryptedMetadata, Checksum, UploadRequestId)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''))
 	`
 
-	_, err := m.db.Exec(q, id, file.S3Key, file.Name, file.Size, file.ExpirationDate,
-		file.UserId, file.DownloadLink, file.UploadedAt)
+	_, err := m.db.Exec(q, file.ID, file.S3Key, file.Name, file.Size, file.ExpirationDate,
+		file.UserId, file.DownloadLink, file.UploadedAt, file.MaxDownloads, file.Encrypted, file.EncryptedMetadata,
+		file.Checksum, file.UploadRequestId)
 	if err != nil {
 		return fmt.Errorf("failed to insert file info: %w", err)
 	}
@@ -45,19 +150,27 @@ func (m *MysqlFileRepo) AddFile(file *models.File) error {
 }
 
 func (m *MysqlFileRepo) GetExpiredFiles(time time.Time) ([]models.File, error) {
-	rows, err := m.db.Query("SELECT Id, S3Key FROM file WHERE ExpirationDate IS NOT NULL AND ExpirationDate <= ?", time)
+	q := "SELECT " + fileSelectColumns + " FROM file WHERE ExpirationDate IS NOT NULL AND ExpirationDate <= ?"
+	return m.queryFiles(q, time)
+}
+
+// GetFilesExpiringBetween returns the files that are still available but expire by to
+func (m *MysqlFileRepo) GetFilesExpiringBetween(from time.Time, to time.Time) ([]models.File, error) {
+	q := "SELECT " + fileSelectColumns + " FROM file WHERE ExpirationDate > ? AND ExpirationDate <= ? ORDER BY ExpirationDate"
+	return m.queryFiles(q, from, to)
+}
+
+// ExtendExpiry moves the expiry of a file only if it is still current, so a stale extend link does nothing
+func (m *MysqlFileRepo) ExtendExpiry(id string, current time.Time, expiry time.Time) (bool, error) {
+	res, err := m.db.Exec("UPDATE file SET ExpirationDate = ? WHERE Id = ? AND ExpirationDate = ?", expiry, id, current)
 	if err != nil {
-		return nil, err
+		return false, fmt.Errorf("failed to extend expiry: %w", err)
 	}
-	defer rows.Close()
-
-	var files []models.File
-	for rows.Next() {
-		var f models.File
-		rows.Scan(&f.ID, &f.S3Key)
-		files = append(files, f)
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to extend expiry: %w", err)
 	}
-	return files, nil
+	return n == 1, nil
 }
 
 func NewMysqlFileRepo(db *sql.DB) FileDbRepo {
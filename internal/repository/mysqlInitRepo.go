@@ -17,7 +17,13 @@ func (m *MySQLInitRepo) CreateUserTableIfNotExist() error {
 		Email VARCHAR(255) UNIQUE NOT NULL,
 		Avatar VARCHAR(255),
 		IsEmailVerified BOOLEAN DEFAULT FALSE,
-    	AuthProvider VARCHAR(255) DEFAULT 'google'
+    	AuthProvider VARCHAR(255) DEFAULT 'google',
+    	AuthSubject VARCHAR(255),
+    	DownloadNotify VARCHAR(16) NOT NULL DEFAULT 'off',
+    	DownloadNotifySetAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    	Role VARCHAR(16) NOT NULL DEFAULT 'user',
+    	Disabled BOOLEAN NOT NULL DEFAULT FALSE,
+    	UNIQUE KEY identity (AuthProvider, AuthSubject)
 	)`
 
 	_, err := m.db.Exec(query)
@@ -32,12 +38,21 @@ func (m *MySQLInitRepo) CreateFileTableIfNotExist() error {
     	Id VARCHAR(255) PRIMARY KEY,
     	S3Key VARCHAR(512) UNIQUE NOT NULL,
 		Name VARCHAR(255) NOT NULL,
-		Size INT NOT NULL,
+		Size BIGINT NOT NULL,
 		ExpirationDate DATETIME,
 		UserId VARCHAR(255),
     	DownloadLink VARCHAR(255) UNIQUE NOT NULL,
     	UploadedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
-    	DownloadCount INT DEFAULT 0
+    	DownloadCount INT DEFAULT 0,
+    	MaxDownloads INT NOT NULL DEFAULT 0,
+    	Encrypted BOOLEAN NOT NULL DEFAULT FALSE,
+    	EncryptedMetadata TEXT,
+    	Checksum CHAR(64),
+    	ChecksumVerifiedAt DATETIME,
+    	ChecksumMismatch BOOLEAN NOT NULL DEFAULT FALSE,
+    	ThumbnailKey VARCHAR(600),
+    	ThumbnailStatus VARCHAR(16) NOT NULL DEFAULT 'pending',
+    	UploadRequestId VARCHAR(255)
 	)` //--FOREIGN KEY (UserId) REFERENCES user(Id) ON DELETE SET NULL can also use CASCADE or RESTRICT
 
 	_, err := m.db.Exec(query)
@@ -47,6 +62,335 @@ func (m *MySQLInitRepo) CreateFileTableIfNotExist() error {
 	return nil
 }
 
+func (m *MySQLInitRepo) CreateUploadRequestTableIfNotExist() error {
+	query := `CREATE TABLE IF NOT EXISTS upload_request (
+    	Id VARCHAR(255) PRIMARY KEY,
+    	TokenHash CHAR(64) UNIQUE NOT NULL,
+    	UserId VARCHAR(255) NOT NULL,
+    	Label VARCHAR(255) NOT NULL,
+    	MaxFileSize BIGINT NOT NULL DEFAULT 0,
+    	MaxFiles INT NOT NULL DEFAULT 0,
+    	AllowedTypes TEXT,
+    	ExpiresAt DATETIME NOT NULL,
+    	CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+    	UploadCount INT NOT NULL DEFAULT 0,
+    	Revoked BOOLEAN NOT NULL DEFAULT FALSE,
+    	INDEX (UserId)
+	)`
+
+	_, err := m.db.Exec(query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// addedColumns are the columns added to tables after their first release,
+// so that existing databases pick them up on start.
+var addedColumns = []struct{ table, name, definition string }{
+	{"file", "MaxDownloads", "INT NOT NULL DEFAULT 0"},
+	{"file", "Encrypted", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"file", "EncryptedMetadata", "TEXT"},
+	{"file", "Checksum", "CHAR(64)"},
+	{"file", "ChecksumVerifiedAt", "DATETIME"},
+	{"file", "ChecksumMismatch", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"file", "ThumbnailKey", "VARCHAR(600)"},
+	{"file", "ThumbnailStatus", "VARCHAR(16) NOT NULL DEFAULT 'pending'"},
+	{"file", "UploadRequestId", "VARCHAR(255)"},
+	{"user", "DownloadNotify", "VARCHAR(16) NOT NULL DEFAULT 'off'"},
+	{"user", "DownloadNotifySetAt", "DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP"},
+	{"user", "AuthSubject", "VARCHAR(255)"},
+	{"user", "Role", "VARCHAR(16) NOT NULL DEFAULT 'user'"},
+	{"user", "Disabled", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"download_event", "Link", "VARCHAR(1024)"},
+	{"download_event", "BytesSent", "BIGINT NOT NULL DEFAULT 0"},
+	{"download_event", "Completed", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"email_outbox", "FileId", "VARCHAR(255)"},
+}
+
+func (m *MySQLInitRepo) MigrateTables() error {
+	for _, col := range addedColumns {
+		if err := m.addColumnIfNotExist(col.table, col.name, col.definition); err != nil {
+			return err
+		}
+	}
+
+	if err := m.addIndexIfNotExist("user", "identity", "UNIQUE KEY identity (AuthProvider, AuthSubject)"); err != nil {
+		return err
+	}
+
+	// Multi-GB uploads overflow the original INT size column
+	if err := m.widenColumn("file", "Size", "bigint", "BIGINT NOT NULL"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *MySQLInitRepo) widenColumn(table, column, dataType, definition string) error {
+	var current string
+	q := `SELECT DATA_TYPE FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?`
+	if err := m.db.QueryRow(q, table, column).Scan(&current); err != nil {
+		return fmt.Errorf("failed to check column %s.%s: %w", table, column, err)
+	}
+	if current == dataType {
+		return nil
+	}
+
+	if _, err := m.db.Exec(fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("failed to modify column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+func (m *MySQLInitRepo) addIndexIfNotExist(table, name, definition string) error {
+	var count int
+	q := `SELECT COUNT(*) FROM information_schema.STATISTICS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME = ?`
+	if err := m.db.QueryRow(q, table, name).Scan(&count); err != nil {
+		return fmt.Errorf("failed to check index %s.%s: %w", table, name, err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	if _, err := m.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD %s", table, definition)); err != nil {
+		return fmt.Errorf("failed to add index %s.%s: %w", table, name, err)
+	}
+	return nil
+}
+
+func (m *MySQLInitRepo) addColumnIfNotExist(table, column, definition string) error {
+	var count int
+	q := `SELECT COUNT(*) FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?`
+	if err := m.db.QueryRow(q, table, column).Scan(&count); err != nil {
+		return fmt.Errorf("failed to check column %s.%s: %w", table, column, err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	if _, err := m.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+func (m *MySQLInitRepo) CreateDownloadEventTableIfNotExist() error {
+	query := `CREATE TABLE IF NOT EXISTS download_event (
+    	Id VARCHAR(255) PRIMARY KEY,
+    	FileId VARCHAR(255) NOT NULL,
+    	Link VARCHAR(1024),
+    	DownloadedAt DATETIME NOT NULL,
+    	ClientIP VARCHAR(64),
+    	UserAgent VARCHAR(512),
+    	DownloadedBy VARCHAR(255),
+    	BytesSent BIGINT NOT NULL DEFAULT 0,
+    	Completed BOOLEAN NOT NULL DEFAULT FALSE,
+    	NotifiedAt DATETIME,
+    	INDEX (FileId, DownloadedAt),
+    	INDEX (DownloadedAt)
+	)`
+
+	_, err := m.db.Exec(query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *MySQLInitRepo) CreateWebhookTablesIfNotExist() error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS webhook_endpoint (
+    	Id VARCHAR(255) PRIMARY KEY,
+    	UserId VARCHAR(255) NOT NULL,
+    	Url VARCHAR(2048) NOT NULL,
+    	Secret VARCHAR(255) NOT NULL,
+    	Events VARCHAR(512) NOT NULL,
+    	IsGlobal BOOLEAN NOT NULL DEFAULT FALSE,
+    	CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+    	INDEX (UserId)
+	)`,
+		`CREATE TABLE IF NOT EXISTS webhook_delivery (
+    	Id VARCHAR(255) PRIMARY KEY,
+    	EndpointId VARCHAR(255) NOT NULL,
+    	EventId VARCHAR(255) NOT NULL,
+    	EventType VARCHAR(64) NOT NULL,
+    	Payload MEDIUMTEXT NOT NULL,
+    	Status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    	Attempts INT NOT NULL DEFAULT 0,
+    	NextAttemptAt DATETIME NOT NULL,
+    	CreatedAt DATETIME NOT NULL,
+    	DeliveredAt DATETIME,
+    	INDEX (Status, NextAttemptAt),
+    	INDEX (EndpointId, CreatedAt)
+	)`,
+		`CREATE TABLE IF NOT EXISTS webhook_attempt (
+    	Id VARCHAR(255) PRIMARY KEY,
+    	DeliveryId VARCHAR(255) NOT NULL,
+    	AttemptedAt DATETIME NOT NULL,
+    	StatusCode INT NOT NULL DEFAULT 0,
+    	Error TEXT,
+    	DurationMs BIGINT NOT NULL DEFAULT 0,
+    	INDEX (DeliveryId)
+	)`,
+	}
+
+	for _, query := range queries {
+		if _, err := m.db.Exec(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MySQLInitRepo) CreateEmailOutboxTableIfNotExist() error {
+	query := `CREATE TABLE IF NOT EXISTS email_outbox (
+    	Id VARCHAR(255) PRIMARY KEY,
+    	UserId VARCHAR(255) NOT NULL,
+    	FileId VARCHAR(255),
+    	Message MEDIUMTEXT NOT NULL,
+    	Recipients TEXT NOT NULL,
+    	Status VARCHAR(16) NOT NULL DEFAULT 'queued',
+    	Attempts INT NOT NULL DEFAULT 0,
+    	NextAttemptAt DATETIME NOT NULL,
+    	LastError TEXT,
+    	ProviderResponse TEXT,
+    	CreatedAt DATETIME NOT NULL,
+    	SentAt DATETIME,
+    	INDEX (Status, NextAttemptAt),
+    	INDEX (FileId)
+	)`
+
+	_, err := m.db.Exec(query)
+	return err
+}
+
+func (m *MySQLInitRepo) CreateExpiryReminderTableIfNotExist() error {
+	query := `CREATE TABLE IF NOT EXISTS expiry_reminder (
+    	Id VARCHAR(255) PRIMARY KEY,
+    	FileId VARCHAR(255) NOT NULL,
+    	Recipient VARCHAR(255) NOT NULL,
+    	LeadTime INT NOT NULL,
+    	ExpiresAt DATETIME NOT NULL,
+    	SentAt DATETIME NOT NULL,
+    	UNIQUE KEY (FileId, Recipient, LeadTime, ExpiresAt)
+	)`
+
+	_, err := m.db.Exec(query)
+	return err
+}
+
+func (m *MySQLInitRepo) CreateEmailAbuseTablesIfNotExist() error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS email_send (
+    	Id VARCHAR(255) PRIMARY KEY,
+    	EmailId VARCHAR(255) NOT NULL,
+    	UserId VARCHAR(255),
+    	ClientIP VARCHAR(64) NOT NULL,
+    	Recipient VARCHAR(255) NOT NULL,
+    	CreatedAt DATETIME NOT NULL,
+    	INDEX (UserId, CreatedAt),
+    	INDEX (ClientIP, CreatedAt),
+    	INDEX (Recipient, CreatedAt)
+	)`,
+		`CREATE TABLE IF NOT EXISTS email_suppression (
+    	Address VARCHAR(255) PRIMARY KEY,
+    	Reason VARCHAR(32) NOT NULL,
+    	Detail TEXT,
+    	CreatedAt DATETIME NOT NULL
+	)`,
+	}
+
+	for _, query := range queries {
+		if _, err := m.db.Exec(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MySQLInitRepo) CreateRefreshTokenTableIfNotExist() error {
+	query := `CREATE TABLE IF NOT EXISTS refresh_token (
+    	Id VARCHAR(255) PRIMARY KEY,
+    	FamilyId VARCHAR(255) NOT NULL,
+    	UserId VARCHAR(255) NOT NULL,
+    	TokenHash CHAR(64) NOT NULL UNIQUE,
+    	CreatedAt DATETIME NOT NULL,
+    	ExpiresAt DATETIME NOT NULL,
+    	UsedAt DATETIME,
+    	RevokedAt DATETIME,
+    	INDEX (FamilyId),
+    	INDEX (UserId),
+    	INDEX (RevokedAt),
+    	INDEX (ExpiresAt)
+	)`
+
+	_, err := m.db.Exec(query)
+	return err
+}
+
+func (m *MySQLInitRepo) CreateApiTokenTableIfNotExist() error {
+	query := `CREATE TABLE IF NOT EXISTS api_token (
+    	Id VARCHAR(255) PRIMARY KEY,
+    	UserId VARCHAR(255) NOT NULL,
+    	Name VARCHAR(255) NOT NULL,
+    	Prefix VARCHAR(16) NOT NULL,
+    	TokenHash CHAR(64) NOT NULL UNIQUE,
+    	Scopes VARCHAR(255) NOT NULL,
+    	CreatedAt DATETIME NOT NULL,
+    	ExpiresAt DATETIME,
+    	LastUsedAt DATETIME,
+    	Revoked BOOLEAN NOT NULL DEFAULT FALSE,
+    	INDEX (UserId)
+	)`
+
+	_, err := m.db.Exec(query)
+	return err
+}
+
+func (m *MySQLInitRepo) CreateDeviceCodeTableIfNotExist() error {
+	query := `CREATE TABLE IF NOT EXISTS device_code (
+    	Id VARCHAR(255) PRIMARY KEY,
+    	DeviceCodeHash CHAR(64) NOT NULL UNIQUE,
+    	UserCode VARCHAR(16) NOT NULL UNIQUE,
+    	ClientName VARCHAR(255) NOT NULL,
+    	Scopes VARCHAR(255) NOT NULL,
+    	ClientIP VARCHAR(64) NOT NULL,
+    	Status VARCHAR(16) NOT NULL,
+    	UserId VARCHAR(255),
+    	PollInterval INT NOT NULL,
+    	LastPolledAt DATETIME,
+    	CreatedAt DATETIME NOT NULL,
+    	ExpiresAt DATETIME NOT NULL,
+    	INDEX (ClientIP, CreatedAt),
+    	INDEX (ExpiresAt)
+	)`
+
+	_, err := m.db.Exec(query)
+	return err
+}
+
+func (m *MySQLInitRepo) CreateAdminActionTableIfNotExist() error {
+	query := `CREATE TABLE IF NOT EXISTS admin_action (
+    	Id VARCHAR(255) PRIMARY KEY,
+    	ActorId VARCHAR(255) NOT NULL,
+    	ActorEmail VARCHAR(255) NOT NULL,
+    	Action VARCHAR(64) NOT NULL,
+    	TargetType VARCHAR(32) NOT NULL,
+    	TargetId VARCHAR(255) NOT NULL,
+    	Reason TEXT NOT NULL,
+    	Detail JSON,
+    	CreatedAt DATETIME NOT NULL,
+    	INDEX (CreatedAt),
+    	INDEX (TargetType, TargetId)
+	)`
+
+	_, err := m.db.Exec(query)
+	return err
+}
+
 func (m *MySQLInitRepo) InsertSampleData() error {
 	// Sample insert into user
 	userInsert := `
@@ -81,7 +425,7 @@ func (m *MySQLInitRepo) TruncateAllTables() error {
 	//}
 
 	// Truncate the tables - order here matters - Always truncate child tables before parent tables in the foreign key hierarchy.
-	tables := []string{"file", "user"}
+	tables := []string{"admin_action", "device_code", "api_token", "refresh_token", "email_suppression", "email_send", "expiry_reminder", "email_outbox", "webhook_attempt", "webhook_delivery", "webhook_endpoint", "download_event", "upload_request", "file", "user"}
 	for _, table := range tables {
 		if _, err := m.db.Exec(fmt.Sprintf("TRUNCATE TABLE %s", table)); err != nil {
 			return fmt.Errorf("failed to truncate table %s: %w", table, err)
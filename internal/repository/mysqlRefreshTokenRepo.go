@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"database/sql"
+	"fileTransfer/internal/models"
+	"fmt"
+	"time"
+)
+
+type MysqlRefreshTokenRepo struct {
+	db *sql.DB
+}
+
+func (m *MysqlRefreshTokenRepo) AddRefreshToken(token *models.RefreshToken) error {
+	_, err := m.db.Exec(`INSERT INTO refresh_token (Id, FamilyId, UserId, TokenHash, CreatedAt, ExpiresAt)
+		VALUES (?, ?, ?, ?, ?, ?)`, token.ID, token.FamilyId, token.UserId, token.TokenHash, token.CreatedAt, token.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to add refresh token: %w", err)
+	}
+	return nil
+}
+
+func (m *MysqlRefreshTokenRepo) GetRefreshTokenByHash(hash string) (*models.RefreshToken, error) {
+	var t models.RefreshToken
+	var usedAt, revokedAt sql.NullTime
+	err := m.db.QueryRow(`SELECT Id, FamilyId, UserId, TokenHash, CreatedAt, ExpiresAt, UsedAt, RevokedAt
+		FROM refresh_token WHERE TokenHash = ?`, hash).Scan(&t.ID, &t.FamilyId, &t.UserId, &t.TokenHash, &t.CreatedAt,
+		&t.ExpiresAt, &usedAt, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+	if usedAt.Valid {
+		t.UsedAt = &usedAt.Time
+	}
+	if revokedAt.Valid {
+		t.RevokedAt = &revokedAt.Time
+	}
+	return &t, nil
+}
+
+// UseRefreshToken marks a token used, returning false when it was already used or revoked, so of two concurrent
+// refreshes with the same token only one wins
+func (m *MysqlRefreshTokenRepo) UseRefreshToken(id string, at time.Time) (bool, error) {
+	res, err := m.db.Exec("UPDATE refresh_token SET UsedAt = ? WHERE Id = ? AND UsedAt IS NULL AND RevokedAt IS NULL", at, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to use refresh token: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to use refresh token: %w", err)
+	}
+	return n == 1, nil
+}
+
+func (m *MysqlRefreshTokenRepo) RevokeFamily(familyId string, at time.Time) error {
+	_, err := m.db.Exec("UPDATE refresh_token SET RevokedAt = ? WHERE FamilyId = ? AND RevokedAt IS NULL", at, familyId)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	return nil
+}
+
+// RevokeUserFamilies revokes every login of a user and returns the families that were still active
+func (m *MysqlRefreshTokenRepo) RevokeUserFamilies(userId string, at time.Time) ([]string, error) {
+	families, err := m.families("SELECT DISTINCT FamilyId FROM refresh_token WHERE UserId = ? AND RevokedAt IS NULL", userId)
+	if err != nil {
+		return nil, err
+	}
+	_, err = m.db.Exec("UPDATE refresh_token SET RevokedAt = ? WHERE UserId = ? AND RevokedAt IS NULL", at, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	return families, nil
+}
+
+func (m *MysqlRefreshTokenRepo) GetRevokedFamilies(since time.Time) ([]string, error) {
+	return m.families("SELECT DISTINCT FamilyId FROM refresh_token WHERE RevokedAt >= ?", since)
+}
+
+func (m *MysqlRefreshTokenRepo) families(q string, args ...any) ([]string, error) {
+	rows, err := m.db.Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token families: %w", err)
+	}
+	defer rows.Close()
+
+	var families []string
+	for rows.Next() {
+		var family string
+		if err := rows.Scan(&family); err != nil {
+			return nil, err
+		}
+		families = append(families, family)
+	}
+	return families, rows.Err()
+}
+
+// DeleteExpiredRefreshTokens forgets tokens that expired before the given time, revoked or not
+func (m *MysqlRefreshTokenRepo) DeleteExpiredRefreshTokens(before time.Time) (int64, error) {
+	res, err := m.db.Exec("DELETE FROM refresh_token WHERE ExpiresAt < ?", before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired refresh tokens: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+func NewMysqlRefreshTokenRepo(db *sql.DB) RefreshTokenDbRepo {
+	return &MysqlRefreshTokenRepo{db: db}
+}
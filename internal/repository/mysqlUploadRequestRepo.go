@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"database/sql"
+	"fileTransfer/internal/models"
+	"fmt"
+	"strings"
+)
+
+type MysqlUploadRequestRepo struct {
+	db *sql.DB
+}
+
+const uploadRequestSelectColumns = `Id, TokenHash, UserId, Label, MaxFileSize, MaxFiles, AllowedTypes, ExpiresAt,
+	CreatedAt, UploadCount, Revoked`
+
+func scanUploadRequest(row rowScanner) (*models.UploadRequest, error) {
+	var r models.UploadRequest
+	var allowedTypes string
+	err := row.Scan(&r.ID, &r.TokenHash, &r.UserId, &r.Label, &r.MaxFileSize, &r.MaxFiles, &allowedTypes,
+		&r.ExpiresAt, &r.CreatedAt, &r.UploadCount, &r.Revoked)
+	if err != nil {
+		return nil, err
+	}
+	if allowedTypes != "" {
+		r.AllowedTypes = strings.Split(allowedTypes, ",")
+	}
+	return &r, nil
+}
+
+func (m *MysqlUploadRequestRepo) AddUploadRequest(request *models.UploadRequest) error {
+	q := `
+		INSERT INTO upload_request (Id, TokenHash, UserId, Label, MaxFileSize, MaxFiles, AllowedTypes, ExpiresAt, CreatedAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	_, err := m.db.Exec(q, request.ID, request.TokenHash, request.UserId, request.Label, request.MaxFileSize,
+		request.MaxFiles, strings.Join(request.AllowedTypes, ","), request.ExpiresAt, request.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert upload request: %w", err)
+	}
+
+	return nil
+}
+
+func (m *MysqlUploadRequestRepo) GetUploadRequestByTokenHash(tokenHash string) (*models.UploadRequest, error) {
+	q := "SELECT " + uploadRequestSelectColumns + " FROM upload_request WHERE TokenHash = ?"
+	return scanUploadRequest(m.db.QueryRow(q, tokenHash))
+}
+
+func (m *MysqlUploadRequestRepo) ListUploadRequestsByUser(userId string) ([]models.UploadRequest, error) {
+	q := "SELECT " + uploadRequestSelectColumns + " FROM upload_request WHERE UserId = ? ORDER BY CreatedAt DESC"
+	rows, err := m.db.Query(q, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []models.UploadRequest
+	for rows.Next() {
+		r, err := scanUploadRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, *r)
+	}
+	return requests, rows.Err()
+}
+
+func (m *MysqlUploadRequestRepo) RevokeUploadRequest(id string, userId string) error {
+	res, err := m.db.Exec("UPDATE upload_request SET Revoked = TRUE WHERE Id = ? AND UserId = ?", id, userId)
+	if err != nil {
+		return fmt.Errorf("failed to revoke upload request: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ClaimUploadSlot reserves one of the request's files, failing with ErrUploadRequestFull once MaxFiles is used up
+func (m *MysqlUploadRequestRepo) ClaimUploadSlot(id string) error {
+	q := `UPDATE upload_request SET UploadCount = UploadCount + 1
+		WHERE Id = ? AND (MaxFiles = 0 OR UploadCount < MaxFiles)`
+
+	res, err := m.db.Exec(q, id)
+	if err != nil {
+		return fmt.Errorf("failed to claim upload slot: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to claim upload slot: %w", err)
+	}
+	if n == 0 {
+		return ErrUploadRequestFull
+	}
+	return nil
+}
+
+// ReleaseUploadSlot gives back a slot claimed for an upload that failed
+func (m *MysqlUploadRequestRepo) ReleaseUploadSlot(id string) error {
+	_, err := m.db.Exec("UPDATE upload_request SET UploadCount = UploadCount - 1 WHERE Id = ? AND UploadCount > 0", id)
+	if err != nil {
+		return fmt.Errorf("failed to release upload slot: %w", err)
+	}
+	return nil
+}
+
+func NewMysqlUploadRequestRepo(db *sql.DB) UploadRequestDbRepo {
+	return &MysqlUploadRequestRepo{db: db}
+}
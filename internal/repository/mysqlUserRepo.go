@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fileTransfer/internal/models"
 	"fmt"
+	"time"
+
 	"github.com/google/uuid"
 )
 
@@ -12,28 +14,45 @@ type MysqlUserRepo struct {
 	db *sql.DB
 }
 
+// FindOrCreateUser finds the user by provider and subject. An account with the same email that was created before
+// subjects were stored is linked to the identity, callers must only pass emails the provider verified.
 func (m *MysqlUserRepo) FindOrCreateUser(user *models.GoogleUser) (*models.GoogleUser, error) {
-	// 1. Check if user exists
-	q1 := `SELECT Id, Email, Name, Avatar, IsEmailVerified  FROM user WHERE Email = ?`
-	row := m.db.QueryRow(q1, user.Email)
+	// 1. Check if the identity is known
+	existingUser, err := m.findUserByIdentity(user.AuthProvider, user.AuthSubject)
+	if err == nil {
+		return existingUser, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
 
-	var existingUser models.GoogleUser
-	err := row.Scan(&existingUser.ID, &existingUser.Email, &existingUser.Name, &existingUser.Avatar, &existingUser.IsEmailVerified)
+	// 2. Link an account with the same email that has no identity yet
+	existingUser, err = m.FindUserByEmail(user.Email)
 	if err == nil {
-		// User found
-		return &existingUser, nil
+		if existingUser.AuthSubject != "" {
+			return nil, ErrIdentityConflict
+		}
+		res, err := m.db.Exec(`UPDATE user SET AuthProvider = ?, AuthSubject = ? WHERE Id = ? AND AuthSubject IS NULL`,
+			user.AuthProvider, user.AuthSubject, existingUser.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to link user: %w", err)
+		}
+		if n, err := res.RowsAffected(); err != nil || n != 1 {
+			return nil, ErrIdentityConflict
+		}
+		existingUser.AuthProvider, existingUser.AuthSubject = user.AuthProvider, user.AuthSubject
+		return existingUser, nil
 	} else if !errors.Is(err, sql.ErrNoRows) {
-		// Some DB error
 		return nil, err
 	}
 
-	// 2. User not found, insert new user
+	// 3. User not found, insert new user
 	userInsert := `
-		INSERT INTO user (Id, Email, Name, Avatar, IsEmailVerified)
-		VALUES (?, ?, ?, ?, ?)
+		INSERT INTO user (Id, Email, Name, Avatar, IsEmailVerified, AuthProvider, AuthSubject)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
 	`
 	id := uuid.New().String()
-	_, err = m.db.Exec(userInsert, id, user.Email, user.Name, user.Avatar, user.IsEmailVerified)
+	_, err = m.db.Exec(userInsert, id, user.Email, user.Name, user.Avatar, user.IsEmailVerified, user.AuthProvider,
+		user.AuthSubject)
 	if err != nil {
 		return nil, fmt.Errorf("failed to insert user: %w", err)
 	}
@@ -42,6 +61,52 @@ func (m *MysqlUserRepo) FindOrCreateUser(user *models.GoogleUser) (*models.Googl
 	return user, nil
 }
 
+func (m *MysqlUserRepo) findUserByIdentity(provider string, subject string) (*models.GoogleUser, error) {
+	return m.scanUser(m.db.QueryRow("SELECT "+userSelectColumns+" FROM user WHERE AuthProvider = ? AND AuthSubject = ?",
+		provider, subject))
+}
+
+func (m *MysqlUserRepo) FindUserByEmail(email string) (*models.GoogleUser, error) {
+	return m.findUser("Email", email)
+}
+
+func (m *MysqlUserRepo) FindUserByID(id string) (*models.GoogleUser, error) {
+	return m.findUser("Id", id)
+}
+
+const userSelectColumns = `Id, Email, Name, Avatar, IsEmailVerified, DownloadNotify, AuthProvider, AuthSubject, Role,
+	Disabled`
+
+func (m *MysqlUserRepo) findUser(column string, value string) (*models.GoogleUser, error) {
+	return m.scanUser(m.db.QueryRow("SELECT "+userSelectColumns+" FROM user WHERE "+column+" = ?", value))
+}
+
+func (m *MysqlUserRepo) scanUser(row rowScanner) (*models.GoogleUser, error) {
+	var user models.GoogleUser
+	var name, avatar, provider, subject sql.NullString
+	err := row.Scan(&user.ID, &user.Email, &name, &avatar, &user.IsEmailVerified, &user.DownloadNotify, &provider, &subject,
+		&user.Role, &user.Disabled)
+	if err != nil {
+		return nil, err
+	}
+	user.Name = name.String
+	user.Avatar = avatar.String
+	user.AuthProvider = provider.String
+	user.AuthSubject = subject.String
+
+	return &user, nil
+}
+
+func (m *MysqlUserRepo) SetDownloadNotify(id string, preference string) error {
+	// Saving the same preference again keeps its time, the assignments run left to right
+	_, err := m.db.Exec(`UPDATE user SET DownloadNotifySetAt = IF(DownloadNotify = ?, DownloadNotifySetAt, ?),
+		DownloadNotify = ? WHERE Id = ?`, preference, time.Now().UTC(), preference, id)
+	if err != nil {
+		return fmt.Errorf("failed to update download notification preference: %w", err)
+	}
+	return nil
+}
+
 func NewMysqlUserRepo(db *sql.DB) UserDbRepo {
 	return &MysqlUserRepo{db: db}
 }
@@ -0,0 +1,177 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fileTransfer/internal/models"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// userTable is an in-memory user table behind a database/sql driver, answering the queries of MysqlUserRepo
+type userTable struct {
+	users []*models.GoogleUser
+	// raceLink makes the link UPDATE match nothing, as if another login linked the account first
+	raceLink bool
+}
+
+func (t *userTable) Connect(context.Context) (driver.Conn, error) { return &userConn{t}, nil }
+func (t *userTable) Driver() driver.Driver                        { return nil }
+
+type userConn struct{ table *userTable }
+
+func (c *userConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *userConn) Close() error                        { return nil }
+func (c *userConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c *userConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	var match func(u *models.GoogleUser) bool
+	switch {
+	case strings.HasSuffix(query, "WHERE AuthProvider = ? AND AuthSubject = ?"):
+		match = func(u *models.GoogleUser) bool {
+			return u.AuthSubject != "" && u.AuthProvider == args[0].Value && u.AuthSubject == args[1].Value
+		}
+	case strings.HasSuffix(query, "WHERE Email = ?"):
+		match = func(u *models.GoogleUser) bool { return u.Email == args[0].Value }
+	case strings.HasSuffix(query, "WHERE Id = ?"):
+		match = func(u *models.GoogleUser) bool { return u.ID == args[0].Value }
+	default:
+		return nil, fmt.Errorf("unexpected query %q", query)
+	}
+	rows := &userRows{}
+	for _, u := range c.table.users {
+		if match(u) {
+			rows.users = append(rows.users, u)
+		}
+	}
+	return rows, nil
+}
+
+func (c *userConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	value := func(i int) string { return args[i].Value.(string) }
+	switch {
+	case strings.HasPrefix(query, "UPDATE user SET AuthProvider = ?, AuthSubject = ? WHERE Id = ? AND AuthSubject IS NULL"):
+		for _, u := range c.table.users {
+			if u.ID == value(2) && u.AuthSubject == "" && !c.table.raceLink {
+				u.AuthProvider, u.AuthSubject = value(0), value(1)
+				return driver.RowsAffected(1), nil
+			}
+		}
+		return driver.RowsAffected(0), nil
+	case strings.HasPrefix(strings.TrimSpace(query), "INSERT INTO user"):
+		for _, u := range c.table.users {
+			if u.Email == value(1) {
+				return nil, errors.New("duplicate entry for key 'Email'")
+			}
+		}
+		c.table.users = append(c.table.users, &models.GoogleUser{ID: value(0), Email: value(1), Name: value(2),
+			Avatar: value(3), IsEmailVerified: args[4].Value.(bool), AuthProvider: value(5), AuthSubject: value(6),
+			DownloadNotify: "off", Role: models.RoleUser})
+		return driver.RowsAffected(1), nil
+	}
+	return nil, fmt.Errorf("unexpected statement %q", query)
+}
+
+type userRows struct {
+	users []*models.GoogleUser
+}
+
+func (r *userRows) Columns() []string {
+	return strings.Split(strings.Join(strings.Fields(userSelectColumns), ""), ",")
+}
+
+func (r *userRows) Close() error { return nil }
+
+func (r *userRows) Next(dest []driver.Value) error {
+	if len(r.users) == 0 {
+		return io.EOF
+	}
+	u := r.users[0]
+	r.users = r.users[1:]
+	nullable := func(s string) driver.Value {
+		if s == "" {
+			return nil
+		}
+		return s
+	}
+	copy(dest, []driver.Value{u.ID, u.Email, nullable(u.Name), nullable(u.Avatar), u.IsEmailVerified, u.DownloadNotify,
+		nullable(u.AuthProvider), nullable(u.AuthSubject), u.Role, u.Disabled})
+	return nil
+}
+
+func TestFindOrCreateUser(t *testing.T) {
+	legacy := func() *models.GoogleUser {
+		return &models.GoogleUser{ID: "legacy", Email: "user@example.com", IsEmailVerified: true, DownloadNotify: "off",
+			Role: models.RoleUser}
+	}
+	linked := func(provider, subject string) *models.GoogleUser {
+		u := legacy()
+		u.ID, u.AuthProvider, u.AuthSubject = "linked", provider, subject
+		return u
+	}
+	login := func(provider, subject string) *models.GoogleUser {
+		return &models.GoogleUser{Email: "user@example.com", IsEmailVerified: true, Name: "User",
+			AuthProvider: provider, AuthSubject: subject}
+	}
+
+	tests := []struct {
+		name        string
+		users       []*models.GoogleUser
+		raceLink    bool
+		login       *models.GoogleUser
+		wantErr     error
+		wantId      string
+		wantSubject string
+		wantUsers   int
+	}{
+		{name: "new identity is inserted", login: login("google", "sub-1"), wantSubject: "sub-1", wantUsers: 1},
+		{name: "known identity", users: []*models.GoogleUser{linked("google", "sub-1")}, login: login("google", "sub-1"),
+			wantId: "linked", wantSubject: "sub-1", wantUsers: 1},
+		{name: "email without an identity is linked", users: []*models.GoogleUser{legacy()},
+			login: login("google", "sub-1"), wantId: "legacy", wantSubject: "sub-1", wantUsers: 1},
+		{name: "email with another subject", users: []*models.GoogleUser{linked("google", "sub-1")},
+			login: login("google", "sub-2"), wantErr: ErrIdentityConflict, wantUsers: 1},
+		{name: "email with another provider", users: []*models.GoogleUser{linked("github", "sub-1")},
+			login: login("google", "sub-1"), wantErr: ErrIdentityConflict, wantUsers: 1},
+		{name: "email linked concurrently", users: []*models.GoogleUser{legacy()}, raceLink: true,
+			login: login("google", "sub-1"), wantErr: ErrIdentityConflict, wantUsers: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := &userTable{users: tt.users, raceLink: tt.raceLink}
+			db := sql.OpenDB(table)
+			defer db.Close()
+			repo := NewMysqlUserRepo(db)
+
+			user, err := repo.FindOrCreateUser(tt.login)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if len(table.users) != tt.wantUsers {
+				t.Errorf("table has %d users, want %d", len(table.users), tt.wantUsers)
+			}
+			if err != nil {
+				return
+			}
+			if tt.wantId != "" && user.ID != tt.wantId {
+				t.Errorf("got user %s, want %s", user.ID, tt.wantId)
+			}
+			if user.AuthSubject != tt.wantSubject {
+				t.Errorf("got subject %q, want %q", user.AuthSubject, tt.wantSubject)
+			}
+
+			// The identity now finds the same user
+			again, err := repo.FindOrCreateUser(tt.login)
+			if err != nil {
+				t.Fatalf("second login: %v", err)
+			}
+			if again.ID != user.ID {
+				t.Errorf("second login found user %s, want %s", again.ID, user.ID)
+			}
+		})
+	}
+}
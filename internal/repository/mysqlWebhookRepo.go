@@ -0,0 +1,220 @@
+package repository
+
+import (
+	"database/sql"
+	"fileTransfer/internal/models"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type MysqlWebhookRepo struct {
+	db *sql.DB
+}
+
+const webhookEndpointSelectColumns = `Id, UserId, Url, Secret, Events, IsGlobal, CreatedAt`
+
+func scanWebhookEndpoint(row rowScanner) (*models.WebhookEndpoint, error) {
+	var e models.WebhookEndpoint
+	var events string
+	if err := row.Scan(&e.ID, &e.UserId, &e.URL, &e.Secret, &events, &e.Global, &e.CreatedAt); err != nil {
+		return nil, err
+	}
+	e.Events = strings.Split(events, ",")
+	return &e, nil
+}
+
+func (m *MysqlWebhookRepo) queryEndpoints(q string, args ...any) ([]models.WebhookEndpoint, error) {
+	rows, err := m.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var endpoints []models.WebhookEndpoint
+	for rows.Next() {
+		e, err := scanWebhookEndpoint(rows)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, *e)
+	}
+	return endpoints, rows.Err()
+}
+
+func (m *MysqlWebhookRepo) AddEndpoint(endpoint *models.WebhookEndpoint) error {
+	q := `
+		INSERT INTO webhook_endpoint (Id, UserId, Url, Secret, Events, IsGlobal, CreatedAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+
+	_, err := m.db.Exec(q, endpoint.ID, endpoint.UserId, endpoint.URL, endpoint.Secret,
+		strings.Join(endpoint.Events, ","), endpoint.Global, endpoint.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert webhook endpoint: %w", err)
+	}
+	return nil
+}
+
+func (m *MysqlWebhookRepo) ListEndpointsByUser(userId string) ([]models.WebhookEndpoint, error) {
+	return m.queryEndpoints("SELECT "+webhookEndpointSelectColumns+" FROM webhook_endpoint WHERE UserId = ? ORDER BY CreatedAt", userId)
+}
+
+func (m *MysqlWebhookRepo) GetEndpoint(id string) (*models.WebhookEndpoint, error) {
+	return scanWebhookEndpoint(m.db.QueryRow("SELECT "+webhookEndpointSelectColumns+" FROM webhook_endpoint WHERE Id = ?", id))
+}
+
+func (m *MysqlWebhookRepo) DeleteEndpoint(id string, userId string) error {
+	res, err := m.db.Exec("DELETE FROM webhook_endpoint WHERE Id = ? AND UserId = ?", id, userId)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook endpoint: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetSubscribedEndpoints returns the user's endpoints and the global ones that subscribe to eventType
+func (m *MysqlWebhookRepo) GetSubscribedEndpoints(userId string, eventType string) ([]models.WebhookEndpoint, error) {
+	q := "SELECT " + webhookEndpointSelectColumns + ` FROM webhook_endpoint
+		WHERE (UserId = ? OR IsGlobal) AND FIND_IN_SET(?, Events) > 0`
+	return m.queryEndpoints(q, userId, eventType)
+}
+
+const webhookDeliverySelectColumns = `Id, EndpointId, EventId, EventType, Payload, Status, Attempts, NextAttemptAt,
+	CreatedAt, DeliveredAt`
+
+func scanWebhookDelivery(row rowScanner) (*models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	var deliveredAt sql.NullTime
+	err := row.Scan(&d.ID, &d.EndpointId, &d.EventId, &d.EventType, &d.Payload, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &d.CreatedAt, &deliveredAt)
+	if err != nil {
+		return nil, err
+	}
+	if deliveredAt.Valid {
+		d.DeliveredAt = &deliveredAt.Time
+	}
+	return &d, nil
+}
+
+func (m *MysqlWebhookRepo) queryDeliveries(q string, args ...any) ([]models.WebhookDelivery, error) {
+	rows, err := m.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []models.WebhookDelivery
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (m *MysqlWebhookRepo) AddDelivery(delivery *models.WebhookDelivery) error {
+	q := `
+		INSERT INTO webhook_delivery (Id, EndpointId, EventId, EventType, Payload, Status, Attempts, NextAttemptAt, CreatedAt)
+		VALUES (?, ?, ?, ?, ?, ?, 0, ?, ?)
+	`
+
+	_, err := m.db.Exec(q, delivery.ID, delivery.EndpointId, delivery.EventId, delivery.EventType, delivery.Payload,
+		delivery.Status, delivery.NextAttemptAt, delivery.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert webhook delivery: %w", err)
+	}
+	return nil
+}
+
+func (m *MysqlWebhookRepo) GetDelivery(id string) (*models.WebhookDelivery, error) {
+	d, err := scanWebhookDelivery(m.db.QueryRow("SELECT "+webhookDeliverySelectColumns+" FROM webhook_delivery WHERE Id = ?", id))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := m.db.Query(`SELECT Id, DeliveryId, AttemptedAt, StatusCode, Error, DurationMs
+		FROM webhook_attempt WHERE DeliveryId = ? ORDER BY AttemptedAt`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a models.WebhookAttempt
+		var errText sql.NullString
+		if err := rows.Scan(&a.ID, &a.DeliveryId, &a.AttemptedAt, &a.StatusCode, &errText, &a.DurationMs); err != nil {
+			return nil, err
+		}
+		a.Error = errText.String
+		d.AttemptLog = append(d.AttemptLog, a)
+	}
+	return d, rows.Err()
+}
+
+func (m *MysqlWebhookRepo) ListDeliveriesByEndpoint(endpointId string, limit int) ([]models.WebhookDelivery, error) {
+	q := "SELECT " + webhookDeliverySelectColumns + " FROM webhook_delivery WHERE EndpointId = ? ORDER BY CreatedAt DESC LIMIT ?"
+	return m.queryDeliveries(q, endpointId, limit)
+}
+
+func (m *MysqlWebhookRepo) GetDueDeliveries(now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	q := "SELECT " + webhookDeliverySelectColumns + ` FROM webhook_delivery
+		WHERE Status = 'pending' AND NextAttemptAt <= ? ORDER BY NextAttemptAt LIMIT ?`
+	return m.queryDeliveries(q, now, limit)
+}
+
+// ClaimDelivery pushes NextAttemptAt out to leaseUntil so no other worker picks the delivery up meanwhile
+func (m *MysqlWebhookRepo) ClaimDelivery(id string, now time.Time, leaseUntil time.Time) (bool, error) {
+	res, err := m.db.Exec(`UPDATE webhook_delivery SET NextAttemptAt = ?
+		WHERE Id = ? AND Status = 'pending' AND NextAttemptAt <= ?`, leaseUntil, id, now)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim webhook delivery: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim webhook delivery: %w", err)
+	}
+	return n == 1, nil
+}
+
+func (m *MysqlWebhookRepo) RecordAttempt(attempt *models.WebhookAttempt, status string, nextAttemptAt time.Time) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO webhook_attempt (Id, DeliveryId, AttemptedAt, StatusCode, Error, DurationMs)
+		VALUES (?, ?, ?, ?, NULLIF(?, ''), ?)`,
+		attempt.ID, attempt.DeliveryId, attempt.AttemptedAt, attempt.StatusCode, attempt.Error, attempt.DurationMs)
+	if err != nil {
+		return fmt.Errorf("failed to insert webhook attempt: %w", err)
+	}
+
+	_, err = tx.Exec(`UPDATE webhook_delivery SET Attempts = Attempts + 1, Status = ?, NextAttemptAt = ?,
+		DeliveredAt = IF(? = 'delivered', ?, DeliveredAt) WHERE Id = ?`,
+		status, nextAttemptAt, status, attempt.AttemptedAt, attempt.DeliveryId)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook delivery: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// Redeliver queues a delivery to be sent again straight away, keeping its attempt history
+func (m *MysqlWebhookRepo) Redeliver(id string) error {
+	_, err := m.db.Exec(`UPDATE webhook_delivery SET Status = 'pending', Attempts = 0, NextAttemptAt = ?, DeliveredAt = NULL
+		WHERE Id = ?`, time.Now().UTC(), id)
+	if err != nil {
+		return fmt.Errorf("failed to redeliver webhook: %w", err)
+	}
+	return nil
+}
+
+func NewMysqlWebhookRepo(db *sql.DB) WebhookDbRepo {
+	return &MysqlWebhookRepo{db: db}
+}
@@ -0,0 +1,16 @@
+package repository
+
+import (
+	"fileTransfer/internal/models"
+	"time"
+)
+
+type RefreshTokenDbRepo interface {
+	AddRefreshToken(token *models.RefreshToken) error
+	GetRefreshTokenByHash(hash string) (*models.RefreshToken, error)
+	UseRefreshToken(id string, at time.Time) (bool, error)
+	RevokeFamily(familyId string, at time.Time) error
+	RevokeUserFamilies(userId string, at time.Time) ([]string, error)
+	GetRevokedFamilies(since time.Time) ([]string, error)
+	DeleteExpiredRefreshTokens(before time.Time) (int64, error)
+}
@@ -0,0 +1,17 @@
+package repository
+
+import (
+	"errors"
+	"fileTransfer/internal/models"
+)
+
+var ErrUploadRequestFull = errors.New("upload request has received the maximum number of files")
+
+type UploadRequestDbRepo interface {
+	AddUploadRequest(request *models.UploadRequest) error
+	GetUploadRequestByTokenHash(tokenHash string) (*models.UploadRequest, error)
+	ListUploadRequestsByUser(userId string) ([]models.UploadRequest, error)
+	RevokeUploadRequest(id string, userId string) error
+	ClaimUploadSlot(id string) error
+	ReleaseUploadSlot(id string) error
+}
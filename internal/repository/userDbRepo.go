@@ -1,7 +1,16 @@
 package repository
 
-import "fileTransfer/internal/models"
+import (
+	"errors"
+	"fileTransfer/internal/models"
+)
+
+// ErrIdentityConflict is returned when a login's email belongs to an account linked to another identity
+var ErrIdentityConflict = errors.New("email is linked to another identity")
 
 type UserDbRepo interface {
 	FindOrCreateUser(user *models.GoogleUser) (*models.GoogleUser, error)
+	FindUserByEmail(email string) (*models.GoogleUser, error)
+	FindUserByID(id string) (*models.GoogleUser, error)
+	SetDownloadNotify(id string, preference string) error
 }
@@ -0,0 +1,22 @@
+package repository
+
+import (
+	"fileTransfer/internal/models"
+	"time"
+)
+
+type WebhookDbRepo interface {
+	AddEndpoint(endpoint *models.WebhookEndpoint) error
+	ListEndpointsByUser(userId string) ([]models.WebhookEndpoint, error)
+	GetEndpoint(id string) (*models.WebhookEndpoint, error)
+	DeleteEndpoint(id string, userId string) error
+	GetSubscribedEndpoints(userId string, eventType string) ([]models.WebhookEndpoint, error)
+
+	AddDelivery(delivery *models.WebhookDelivery) error
+	GetDelivery(id string) (*models.WebhookDelivery, error)
+	ListDeliveriesByEndpoint(endpointId string, limit int) ([]models.WebhookDelivery, error)
+	GetDueDeliveries(now time.Time, limit int) ([]models.WebhookDelivery, error)
+	ClaimDelivery(id string, now time.Time, leaseUntil time.Time) (bool, error)
+	RecordAttempt(attempt *models.WebhookAttempt, status string, nextAttemptAt time.Time) error
+	Redeliver(id string) error
+}
@@ -0,0 +1,264 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fileTransfer/internal/config"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+)
+
+// Identity is the account an auth provider vouched for
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Avatar        string
+}
+
+// AuthProvider is a login provider. Logins use PKCE, and a nonce when the provider issues ID tokens.
+type AuthProvider interface {
+	Name() string
+	AuthCodeURL(state string, verifier string, nonce string) string
+	// Exchange redeems the code and returns the identity, checking the ID token against the nonce if there is one
+	Exchange(ctx context.Context, code string, verifier string, nonce string) (*Identity, error)
+}
+
+// AuthProviders is the registry of configured providers, keyed by name
+type AuthProviders map[string]AuthProvider
+
+// NewAuthProviders sets up every configured provider, OIDC issuers are discovered so they must be reachable
+func NewAuthProviders(ctx context.Context, cfgs []config.AuthProviderConfig) (AuthProviders, error) {
+	providers := make(AuthProviders)
+	for _, cfg := range cfgs {
+		var p AuthProvider
+		var err error
+		switch cfg.Type {
+		case "oidc":
+			p, err = NewOIDCProvider(ctx, cfg)
+		case "github":
+			p = NewGitHubProvider(cfg)
+		default:
+			err = fmt.Errorf("unknown type %q", cfg.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("auth provider %s: %w", cfg.Name, err)
+		}
+		providers[cfg.Name] = p
+	}
+	return providers, nil
+}
+
+// Names lists the providers in a stable order
+func (p AuthProviders) Names() []string {
+	var names []string
+	for name := range p {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// OIDCProvider logs in with any OpenID Connect issuer, such as Google, Keycloak, Azure AD or Okta.
+// ID tokens are verified against the issuer's JWKS, which is fetched and cached as keys rotate.
+type OIDCProvider struct {
+	name     string
+	oauth    *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+func NewOIDCProvider(ctx context.Context, cfg config.AuthProviderConfig) (*OIDCProvider, error) {
+	provider, err := oidc.NewProvider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("discovery failed: %w", err)
+	}
+
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"profile", "email"}
+	}
+	if !slices.Contains(scopes, oidc.ScopeOpenID) {
+		scopes = append([]string{oidc.ScopeOpenID}, scopes...)
+	}
+
+	return &OIDCProvider{
+		name: cfg.Name,
+		oauth: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Scopes:       scopes,
+			Endpoint:     provider.Endpoint(),
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+	}, nil
+}
+
+func (p *OIDCProvider) Name() string {
+	return p.name
+}
+
+func (p *OIDCProvider) AuthCodeURL(state string, verifier string, nonce string) string {
+	return p.oauth.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier), oidc.Nonce(nonce))
+}
+
+func (p *OIDCProvider) Exchange(ctx context.Context, code string, verifier string, nonce string) (*Identity, error) {
+	token, err := p.oauth.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, redactOAuthError(err)
+	}
+
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		return nil, errors.New("no ID token in the token response")
+	}
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("invalid ID token: nonce mismatch")
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified any    `json:"email_verified"`
+		Name          string `json:"name"`
+		Picture       string `json:"picture"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+
+	return &Identity{
+		Provider:      p.name,
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: claimIsTrue(claims.EmailVerified),
+		Name:          claims.Name,
+		Avatar:        claims.Picture,
+	}, nil
+}
+
+// claimIsTrue reads a boolean claim, which some issuers send as a string
+func claimIsTrue(v any) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case string:
+		b, _ := strconv.ParseBool(v)
+		return b
+	}
+	return false
+}
+
+const githubAPI = "https://api.github.com"
+
+// GitHubProvider logs in with a GitHub OAuth app. GitHub has no ID tokens, the identity is read from its API.
+type GitHubProvider struct {
+	name   string
+	oauth  *oauth2.Config
+	apiURL string
+}
+
+func NewGitHubProvider(cfg config.AuthProviderConfig) *GitHubProvider {
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"read:user", "user:email"}
+	}
+	return &GitHubProvider{
+		name: cfg.Name,
+		oauth: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Scopes:       scopes,
+			Endpoint:     github.Endpoint,
+		},
+		apiURL: githubAPI,
+	}
+}
+
+func (p *GitHubProvider) Name() string {
+	return p.name
+}
+
+func (p *GitHubProvider) AuthCodeURL(state string, verifier string, nonce string) string {
+	return p.oauth.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier))
+}
+
+func (p *GitHubProvider) Exchange(ctx context.Context, code string, verifier string, nonce string) (*Identity, error) {
+	token, err := p.oauth.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, redactOAuthError(err)
+	}
+	client := p.oauth.Client(ctx, token)
+	client.Timeout = 10 * time.Second
+
+	var user struct {
+		ID        int64  `json:"id"`
+		Login     string `json:"login"`
+		Name      string `json:"name"`
+		AvatarURL string `json:"avatar_url"`
+	}
+	if err := getJSON(client, p.apiURL+"/user", &user); err != nil {
+		return nil, err
+	}
+
+	// The profile email may be unset or unverified, the primary verified one comes from the emails endpoint
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(client, p.apiURL+"/user/emails", &emails); err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{Provider: p.name, Subject: strconv.FormatInt(user.ID, 10), Name: user.Name, Avatar: user.AvatarURL}
+	if identity.Name == "" {
+		identity.Name = user.Login
+	}
+	for _, e := range emails {
+		if e.Primary {
+			identity.Email, identity.EmailVerified = e.Email, e.Verified
+		}
+	}
+	return identity, nil
+}
+
+func getJSON(client *http.Client, url string, v any) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// redactOAuthError keeps the provider's error code but drops the response body, which may echo the request
+func redactOAuthError(err error) error {
+	var re *oauth2.RetrieveError
+	if errors.As(err, &re) {
+		return errors.New("token endpoint returned " + re.ErrorCode)
+	}
+	return err
+}
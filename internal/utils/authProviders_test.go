@@ -0,0 +1,191 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fileTransfer/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// testIssuer is an OpenID Connect issuer serving discovery, its JWKS and a token endpoint that returns idToken
+type testIssuer struct {
+	*httptest.Server
+	key     *rsa.PrivateKey
+	idToken string
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &testIssuer{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                issuer.URL,
+			"authorization_endpoint":                issuer.URL + "/authorize",
+			"token_endpoint":                        issuer.URL + "/token",
+			"jwks_uri":                              issuer.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "issuer-key", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "code" || r.FormValue("code_verifier") != "verifier" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "access", "token_type": "Bearer",
+			"id_token": issuer.idToken})
+	})
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+// sign makes an ID token, the claims default to a valid token for the client "client" with the nonce "nonce"
+func (i *testIssuer) sign(t *testing.T, key *rsa.PrivateKey, edit func(claims map[string]any)) string {
+	t.Helper()
+	claims := map[string]any{
+		"iss":            i.URL,
+		"sub":            "subject",
+		"aud":            "client",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          "nonce",
+		"email":          "user@example.com",
+		"email_verified": true,
+		"name":           "User",
+	}
+	edit(claims)
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "issuer-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := signed.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func newTestOIDCProvider(t *testing.T, issuer string) (*OIDCProvider, error) {
+	t.Helper()
+	return NewOIDCProvider(context.Background(), config.AuthProviderConfig{Name: "test", Type: "oidc", Issuer: issuer,
+		ClientID: "client", ClientSecret: "secret", RedirectURL: "http://localhost/auth/test/callback"})
+}
+
+func TestOIDCDiscovery(t *testing.T) {
+	issuer := newTestIssuer(t)
+
+	p, err := newTestOIDCProvider(t, issuer.URL)
+	if err != nil {
+		t.Fatalf("NewOIDCProvider: %v", err)
+	}
+	authURL := p.AuthCodeURL("state", "verifier", "nonce")
+	for _, want := range []string{issuer.URL + "/authorize?", "code_challenge_method=S256", "nonce=nonce",
+		"scope=openid+profile+email"} {
+		if !strings.Contains(authURL, want) {
+			t.Errorf("auth URL %s lacks %s", authURL, want)
+		}
+	}
+
+	// The issuer in the discovery document must be the one configured
+	if _, err := newTestOIDCProvider(t, issuer.URL+"/"); err == nil {
+		t.Error("discovery accepted an issuer mismatch")
+	}
+	if _, err := newTestOIDCProvider(t, issuer.URL+"/missing"); err == nil {
+		t.Error("discovery accepted a missing configuration")
+	}
+}
+
+func TestOIDCExchange(t *testing.T) {
+	issuer := newTestIssuer(t)
+	p, err := newTestOIDCProvider(t, issuer.URL)
+	if err != nil {
+		t.Fatalf("NewOIDCProvider: %v", err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		key          *rsa.PrivateKey
+		claims       func(map[string]any)
+		code         string
+		wantErr      string
+		wantVerified bool
+	}{
+		{name: "valid", wantVerified: true},
+		{name: "email_verified as a string", claims: func(c map[string]any) { c["email_verified"] = "true" }, wantVerified: true},
+		{name: "unverified email", claims: func(c map[string]any) { c["email_verified"] = false }},
+		{name: "no email_verified", claims: func(c map[string]any) { delete(c, "email_verified") }},
+		{name: "nonce mismatch", claims: func(c map[string]any) { c["nonce"] = "other" }, wantErr: "nonce mismatch"},
+		{name: "no nonce", claims: func(c map[string]any) { delete(c, "nonce") }, wantErr: "nonce mismatch"},
+		{name: "signed with a key not in the JWKS", key: otherKey, wantErr: "invalid ID token"},
+		{name: "another audience", claims: func(c map[string]any) { c["aud"] = "other" }, wantErr: "invalid ID token"},
+		{name: "another issuer", claims: func(c map[string]any) { c["iss"] = "https://other.example.com" }, wantErr: "invalid ID token"},
+		{name: "expired", claims: func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, wantErr: "invalid ID token"},
+		{name: "rejected code", code: "wrong", wantErr: "token endpoint returned invalid_grant"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, claims, code := issuer.key, tt.claims, tt.code
+			if tt.key != nil {
+				key = tt.key
+			}
+			if claims == nil {
+				claims = func(map[string]any) {}
+			}
+			if code == "" {
+				code = "code"
+			}
+			issuer.idToken = issuer.sign(t, key, claims)
+
+			identity, err := p.Exchange(context.Background(), code, "verifier", "nonce")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Exchange: %v", err)
+			}
+			want := Identity{Provider: "test", Subject: "subject", Email: "user@example.com",
+				EmailVerified: tt.wantVerified, Name: "User"}
+			if *identity != want {
+				t.Errorf("got %+v, want %+v", *identity, want)
+			}
+		})
+	}
+}
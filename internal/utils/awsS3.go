@@ -2,10 +2,12 @@ package utils
 
 import (
 	"context"
+	appConfig "fileTransfer/internal/config"
+	"fileTransfer/internal/models"
 	"fileTransfer/internal/repository"
 	"fmt"
+	"io"
 	"log"
-	"mime/multipart"
 	"os"
 	"time"
 
@@ -13,6 +15,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
 type AwsS3 struct {
@@ -30,7 +33,12 @@ func NewAwsS3() *AwsS3 {
 	}
 
 	s3Client := s3.NewFromConfig(cfg)
-	uploader := manager.NewUploader(s3Client)
+	uploader := manager.NewUploader(s3Client, func(u *manager.Uploader) {
+		u.PartSize = max(appConfig.Storage.UploadPartSize, manager.MinUploadPartSize)
+		if appConfig.Storage.UploadConcurrency > 0 {
+			u.Concurrency = appConfig.Storage.UploadConcurrency
+		}
+	})
 
 	return &AwsS3{
 		Client:     s3Client,
@@ -39,27 +47,45 @@ func NewAwsS3() *AwsS3 {
 	}
 }
 
-// UploadFile : Upload file to S3-AWS
-func (a *AwsS3) UploadFile(file *multipart.FileHeader) (*manager.UploadOutput, error) {
-	src, err := file.Open()
-	if err != nil {
-		return nil, err
-	}
-	defer src.Close()
+// UploadResult describes a stored object along with the size and SHA-256 seen while uploading
+type UploadResult struct {
+	Key      string
+	Location string
+	Size     int64
+	Checksum string
+}
 
-	key := fmt.Sprintf("uploads/%d_%s", time.Now().Unix(), file.Filename)
+// UploadFile : Stream a file to S3-AWS
+func (a *AwsS3) UploadFile(filename string, body io.Reader) (*UploadResult, error) {
+	key := fmt.Sprintf("uploads/%d_%s", time.Now().Unix(), filename)
 
-	res, err := a.Uploader.Upload(context.TODO(), &s3.PutObjectInput{
-		Bucket: aws.String(a.BucketName),
-		Key:    aws.String(key),
-		Body:   src,
-	})
+	return a.UploadObject(key, body, "")
+}
+
+// UploadObject : Upload a stream to S3-AWS under the given key, hashing it on the way
+func (a *AwsS3) UploadObject(key string, body io.Reader, contentType string) (*UploadResult, error) {
+	hr := NewHashingReader(body)
+	input := &s3.PutObjectInput{
+		Bucket:            aws.String(a.BucketName),
+		Key:               aws.String(key),
+		Body:              hr,
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+
+	res, err := a.Uploader.Upload(context.TODO(), input)
 	if err != nil {
 		return nil, err
 	}
 
-	//log.Println("1: ", *res.Expiration, "1: ", *res.Key, "1: ", res.Location)
-	return res, nil
+	return &UploadResult{
+		Key:      aws.ToString(res.Key),
+		Location: res.Location,
+		Size:     hr.Size(),
+		Checksum: hr.Checksum(),
+	}, nil
 }
 
 // ListFiles Lists all files on S3-AWS
@@ -77,8 +103,9 @@ func (a *AwsS3) ListFiles() (*s3.ListObjectsV2Output, error) {
 // DownloadFile Downloads file from S3-AWS
 func (a *AwsS3) DownloadFile(key string) (*s3.GetObjectOutput, error) {
 	resp, err := a.Client.GetObject(context.TODO(), &s3.GetObjectInput{
-		Bucket: aws.String(a.BucketName),
-		Key:    aws.String(key),
+		Bucket:       aws.String(a.BucketName),
+		Key:          aws.String(key),
+		ChecksumMode: types.ChecksumModeEnabled,
 	})
 	if err != nil {
 		return nil, err
@@ -87,6 +114,19 @@ func (a *AwsS3) DownloadFile(key string) (*s3.GetObjectOutput, error) {
 	return resp, nil
 }
 
+// DownloadFileRange Downloads part of a file from S3-AWS, rangeHeader is an HTTP Range value or empty for the whole file
+func (a *AwsS3) DownloadFileRange(key string, rangeHeader string) (*s3.GetObjectOutput, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(a.BucketName),
+		Key:    aws.String(key),
+	}
+	if rangeHeader != "" {
+		input.Range = aws.String(rangeHeader)
+	}
+
+	return a.Client.GetObject(context.TODO(), input)
+}
+
 // GenerateSignedURL Generates signed URL for uploaded files
 func (a *AwsS3) GenerateSignedURL(key string, expiry time.Duration) (string, error) {
 	input := &s3.GetObjectInput{
@@ -106,8 +146,17 @@ func (a *AwsS3) GenerateSignedURL(key string, expiry time.Duration) (string, err
 	return presignResult.URL, nil
 }
 
+// DeleteObject Deletes an object from S3-AWS
+func (a *AwsS3) DeleteObject(key string) error {
+	_, err := a.Client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+		Bucket: aws.String(a.BucketName),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
 // DeleteExpiredFiles Check and Delete Expired file from AWS
-func (a *AwsS3) DeleteExpiredFiles(repo repository.FileDbRepo) {
+func (a *AwsS3) DeleteExpiredFiles(repo repository.FileDbRepo, webhooks *WebhookService) {
 	log.Println("Checking for expired files...")
 
 	expiredFiles, err := repo.GetExpiredFiles(time.Now())
@@ -118,21 +167,26 @@ func (a *AwsS3) DeleteExpiredFiles(repo repository.FileDbRepo) {
 
 	for _, file := range expiredFiles {
 		// Delete from S3
-		_, err := a.Client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
-			Bucket: aws.String(a.BucketName),
-			Key:    aws.String(file.S3Key),
-		})
+		err := a.DeleteObject(file.S3Key)
 		if err != nil {
 			log.Printf("Failed to delete from S3: %v", err)
 			continue
 		}
 
+		// Delete the thumbnail stored next to it
+		if file.ThumbnailKey != "" {
+			if err := a.DeleteObject(file.ThumbnailKey); err != nil {
+				log.Printf("Failed to delete thumbnail from S3: %v", err)
+			}
+		}
+
 		// Delete DB record
 		err = repo.DeleteFileByID(file.ID)
 		if err != nil {
 			log.Printf("Failed to delete DB record: %v", err)
 		} else {
 			log.Printf("Deleted file: %s", file.S3Key)
+			webhooks.Publish(models.EventFileExpired, file.UserId, FileEventData(&file))
 		}
 	}
 }
@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fileTransfer/internal/repository"
+	"hash"
+	"io"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// HashingReader counts and SHA-256 hashes everything read through it
+type HashingReader struct {
+	r    io.Reader
+	h    hash.Hash
+	size int64
+}
+
+func NewHashingReader(r io.Reader) *HashingReader {
+	return &HashingReader{r: r, h: sha256.New()}
+}
+
+func (hr *HashingReader) Read(p []byte) (int, error) {
+	n, err := hr.r.Read(p)
+	if n > 0 {
+		hr.h.Write(p[:n])
+		hr.size += int64(n)
+	}
+	return n, err
+}
+
+// Size returns the number of bytes read so far
+func (hr *HashingReader) Size() int64 {
+	return hr.size
+}
+
+// Checksum returns the hex encoded SHA-256 of the bytes read so far
+func (hr *HashingReader) Checksum() string {
+	return hex.EncodeToString(hr.h.Sum(nil))
+}
+
+// DigestHeader formats a hex SHA-256 checksum as Repr-Digest (RFC 9530) and legacy Digest (RFC 3230) values
+func DigestHeader(checksum string) (reprDigest string, legacyDigest string, ok bool) {
+	sum, err := hex.DecodeString(checksum)
+	if err != nil || len(sum) != sha256.Size {
+		return "", "", false
+	}
+	b64 := base64.StdEncoding.EncodeToString(sum)
+	return "sha-256=:" + b64 + ":", "SHA-256=" + b64, true
+}
+
+// VerifyStoredChecksums re-hashes a random sample of stored objects up to maxObjectSize bytes and reports any that
+// no longer match. Uploads over the part size are multipart, and S3 keeps only a checksum of the part checksums for
+// those, so the object has to be read back rather than compared against the checksum S3 stored.
+func (a *AwsS3) VerifyStoredChecksums(repo repository.FileDbRepo, sampleSize int, maxObjectSize int64) {
+	log.Println("Verifying stored checksums...")
+
+	files, err := repo.GetFilesForChecksumVerification(sampleSize, maxObjectSize)
+	if err != nil {
+		log.Printf("Failed to get files for checksum verification: %v", err)
+		return
+	}
+
+	mismatches := 0
+	for _, file := range files {
+		resp, err := a.Client.GetObject(context.TODO(), &s3.GetObjectInput{
+			Bucket: aws.String(a.BucketName),
+			Key:    aws.String(file.S3Key),
+		})
+		if err != nil {
+			log.Printf("Checksum verification could not read %s: %v", file.S3Key, err)
+			continue
+		}
+
+		// An object that grew past the limit since it was recorded can't match, so there is no need to read all of it
+		hr := NewHashingReader(io.LimitReader(resp.Body, maxObjectSize+1))
+		_, err = io.Copy(io.Discard, hr)
+		resp.Body.Close()
+		if err != nil {
+			log.Printf("Checksum verification could not read %s: %v", file.S3Key, err)
+			continue
+		}
+
+		ok := hr.Checksum() == file.Checksum
+		if !ok {
+			mismatches++
+			log.Printf("CHECKSUM MISMATCH for %s (file %s): recorded %s, stored object hashes to %s",
+				file.S3Key, file.ID, file.Checksum, hr.Checksum())
+		}
+		if err := repo.RecordChecksumVerification(file.ID, ok); err != nil {
+			log.Printf("Failed to record checksum verification for %s: %v", file.S3Key, err)
+		}
+	}
+
+	log.Printf("Checksum verification finished: %d checked, %d mismatched", len(files), mismatches)
+}
@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/repository"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestHashingReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		checksum string
+	}{
+		{"empty", "", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{"text", "hello world", "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// One byte at a time, as a slow upload would arrive
+			hr := NewHashingReader(iotest.OneByteReader(strings.NewReader(tt.input)))
+			data, err := io.ReadAll(hr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.input {
+				t.Errorf("read %q, want %q", data, tt.input)
+			}
+			if hr.Size() != int64(len(tt.input)) {
+				t.Errorf("got size %d, want %d", hr.Size(), len(tt.input))
+			}
+			if hr.Checksum() != tt.checksum {
+				t.Errorf("got checksum %s, want %s", hr.Checksum(), tt.checksum)
+			}
+		})
+	}
+}
+
+func TestDigestHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		checksum string
+		repr     string
+		legacy   string
+		ok       bool
+	}{
+		{"empty file", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			"sha-256=:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=:", "SHA-256=47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=", true},
+		{"text", "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9",
+			"sha-256=:uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek=:", "SHA-256=uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek=", true},
+		{"no checksum recorded", "", "", "", false},
+		{"not hex", strings.Repeat("z", 64), "", "", false},
+		{"too short", "b94d27b9", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repr, legacy, ok := DigestHeader(tt.checksum)
+			if repr != tt.repr || legacy != tt.legacy || ok != tt.ok {
+				t.Errorf("got %q %q %v, want %q %q %v", repr, legacy, ok, tt.repr, tt.legacy, tt.ok)
+			}
+		})
+	}
+}
+
+// scrubbedFiles samples every file up to the size limit and records the verification results
+type scrubbedFiles struct {
+	repository.FileDbRepo
+	files    []models.File
+	verified map[string]bool
+}
+
+func (s *scrubbedFiles) GetFilesForChecksumVerification(limit int, maxSize int64) ([]models.File, error) {
+	var sample []models.File
+	for _, f := range s.files {
+		if f.Size <= maxSize && len(sample) < limit {
+			sample = append(sample, f)
+		}
+	}
+	return sample, nil
+}
+
+func (s *scrubbedFiles) RecordChecksumVerification(id string, ok bool) error {
+	s.verified[id] = ok
+	return nil
+}
+
+func TestVerifyStoredChecksums(t *testing.T) {
+	helloWorld := "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+	objects := map[string]string{
+		"intact":    "hello world",
+		"corrupted": "hello wOrld",
+		// Recorded at 11 bytes, but the object has grown past the limit since
+		"grown": "hello world" + strings.Repeat("!", 64),
+		"large": strings.Repeat("x", 100),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := objects[strings.TrimPrefix(r.URL.Path, "/bucket/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+	client := s3.New(s3.Options{BaseEndpoint: aws.String(server.URL), UsePathStyle: true, Region: "us-east-1",
+		Credentials: aws.AnonymousCredentials{}})
+	a := &AwsS3{Client: client, BucketName: "bucket"}
+
+	repo := &scrubbedFiles{verified: map[string]bool{}}
+	for _, key := range []string{"intact", "corrupted", "grown"} {
+		repo.files = append(repo.files, models.File{ID: key, S3Key: key, Size: 11, Checksum: helloWorld})
+	}
+	repo.files = append(repo.files, models.File{ID: "large", S3Key: "large", Size: 100, Checksum: helloWorld})
+
+	a.VerifyStoredChecksums(repo, 10, 32)
+	want := map[string]bool{"intact": true, "corrupted": false, "grown": false}
+	if len(repo.verified) != len(want) {
+		t.Errorf("verified %v, want %v", repo.verified, want)
+	}
+	for id, ok := range want {
+		if got, checked := repo.verified[id]; !checked || got != ok {
+			t.Errorf("%s verified %v (checked %v), want %v", id, got, checked, ok)
+		}
+	}
+}
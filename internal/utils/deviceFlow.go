@@ -0,0 +1,263 @@
+package utils
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fileTransfer/internal/config"
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/repository"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// userCodeAlphabet has no vowels, so codes don't spell words, and no look-alike characters (RFC 8628 section 6.1)
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+const (
+	userCodeLength = 8
+	// slowDownSeconds is added to the poll interval of a device polling too fast
+	slowDownSeconds = 5
+)
+
+var (
+	ErrDeviceCodeQuota  = errors.New("too many device codes requested")
+	ErrUnknownUserCode  = errors.New("unknown or expired user code")
+	ErrTooManyUserCodes = errors.New("too many wrong user codes")
+	ErrUserCodeDecided  = errors.New("user code was already approved or denied")
+)
+
+// DeviceFlowError is an error of the token endpoint, Code is one of the RFC 8628 error codes
+type DeviceFlowError struct {
+	Code string
+}
+
+func (e *DeviceFlowError) Error() string {
+	return e.Code
+}
+
+// DeviceAuthorization is what a device gets when it starts a login
+type DeviceAuthorization struct {
+	DeviceCode string
+	UserCode   string
+	ExpiresIn  time.Duration
+	Interval   time.Duration
+}
+
+// DeviceFlow runs the RFC 8628 device authorization grant. An approved device gets an API token with the scopes
+// it asked for, which the user can see and revoke with their other tokens.
+type DeviceFlow struct {
+	repo repository.DeviceCodeDbRepo
+	cfg  config.DeviceFlowConfig
+
+	mu       sync.Mutex
+	failures map[string][]time.Time // user id to when they entered a wrong user code
+}
+
+func NewDeviceFlow(repo repository.DeviceCodeDbRepo, cfg config.DeviceFlowConfig) *DeviceFlow {
+	return &DeviceFlow{repo: repo, cfg: cfg, failures: make(map[string][]time.Time)}
+}
+
+// Start creates a device code and user code, within the client IP's hourly quota
+func (d *DeviceFlow) Start(clientName string, scopes []string, clientIP string) (*DeviceAuthorization, error) {
+	now := time.Now().UTC()
+	if d.cfg.IPHourly > 0 {
+		n, err := d.repo.CountDeviceCodesByIP(clientIP, now.Add(-time.Hour))
+		if err != nil {
+			return nil, err
+		}
+		if n >= d.cfg.IPHourly {
+			return nil, ErrDeviceCodeQuota
+		}
+	}
+
+	deviceCode, hash, err := NewToken()
+	if err != nil {
+		return nil, err
+	}
+	// A clash with a live user code fails on the unique key, a fresh code is tried a few times
+	for attempt := 0; ; attempt++ {
+		userCode, err := newUserCode()
+		if err != nil {
+			return nil, err
+		}
+		code := models.NewDeviceCode(uuid.New().String(), hash, userCode, clientName, scopes, clientIP,
+			int(d.cfg.PollInterval.Seconds()), now, now.Add(d.cfg.CodeTTL))
+		err = d.repo.AddDeviceCode(code)
+		if err == nil {
+			return &DeviceAuthorization{DeviceCode: deviceCode, UserCode: FormatUserCode(userCode),
+				ExpiresIn: d.cfg.CodeTTL, Interval: d.cfg.PollInterval}, nil
+		}
+		if attempt == 2 {
+			return nil, err
+		}
+	}
+}
+
+func newUserCode() (string, error) {
+	var b strings.Builder
+	for range userCodeLength {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(userCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(userCodeAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// FormatUserCode splits a user code in two halves for reading out, such as WDJB-MJHT
+func FormatUserCode(userCode string) string {
+	return userCode[:userCodeLength/2] + "-" + userCode[userCodeLength/2:]
+}
+
+// normalizeUserCode accepts user codes typed in lower case or with dashes and spaces
+func normalizeUserCode(userCode string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		if !strings.ContainsRune(userCodeAlphabet, r) {
+			return -1
+		}
+		return r
+	}, userCode)
+}
+
+// Lookup finds the pending login of a user code. Wrong codes count against the user, so codes can't be guessed.
+func (d *DeviceFlow) Lookup(userId string, userCode string) (*models.DeviceCode, error) {
+	if !d.allowAttempt(userId) {
+		return nil, ErrTooManyUserCodes
+	}
+	code, err := d.repo.GetDeviceCodeByUserCode(normalizeUserCode(userCode))
+	if err == nil && time.Now().UTC().After(code.ExpiresAt) {
+		err = sql.ErrNoRows
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		d.recordFailure(userId)
+		return nil, ErrUnknownUserCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	if code.Status != models.DeviceCodePending {
+		return nil, ErrUserCodeDecided
+	}
+	return code, nil
+}
+
+// Decide approves or denies the login of a user code for the user
+func (d *DeviceFlow) Decide(userId string, userCode string, approve bool) (*models.DeviceCode, error) {
+	code, err := d.Lookup(userId, userCode)
+	if err != nil {
+		return nil, err
+	}
+	status := models.DeviceCodeDenied
+	if approve {
+		status = models.DeviceCodeApproved
+	}
+	decided, err := d.repo.DecideDeviceCode(code.ID, userId, status, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	if !decided {
+		return nil, ErrUserCodeDecided
+	}
+	code.Status, code.UserId = status, userId
+	return code, nil
+}
+
+func (d *DeviceFlow) allowAttempt(userId string) bool {
+	if d.cfg.ApproveAttempts == 0 {
+		return true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	since := time.Now().Add(-d.cfg.CodeTTL)
+	recent := d.failures[userId][:0]
+	for _, at := range d.failures[userId] {
+		if at.After(since) {
+			recent = append(recent, at)
+		}
+	}
+	if len(recent) == 0 {
+		delete(d.failures, userId)
+	} else {
+		d.failures[userId] = recent
+	}
+	return len(recent) < d.cfg.ApproveAttempts
+}
+
+func (d *DeviceFlow) recordFailure(userId string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failures[userId] = append(d.failures[userId], time.Now())
+}
+
+// Poll is the device asking whether its login was approved. Once approved the code is used up and the device gets
+// an API token, returned with its record.
+func (d *DeviceFlow) Poll(deviceCode string) (string, *models.ApiToken, error) {
+	code, err := d.repo.GetDeviceCodeByHash(HashToken(deviceCode))
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil, &DeviceFlowError{Code: "invalid_grant"}
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now().UTC()
+	if now.After(code.ExpiresAt) {
+		return "", nil, &DeviceFlowError{Code: "expired_token"}
+	}
+
+	inTime, err := d.repo.PollDeviceCode(code.ID, now, slowDownSeconds)
+	if err != nil {
+		return "", nil, err
+	}
+	if !inTime {
+		return "", nil, &DeviceFlowError{Code: "slow_down"}
+	}
+
+	switch code.Status {
+	case models.DeviceCodePending:
+		return "", nil, &DeviceFlowError{Code: "authorization_pending"}
+	case models.DeviceCodeDenied:
+		return "", nil, &DeviceFlowError{Code: "access_denied"}
+	case models.DeviceCodeConsumed:
+		return "", nil, &DeviceFlowError{Code: "invalid_grant"}
+	}
+
+	expiresAt := now.Add(d.cfg.TokenTTL)
+	name := "Device login"
+	if code.ClientName != "" {
+		name += ": " + code.ClientName
+	}
+	token, apiToken, err := NewApiToken(code.UserId, name, code.Scopes, &expiresAt)
+	if err != nil {
+		return "", nil, err
+	}
+	consumed, err := d.repo.ConsumeDeviceCode(code.ID, apiToken)
+	if err != nil {
+		return "", nil, err
+	}
+	if !consumed {
+		return "", nil, &DeviceFlowError{Code: "invalid_grant"}
+	}
+	return token, apiToken, nil
+}
+
+// DeleteExpired forgets device codes that expired a while ago
+func (d *DeviceFlow) DeleteExpired() {
+	n, err := d.repo.DeleteExpiredDeviceCodes(time.Now().UTC().Add(-24 * time.Hour))
+	if err != nil {
+		log.Printf("Failed to delete expired device codes: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("Deleted %d expired device codes", n)
+	}
+}
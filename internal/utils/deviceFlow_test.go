@@ -0,0 +1,347 @@
+package utils
+
+import (
+	"database/sql"
+	"errors"
+	"fileTransfer/internal/config"
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/repository"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memDeviceCodes keeps device codes and the API tokens issued for them in memory, with the conditional updates of the
+// MySQL repo. While tokenErr is set no token can be stored.
+type memDeviceCodes struct {
+	repository.DeviceCodeDbRepo
+	codes    []*models.DeviceCode
+	tokens   []*models.ApiToken
+	tokenErr error
+}
+
+func (m *memDeviceCodes) AddDeviceCode(code *models.DeviceCode) error {
+	copied := *code
+	m.codes = append(m.codes, &copied)
+	return nil
+}
+
+func (m *memDeviceCodes) find(match func(c *models.DeviceCode) bool) (*models.DeviceCode, error) {
+	for _, c := range m.codes {
+		if match(c) {
+			copied := *c
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *memDeviceCodes) GetDeviceCodeByHash(deviceCodeHash string) (*models.DeviceCode, error) {
+	return m.find(func(c *models.DeviceCode) bool { return c.DeviceCodeHash == deviceCodeHash })
+}
+
+func (m *memDeviceCodes) GetDeviceCodeByUserCode(userCode string) (*models.DeviceCode, error) {
+	return m.find(func(c *models.DeviceCode) bool { return c.UserCode == userCode })
+}
+
+func (m *memDeviceCodes) CountDeviceCodesByIP(clientIP string, since time.Time) (int, error) {
+	n := 0
+	for _, c := range m.codes {
+		if c.ClientIP == clientIP && !c.CreatedAt.Before(since) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memDeviceCodes) get(id string) *models.DeviceCode {
+	for _, c := range m.codes {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+func (m *memDeviceCodes) PollDeviceCode(id string, at time.Time, slowDownBy int) (bool, error) {
+	c := m.get(id)
+	inTime := c.LastPolledAt == nil || !c.LastPolledAt.After(at.Add(-time.Duration(c.PollInterval)*time.Second))
+	if !inTime {
+		c.PollInterval += slowDownBy
+	}
+	c.LastPolledAt = &at
+	return inTime, nil
+}
+
+func (m *memDeviceCodes) DecideDeviceCode(id string, userId string, status string, at time.Time) (bool, error) {
+	c := m.get(id)
+	if c.Status != models.DeviceCodePending || !c.ExpiresAt.After(at) {
+		return false, nil
+	}
+	c.Status, c.UserId = status, userId
+	return true, nil
+}
+
+func (m *memDeviceCodes) ConsumeDeviceCode(id string, token *models.ApiToken) (bool, error) {
+	c := m.get(id)
+	if c.Status != models.DeviceCodeApproved {
+		return false, nil
+	}
+	// Both change together or not at all, as in the transaction
+	if m.tokenErr != nil {
+		return false, m.tokenErr
+	}
+	c.Status = models.DeviceCodeConsumed
+	m.tokens = append(m.tokens, token)
+	return true, nil
+}
+
+var deviceFlowConfig = config.DeviceFlowConfig{
+	CodeTTL:         10 * time.Minute,
+	PollInterval:    5 * time.Second,
+	TokenTTL:        24 * time.Hour,
+	IPHourly:        3,
+	ApproveAttempts: 3,
+}
+
+func TestDeviceFlowPoll(t *testing.T) {
+	// waited moves the last poll back past the interval, as if the device waited before polling again
+	waited := func(codes *memDeviceCodes) {
+		for _, c := range codes.codes {
+			if c.LastPolledAt != nil {
+				earlier := c.LastPolledAt.Add(-time.Minute)
+				c.LastPolledAt = &earlier
+			}
+		}
+	}
+
+	tests := []struct {
+		name string
+		// prepare acts on the started login and returns the device code to poll with
+		prepare  func(t *testing.T, d *DeviceFlow, codes *memDeviceCodes, auth *DeviceAuthorization) string
+		wantCode string
+	}{
+		{
+			name: "pending",
+			prepare: func(t *testing.T, d *DeviceFlow, codes *memDeviceCodes, auth *DeviceAuthorization) string {
+				return auth.DeviceCode
+			},
+			wantCode: "authorization_pending",
+		},
+		{
+			name: "polled too fast",
+			prepare: func(t *testing.T, d *DeviceFlow, codes *memDeviceCodes, auth *DeviceAuthorization) string {
+				if _, _, err := d.Poll(auth.DeviceCode); err == nil {
+					t.Fatal("pending login returned a token")
+				}
+				return auth.DeviceCode
+			},
+			wantCode: "slow_down",
+		},
+		{
+			name: "denied",
+			prepare: func(t *testing.T, d *DeviceFlow, codes *memDeviceCodes, auth *DeviceAuthorization) string {
+				if _, err := d.Decide("user", auth.UserCode, false); err != nil {
+					t.Fatal(err)
+				}
+				return auth.DeviceCode
+			},
+			wantCode: "access_denied",
+		},
+		{
+			name: "expired",
+			prepare: func(t *testing.T, d *DeviceFlow, codes *memDeviceCodes, auth *DeviceAuthorization) string {
+				codes.codes[0].ExpiresAt = time.Now().UTC().Add(-time.Second)
+				return auth.DeviceCode
+			},
+			wantCode: "expired_token",
+		},
+		{
+			name: "unknown device code",
+			prepare: func(t *testing.T, d *DeviceFlow, codes *memDeviceCodes, auth *DeviceAuthorization) string {
+				return "unknown"
+			},
+			wantCode: "invalid_grant",
+		},
+		{
+			name: "approved",
+			prepare: func(t *testing.T, d *DeviceFlow, codes *memDeviceCodes, auth *DeviceAuthorization) string {
+				if _, err := d.Decide("user", auth.UserCode, true); err != nil {
+					t.Fatal(err)
+				}
+				return auth.DeviceCode
+			},
+		},
+		{
+			name: "used device code",
+			prepare: func(t *testing.T, d *DeviceFlow, codes *memDeviceCodes, auth *DeviceAuthorization) string {
+				if _, err := d.Decide("user", auth.UserCode, true); err != nil {
+					t.Fatal(err)
+				}
+				if _, _, err := d.Poll(auth.DeviceCode); err != nil {
+					t.Fatal(err)
+				}
+				waited(codes)
+				return auth.DeviceCode
+			},
+			wantCode: "invalid_grant",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codes := &memDeviceCodes{}
+			d := NewDeviceFlow(codes, deviceFlowConfig)
+			auth, err := d.Start("ftcli", []string{models.ScopeFilesRead, models.ScopeFilesWrite}, "203.0.113.7")
+			if err != nil {
+				t.Fatal(err)
+			}
+			deviceCode := tt.prepare(t, d, codes, auth)
+			issued := len(codes.tokens)
+
+			token, apiToken, err := d.Poll(deviceCode)
+			if tt.wantCode != "" {
+				var flowErr *DeviceFlowError
+				if !errors.As(err, &flowErr) || flowErr.Code != tt.wantCode {
+					t.Fatalf("got error %v, want %s", err, tt.wantCode)
+				}
+				if len(codes.tokens) != issued {
+					t.Errorf("issued an API token")
+				}
+				if tt.wantCode == "slow_down" && codes.codes[0].PollInterval != 5+slowDownSeconds {
+					t.Errorf("poll interval is %d after polling too fast", codes.codes[0].PollInterval)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(token, models.ApiTokenPrefix) || HashToken(token) != apiToken.TokenHash {
+				t.Errorf("got token %q for %+v", token, apiToken)
+			}
+			if apiToken.UserId != "user" || apiToken.Name != "Device login: ftcli" ||
+				!slices.Equal(apiToken.Scopes, []string{models.ScopeFilesRead, models.ScopeFilesWrite}) {
+				t.Errorf("issued %+v", apiToken)
+			}
+			if len(codes.tokens) != 1 || codes.tokens[0] != apiToken || codes.codes[0].Status != models.DeviceCodeConsumed {
+				t.Errorf("stored %d tokens, code is %s", len(codes.tokens), codes.codes[0].Status)
+			}
+		})
+	}
+}
+
+func TestDeviceFlowPollTokenNotStored(t *testing.T) {
+	codes := &memDeviceCodes{}
+	d := NewDeviceFlow(codes, deviceFlowConfig)
+	auth, err := d.Start("ftcli", []string{models.ScopeFilesRead}, "203.0.113.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Decide("user", auth.UserCode, true); err != nil {
+		t.Fatal(err)
+	}
+
+	codes.tokenErr = errors.New("connection lost")
+	if _, _, err := d.Poll(auth.DeviceCode); !errors.Is(err, codes.tokenErr) {
+		t.Fatalf("got %v", err)
+	}
+	if codes.codes[0].Status != models.DeviceCodeApproved || len(codes.tokens) != 0 {
+		t.Fatalf("code is %s with %d tokens after a failed insert", codes.codes[0].Status, len(codes.tokens))
+	}
+
+	// The next poll still gets the token
+	codes.tokenErr = nil
+	earlier := codes.codes[0].LastPolledAt.Add(-time.Minute)
+	codes.codes[0].LastPolledAt = &earlier
+	if _, _, err := d.Poll(auth.DeviceCode); err != nil {
+		t.Fatal(err)
+	}
+	if codes.codes[0].Status != models.DeviceCodeConsumed || len(codes.tokens) != 1 {
+		t.Errorf("code is %s with %d tokens", codes.codes[0].Status, len(codes.tokens))
+	}
+}
+
+func TestDeviceFlowLookup(t *testing.T) {
+	codes := &memDeviceCodes{}
+	d := NewDeviceFlow(codes, deviceFlowConfig)
+	auth, err := d.Start("ftcli", []string{models.ScopeFilesRead}, "203.0.113.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Typed in lower case and with a space instead of the dash
+	typed := strings.ToLower(strings.Replace(auth.UserCode, "-", " ", 1))
+	code, err := d.Lookup("user", typed)
+	if err != nil || code.ID != codes.codes[0].ID {
+		t.Fatalf("got %v, %v for %q", code, err, typed)
+	}
+
+	// Wrong codes lock the user out, even of the right code, until they are older than a code's lifetime
+	for i := 0; i < deviceFlowConfig.ApproveAttempts; i++ {
+		if _, err := d.Lookup("guesser", "BBBB-BBBB"); !errors.Is(err, ErrUnknownUserCode) {
+			t.Fatalf("attempt %d: got %v", i+1, err)
+		}
+	}
+	if _, err := d.Lookup("guesser", auth.UserCode); !errors.Is(err, ErrTooManyUserCodes) {
+		t.Fatalf("got %v after too many wrong codes", err)
+	}
+	if _, err := d.Lookup("user", auth.UserCode); err != nil {
+		t.Errorf("another user is locked out: %v", err)
+	}
+	for i := range d.failures["guesser"] {
+		d.failures["guesser"][i] = time.Now().Add(-deviceFlowConfig.CodeTTL - time.Second)
+	}
+	if _, err := d.Lookup("guesser", auth.UserCode); err != nil {
+		t.Errorf("still locked out after the lockout: %v", err)
+	}
+
+	if _, err := d.Decide("user", auth.UserCode, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Decide("user", auth.UserCode, false); !errors.Is(err, ErrUserCodeDecided) {
+		t.Errorf("got %v deciding a code twice", err)
+	}
+
+	codes.codes[0].ExpiresAt = time.Now().UTC().Add(-time.Second)
+	if _, err := d.Lookup("user", auth.UserCode); !errors.Is(err, ErrUnknownUserCode) {
+		t.Errorf("got %v for an expired code", err)
+	}
+}
+
+func TestDeviceFlowStartQuota(t *testing.T) {
+	d := NewDeviceFlow(&memDeviceCodes{}, deviceFlowConfig)
+	for i := 0; i < deviceFlowConfig.IPHourly; i++ {
+		auth, err := d.Start("", []string{models.ScopeFilesRead}, "203.0.113.7")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(auth.UserCode) != userCodeLength+1 || normalizeUserCode(auth.UserCode) != strings.Replace(auth.UserCode, "-", "", 1) {
+			t.Errorf("got user code %q", auth.UserCode)
+		}
+	}
+	if _, err := d.Start("", []string{models.ScopeFilesRead}, "203.0.113.7"); !errors.Is(err, ErrDeviceCodeQuota) {
+		t.Errorf("got %v over the quota", err)
+	}
+	if _, err := d.Start("", []string{models.ScopeFilesRead}, "198.51.100.1"); err != nil {
+		t.Errorf("another IP is limited: %v", err)
+	}
+}
+
+func TestNormalizeUserCode(t *testing.T) {
+	tests := []struct {
+		typed, want string
+	}{
+		{"WDJB-MJHT", "WDJBMJHT"},
+		{"wdjb-mjht", "WDJBMJHT"},
+		{" wdjb mjht ", "WDJBMJHT"},
+		{"WDJB—MJHT", "WDJBMJHT"},
+		// Vowels and digits are not in the alphabet, so they can't be part of a code
+		{"WAJB-MJ0T", "WJBMJT"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeUserCode(tt.typed); got != tt.want {
+			t.Errorf("normalizeUserCode(%q) = %q, want %q", tt.typed, got, tt.want)
+		}
+	}
+}
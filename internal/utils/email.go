@@ -1,71 +1,366 @@
 package utils
 
 import (
+	"crypto/tls"
 	"errors"
+	"fileTransfer/internal/config"
 	"fmt"
-	"github.com/jordan-wright/email"
-	"github.com/sendgrid/sendgrid-go"
-	"github.com/sendgrid/sendgrid-go/helpers/mail"
+	"net"
+	"net/http"
+	"net/mail"
 	"net/smtp"
+	"net/textproto"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jordan-wright/email"
+	"github.com/sendgrid/sendgrid-go"
+	sgmail "github.com/sendgrid/sendgrid-go/helpers/mail"
 )
 
-func SendEmailWithSendGrid(to, cc, bcc, textContent, htmlBody string) error {
-	from := mail.NewEmail("File Sender", os.Getenv("SENDGRID_FROM"))
-	toEmail := mail.NewEmail("Recipient", to)
-	var subject = "Your Secure File Link Sent By" + "...."
-	message := mail.NewSingleEmail(from, subject, toEmail, textContent, htmlBody)
+// Message is an email to send, the Mailer fills in the From address when it is empty or has only a name
+type Message struct {
+	From    *mail.Address
+	ReplyTo *mail.Address
+	To      []*mail.Address
+	Cc      []*mail.Address
+	Bcc     []*mail.Address
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer sends emails through the provider chosen in config and returns the provider's response
+type Mailer interface {
+	Send(msg *Message) (string, error)
+}
+
+// permanentError marks a failure that retrying won't fix, such as a malformed request or refused recipients
+type permanentError struct {
+	err error
+}
+
+func (p *permanentError) Error() string { return p.err.Error() }
+func (p *permanentError) Unwrap() error { return p.err }
+
+// IsPermanent reports whether a send failed in a way that will fail again if retried
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// NewMailer returns the Mailer for config.Mail.Provider
+func NewMailer(cfg config.MailConfig) (Mailer, error) {
+	from := &mail.Address{Name: cfg.FromName, Address: cfg.From}
 
-	// Add CC and BCC if provided
-	if cc != "" {
-		message.Personalizations[0].AddCCs(mail.NewEmail("CC", cc))
+	switch cfg.Provider {
+	case "sendgrid":
+		return &SendGridMailer{apiKey: cfg.SendGridAPIKey, from: from}, nil
+	case "smtp":
+		if cfg.SMTPHost == "" {
+			return nil, errors.New("SMTP_HOST is not set")
+		}
+		switch cfg.SMTPTLS {
+		case "starttls", "tls", "none":
+		default:
+			return nil, fmt.Errorf("invalid SMTP_TLS %q, must be starttls, tls or none", cfg.SMTPTLS)
+		}
+		switch cfg.SMTPAuth {
+		case "plain", "login", "cram-md5", "none":
+		default:
+			return nil, fmt.Errorf("invalid SMTP_AUTH %q, must be plain, login, cram-md5 or none", cfg.SMTPAuth)
+		}
+		return &SMTPMailer{cfg: cfg, from: from}, nil
+	case "file":
+		if err := os.MkdirAll(cfg.SinkDir, 0o755); err != nil {
+			return nil, err
+		}
+		return &FileMailer{dir: cfg.SinkDir, from: from}, nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_PROVIDER %q", cfg.Provider)
 	}
-	if bcc != "" {
-		message.Personalizations[0].AddBCCs(mail.NewEmail("BCC", bcc))
+}
+
+// NotificationMessage builds a system notification to a single user
+func NotificationMessage(to, subject, text, html string) *Message {
+	return &Message{To: []*mail.Address{{Address: to}}, Subject: subject, Text: text, HTML: html}
+}
+
+type SendGridMailer struct {
+	apiKey string
+	from   *mail.Address
+}
+
+func (s *SendGridMailer) Send(msg *Message) (string, error) {
+	if len(msg.To) == 0 {
+		return "", &permanentError{errors.New("email address is required")}
 	}
 
-	client := sendgrid.NewSendClient(os.Getenv("SENDGRID_API_KEY"))
-	response, err := client.Send(message)
+	message := sgmail.NewV3Mail()
+	message.SetFrom(sgEmail(senderOrDefault(msg.From, s.from)))
+	message.Subject = msg.Subject
+	if msg.ReplyTo != nil {
+		message.SetReplyTo(sgEmail(msg.ReplyTo))
+	}
+
+	p := sgmail.NewPersonalization()
+	for _, a := range msg.To {
+		p.AddTos(sgEmail(a))
+	}
+	for _, a := range msg.Cc {
+		p.AddCCs(sgEmail(a))
+	}
+	for _, a := range msg.Bcc {
+		p.AddBCCs(sgEmail(a))
+	}
+	message.AddPersonalizations(p)
+
+	if msg.Text != "" {
+		message.AddContent(sgmail.NewContent("text/plain", msg.Text))
+	}
+	if msg.HTML != "" {
+		message.AddContent(sgmail.NewContent("text/html", msg.HTML))
+	}
 
+	response, err := sendgrid.NewSendClient(s.apiKey).Send(message)
 	if err != nil {
-		return err
+		return "", err
 	}
 
+	result := fmt.Sprintf("%d %s", response.StatusCode, strings.Join(response.Headers["X-Message-Id"], ","))
 	if response.StatusCode >= 400 {
-		return fmt.Errorf("SendGrid error: %v - %v", response.StatusCode, response.Body)
+		err := fmt.Errorf("SendGrid error: %v - %v", response.StatusCode, response.Body)
+		if response.StatusCode < 500 && response.StatusCode != http.StatusTooManyRequests &&
+			response.StatusCode != http.StatusRequestTimeout {
+			err = &permanentError{err}
+		}
+		return result, err
 	}
 
-	return nil
+	return result, nil
 }
 
-func SendEmail(toEmail, bccEmail, ccEmail, body string) error {
-	e := email.NewEmail()
-	e.From = "file <" + os.Getenv("SMTP_USERNAME") + ">"
+func sgEmail(a *mail.Address) *sgmail.Email {
+	return sgmail.NewEmail(a.Name, a.Address)
+}
+
+// SMTPMailer sends through an SMTP relay using STARTTLS, implicit TLS or, for local relays only, plain text
+type SMTPMailer struct {
+	cfg  config.MailConfig
+	from *mail.Address
+}
 
-	if toEmail != "" {
-		e.To = []string{toEmail}
+func (s *SMTPMailer) Send(msg *Message) (string, error) {
+	if len(msg.To) == 0 {
+		return "", &permanentError{errors.New("email address is required")}
+	}
+
+	// 5xx replies are permanent failures, 4xx ones are worth retrying
+	err := s.send(msg)
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) && protoErr.Code >= 500 {
+		return "", &permanentError{err}
+	}
+	var rejected *RejectedRecipientsError
+	if IsPermanent(err) || (err != nil && !errors.As(err, &rejected)) {
+		return "", err
+	}
+	return "accepted by " + s.cfg.SMTPHost, err
+}
+
+func (s *SMTPMailer) send(msg *Message) error {
+	from := senderOrDefault(msg.From, s.from)
+	raw, err := buildEmail(msg, from, false).Bytes()
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(s.cfg.SMTPHost, strconv.Itoa(s.cfg.SMTPPort))
+	tlsConfig := &tls.Config{ServerName: s.cfg.SMTPHost, MinVersion: tls.VersionTLS12}
+
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if s.cfg.SMTPTLS == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
 	} else {
-		return errors.New("email address is required")
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
 	}
 
-	// Set Bcc only if non-empty
-	if bccEmail != "" {
-		e.Bcc = []string{bccEmail}
+	client, err := smtp.NewClient(conn, s.cfg.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if s.cfg.SMTPTLS == "starttls" {
+		// Never fall back to plain text when STARTTLS was asked for
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("SMTP server does not support STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
 	}
 
-	// Set Cc only if non-empty
-	if ccEmail != "" {
-		e.Cc = []string{ccEmail}
+	if auth := s.auth(); auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
 	}
 
-	e.Subject = "Your Secure File Link Sent By" + "...."
-	e.Text = []byte(body)
-	e.HTML = []byte("<h1>Fancy HTML is supported, too!</h1>")
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	// A refused recipient doesn't stop the email going to the others
+	rejected := make(map[string]error)
+	total := 0
+	for _, list := range [][]*mail.Address{msg.To, msg.Cc, msg.Bcc} {
+		for _, a := range list {
+			total++
+			if err := client.Rcpt(a.Address); err != nil {
+				rejected[strings.ToLower(a.Address)] = err
+			}
+		}
+	}
+	if len(rejected) == total {
+		return &permanentError{&RejectedRecipientsError{Rejected: rejected}}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	if err := client.Quit(); err != nil {
+		return err
+	}
+	if len(rejected) > 0 {
+		return &RejectedRecipientsError{Rejected: rejected}
+	}
+	return nil
+}
+
+func (s *SMTPMailer) auth() smtp.Auth {
+	if s.cfg.SMTPUsername == "" {
+		return nil
+	}
+
+	switch s.cfg.SMTPAuth {
+	case "plain":
+		return smtp.PlainAuth("", s.cfg.SMTPUsername, s.cfg.SMTPPassword, s.cfg.SMTPHost)
+	case "login":
+		return &loginAuth{username: s.cfg.SMTPUsername, password: s.cfg.SMTPPassword, host: s.cfg.SMTPHost}
+	case "cram-md5":
+		return smtp.CRAMMD5Auth(s.cfg.SMTPUsername, s.cfg.SMTPPassword)
+	default:
+		return nil
+	}
+}
+
+// loginAuth implements the LOGIN mechanism still required by some relays, such as Office 365
+type loginAuth struct {
+	username, password, host string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	// Like PlainAuth, refuse to send the password over an unencrypted connection
+	if !server.TLS && server.Name != "localhost" && server.Name != "127.0.0.1" && server.Name != "::1" {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch string(fromServer) {
+	case "Username:", "User Name\x00":
+		return []byte(a.username), nil
+	case "Password:", "Password\x00":
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected LOGIN challenge %q", fromServer)
+	}
+}
+
+// FileMailer writes every email to a .eml file in dir, for development and offline testing
+type FileMailer struct {
+	dir  string
+	from *mail.Address
+}
+
+func (f *FileMailer) Send(msg *Message) (string, error) {
+	if len(msg.To) == 0 {
+		return "", &permanentError{errors.New("email address is required")}
+	}
+
+	// Bcc is kept as a header so the recipients can be checked in the file
+	raw, err := buildEmail(msg, senderOrDefault(msg.From, f.from), true).Bytes()
+	if err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405"), uuid.New().String()[:8])
+	if err := os.WriteFile(filepath.Join(f.dir, name), raw, 0o644); err != nil {
+		return "", err
+	}
+	return "written to " + name, nil
+}
+
+func buildEmail(msg *Message, from *mail.Address, bccHeader bool) *email.Email {
+	e := email.NewEmail()
+	e.From = from.String()
+	e.To = addressStrings(msg.To)
+	e.Cc = addressStrings(msg.Cc)
+	if msg.ReplyTo != nil {
+		e.ReplyTo = []string{msg.ReplyTo.String()}
+	}
+	if bccHeader && len(msg.Bcc) > 0 {
+		e.Headers.Set("Bcc", joinAddresses(msg.Bcc))
+	}
+	e.Subject = msg.Subject
+	e.Text = []byte(msg.Text)
+	e.HTML = []byte(msg.HTML)
+	return e
+}
+
+// senderOrDefault fills in our own address, a message may only choose the display name
+func senderOrDefault(from, def *mail.Address) *mail.Address {
+	if from == nil {
+		return def
+	}
+	if from.Address == "" {
+		return &mail.Address{Name: from.Name, Address: def.Address}
+	}
+	return from
+}
+
+func addressStrings(list []*mail.Address) []string {
+	var out []string
+	for _, a := range list {
+		out = append(out, a.String())
+	}
+	return out
+}
 
-	return e.Send(os.Getenv("SMTP_ADDRESS"), smtp.PlainAuth(
-		"",
-		os.Getenv("SMTP_USERNAME"),
-		os.Getenv("SMTP_PASSWORD"),
-		os.Getenv("SMTP_HOST"),
-	))
+func joinAddresses(list []*mail.Address) string {
+	return strings.Join(addressStrings(list), ", ")
 }
@@ -0,0 +1,223 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fileTransfer/internal/config"
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/repository"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sendgrid/sendgrid-go/helpers/eventwebhook"
+)
+
+// sendGridEventTolerance is how old a signed event batch may be, older ones are treated as replays
+const sendGridEventTolerance = 10 * time.Minute
+
+var ErrEventWebhookDisabled = errors.New("event webhook is not configured")
+
+// QuotaError is returned when an email would take a user or client IP over its send quota
+type QuotaError struct {
+	Scope  string // "user" or "ip"
+	Limit  int
+	Window time.Duration
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s send quota of %d recipients per %s exceeded", e.Scope, e.Limit, ValidityText(e.Window))
+}
+
+// EmailGuard enforces the send quotas and keeps emails from going to suppressed or over-emailed addresses
+type EmailGuard struct {
+	repo       repository.EmailAbuseDbRepo
+	limits     config.MailLimitConfig
+	webhookKey *ecdsa.PublicKey
+}
+
+func NewEmailGuard(repo repository.EmailAbuseDbRepo, limits config.MailLimitConfig) (*EmailGuard, error) {
+	g := &EmailGuard{repo: repo, limits: limits}
+	if limits.SendGridWebhookKey != "" {
+		key, err := parseSendGridKey(limits.SendGridWebhookKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SendGrid webhook key: %w", err)
+		}
+		g.webhookKey = key
+	}
+	return g, nil
+}
+
+func parseSendGridKey(b64 string) (*ecdsa.PublicKey, error) {
+	der, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	ecKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an ECDSA public key")
+	}
+	return ecKey, nil
+}
+
+type quotaCheck struct {
+	scope  string
+	limit  int
+	window time.Duration
+	count  func(since time.Time) (int, error)
+}
+
+// CheckQuota fails with a *QuotaError when emailing count more recipients would exceed the hourly or daily quota
+// of the user or client IP. Anonymous senders only have the IP quota.
+func (g *EmailGuard) CheckQuota(userId string, clientIP string, count int) error {
+	byIP := func(since time.Time) (int, error) { return g.repo.CountSendsByIP(clientIP, since) }
+	byUser := func(since time.Time) (int, error) { return g.repo.CountSendsByUser(userId, since) }
+	checks := []quotaCheck{
+		{"ip", g.limits.IPHourly, time.Hour, byIP},
+		{"ip", g.limits.IPDaily, 24 * time.Hour, byIP},
+	}
+	if userId != "" {
+		checks = append(checks, quotaCheck{"user", g.limits.UserHourly, time.Hour, byUser},
+			quotaCheck{"user", g.limits.UserDaily, 24 * time.Hour, byUser})
+	}
+
+	now := time.Now().UTC()
+	for _, check := range checks {
+		if check.limit == 0 {
+			continue
+		}
+		sent, err := check.count(now.Add(-check.window))
+		if err != nil {
+			return err
+		}
+		if sent+count > check.limit {
+			return &QuotaError{Scope: check.scope, Limit: check.limit, Window: check.window}
+		}
+	}
+	return nil
+}
+
+// Filter drops the recipients that are suppressed or already got too many emails, reporting them in the statuses
+func (g *EmailGuard) Filter(r *Recipients) error {
+	suppressions, err := g.repo.GetSuppressions(r.Addresses())
+	if err != nil {
+		return err
+	}
+	for _, s := range suppressions {
+		r.Drop(s.Address, RecipientSuppressed, "address is suppressed: "+s.Reason)
+	}
+
+	now := time.Now().UTC()
+	for _, address := range r.Addresses() {
+		for _, window := range []struct {
+			limit  int
+			period time.Duration
+		}{{g.limits.RecipientHourly, time.Hour}, {g.limits.RecipientDaily, 24 * time.Hour}} {
+			if window.limit == 0 {
+				continue
+			}
+			n, err := g.repo.CountSendsToRecipient(address, now.Add(-window.period))
+			if err != nil {
+				return err
+			}
+			if n >= window.limit {
+				r.Drop(address, RecipientThrottled, fmt.Sprintf("address got %d emails in the last %s", n, ValidityText(window.period)))
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// Suppressed reports whether address is on the suppression list, for emails to a single known address such as
+// notifications to owners
+func (g *EmailGuard) Suppressed(address string) (bool, error) {
+	suppressions, err := g.repo.GetSuppressions([]string{address})
+	if err != nil {
+		return false, err
+	}
+	return len(suppressions) > 0, nil
+}
+
+// Record counts the recipients of a queued email against the quotas
+func (g *EmailGuard) Record(emailId string, userId string, clientIP string, r *Recipients) error {
+	now := time.Now().UTC()
+	var sends []*models.EmailSend
+	for _, address := range r.Addresses() {
+		sends = append(sends, models.NewEmailSend(uuid.New().String(), emailId, userId, clientIP, address, now))
+	}
+	return g.repo.AddSends(sends)
+}
+
+// SendGridEvent is the part of a SendGrid event webhook entry we act on
+type SendGridEvent struct {
+	Email     string `json:"email"`
+	Event     string `json:"event"`
+	Type      string `json:"type"`
+	Reason    string `json:"reason"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// VerifySendGridEvents checks the ECDSA signature SendGrid puts over the timestamp and raw body
+func (g *EmailGuard) VerifySendGridEvents(body []byte, signature string, timestamp string) error {
+	if g.webhookKey == nil {
+		return ErrEventWebhookDisabled
+	}
+
+	var ts int64
+	if _, err := fmt.Sscan(timestamp, &ts); err != nil {
+		return errors.New("invalid timestamp")
+	}
+	if age := time.Since(time.Unix(ts, 0)); age > sendGridEventTolerance || age < -sendGridEventTolerance {
+		return errors.New("timestamp is outside the tolerance")
+	}
+
+	ok, err := eventwebhook.VerifySignature(g.webhookKey, body, signature, timestamp)
+	if err != nil || !ok {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// ApplySendGridEvents suppresses the addresses that hard bounced, reported spam or unsubscribed.
+// Blocked bounces are temporary and other events are ignored.
+func (g *EmailGuard) ApplySendGridEvents(events []SendGridEvent) (int, error) {
+	suppressed := 0
+	for _, e := range events {
+		var reason string
+		switch e.Event {
+		case "bounce":
+			if e.Type == "blocked" {
+				continue
+			}
+			reason = models.SuppressBounce
+		case "spamreport":
+			reason = models.SuppressSpamReport
+		case "unsubscribe", "group_unsubscribe":
+			reason = models.SuppressUnsubscribe
+		default:
+			continue
+		}
+		if strings.TrimSpace(e.Email) == "" {
+			continue
+		}
+
+		at := time.Unix(e.Timestamp, 0).UTC()
+		if e.Timestamp == 0 {
+			at = time.Now().UTC()
+		}
+		if err := g.repo.AddSuppression(models.NewEmailSuppression(strings.TrimSpace(e.Email), reason,
+			e.Reason, at)); err != nil {
+			return suppressed, err
+		}
+		suppressed++
+	}
+	return suppressed, nil
+}
@@ -0,0 +1,237 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fileTransfer/internal/config"
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/repository"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memAbuse counts the sends and suppressions it was given
+type memAbuse struct {
+	repository.EmailAbuseDbRepo
+	sends        []*models.EmailSend
+	suppressions []models.EmailSuppression
+}
+
+func (m *memAbuse) AddSends(sends []*models.EmailSend) error {
+	m.sends = append(m.sends, sends...)
+	return nil
+}
+
+func (m *memAbuse) count(since time.Time, match func(s *models.EmailSend) bool) (int, error) {
+	n := 0
+	for _, s := range m.sends {
+		if !s.CreatedAt.Before(since) && match(s) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memAbuse) CountSendsByUser(userId string, since time.Time) (int, error) {
+	return m.count(since, func(s *models.EmailSend) bool { return s.UserId == userId })
+}
+
+func (m *memAbuse) CountSendsByIP(clientIP string, since time.Time) (int, error) {
+	return m.count(since, func(s *models.EmailSend) bool { return s.ClientIP == clientIP })
+}
+
+func (m *memAbuse) CountSendsToRecipient(recipient string, since time.Time) (int, error) {
+	return m.count(since, func(s *models.EmailSend) bool { return strings.EqualFold(s.Recipient, recipient) })
+}
+
+func (m *memAbuse) AddSuppression(suppression *models.EmailSuppression) error {
+	m.suppressions = append(m.suppressions, *suppression)
+	return nil
+}
+
+func (m *memAbuse) GetSuppressions(addresses []string) ([]models.EmailSuppression, error) {
+	var found []models.EmailSuppression
+	for _, s := range m.suppressions {
+		if slices.ContainsFunc(addresses, func(a string) bool { return strings.EqualFold(a, s.Address) }) {
+			found = append(found, s)
+		}
+	}
+	return found, nil
+}
+
+// record adds n sends made at the given time
+func (m *memAbuse) record(n int, userId string, clientIP string, recipient string, at time.Time) {
+	for i := 0; i < n; i++ {
+		m.sends = append(m.sends, models.NewEmailSend(strconv.Itoa(len(m.sends)), "email", userId, clientIP, recipient, at))
+	}
+}
+
+func TestCheckQuota(t *testing.T) {
+	limits := config.MailLimitConfig{UserHourly: 5, UserDaily: 8, IPHourly: 3, IPDaily: 10}
+	now := time.Now().UTC()
+
+	tests := []struct {
+		name      string
+		userSends int
+		ipSends   int
+		// earlier sends happened two hours ago, so they only count against the daily quotas
+		earlier   bool
+		userId    string
+		count     int
+		wantScope string
+	}{
+		{name: "anonymous under the IP quota", ipSends: 2, count: 1},
+		{name: "anonymous over the IP quota", ipSends: 2, count: 2, wantScope: "ip"},
+		{name: "signed in under both quotas", userSends: 4, userId: "user", count: 1},
+		{name: "signed in over the user quota", userSends: 4, userId: "user", count: 2, wantScope: "user"},
+		{name: "user quota ignored for anonymous senders", userSends: 20, count: 1},
+		{name: "signed in over the IP quota", ipSends: 3, userId: "user", count: 1, wantScope: "ip"},
+		{name: "hourly quota has passed", userSends: 5, earlier: true, userId: "user", count: 3},
+		{name: "over the daily user quota", userSends: 6, earlier: true, userId: "user", count: 3, wantScope: "user"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at := now.Add(-time.Minute)
+			if tt.earlier {
+				at = now.Add(-2 * time.Hour)
+			}
+			repo := &memAbuse{}
+			// The user sent from elsewhere, other clients sent from this IP
+			repo.record(tt.userSends, "user", "198.51.100.1", "x@example.com", at)
+			repo.record(tt.ipSends, "", "203.0.113.7", "x@example.com", at)
+			g := &EmailGuard{repo: repo, limits: limits}
+
+			err := g.CheckQuota(tt.userId, "203.0.113.7", tt.count)
+			var quotaErr *QuotaError
+			if tt.wantScope == "" {
+				if err != nil {
+					t.Fatalf("got %v", err)
+				}
+				return
+			}
+			if !errors.As(err, &quotaErr) || quotaErr.Scope != tt.wantScope {
+				t.Fatalf("got %v, want a %s quota error", err, tt.wantScope)
+			}
+		})
+	}
+}
+
+func TestEmailGuardFilter(t *testing.T) {
+	limits := config.MailLimitConfig{RecipientHourly: 2, RecipientDaily: 3}
+	now := time.Now().UTC()
+	repo := &memAbuse{suppressions: []models.EmailSuppression{{Address: "Bounced@example.com", Reason: models.SuppressBounce}}}
+	// under is one short of the hourly limit, at has reached it, daily reached it over the day
+	repo.record(1, "", "", "under@example.com", now.Add(-time.Minute))
+	repo.record(2, "", "", "at@example.com", now.Add(-time.Minute))
+	repo.record(3, "", "", "daily@example.com", now.Add(-5*time.Hour))
+	g := &EmailGuard{repo: repo, limits: limits}
+
+	r := ParseRecipients([]string{"under@example.com", "at@example.com"}, []string{"bounced@example.com"},
+		[]string{"daily@example.com", "new@example.com"})
+	if err := g.Filter(r); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Addresses(); !slices.Equal(got, []string{"under@example.com", "new@example.com"}) {
+		t.Errorf("kept %v", got)
+	}
+	want := []string{RecipientQueued, RecipientThrottled, RecipientSuppressed, RecipientThrottled, RecipientQueued}
+	for i, s := range r.Statuses {
+		if s.Status != want[i] {
+			t.Errorf("%s is %s, want %s", s.Address, s.Status, want[i])
+		}
+	}
+}
+
+func TestApplySendGridEvents(t *testing.T) {
+	repo := &memAbuse{}
+	g := &EmailGuard{repo: repo}
+	events := []SendGridEvent{
+		{Email: "bounced@example.com", Event: "bounce", Type: "bounce", Reason: "550 no such user", Timestamp: 1700000000},
+		{Email: "blocked@example.com", Event: "bounce", Type: "blocked", Reason: "421 try later"},
+		{Email: "spam@example.com", Event: "spamreport"},
+		{Email: "gone@example.com", Event: "group_unsubscribe"},
+		{Email: "open@example.com", Event: "open"},
+		{Email: " ", Event: "bounce"},
+	}
+
+	n, err := g.ApplySendGridEvents(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range repo.suppressions {
+		got = append(got, s.Address+":"+s.Reason)
+	}
+	want := []string{"bounced@example.com:bounce", "spam@example.com:spamreport", "gone@example.com:unsubscribe"}
+	if n != 3 || !slices.Equal(got, want) {
+		t.Fatalf("suppressed %d: %v, want %v", n, got, want)
+	}
+	if at := repo.suppressions[0].CreatedAt; !at.Equal(time.Unix(1700000000, 0)) || repo.suppressions[0].Detail != "550 no such user" {
+		t.Errorf("stored %+v", repo.suppressions[0])
+	}
+}
+
+func TestVerifySendGridEvents(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := NewEmailGuard(&memAbuse{}, config.MailLimitConfig{SendGridWebhookKey: base64.StdEncoding.EncodeToString(der)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(timestamp string, body string) string {
+		digest := sha256.Sum256([]byte(timestamp + body))
+		sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.StdEncoding.EncodeToString(sig)
+	}
+	body := `[{"email":"a@example.com","event":"bounce"}]`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-11*time.Minute).Unix(), 10)
+	ahead := strconv.FormatInt(time.Now().Add(11*time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name      string
+		body      string
+		signature string
+		timestamp string
+		wantErr   bool
+	}{
+		{"valid", body, sign(now, body), now, false},
+		{"body changed", `[{"email":"b@example.com","event":"bounce"}]`, sign(now, body), now, true},
+		{"signed for another timestamp", body, sign(stale, body), now, true},
+		{"not a signature", body, "bm90IGEgc2lnbmF0dXJl", now, true},
+		{"older than the tolerance", body, sign(stale, body), stale, true},
+		{"ahead of the tolerance", body, sign(ahead, body), ahead, true},
+		{"no timestamp", body, sign("", body), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := g.VerifySendGridEvents([]byte(tt.body), tt.signature, tt.timestamp)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Without a key nothing is accepted
+	unsigned := &EmailGuard{repo: &memAbuse{}}
+	if err := unsigned.VerifySendGridEvents([]byte(body), sign(now, body), now); !errors.Is(err, ErrEventWebhookDisabled) {
+		t.Errorf("got %v without a key", err)
+	}
+}
@@ -0,0 +1,174 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/repository"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	emailLease      = 5 * time.Minute
+	emailBaseRetry  = time.Minute
+	emailMaxRetry   = 2 * time.Hour
+	maxEmailErrText = 1000
+)
+
+// EmailOutbox queues emails in the db and sends them from a worker, retrying transient failures
+type EmailOutbox struct {
+	repo        repository.EmailOutboxDbRepo
+	mailer      Mailer
+	webhooks    *WebhookService
+	maxAttempts int
+}
+
+func NewEmailOutbox(repo repository.EmailOutboxDbRepo, mailer Mailer, webhooks *WebhookService, maxAttempts int) *EmailOutbox {
+	return &EmailOutbox{repo: repo, mailer: mailer, webhooks: webhooks, maxAttempts: maxAttempts}
+}
+
+// Enqueue stores an email to be sent by the worker, recipients are reported as queued until then.
+// fileId is the file the email shares, if any.
+func (o *EmailOutbox) Enqueue(userId string, fileId string, msg *Message, recipients *Recipients) (*models.OutboxEmail, error) {
+	message, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	statuses, err := json.Marshal(recipients.Statuses)
+	if err != nil {
+		return nil, err
+	}
+
+	email := models.NewOutboxEmail(uuid.New().String(), userId, fileId, string(message), statuses, time.Now().UTC())
+	if err := o.repo.AddEmail(email); err != nil {
+		return nil, err
+	}
+	return email, nil
+}
+
+// SendNow sends an email straight away instead of queueing it, for emails that carry the key of an end-to-end
+// encrypted file. Only a record of the email is stored, never the message, so it can't be retried and a
+// transient failure fails it for good.
+func (o *EmailOutbox) SendNow(userId string, fileId string, msg *Message, recipients *Recipients) (*models.OutboxEmail, error) {
+	statuses, err := json.Marshal(recipients.Statuses)
+	if err != nil {
+		return nil, err
+	}
+
+	// Stored as claimed, so the worker leaves it alone while it is sent here
+	now := time.Now().UTC()
+	email := models.NewOutboxEmail(uuid.New().String(), userId, fileId, "", statuses, now)
+	email.NextAttemptAt = now.Add(emailLease)
+	if err := o.repo.AddEmail(email); err != nil {
+		return nil, err
+	}
+	o.deliver(email, msg, recipients.Statuses, false)
+	return email, nil
+}
+
+// SendPending sends a batch of queued emails that are due
+func (o *EmailOutbox) SendPending(batchSize int) {
+	now := time.Now().UTC()
+	emails, err := o.repo.GetDueEmails(now, batchSize)
+	if err != nil {
+		log.Printf("Failed to get queued emails: %v", err)
+		return
+	}
+
+	for _, email := range emails {
+		claimed, err := o.repo.ClaimEmail(email.ID, now, now.Add(emailLease))
+		if err != nil {
+			log.Printf("Failed to claim queued email: %v", err)
+			continue
+		}
+		if claimed {
+			o.send(&email)
+		}
+	}
+}
+
+func (o *EmailOutbox) send(email *models.OutboxEmail) {
+	var msg Message
+	var statuses []RecipientStatus
+	if err := json.Unmarshal([]byte(email.Message), &msg); err != nil {
+		o.finish(email, nil, models.EmailFailed, err)
+		return
+	}
+	if err := json.Unmarshal(email.Recipients, &statuses); err != nil {
+		o.finish(email, nil, models.EmailFailed, err)
+		return
+	}
+	o.deliver(email, &msg, statuses, true)
+}
+
+// deliver sends the message of an email, rescheduling it after a transient failure when it can be retried
+func (o *EmailOutbox) deliver(email *models.OutboxEmail, msg *Message, statuses []RecipientStatus, retry bool) {
+	response, sendErr := o.mailer.Send(msg)
+	email.Attempts++
+	email.ProviderResponse = response
+
+	// A transient failure leaves every recipient queued for the next attempt, while a server that refused
+	// only some recipients still delivered to the rest
+	var rejected *RejectedRecipientsError
+	delivered := sendErr == nil || (errors.As(sendErr, &rejected) && !IsPermanent(sendErr))
+	if retry && !delivered && !IsPermanent(sendErr) && email.Attempts < o.maxAttempts {
+		email.LastError = truncateError(sendErr)
+		email.NextAttemptAt = time.Now().UTC().Add(retryBackoff(email.Attempts, emailBaseRetry, emailMaxRetry))
+		if err := o.repo.UpdateEmail(email); err != nil {
+			log.Printf("Failed to update queued email %s: %v", email.ID, err)
+		}
+		return
+	}
+
+	recipients := &Recipients{Statuses: statuses}
+	recipients.SetResult(sendErr)
+	status := models.EmailFailed
+	if SentCount(statuses) > 0 {
+		status = models.EmailSent
+	}
+	o.finish(email, statuses, status, sendErr)
+
+	if status == models.EmailSent {
+		// Recipients are personal data, the event only says how many there were
+		o.webhooks.Publish(models.EventEmailSent, email.UserId, map[string]any{"emailId": email.ID,
+			"recipients": SentCount(statuses)})
+	}
+}
+
+// finish moves an email to sent or, dead-lettered, to failed. The message is dropped as it is no longer needed.
+func (o *EmailOutbox) finish(email *models.OutboxEmail, statuses []RecipientStatus, status string, sendErr error) {
+	now := time.Now().UTC()
+	email.Status = status
+	email.Message = ""
+	email.NextAttemptAt = now
+	email.LastError = truncateError(sendErr)
+	if status == models.EmailSent {
+		email.SentAt = &now
+	}
+	if statuses != nil {
+		if b, err := json.Marshal(statuses); err == nil {
+			email.Recipients = b
+		}
+	}
+
+	if err := o.repo.UpdateEmail(email); err != nil {
+		log.Printf("Failed to update queued email %s: %v", email.ID, err)
+	}
+	if status == models.EmailFailed {
+		log.Printf("Email %s dead-lettered after %d attempts: %s", email.ID, email.Attempts, email.LastError)
+	}
+}
+
+func truncateError(err error) string {
+	if err == nil {
+		return ""
+	}
+	s := err.Error()
+	if len(s) > maxEmailErrText {
+		s = s[:maxEmailErrText]
+	}
+	return s
+}
@@ -0,0 +1,212 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/repository"
+	"net/mail"
+	"testing"
+	"time"
+)
+
+// memOutbox keeps queued emails in memory, ClaimEmail refuses emails listed in taken
+type memOutbox struct {
+	repository.EmailOutboxDbRepo
+	emails map[string]*models.OutboxEmail
+	taken  map[string]bool
+}
+
+func (m *memOutbox) AddEmail(email *models.OutboxEmail) error {
+	copied := *email
+	m.emails[email.ID] = &copied
+	return nil
+}
+
+func (m *memOutbox) GetDueEmails(now time.Time, limit int) ([]models.OutboxEmail, error) {
+	var due []models.OutboxEmail
+	for _, e := range m.emails {
+		if e.Status == models.EmailQueued && !e.NextAttemptAt.After(now) && len(due) < limit {
+			due = append(due, *e)
+		}
+	}
+	return due, nil
+}
+
+func (m *memOutbox) ClaimEmail(id string, now time.Time, leaseUntil time.Time) (bool, error) {
+	return !m.taken[id], nil
+}
+
+func (m *memOutbox) UpdateEmail(email *models.OutboxEmail) error {
+	copied := *email
+	m.emails[email.ID] = &copied
+	return nil
+}
+
+// eventRepo subscribes a single endpoint to everything and counts the queued deliveries
+type eventRepo struct {
+	repository.WebhookDbRepo
+	events []string
+}
+
+func (r *eventRepo) GetSubscribedEndpoints(userId string, eventType string) ([]models.WebhookEndpoint, error) {
+	return []models.WebhookEndpoint{{ID: "endpoint"}}, nil
+}
+
+func (r *eventRepo) AddDelivery(delivery *models.WebhookDelivery) error {
+	r.events = append(r.events, delivery.EventType)
+	return nil
+}
+
+// scriptedMailer fails with the next error in errs on every send, then succeeds
+type scriptedMailer struct {
+	errs  []error
+	sends int
+}
+
+func (m *scriptedMailer) Send(msg *Message) (string, error) {
+	m.sends++
+	if len(m.errs) == 0 {
+		return "250 ok", nil
+	}
+	err := m.errs[0]
+	m.errs = m.errs[1:]
+	return "", err
+}
+
+func TestEmailOutbox(t *testing.T) {
+	temporary := errors.New("connection refused")
+	tests := []struct {
+		name         string
+		errs         []error
+		runs         int
+		wantStatus   string
+		wantAttempts int
+		wantStatuses []string
+		wantEvents   int
+	}{
+		{name: "sent", runs: 1, wantStatus: models.EmailSent, wantAttempts: 1,
+			wantStatuses: []string{RecipientSent, RecipientSent, RecipientInvalid}, wantEvents: 1},
+		{name: "temporary failure is retried", errs: []error{temporary}, runs: 1, wantStatus: models.EmailQueued,
+			wantAttempts: 1, wantStatuses: []string{RecipientQueued, RecipientQueued, RecipientInvalid}},
+		{name: "sent on the second attempt", errs: []error{temporary}, runs: 2, wantStatus: models.EmailSent,
+			wantAttempts: 2, wantStatuses: []string{RecipientSent, RecipientSent, RecipientInvalid}, wantEvents: 1},
+		{name: "dead-lettered after the last attempt", errs: []error{temporary, temporary, temporary}, runs: 3,
+			wantStatus: models.EmailFailed, wantAttempts: 3,
+			wantStatuses: []string{RecipientFailed, RecipientFailed, RecipientInvalid}},
+		{name: "permanent failure is not retried", errs: []error{&permanentError{errors.New("550 no")}}, runs: 1,
+			wantStatus: models.EmailFailed, wantAttempts: 1,
+			wantStatuses: []string{RecipientFailed, RecipientFailed, RecipientInvalid}},
+		{name: "some recipients rejected", errs: []error{&RejectedRecipientsError{Rejected: map[string]error{
+			"b@example.com": errors.New("550 no such user")}}}, runs: 1, wantStatus: models.EmailSent, wantAttempts: 1,
+			wantStatuses: []string{RecipientSent, RecipientRejected, RecipientInvalid}, wantEvents: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &memOutbox{emails: map[string]*models.OutboxEmail{}}
+			events := &eventRepo{}
+			mailer := &scriptedMailer{errs: tt.errs}
+			outbox := NewEmailOutbox(repo, mailer, NewWebhookService(events, false), 3)
+
+			recipients := ParseRecipients([]string{"a@example.com"}, []string{"B@example.com", "not an address"}, nil)
+			msg := &Message{To: recipients.To, Cc: recipients.Cc, Subject: "Your Secure File Link", Text: "link#key"}
+			queued, err := outbox.Enqueue("user", "", msg, recipients)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tt.runs; i++ {
+				// Skip the backoff so the retry is due
+				repo.emails[queued.ID].NextAttemptAt = time.Now().Add(-time.Second)
+				outbox.SendPending(10)
+			}
+
+			email := repo.emails[queued.ID]
+			if email.Status != tt.wantStatus || email.Attempts != tt.wantAttempts || mailer.sends != tt.wantAttempts {
+				t.Fatalf("got %s after %d attempts and %d sends, want %s after %d", email.Status, email.Attempts,
+					mailer.sends, tt.wantStatus, tt.wantAttempts)
+			}
+			var statuses []RecipientStatus
+			if err := json.Unmarshal(email.Recipients, &statuses); err != nil {
+				t.Fatal(err)
+			}
+			for i, s := range statuses {
+				if s.Status != tt.wantStatuses[i] {
+					t.Errorf("recipient %s is %s, want %s", s.Address, s.Status, tt.wantStatuses[i])
+				}
+			}
+			// The message, which may hold a file key, is only kept while it may still be sent
+			if (email.Message != "") != (email.Status == models.EmailQueued) {
+				t.Errorf("message kept %v with status %s", email.Message != "", email.Status)
+			}
+			if email.Status == models.EmailQueued && !email.NextAttemptAt.After(time.Now().Add(emailBaseRetry-time.Second)) {
+				t.Errorf("retry is due at %v, expected a backoff", email.NextAttemptAt)
+			}
+			if (email.SentAt != nil) != (email.Status == models.EmailSent) {
+				t.Errorf("sent at %v with status %s", email.SentAt, email.Status)
+			}
+			if len(events.events) != tt.wantEvents {
+				t.Errorf("published %v, want %d email.sent events", events.events, tt.wantEvents)
+			}
+		})
+	}
+}
+
+func TestEmailOutboxEnqueue(t *testing.T) {
+	repo := &memOutbox{emails: map[string]*models.OutboxEmail{}}
+	outbox := NewEmailOutbox(repo, &scriptedMailer{}, nil, 3)
+	msg := &Message{To: []*mail.Address{{Name: "A", Address: "a@example.com"}}, Subject: "Hello", HTML: "<p>hi</p>"}
+
+	email, err := outbox.Enqueue("user", "", msg, ParseRecipients([]string{"A <a@example.com>"}, nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := repo.emails[email.ID]
+	if stored.Status != models.EmailQueued || stored.UserId != "user" || stored.NextAttemptAt.After(time.Now()) {
+		t.Errorf("stored %+v", stored)
+	}
+	var got Message
+	if err := json.Unmarshal([]byte(stored.Message), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Subject != "Hello" || got.HTML != "<p>hi</p>" || got.To[0].String() != msg.To[0].String() {
+		t.Errorf("stored message %+v", got)
+	}
+}
+
+func TestEmailOutboxSkipsClaimedEmails(t *testing.T) {
+	repo := &memOutbox{emails: map[string]*models.OutboxEmail{}, taken: map[string]bool{}}
+	mailer := &scriptedMailer{}
+	outbox := NewEmailOutbox(repo, mailer, NewWebhookService(&eventRepo{}, false), 3)
+	msg := &Message{To: []*mail.Address{{Address: "a@example.com"}}}
+	email, err := outbox.Enqueue("user", "", msg, ParseRecipients([]string{"a@example.com"}, nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another worker holds the lease
+	repo.taken[email.ID] = true
+	outbox.SendPending(10)
+	if mailer.sends != 0 || repo.emails[email.ID].Status != models.EmailQueued {
+		t.Fatalf("sent an email claimed by another worker")
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+		{8, time.Hour},
+		{40, time.Hour},
+	}
+	for _, tt := range tests {
+		got := retryBackoff(tt.attempts, time.Minute, time.Hour)
+		// Up to 20% jitter on top
+		if got < tt.want || got > tt.want+tt.want/5 {
+			t.Errorf("retryBackoff(%d) = %v, want %v plus up to 20%%", tt.attempts, got, tt.want)
+		}
+	}
+}
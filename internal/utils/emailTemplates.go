@@ -0,0 +1,274 @@
+package utils
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+)
+
+// Email templates are named "<name>[.<locale>].html" and "<name>[.<locale>].txt", such as "share.de.html".
+// The text template may define a "subject" block.
+//
+//go:embed templates/email
+var embeddedEmailTemplates embed.FS
+
+type emailTemplateSet struct {
+	html map[string]*htmltemplate.Template
+	text map[string]*texttemplate.Template
+}
+
+var (
+	emailTemplatesMu sync.RWMutex
+	emailTemplates   = mustParseEmailTemplates("")
+)
+
+type RenderedEmail struct {
+	Subject string
+	HTML    string
+	Text    string
+}
+
+type EmailData struct {
+	Subject         string
+	DownloadLink    string
+	LinkValidity    string
+	ExpiresAt       string
+	KeyOmitted      bool
+	FileName        string
+	FileSize        string
+	SenderName      string
+	SenderEmail     string
+	PersonalMessage string
+}
+
+type NotificationData struct {
+	Title    string
+	Lines    []string
+	Link     string
+	LinkText string
+}
+
+// emailSamples is the data templates are previewed with
+var emailSamples = map[string]any{
+	"share": EmailData{
+		DownloadLink:    "https://example.com/download?key=uploads/1700000000_report.pdf",
+		LinkValidity:    "2 days",
+		ExpiresAt:       FormatExpiry(time.Now().Add(48*time.Hour), "en", "UTC"),
+		FileName:        "report.pdf",
+		FileSize:        "2.4 MB",
+		SenderName:      "Jane Doe",
+		SenderEmail:     "jane@example.com",
+		PersonalMessage: "Here is the report we talked about.\nLet me know what you think!",
+	},
+	"reminder": ReminderData{
+		FileName:     "report.pdf",
+		FileSize:     "2.4 MB",
+		ExpiresAt:    FormatExpiry(time.Now().Add(24*time.Hour), "en", "UTC"),
+		TimeLeft:     "24 hours",
+		DownloadLink: "https://example.com/download?key=uploads/1700000000_report.pdf",
+		ExtendLink:   "https://example.com/extend?token=sample",
+		ExtendBy:     "24 hours",
+		IsOwner:      true,
+	},
+	"notification": NotificationData{
+		Title:    "Your file was downloaded",
+		Lines:    []string{"Your file report.pdf was downloaded by bob@example.com."},
+		Link:     "https://example.com",
+		LinkText: "View your files",
+	},
+}
+
+// LoadEmailTemplates parses the embedded templates once and lets files in overrideDir replace or add to them
+func LoadEmailTemplates(overrideDir string) error {
+	set, err := parseEmailTemplates(overrideDir)
+	if err != nil {
+		return err
+	}
+
+	emailTemplatesMu.Lock()
+	emailTemplates = set
+	emailTemplatesMu.Unlock()
+	return nil
+}
+
+func mustParseEmailTemplates(overrideDir string) *emailTemplateSet {
+	set, err := parseEmailTemplates(overrideDir)
+	if err != nil {
+		panic(err)
+	}
+	return set
+}
+
+func parseEmailTemplates(overrideDir string) (*emailTemplateSet, error) {
+	set := &emailTemplateSet{html: map[string]*htmltemplate.Template{}, text: map[string]*texttemplate.Template{}}
+
+	embedded, err := fs.Sub(embeddedEmailTemplates, "templates/email")
+	if err != nil {
+		return nil, err
+	}
+	if err := set.add(embedded); err != nil {
+		return nil, err
+	}
+
+	if overrideDir != "" {
+		if err := set.add(os.DirFS(overrideDir)); err != nil {
+			return nil, fmt.Errorf("email template override: %w", err)
+		}
+	}
+	return set, nil
+}
+
+func (s *emailTemplateSet) add(fsys fs.FS) error {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		ext := path.Ext(name)
+		if entry.IsDir() || (ext != ".html" && ext != ".txt") {
+			continue
+		}
+		src, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+
+		key := strings.ToLower(strings.TrimSuffix(name, ext))
+		if ext == ".html" {
+			t, err := htmltemplate.New(name).Parse(string(src))
+			if err != nil {
+				return err
+			}
+			s.html[key] = t
+		} else {
+			t, err := texttemplate.New(name).Parse(string(src))
+			if err != nil {
+				return err
+			}
+			s.text[key] = t
+		}
+	}
+	return nil
+}
+
+// localeFallbacks lists the template keys to try for a locale, "share.de-at", "share.de" then "share"
+func localeFallbacks(name, locale string) []string {
+	locale = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+	if strings.ContainsFunc(locale, func(r rune) bool { return (r < 'a' || r > 'z') && r != '-' }) {
+		locale = ""
+	}
+
+	var keys []string
+	for locale != "" {
+		keys = append(keys, name+"."+locale)
+		i := strings.LastIndexByte(locale, '-')
+		if i < 0 {
+			break
+		}
+		locale = locale[:i]
+	}
+	return append(keys, name)
+}
+
+func lookupTemplate[T any](templates map[string]T, name, locale string) (T, bool) {
+	for _, key := range localeFallbacks(name, locale) {
+		if t, ok := templates[key]; ok {
+			return t, true
+		}
+	}
+	var zero T
+	return zero, false
+}
+
+// RenderEmail renders the HTML and plain text versions of a template in the closest available locale
+func RenderEmail(name, locale string, data any) (*RenderedEmail, error) {
+	emailTemplatesMu.RLock()
+	set := emailTemplates
+	emailTemplatesMu.RUnlock()
+
+	htmlTmpl, ok := lookupTemplate(set.html, name, locale)
+	if !ok {
+		return nil, fmt.Errorf("email template %q not found", name)
+	}
+
+	var out RenderedEmail
+	var buf bytes.Buffer
+	if err := htmlTmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	out.HTML = buf.String()
+
+	if textTmpl, ok := lookupTemplate(set.text, name, locale); ok {
+		buf.Reset()
+		if err := textTmpl.Execute(&buf, data); err != nil {
+			return nil, err
+		}
+		out.Text = buf.String()
+
+		if subject := textTmpl.Lookup("subject"); subject != nil {
+			buf.Reset()
+			if err := subject.Execute(&buf, data); err != nil {
+				return nil, err
+			}
+			out.Subject = strings.TrimSpace(buf.String())
+		}
+	}
+
+	return &out, nil
+}
+
+// RenderShareEmail renders the email that shares a file link
+func RenderShareEmail(locale string, data EmailData) (*RenderedEmail, error) {
+	return RenderEmail("share", locale, data)
+}
+
+// RenderNotification renders a short account notification, such as a file arriving or being downloaded
+func RenderNotification(data NotificationData) (*RenderedEmail, error) {
+	return RenderEmail("notification", "", data)
+}
+
+// EmailTemplateLocales lists every template with the locales it has variants for
+func EmailTemplateLocales() map[string][]string {
+	emailTemplatesMu.RLock()
+	defer emailTemplatesMu.RUnlock()
+
+	keys := slices.Collect(maps.Keys(emailTemplates.html))
+	keys = append(keys, slices.Collect(maps.Keys(emailTemplates.text))...)
+
+	out := make(map[string][]string)
+	for _, key := range keys {
+		name, locale, _ := strings.Cut(key, ".")
+		if _, ok := out[name]; !ok {
+			out[name] = []string{}
+		}
+		if locale != "" {
+			out[name] = append(out[name], locale)
+		}
+	}
+	for name := range out {
+		slices.Sort(out[name])
+		out[name] = slices.Compact(out[name])
+	}
+	return out
+}
+
+// PreviewEmail renders a template with sample data
+func PreviewEmail(name, locale string) (*RenderedEmail, error) {
+	data, ok := emailSamples[name]
+	if !ok {
+		return nil, fmt.Errorf("no sample data for email template %q", name)
+	}
+	return RenderEmail(name, locale, data)
+}
@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLocaleFallbacks(t *testing.T) {
+	tests := []struct {
+		locale string
+		want   []string
+	}{
+		{"", []string{"share"}},
+		{"de", []string{"share.de", "share"}},
+		{"de_AT", []string{"share.de-at", "share.de", "share"}},
+		{" zh-Hant-TW ", []string{"share.zh-hant-tw", "share.zh-hant", "share.zh", "share"}},
+		// Anything that could walk out of the template names is ignored
+		{"../../etc", []string{"share"}},
+		{"de.html", []string{"share"}},
+	}
+	for _, tt := range tests {
+		if got := localeFallbacks("share", tt.locale); !slices.Equal(got, tt.want) {
+			t.Errorf("localeFallbacks(%q) = %v, want %v", tt.locale, got, tt.want)
+		}
+	}
+}
+
+func TestRenderShareEmail(t *testing.T) {
+	data := EmailData{DownloadLink: "https://example.com/download?key=a&b", LinkValidity: "2 days", FileName: "<report>.pdf",
+		SenderName: "Jane", SenderEmail: "jane@example.com", PersonalMessage: "<script>alert(1)</script>"}
+	tests := []struct {
+		name        string
+		locale      string
+		subject     string
+		wantSubject string
+		wantText    string
+	}{
+		{"default", "", "", "Jane shared <report>.pdf with you", "Jane (jane@example.com) shared a file with you."},
+		{"regional variant falls back to the language", "de-AT", "", "Jane hat <report>.pdf mit Ihnen geteilt", "Datei:"},
+		{"unknown locale", "pt-BR", "", "Jane shared <report>.pdf with you", "Here is your download link"},
+		{"custom subject", "de", "Bericht", "Bericht", "Ihr Download-Link"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := data
+			d.Subject = tt.subject
+			rendered, err := RenderShareEmail(tt.locale, d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rendered.Subject != tt.wantSubject {
+				t.Errorf("got subject %q, want %q", rendered.Subject, tt.wantSubject)
+			}
+			// The text part is plain text, only the HTML part is escaped
+			if !strings.Contains(rendered.Text, tt.wantText) || !strings.Contains(rendered.Text, data.PersonalMessage) {
+				t.Errorf("text lacks %q:\n%s", tt.wantText, rendered.Text)
+			}
+			if strings.Contains(rendered.HTML, "<script>") || strings.Contains(rendered.HTML, "<report>") {
+				t.Errorf("HTML is not escaped:\n%s", rendered.HTML)
+			}
+		})
+	}
+}
+
+func TestLoadEmailTemplatesOverride(t *testing.T) {
+	t.Cleanup(func() {
+		if err := LoadEmailTemplates(""); err != nil {
+			t.Fatal(err)
+		}
+	})
+	dir := t.TempDir()
+	files := map[string]string{
+		"share.html":    `<p>Custom {{.FileName}}</p>`,
+		"share.fr.html": `<p>Fichier {{.FileName}}</p>`,
+		"share.fr.txt":  `{{define "subject"}}Un fichier{{end}}Fichier {{.FileName}}`,
+		"README.md":     `not a template`,
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := LoadEmailTemplates(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	rendered, err := RenderShareEmail("", EmailData{FileName: "a.pdf"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The override replaces the HTML while the embedded text part stays
+	if rendered.HTML != "<p>Custom a.pdf</p>" || !strings.Contains(rendered.Text, "a.pdf") {
+		t.Errorf("got %+v", rendered)
+	}
+	rendered, err = RenderShareEmail("fr-CA", EmailData{FileName: "a.pdf"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rendered.Subject != "Un fichier" || rendered.HTML != "<p>Fichier a.pdf</p>" {
+		t.Errorf("got %+v", rendered)
+	}
+	if got := EmailTemplateLocales()["share"]; !slices.Equal(got, []string{"de", "fr"}) {
+		t.Errorf("share locales are %v", got)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "broken.html"), []byte("{{.Oops"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadEmailTemplates(dir); err == nil {
+		t.Error("loaded a broken template")
+	}
+	if _, err := RenderShareEmail("fr", EmailData{}); err != nil {
+		t.Errorf("a failed reload replaced the working templates: %v", err)
+	}
+}
+
+func TestPreviewEmail(t *testing.T) {
+	for _, name := range []string{"share", "notification"} {
+		rendered, err := PreviewEmail(name, "")
+		if err != nil || rendered.HTML == "" || rendered.Text == "" {
+			t.Errorf("%s: got %+v, %v", name, rendered, err)
+		}
+	}
+	if _, err := PreviewEmail("missing", ""); err == nil {
+		t.Error("previewed a template without sample data")
+	}
+}
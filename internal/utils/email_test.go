@@ -0,0 +1,330 @@
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"fileTransfer/internal/config"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNewMailer(t *testing.T) {
+	sink := filepath.Join(t.TempDir(), "sink")
+	tests := []struct {
+		name    string
+		cfg     config.MailConfig
+		wantErr string
+	}{
+		{"sendgrid", config.MailConfig{Provider: "sendgrid"}, ""},
+		{"smtp", config.MailConfig{Provider: "smtp", SMTPHost: "mail.example.com", SMTPTLS: "starttls", SMTPAuth: "login"}, ""},
+		{"smtp without a host", config.MailConfig{Provider: "smtp", SMTPTLS: "starttls", SMTPAuth: "plain"}, "SMTP_HOST"},
+		{"smtp with an unknown TLS mode", config.MailConfig{Provider: "smtp", SMTPHost: "h", SMTPTLS: "ssl", SMTPAuth: "plain"}, "SMTP_TLS"},
+		{"smtp with an unknown auth", config.MailConfig{Provider: "smtp", SMTPHost: "h", SMTPTLS: "tls", SMTPAuth: "ntlm"}, "SMTP_AUTH"},
+		{"file", config.MailConfig{Provider: "file", SinkDir: sink}, ""},
+		{"unknown provider", config.MailConfig{Provider: "pigeon"}, "MAIL_PROVIDER"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewMailer(tt.cfg)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("NewMailer: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("got error %v, want one about %s", err, tt.wantErr)
+			}
+		})
+	}
+	if _, err := os.Stat(sink); err != nil {
+		t.Errorf("file mailer did not create its directory: %v", err)
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	mailer, err := NewMailer(config.MailConfig{Provider: "file", SinkDir: dir, From: "noreply@example.com", FromName: "File Transfer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := mailer.Send(&Message{Subject: "no recipients"}); !IsPermanent(err) {
+		t.Errorf("got error %v for a message without recipients, want a permanent one", err)
+	}
+	msg := &Message{To: []*mail.Address{{Address: "to@example.com"}}, Bcc: []*mail.Address{{Address: "hidden@example.com"}},
+		Subject: "Hello", Text: "plain body", HTML: "<p>html body</p>"}
+	response, err := mailer.Send(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("got %d files", len(files))
+	}
+	if response != "written to "+filepath.Base(files[0]) {
+		t.Errorf("got response %q", response)
+	}
+	raw, _ := os.ReadFile(files[0])
+	parsed, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for header, want := range map[string]string{"From": `"File Transfer" <noreply@example.com>`, "To": "<to@example.com>",
+		"Bcc": "<hidden@example.com>", "Subject": "Hello"} {
+		if got := parsed.Header.Get(header); got != want {
+			t.Errorf("%s is %q, want %q", header, got, want)
+		}
+	}
+	if !strings.Contains(string(raw), "plain body") || !strings.Contains(string(raw), "<p>html body</p>") {
+		t.Errorf("message lacks a body part:\n%s", raw)
+	}
+}
+
+// smtpServer is a minimal SMTP server that accepts plain authentication and rejects recipients at
+// reject.example.com. Senders at greylist.example.com get a temporary failure and those at blocked.example.com
+// a permanent one.
+type smtpServer struct {
+	ln         net.Listener
+	extensions []string
+	mu         sync.Mutex
+	authed     bool
+	rcpts      []string
+	data       string
+}
+
+func newSMTPServer(t *testing.T, extensions ...string) *smtpServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{ln: ln, extensions: extensions}
+	t.Cleanup(func() { ln.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *smtpServer) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *smtpServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		s.mu.Lock()
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"):
+			for _, ext := range s.extensions {
+				reply("250-" + ext)
+			}
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "AUTH PLAIN"):
+			s.authed = true
+			reply("235 Authenticated")
+		case strings.HasPrefix(cmd, "MAIL FROM"):
+			switch {
+			case strings.Contains(cmd, "GREYLIST.EXAMPLE.COM"):
+				reply("451 Try again later")
+			case strings.Contains(cmd, "BLOCKED.EXAMPLE.COM"):
+				reply("553 Sender blocked")
+			default:
+				reply("250 OK")
+			}
+		case strings.HasPrefix(cmd, "RCPT TO"):
+			if strings.Contains(cmd, "REJECT.EXAMPLE.COM") {
+				reply("550 No such user")
+			} else {
+				s.rcpts = append(s.rcpts, strings.TrimSpace(line[len("RCPT TO:"):]))
+				reply("250 OK")
+			}
+		case cmd == "DATA":
+			reply("354 Go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					s.mu.Unlock()
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			s.data = data.String()
+			reply("250 Queued")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			s.mu.Unlock()
+			return
+		default:
+			reply("502 Not implemented")
+		}
+		s.mu.Unlock()
+	}
+}
+
+func TestSMTPMailer(t *testing.T) {
+	msg := func(to ...string) *Message {
+		m := &Message{Subject: "Hello", Text: "body", Bcc: []*mail.Address{{Address: "bcc@example.com"}}}
+		for _, a := range to {
+			m.To = append(m.To, &mail.Address{Address: a})
+		}
+		return m
+	}
+	tests := []struct {
+		name       string
+		extensions []string
+		username   string
+		tlsMode    string
+		msg        *Message
+		wantErr    string
+		// wantPermanent errors are not worth retrying
+		wantPermanent bool
+		wantRcpts     []string
+		wantAuth      bool
+		// wantRejected are refused while the email still goes to the others
+		wantRejected []string
+	}{
+		{name: "plain text relay", tlsMode: "none", msg: msg("to@example.com"),
+			wantRcpts: []string{"<to@example.com>", "<bcc@example.com>"}},
+		{name: "authenticated on localhost", extensions: []string{"AUTH PLAIN"}, username: "user", tlsMode: "none",
+			msg: msg("to@example.com"), wantRcpts: []string{"<to@example.com>", "<bcc@example.com>"}, wantAuth: true},
+		{name: "STARTTLS is never skipped", tlsMode: "starttls", msg: msg("to@example.com"), wantErr: "STARTTLS"},
+		{name: "temporary failure", tlsMode: "none", msg: &Message{From: &mail.Address{Address: "a@greylist.example.com"},
+			To: []*mail.Address{{Address: "to@example.com"}}}, wantErr: "451"},
+		{name: "permanent failure", tlsMode: "none", msg: &Message{From: &mail.Address{Address: "a@blocked.example.com"},
+			To: []*mail.Address{{Address: "to@example.com"}}}, wantErr: "553", wantPermanent: true},
+		{name: "rejected recipient", tlsMode: "none", msg: msg("to@example.com", "Nobody@reject.example.com"),
+			wantRcpts: []string{"<to@example.com>", "<bcc@example.com>"}, wantRejected: []string{"nobody@reject.example.com"}},
+		{name: "every recipient rejected", tlsMode: "none", msg: &Message{Subject: "Hello", To: []*mail.Address{
+			{Address: "a@reject.example.com"}}}, wantErr: "1 recipients were rejected", wantPermanent: true},
+		{name: "no recipients", tlsMode: "none", msg: msg(), wantErr: "email address is required", wantPermanent: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newSMTPServer(t, tt.extensions...)
+			mailer := &SMTPMailer{from: &mail.Address{Address: "noreply@example.com"}, cfg: config.MailConfig{
+				SMTPHost: "127.0.0.1", SMTPPort: server.port(), SMTPTLS: tt.tlsMode, SMTPAuth: "plain",
+				SMTPUsername: tt.username, SMTPPassword: "secret"}}
+
+			response, err := mailer.Send(tt.msg)
+			var rejected *RejectedRecipientsError
+			if len(tt.wantRejected) > 0 {
+				if !errors.As(err, &rejected) || len(rejected.Rejected) != len(tt.wantRejected) {
+					t.Fatalf("got error %v, want %v rejected", err, tt.wantRejected)
+				}
+				for _, a := range tt.wantRejected {
+					if rejected.Rejected[a] == nil {
+						t.Errorf("%s is not among the rejected", a)
+					}
+				}
+				err = nil
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				if IsPermanent(err) != tt.wantPermanent {
+					t.Errorf("permanent is %v, want %v", IsPermanent(err), tt.wantPermanent)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			if response != "accepted by 127.0.0.1" {
+				t.Errorf("got response %q", response)
+			}
+			server.mu.Lock()
+			defer server.mu.Unlock()
+			if strings.Join(server.rcpts, ",") != strings.Join(tt.wantRcpts, ",") {
+				t.Errorf("got recipients %v, want %v", server.rcpts, tt.wantRcpts)
+			}
+			if server.authed != tt.wantAuth {
+				t.Errorf("authenticated %v, want %v", server.authed, tt.wantAuth)
+			}
+			// Bcc recipients get the message but are never listed in it
+			if strings.Contains(server.data, "bcc@example.com") || !strings.Contains(server.data, "Subject: Hello") {
+				t.Errorf("got message:\n%s", server.data)
+			}
+		})
+	}
+}
+
+func TestLoginAuth(t *testing.T) {
+	tests := []struct {
+		name    string
+		host    string
+		server  smtp.ServerInfo
+		wantErr bool
+	}{
+		{"TLS", "mail.example.com", smtp.ServerInfo{Name: "mail.example.com", TLS: true}, false},
+		{"plain text to localhost", "localhost", smtp.ServerInfo{Name: "localhost"}, false},
+		{"plain text", "mail.example.com", smtp.ServerInfo{Name: "mail.example.com"}, true},
+		{"wrong host", "mail.example.com", smtp.ServerInfo{Name: "evil.example.com", TLS: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := &loginAuth{username: "user", password: "secret", host: tt.host}
+			mech, _, err := auth.Start(&tt.server)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && mech != "LOGIN" {
+				t.Errorf("got mechanism %q", mech)
+			}
+		})
+	}
+
+	auth := &loginAuth{username: "user", password: "secret", host: "mail.example.com"}
+	for challenge, want := range map[string]string{"Username:": "user", "User Name\x00": "user", "Password:": "secret"} {
+		if got, err := auth.Next([]byte(challenge), true); err != nil || string(got) != want {
+			t.Errorf("Next(%q) = %q, %v, want %q", challenge, got, err, want)
+		}
+	}
+	if _, err := auth.Next([]byte("Token:"), true); err == nil {
+		t.Error("answered an unknown challenge")
+	}
+	if got, err := auth.Next(nil, false); got != nil || err != nil {
+		t.Errorf("Next after the last challenge = %q, %v", got, err)
+	}
+}
+
+func TestSenderOrDefault(t *testing.T) {
+	def := &mail.Address{Address: "noreply@example.com"}
+	if got := senderOrDefault(nil, def); got != def {
+		t.Errorf("got %v for no sender", got)
+	}
+	// A message may pick the display name but never the address
+	if got := senderOrDefault(&mail.Address{Name: "Jane via File Transfer"}, def); got.String() != `"Jane via File Transfer" <noreply@example.com>` {
+		t.Errorf("got %v for a sender without an address", got)
+	}
+	from := &mail.Address{Address: "owner@example.com"}
+	if got := senderOrDefault(from, def); got != from {
+		t.Errorf("got %v, want %v", got, from)
+	}
+}
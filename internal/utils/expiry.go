@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"fileTransfer/internal/config"
+	"fileTransfer/internal/models"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ShareLink is the frontend link recipients open to download a file, keys of encrypted files are never part of it
+func ShareLink(file *models.File) string {
+	if file.Encrypted {
+		return config.FrontendURL + "/e2e?key=" + url.QueryEscape(file.S3Key)
+	}
+	return config.FrontendURL + "/download?key=" + url.QueryEscape(file.S3Key)
+}
+
+type dateLocale struct {
+	days   [7]string
+	months [12]string
+	// format receives the weekday, day, month, year and the clock time
+	format func(weekday string, day int, month string, year int, clock string) string
+	clock  string
+}
+
+var englishDays = [7]string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
+var englishMonths = [12]string{"January", "February", "March", "April", "May", "June", "July", "August",
+	"September", "October", "November", "December"}
+
+// dateLocales holds the expiry date formats, keyed by language or language-region
+var dateLocales = map[string]*dateLocale{
+	"en": {
+		days: englishDays, months: englishMonths, clock: "3:04 PM MST",
+		format: func(w string, d int, m string, y int, c string) string {
+			return fmt.Sprintf("%s, %s %d, %d at %s", w, m, d, y, c)
+		},
+	},
+	"en-gb": {
+		days: englishDays, months: englishMonths, clock: "15:04 MST",
+		format: func(w string, d int, m string, y int, c string) string {
+			return fmt.Sprintf("%s %d %s %d at %s", w, d, m, y, c)
+		},
+	},
+	"de": {
+		days: [7]string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"},
+		months: [12]string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September",
+			"Oktober", "November", "Dezember"},
+		clock: "15:04 MST",
+		format: func(w string, d int, m string, y int, c string) string {
+			return fmt.Sprintf("%s, %d. %s %d um %s", w, d, m, y, c)
+		},
+	},
+	"fr": {
+		days: [7]string{"dimanche", "lundi", "mardi", "mercredi", "jeudi", "vendredi", "samedi"},
+		months: [12]string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre",
+			"octobre", "novembre", "décembre"},
+		clock: "15:04 MST",
+		format: func(w string, d int, m string, y int, c string) string {
+			return fmt.Sprintf("%s %d %s %d à %s", w, d, m, y, c)
+		},
+	},
+	"es": {
+		days: [7]string{"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"},
+		months: [12]string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre",
+			"octubre", "noviembre", "diciembre"},
+		clock: "15:04 MST",
+		format: func(w string, d int, m string, y int, c string) string {
+			return fmt.Sprintf("%s, %d de %s de %d, %s", w, d, m, y, c)
+		},
+	},
+}
+
+// FormatExpiry renders t in the given IANA time zone and locale, such as "de-DE" or "en-GB".
+// Unknown locales fall back to US English and unknown time zones to UTC.
+func FormatExpiry(t time.Time, locale string, timezone string) string {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil || timezone == "" {
+		loc = time.UTC
+	}
+	t = t.In(loc)
+
+	l := lookupDateLocale(locale)
+	return l.format(l.days[t.Weekday()], t.Day(), l.months[t.Month()-1], t.Year(), t.Format(l.clock))
+}
+
+func lookupDateLocale(locale string) *dateLocale {
+	locale = strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+	if l, ok := dateLocales[locale]; ok {
+		return l
+	}
+	lang, _, _ := strings.Cut(locale, "-")
+	if l, ok := dateLocales[lang]; ok {
+		return l
+	}
+	return dateLocales["en"]
+}
+
+// ValidityText describes how long is left until an expiry, such as "2 days" or "5 hours"
+func ValidityText(d time.Duration) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return "1 " + unit
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+
+	switch {
+	case d >= 48*time.Hour:
+		return plural(int(d/(24*time.Hour)), "day")
+	case d >= time.Hour:
+		return plural(int(d/time.Hour), "hour")
+	case d >= time.Minute:
+		return plural(int(d/time.Minute), "minute")
+	default:
+		return "less than a minute"
+	}
+}
+
+// FormatSize renders a byte count for people, such as "12.5 MB"
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,209 @@
+package utils
+
+import (
+	"encoding/json"
+	"fileTransfer/internal/config"
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/repository"
+	"log"
+	"net/mail"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ReminderData struct {
+	FileName     string
+	FileSize     string
+	ExpiresAt    string
+	TimeLeft     string
+	DownloadLink string
+	// ExtendLink and ExtendBy are only set for the owner, and only while the file can still be extended
+	ExtendLink string
+	ExtendBy   string
+	IsOwner    bool
+	KeyOmitted bool
+}
+
+// ExpiryReminders emails owners, and optionally the people a file was shared with, before the file expires
+type ExpiryReminders struct {
+	files     repository.FileDbRepo
+	users     repository.UserDbRepo
+	reminders repository.ExpiryReminderDbRepo
+	emails    repository.EmailOutboxDbRepo
+	downloads repository.DownloadEventDbRepo
+	outbox    *EmailOutbox
+	guard     *EmailGuard
+	jwt       *JWTService
+	cfg       config.ReminderConfig
+}
+
+func NewExpiryReminders(files repository.FileDbRepo, users repository.UserDbRepo, reminders repository.ExpiryReminderDbRepo,
+	emails repository.EmailOutboxDbRepo, downloads repository.DownloadEventDbRepo, outbox *EmailOutbox, guard *EmailGuard,
+	jwt *JWTService, cfg config.ReminderConfig) *ExpiryReminders {
+	leadTimes := slices.Clone(cfg.LeadTimes)
+	slices.Sort(leadTimes)
+	cfg.LeadTimes = leadTimes
+	return &ExpiryReminders{files: files, users: users, reminders: reminders, emails: emails, downloads: downloads,
+		outbox: outbox, guard: guard, jwt: jwt, cfg: cfg}
+}
+
+// ExtendedExpiry is the expiry a file gets when extended, ok is false once it has reached its maximum lifetime
+func ExtendedExpiry(file *models.File, cfg config.ReminderConfig) (time.Time, bool) {
+	expiry := file.ExpirationDate.Add(cfg.ExtendBy)
+	if limit := file.UploadedAt.Add(cfg.MaxLifetime); expiry.After(limit) {
+		expiry = limit
+	}
+	expiry = expiry.UTC().Truncate(time.Second)
+	return expiry, expiry.After(file.ExpirationDate)
+}
+
+// SendDue queues the reminders of files that entered a lead time window since the last run. Only the shortest
+// window a file is in counts, so a file uploaded an hour before it expires doesn't also get the 24 hour reminder,
+// and windows longer than the file's whole lifetime are skipped.
+func (r *ExpiryReminders) SendDue() {
+	if len(r.cfg.LeadTimes) == 0 {
+		return
+	}
+	now := time.Now().UTC()
+	files, err := r.files.GetFilesExpiringBetween(now, now.Add(r.cfg.LeadTimes[len(r.cfg.LeadTimes)-1]))
+	if err != nil {
+		log.Printf("Failed to get expiring files: %v", err)
+		return
+	}
+
+	for i := range files {
+		file := &files[i]
+		if file.OwnerDisabled {
+			continue
+		}
+		remaining := file.ExpirationDate.Sub(now)
+		n := slices.IndexFunc(r.cfg.LeadTimes, func(lead time.Duration) bool { return remaining <= lead })
+		if n < 0 {
+			continue
+		}
+		lead := r.cfg.LeadTimes[n]
+		if lead >= file.ExpirationDate.Sub(file.UploadedAt) {
+			continue
+		}
+
+		r.remindOwner(file, lead)
+		if r.cfg.NotifyRecipients {
+			r.remindRecipients(file, lead)
+		}
+	}
+}
+
+func (r *ExpiryReminders) remindOwner(file *models.File, lead time.Duration) {
+	if file.UserId == "" || file.UserId == models.AnonymousUserId {
+		return
+	}
+	owner, err := r.users.FindUserByID(file.UserId)
+	if err != nil {
+		log.Printf("Failed to find owner of file %s: %v", file.ID, err)
+		return
+	}
+
+	data := r.reminderData(file)
+	data.IsOwner = true
+	if _, ok := ExtendedExpiry(file, r.cfg); ok {
+		token, err := r.jwt.GenerateExtendToken(file.ID, file.ExpirationDate)
+		if err != nil {
+			log.Printf("Failed to sign extend link for file %s: %v", file.ID, err)
+			return
+		}
+		data.ExtendLink = config.FrontendURL + "/extend?token=" + url.QueryEscape(token)
+		data.ExtendBy = ValidityText(r.cfg.ExtendBy)
+	}
+
+	r.send(file, lead, &mail.Address{Name: owner.Name, Address: owner.Email}, data)
+}
+
+// remindRecipients reminds the people a file was emailed to. Downloads are matched to them by the account they
+// downloaded with, and any anonymous download counts for everyone, since we can't tell who it was.
+func (r *ExpiryReminders) remindRecipients(file *models.File, lead time.Duration) {
+	emails, err := r.emails.GetSentEmailsByFile(file.ID)
+	if err != nil || len(emails) == 0 {
+		if err != nil {
+			log.Printf("Failed to get emails of file %s: %v", file.ID, err)
+		}
+		return
+	}
+
+	// Anonymous downloads can't be matched to a recipient, so they skip no one
+	downloaders, err := r.downloads.GetFileDownloaders(file.ID)
+	if err != nil {
+		log.Printf("Failed to get downloads of file %s: %v", file.ID, err)
+		return
+	}
+	downloaded := make(map[string]bool)
+	for _, email := range downloaders {
+		downloaded[email] = true
+	}
+
+	data := r.reminderData(file)
+	for _, email := range emails {
+		var statuses []RecipientStatus
+		if err := json.Unmarshal(email.Recipients, &statuses); err != nil {
+			log.Printf("Failed to read recipients of email %s: %v", email.ID, err)
+			continue
+		}
+		for _, s := range statuses {
+			key := strings.ToLower(s.Address)
+			if s.Status != RecipientSent || downloaded[key] {
+				continue
+			}
+			downloaded[key] = true
+			r.send(file, lead, &mail.Address{Name: s.Name, Address: s.Address}, data)
+		}
+	}
+}
+
+func (r *ExpiryReminders) reminderData(file *models.File) ReminderData {
+	return ReminderData{
+		FileName:     FileDisplayName(file.Name, file.Encrypted),
+		FileSize:     FormatSize(file.Size),
+		ExpiresAt:    FormatExpiry(file.ExpirationDate, "", ""),
+		TimeLeft:     ValidityText(time.Until(file.ExpirationDate)),
+		DownloadLink: ShareLink(file),
+		KeyOmitted:   file.Encrypted,
+	}
+}
+
+// send claims the reminder first, so a reminder that was already sent is skipped, and releases it if it
+// could not be queued. Suppressed and throttled addresses are skipped.
+func (r *ExpiryReminders) send(file *models.File, lead time.Duration, to *mail.Address, data ReminderData) {
+	recipients := ParseRecipients([]string{to.String()}, nil, nil)
+	if err := r.guard.Filter(recipients); err != nil {
+		log.Printf("Failed to check expiry reminder recipient for file %s: %v", file.ID, err)
+		return
+	}
+	if len(recipients.To) == 0 {
+		return
+	}
+
+	reminder := models.NewExpiryReminder(uuid.New().String(), file.ID, strings.ToLower(to.Address),
+		int(lead/time.Minute), file.ExpirationDate, time.Now().UTC())
+	claimed, err := r.reminders.ClaimReminder(reminder)
+	if err != nil || !claimed {
+		if err != nil {
+			log.Printf("Failed to claim expiry reminder for file %s: %v", file.ID, err)
+		}
+		return
+	}
+
+	rendered, err := RenderEmail("reminder", "", data)
+	if err == nil {
+		msg := &Message{To: recipients.To, Subject: rendered.Subject, Text: rendered.Text, HTML: rendered.HTML}
+		_, err = r.outbox.Enqueue(file.UserId, "", msg, recipients)
+	}
+	if err != nil {
+		log.Printf("Failed to queue expiry reminder for file %s: %v", file.ID, err)
+		if err := r.reminders.ReleaseReminder(reminder.ID); err != nil {
+			log.Printf("Failed to release expiry reminder %s: %v", reminder.ID, err)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fileTransfer/internal/config"
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/repository"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// expiringFiles returns its files that expire inside the window asked for
+type expiringFiles struct {
+	repository.FileDbRepo
+	files []models.File
+}
+
+func (f *expiringFiles) GetFilesExpiringBetween(from time.Time, to time.Time) ([]models.File, error) {
+	var expiring []models.File
+	for _, file := range f.files {
+		if file.ExpirationDate.After(from) && !file.ExpirationDate.After(to) {
+			expiring = append(expiring, file)
+		}
+	}
+	return expiring, nil
+}
+
+// ownerRepo knows every user, with an address made from their id
+type ownerRepo struct {
+	repository.UserDbRepo
+}
+
+func (u *ownerRepo) FindUserByID(id string) (*models.GoogleUser, error) {
+	return &models.GoogleUser{ID: id, Email: id + "@example.com", Name: "Owner"}, nil
+}
+
+// memReminders claims each file, recipient, lead time and expiry once
+type memReminders struct {
+	repository.ExpiryReminderDbRepo
+	claimed  map[string]*models.ExpiryReminder
+	released []string
+}
+
+func reminderKey(r *models.ExpiryReminder) string {
+	return fmt.Sprintf("%s|%s|%d|%d", r.FileId, r.Recipient, r.LeadTime, r.ExpiresAt.Unix())
+}
+
+func (m *memReminders) ClaimReminder(reminder *models.ExpiryReminder) (bool, error) {
+	key := reminderKey(reminder)
+	if m.claimed[key] != nil {
+		return false, nil
+	}
+	m.claimed[key] = reminder
+	return true, nil
+}
+
+func (m *memReminders) ReleaseReminder(id string) error {
+	for key, r := range m.claimed {
+		if r.ID == id {
+			delete(m.claimed, key)
+		}
+	}
+	m.released = append(m.released, id)
+	return nil
+}
+
+func (m *memOutbox) GetSentEmailsByFile(fileId string) ([]models.OutboxEmail, error) {
+	var sent []models.OutboxEmail
+	for _, e := range m.emails {
+		if e.FileId == fileId && e.Status == models.EmailSent {
+			sent = append(sent, *e)
+		}
+	}
+	return sent, nil
+}
+
+// fileDownloads lists the same downloads for every file
+type fileDownloads struct {
+	repository.DownloadEventDbRepo
+	events []models.DownloadEvent
+}
+
+func (d *fileDownloads) GetFileDownloaders(fileId string) ([]string, error) {
+	var downloaders []string
+	for _, e := range d.events {
+		if e.Completed && e.DownloadedBy != "" {
+			downloaders = append(downloaders, strings.ToLower(e.DownloadedBy))
+		}
+	}
+	return downloaders, nil
+}
+
+// brokenOutbox can't queue anything
+type brokenOutbox struct {
+	repository.EmailOutboxDbRepo
+}
+
+func (b *brokenOutbox) AddEmail(email *models.OutboxEmail) error {
+	return errors.New("database is down")
+}
+
+var reminderConfig = config.ReminderConfig{
+	LeadTimes:   []time.Duration{time.Hour, 24 * time.Hour},
+	ExtendBy:    24 * time.Hour,
+	MaxLifetime: 7 * 24 * time.Hour,
+}
+
+// queuedMessages returns the messages in the outbox by recipient, left out are the share emails the test put there
+func queuedMessages(t *testing.T, repo *memOutbox) map[string]*Message {
+	t.Helper()
+	queued := make(map[string]*Message)
+	for _, e := range repo.emails {
+		if e.FileId != "" {
+			continue
+		}
+		var msg Message
+		if err := json.Unmarshal([]byte(e.Message), &msg); err != nil {
+			t.Fatal(err)
+		}
+		queued[msg.To[0].Address] = &msg
+	}
+	return queued
+}
+
+func TestExpiryRemindersLeadTimes(t *testing.T) {
+	config.FrontendURL = "https://files.example.com"
+	now := time.Now().UTC()
+
+	tests := []struct {
+		name     string
+		uploaded time.Duration
+		expires  time.Duration
+		userId   string
+		wantLead int
+	}{
+		{name: "inside the shortest window", uploaded: -48 * time.Hour, expires: 30 * time.Minute, userId: "owner",
+			wantLead: 60},
+		{name: "inside the longest window", uploaded: -48 * time.Hour, expires: 20 * time.Hour, userId: "owner",
+			wantLead: 24 * 60},
+		{name: "window longer than the file's lifetime", uploaded: -time.Hour, expires: 20 * time.Hour,
+			userId: "owner"},
+		{name: "shorter window that fits the lifetime", uploaded: -time.Hour, expires: 30 * time.Minute,
+			userId: "owner", wantLead: 60},
+		{name: "outside every window", uploaded: -48 * time.Hour, expires: 30 * time.Hour, userId: "owner"},
+		{name: "no owner", uploaded: -48 * time.Hour, expires: 30 * time.Minute},
+		{name: "anonymous upload", uploaded: -48 * time.Hour, expires: 30 * time.Minute, userId: models.AnonymousUserId},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := models.File{ID: "file", UserId: tt.userId, Name: "report.pdf", S3Key: "uploads/report.pdf",
+				UploadedAt: now.Add(tt.uploaded), ExpirationDate: now.Add(tt.expires)}
+			reminders := &memReminders{claimed: map[string]*models.ExpiryReminder{}}
+			repo := &memOutbox{emails: map[string]*models.OutboxEmail{}}
+			r := NewExpiryReminders(&expiringFiles{files: []models.File{file}}, &ownerRepo{}, reminders, repo,
+				&fileDownloads{}, NewEmailOutbox(repo, &scriptedMailer{}, nil, 3), &EmailGuard{repo: &memAbuse{}},
+				newTestJWTService(t, ed25519Key(t)), reminderConfig)
+
+			r.SendDue()
+			queued := queuedMessages(t, repo)
+			if tt.wantLead == 0 {
+				if len(queued) != 0 || len(reminders.claimed) != 0 {
+					t.Fatalf("queued %v", queued)
+				}
+				return
+			}
+			msg := queued["owner@example.com"]
+			if len(queued) != 1 || msg == nil {
+				t.Fatalf("queued %v, want a reminder to the owner", queued)
+			}
+			for _, claimed := range reminders.claimed {
+				if claimed.LeadTime != tt.wantLead || claimed.Recipient != "owner@example.com" ||
+					!claimed.ExpiresAt.Equal(file.ExpirationDate) {
+					t.Errorf("claimed %+v, want the %d minute reminder", claimed, tt.wantLead)
+				}
+			}
+
+			// The next run finds the reminder claimed
+			r.SendDue()
+			if got := len(queuedMessages(t, repo)); got != 1 {
+				t.Errorf("queued %d reminders after a second run", got)
+			}
+		})
+	}
+}
+
+func TestExpiryRemindersExtendLink(t *testing.T) {
+	config.FrontendURL = "https://files.example.com"
+	now := time.Now().UTC()
+
+	tests := []struct {
+		name       string
+		uploaded   time.Duration
+		wantExtend bool
+	}{
+		{name: "can be extended", uploaded: -48 * time.Hour, wantExtend: true},
+		{name: "at the maximum lifetime", uploaded: -7*24*time.Hour + 30*time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := models.File{ID: "file", UserId: "owner", Name: "report.pdf", UploadedAt: now.Add(tt.uploaded),
+				ExpirationDate: now.Add(30 * time.Minute).Truncate(time.Second)}
+			repo := &memOutbox{emails: map[string]*models.OutboxEmail{}}
+			jwt := newTestJWTService(t, ed25519Key(t))
+			r := NewExpiryReminders(&expiringFiles{files: []models.File{file}}, &ownerRepo{},
+				&memReminders{claimed: map[string]*models.ExpiryReminder{}}, repo, &fileDownloads{},
+				NewEmailOutbox(repo, &scriptedMailer{}, nil, 3), &EmailGuard{repo: &memAbuse{}}, jwt, reminderConfig)
+
+			r.SendDue()
+			msg := queuedMessages(t, repo)["owner@example.com"]
+			if msg == nil {
+				t.Fatal("no reminder queued")
+			}
+			_, link, found := strings.Cut(msg.Text, "https://files.example.com/extend?token=")
+			if found != tt.wantExtend {
+				t.Fatalf("extend link in %q: %v, want %v", msg.Text, found, tt.wantExtend)
+			}
+			if !found {
+				return
+			}
+			token, err := url.QueryUnescape(strings.Fields(link)[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims, err := jwt.ParseExtendToken(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.FileId != "file" || claims.FileExpiry != file.ExpirationDate.Unix() {
+				t.Errorf("extend token for %+v", claims)
+			}
+			// An extend link is no session
+			if _, err := jwt.ParseToken(token); err == nil {
+				t.Error("extend token accepted as a session")
+			}
+		})
+	}
+}
+
+func TestExpiryRemindersRecipients(t *testing.T) {
+	config.FrontendURL = "https://files.example.com"
+	now := time.Now().UTC()
+	file := models.File{ID: "file", UserId: "owner", Name: "report.pdf", S3Key: "uploads/report.pdf",
+		UploadedAt: now.Add(-48 * time.Hour), ExpirationDate: now.Add(30 * time.Minute)}
+
+	repo := &memOutbox{emails: map[string]*models.OutboxEmail{}}
+	statuses, _ := json.Marshal([]RecipientStatus{
+		{Address: "a@example.com", Status: RecipientSent},
+		{Address: "B@example.com", Status: RecipientSent},
+		{Address: "c@example.com", Status: RecipientRejected},
+		{Address: "d@example.com", Status: RecipientSent},
+	})
+	repo.emails["share"] = &models.OutboxEmail{ID: "share", FileId: "file", Status: models.EmailSent, Recipients: statuses}
+	downloads := &fileDownloads{events: []models.DownloadEvent{
+		{DownloadedBy: "B@example.com", Completed: true},
+		{DownloadedBy: "a@example.com", Completed: false},
+		{ClientIP: "203.0.113.7", Completed: true},
+	}}
+	cfg := reminderConfig
+	cfg.NotifyRecipients = true
+	r := NewExpiryReminders(&expiringFiles{files: []models.File{file}}, &ownerRepo{},
+		&memReminders{claimed: map[string]*models.ExpiryReminder{}}, repo, downloads,
+		NewEmailOutbox(repo, &scriptedMailer{}, nil, 3), &EmailGuard{repo: &memAbuse{suppressions: []models.EmailSuppression{
+			{Address: "d@example.com", Reason: models.SuppressUnsubscribe}}}}, newTestJWTService(t, ed25519Key(t)), cfg)
+
+	r.SendDue()
+	queued := queuedMessages(t, repo)
+	var to []string
+	for address := range queued {
+		to = append(to, address)
+	}
+	slices.Sort(to)
+	// b has downloaded the file, c never got the email, d unsubscribed, a only started the download. Someone
+	// downloaded it anonymously, which may have been a or not, so a is still reminded.
+	if strings.Join(to, " ") != "a@example.com owner@example.com" {
+		t.Fatalf("reminded %v", to)
+	}
+	if msg := queued["a@example.com"]; !strings.Contains(msg.Text, "https://files.example.com/download?key=uploads%2Freport.pdf") ||
+		strings.Contains(msg.Text, "/extend") {
+		t.Errorf("recipient reminder %q", msg.Text)
+	}
+}
+
+func TestExpiryRemindersReleaseUnsent(t *testing.T) {
+	now := time.Now().UTC()
+	file := models.File{ID: "file", UserId: "owner", Name: "report.pdf", UploadedAt: now.Add(-48 * time.Hour),
+		ExpirationDate: now.Add(30 * time.Minute)}
+	reminders := &memReminders{claimed: map[string]*models.ExpiryReminder{}}
+	outbox := &brokenOutbox{}
+	r := NewExpiryReminders(&expiringFiles{files: []models.File{file}}, &ownerRepo{}, reminders, outbox,
+		&fileDownloads{}, NewEmailOutbox(outbox, &scriptedMailer{}, nil, 3), &EmailGuard{repo: &memAbuse{}},
+		newTestJWTService(t, ed25519Key(t)), reminderConfig)
+
+	r.SendDue()
+	// The reminder could not be queued, so the next run tries again
+	if len(reminders.released) != 1 || len(reminders.claimed) != 0 {
+		t.Errorf("released %v, still claimed %v", reminders.released, reminders.claimed)
+	}
+}
+
+func TestExtendedExpiry(t *testing.T) {
+	uploaded := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		expires time.Time
+		want    time.Time
+		wantOk  bool
+	}{
+		{"extended by a day", uploaded.Add(48 * time.Hour), uploaded.Add(72 * time.Hour), true},
+		{"capped at the maximum lifetime", uploaded.Add(6*24*time.Hour + 12*time.Hour), uploaded.Add(7 * 24 * time.Hour), true},
+		{"at the maximum lifetime", uploaded.Add(7 * 24 * time.Hour), uploaded.Add(7 * 24 * time.Hour), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := &models.File{UploadedAt: uploaded, ExpirationDate: tt.expires}
+			got, ok := ExtendedExpiry(file, reminderConfig)
+			if !got.Equal(tt.want) || ok != tt.wantOk {
+				t.Errorf("got %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatExpiry(t *testing.T) {
+	at := time.Date(2026, 3, 2, 17, 30, 0, 0, time.UTC)
+	tests := []struct {
+		locale, timezone, want string
+	}{
+		{"", "", "Monday, March 2, 2026 at 5:30 PM UTC"},
+		{"en-US", "America/New_York", "Monday, March 2, 2026 at 12:30 PM EST"},
+		{"en_GB", "Europe/London", "Monday 2 March 2026 at 17:30 GMT"},
+		{"de-DE", "Europe/Berlin", "Montag, 2. März 2026 um 18:30 CET"},
+		{"fr", "Europe/Paris", "lundi 2 mars 2026 à 18:30 CET"},
+		{"es-MX", "UTC", "lunes, 2 de marzo de 2026, 17:30 UTC"},
+		{"xx", "Not/AZone", "Monday, March 2, 2026 at 5:30 PM UTC"},
+	}
+	for _, tt := range tests {
+		if got := FormatExpiry(at, tt.locale, tt.timezone); got != tt.want {
+			t.Errorf("FormatExpiry(%q, %q) = %q, want %q", tt.locale, tt.timezone, got, tt.want)
+		}
+	}
+}
+
+func TestValidityText(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{72 * time.Hour, "3 days"},
+		{48 * time.Hour, "2 days"},
+		{47 * time.Hour, "47 hours"},
+		{time.Hour, "1 hour"},
+		{90 * time.Second, "1 minute"},
+		{30 * time.Second, "less than a minute"},
+		{-time.Hour, "less than a minute"},
+	}
+	for _, tt := range tests {
+		if got := ValidityText(tt.d); got != tt.want {
+			t.Errorf("ValidityText(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		size int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KB"},
+		{1536, "1.5 KB"},
+		{13107200, "12.5 MB"},
+		{5 << 30, "5.0 GB"},
+	}
+	for _, tt := range tests {
+		if got := FormatSize(tt.size); got != tt.want {
+			t.Errorf("FormatSize(%d) = %q, want %q", tt.size, got, tt.want)
+		}
+	}
+}
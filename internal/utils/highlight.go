@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"html"
+	"html/template"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+type codeLang struct {
+	lineComments  []string
+	blockComment  [2]string
+	keywords      map[string]bool
+	caseSensitive bool
+}
+
+func keywordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(words) {
+		set[w] = true
+	}
+	return set
+}
+
+var (
+	cLikeLang = &codeLang{
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		keywords: keywordSet(`break case catch class const continue default defer do else enum export extends
+			false final finally fn for func go goto if impl implements import in interface let match mod mut new nil
+			null package private protected pub public return select static struct super switch this throw throws
+			trait true try type typeof use var void while yield async await function int long char float double bool
+			boolean string byte auto unsigned signed sizeof namespace template typename using val when object override`),
+		caseSensitive: true,
+	}
+	hashLang = &codeLang{
+		lineComments: []string{"#"},
+		keywords: keywordSet(`and as assert async await break class continue def del elif else except False finally
+			for from global if import in is lambda None nonlocal not or pass raise return True try while with yield
+			then fi do done case esac function local export echo begin end module require unless until true false`),
+		caseSensitive: true,
+	}
+	sqlLang = &codeLang{
+		lineComments: []string{"--"},
+		blockComment: [2]string{"/*", "*/"},
+		keywords: keywordSet(`select from where and or not insert into values update set delete create table alter
+			drop index join left right inner outer on as group by order having limit offset union all distinct
+			primary key foreign references null is in like between case when then else end exists default`),
+	}
+	dataLang  = &codeLang{caseSensitive: true}
+	plainLang = (*codeLang)(nil)
+)
+
+// codeExtensions maps previewable text and code file extensions to their highlighting rules
+var codeExtensions = map[string]*codeLang{
+	".go": cLikeLang, ".js": cLikeLang, ".mjs": cLikeLang, ".ts": cLikeLang, ".tsx": cLikeLang, ".jsx": cLikeLang,
+	".java": cLikeLang, ".kt": cLikeLang, ".c": cLikeLang, ".h": cLikeLang, ".cpp": cLikeLang, ".hpp": cLikeLang,
+	".cc": cLikeLang, ".cs": cLikeLang, ".rs": cLikeLang, ".swift": cLikeLang, ".php": cLikeLang, ".css": cLikeLang,
+	".scala": cLikeLang, ".dart": cLikeLang,
+	".py": hashLang, ".sh": hashLang, ".bash": hashLang, ".rb": hashLang, ".pl": hashLang, ".r": hashLang,
+	".yaml": hashLang, ".yml": hashLang, ".toml": hashLang, ".ini": hashLang, ".conf": hashLang, ".env": hashLang,
+	".sql":  sqlLang,
+	".json": dataLang, ".csv": dataLang, ".xml": dataLang, ".html": dataLang, ".svg": dataLang,
+	".txt": plainLang, ".log": plainLang, ".md": plainLang,
+}
+
+// IsPreviewableText reports whether a file name looks like text or code that can be rendered inline
+func IsPreviewableText(name string) bool {
+	_, ok := codeExtensions[strings.ToLower(filepath.Ext(name))]
+	return ok
+}
+
+// HighlightCode escapes src and wraps keywords, strings, numbers and comments in spans for styling
+func HighlightCode(name string, src []byte) template.HTML {
+	lang := codeExtensions[strings.ToLower(filepath.Ext(name))]
+	text := strings.ToValidUTF8(string(src), "�")
+	if lang == nil {
+		return template.HTML(html.EscapeString(text))
+	}
+
+	var b strings.Builder
+	span := func(class, s string) {
+		b.WriteString(`<span class="` + class + `">`)
+		b.WriteString(html.EscapeString(s))
+		b.WriteString(`</span>`)
+	}
+
+	for i := 0; i < len(text); {
+		rest := text[i:]
+
+		if n := lang.commentLen(rest); n > 0 {
+			span("c", rest[:n])
+			i += n
+			continue
+		}
+
+		switch r, size := utf8.DecodeRuneInString(rest); {
+		case r == '"' || r == '\'' || r == '`':
+			n := stringLen(rest, byte(r))
+			span("s", rest[:n])
+			i += n
+		case r >= '0' && r <= '9' && (i == 0 || !isWordByte(text[i-1])):
+			n := 1
+			for n < len(rest) && (isWordByte(rest[n]) || rest[n] == '.') {
+				n++
+			}
+			span("n", rest[:n])
+			i += n
+		case isWordByte(rest[0]):
+			n := 1
+			for n < len(rest) && isWordByte(rest[n]) {
+				n++
+			}
+			word := rest[:n]
+			lookup := word
+			if !lang.caseSensitive {
+				lookup = strings.ToLower(word)
+			}
+			if lang.keywords[lookup] {
+				span("k", word)
+			} else {
+				b.WriteString(html.EscapeString(word))
+			}
+			i += n
+		default:
+			b.WriteString(html.EscapeString(rest[:size]))
+			i += size
+		}
+	}
+
+	return template.HTML(b.String())
+}
+
+func (l *codeLang) commentLen(s string) int {
+	for _, prefix := range l.lineComments {
+		if strings.HasPrefix(s, prefix) {
+			if end := strings.IndexByte(s, '\n'); end >= 0 {
+				return end
+			}
+			return len(s)
+		}
+	}
+	if l.blockComment[0] != "" && strings.HasPrefix(s, l.blockComment[0]) {
+		if end := strings.Index(s[len(l.blockComment[0]):], l.blockComment[1]); end >= 0 {
+			return len(l.blockComment[0]) + end + len(l.blockComment[1])
+		}
+		return len(s)
+	}
+	return 0
+}
+
+// stringLen returns the length of the quoted string at the start of s, stopping at the end of the line
+// for ordinary quotes so an unbalanced quote doesn't swallow the rest of the file
+func stringLen(s string, quote byte) int {
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quote != '`':
+			i++
+		case s[i] == quote:
+			return i + 1
+		case s[i] == '\n' && quote != '`':
+			return i
+		}
+	}
+	return len(s)
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= utf8.RuneSelf
+}
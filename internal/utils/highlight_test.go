@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var spanTag = regexp.MustCompile(`<span class="[kcsn]">|</span>`)
+
+func TestHighlightCodeEscapes(t *testing.T) {
+	const specials = `<script>alert(1)</script> & "double" 'single'`
+	tests := []struct {
+		name string
+		file string
+		src  string
+	}{
+		{"plain text", "notes.txt", specials},
+		{"outside tokens", "main.go", "x := a <b> && c"},
+		{"line comment", "main.go", "// " + specials},
+		{"block comment", "main.go", "/* " + specials + " */"},
+		{"unterminated block comment", "main.go", "/* " + specials},
+		{"double quoted string", "main.go", `"<b>&'"`},
+		{"single quoted string", "main.py", `'<b>&"'`},
+		{"backtick string", "main.js", "`<b>\n&\"'`"},
+		{"unterminated string", "main.go", `"<b>&` + "\n<i>"},
+		{"escaped quote in string", "main.go", `"<\"&>"`},
+		{"hash comment", "run.sh", "# " + specials},
+		{"sql comment", "query.sql", "-- " + specials + "\nSELECT '<x>' FROM t"},
+		{"data file", "page.html", `<a href="x">&amp;</a>`},
+		{"numbers and keywords next to markup", "main.go", "return 1<2&&3>0"},
+		{"invalid UTF-8", "main.go", "\xff<\xfe>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := string(HighlightCode(tt.file, []byte(tt.src)))
+			text := spanTag.ReplaceAllString(out, "")
+			if strings.ContainsAny(text, `<>"'`) {
+				t.Errorf("unescaped markup in %q", out)
+			}
+			if want := strings.ToValidUTF8(tt.src, "�"); html.UnescapeString(text) != want {
+				t.Errorf("unescaped output %q, want the source %q", html.UnescapeString(text), want)
+			}
+		})
+	}
+}
+
+func TestHighlightCodeClasses(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		src  string
+		want []string
+	}{
+		{"go", "main.go", "func f() int { return 42 } // done",
+			[]string{`<span class="k">func</span>`, `<span class="k">return</span>`, `<span class="n">42</span>`,
+				`<span class="c">// done</span>`}},
+		{"string", "main.go", `s := "a<b"`, []string{`<span class="s">&#34;a&lt;b&#34;</span>`}},
+		{"sql keywords ignore case", "q.sql", "Select x", []string{`<span class="k">Select</span>`}},
+		{"go keywords are case sensitive", "main.go", "Func", []string{"Func"}},
+		{"number inside a word", "main.go", "x1", []string{"x1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := string(HighlightCode(tt.file, []byte(tt.src)))
+			for _, want := range tt.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("%q lacks %q", out, want)
+				}
+			}
+		})
+	}
+}
+
+func TestIsPreviewableText(t *testing.T) {
+	for name, want := range map[string]bool{"main.go": true, "README.MD": true, "data.json": true, "photo.png": false,
+		"archive.zip": false, "noext": false} {
+		if got := IsPreviewableText(name); got != want {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestRenderPreviewHTMLEscapesName(t *testing.T) {
+	page, err := RenderPreviewHTML(`<img src=x onerror=alert(1)>.go`, []byte("package main"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(page, "<img") {
+		t.Errorf("file name is not escaped: %s", page)
+	}
+	if !strings.Contains(page, `<span class="k">package</span>`) {
+		t.Errorf("code is not highlighted: %s", page)
+	}
+}
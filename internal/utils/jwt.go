@@ -1,58 +1,269 @@
 package utils
 
 import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fileTransfer/internal/config"
 	"fmt"
-	"github.com/golang-jwt/jwt/v5"
+	"log"
+	"os"
+	"slices"
+	"strings"
 	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
-var jwtKey = []byte("my-secret-key")
+// jwtKey is a key tokens are verified with, kid is its RFC 7638 thumbprint so every service derives the same one
+type jwtKey struct {
+	kid    string
+	method jwt.SigningMethod
+	public crypto.PublicKey
+}
+
+type JWTService struct {
+	signer     crypto.Signer
+	signingKey *jwtKey
+	keys       map[string]*jwtKey
+	issuer     string
+	audience   string
+}
+
+// NewJWTService loads the signing and verification keys. A signing key is required, unless ephemeral keys are
+// allowed for development, in which case a random one is generated: tokens then don't survive restarts and other
+// instances and services can't verify them.
+func NewJWTService(cfg config.JWTConfig) (*JWTService, error) {
+	j := &JWTService{keys: make(map[string]*jwtKey), issuer: cfg.Issuer, audience: cfg.Audience}
+
+	var signer crypto.Signer
+	if cfg.SigningKey == "" {
+		if !cfg.EphemeralKey {
+			return nil, errors.New("JWT_SIGNING_KEY is not set, set JWT_ALLOW_EPHEMERAL_KEY=true to use a random key in development")
+		}
+		log.Printf("Warning: JWT_SIGNING_KEY is not set, using a random key")
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		signer = private
+	} else {
+		block, err := readPEM(cfg.SigningKey)
+		if err != nil {
+			return nil, fmt.Errorf("signing key: %w", err)
+		}
+		if signer, err = parsePrivateKey(block); err != nil {
+			return nil, fmt.Errorf("signing key: %w", err)
+		}
+	}
+	key, err := newJWTKey(signer.Public())
+	if err != nil {
+		return nil, fmt.Errorf("signing key: %w", err)
+	}
+	j.signer, j.signingKey = signer, key
+	j.keys[key.kid] = key
+
+	for i, v := range cfg.VerificationKeys {
+		block, err := readPEM(v)
+		if err != nil {
+			return nil, fmt.Errorf("verification key %d: %w", i+1, err)
+		}
+		public, err := parsePublicKey(block)
+		if err != nil {
+			return nil, fmt.Errorf("verification key %d: %w", i+1, err)
+		}
+		key, err := newJWTKey(public)
+		if err != nil {
+			return nil, fmt.Errorf("verification key %d: %w", i+1, err)
+		}
+		j.keys[key.kid] = key
+	}
+	return j, nil
+}
+
+// readPEM takes a PEM, or else reads it from the file at that path
+func readPEM(v string) (*pem.Block, error) {
+	data := []byte(v)
+	if !strings.HasPrefix(strings.TrimSpace(v), "-----BEGIN") {
+		var err error
+		if data, err = os.ReadFile(v); err != nil {
+			return nil, err
+		}
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	return block, nil
+}
 
-type JWTService struct{}
+func parsePrivateKey(block *pem.Block) (crypto.Signer, error) {
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("unsupported private key")
+		}
+		return signer, nil
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	return nil, fmt.Errorf("unsupported PEM type %q", block.Type)
+}
 
-func NewJWTService() *JWTService {
-	return &JWTService{}
+// parsePublicKey also accepts a private key, so a retired signing key file can be reused as is
+func parsePublicKey(block *pem.Block) (crypto.PublicKey, error) {
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	signer, err := parsePrivateKey(block)
+	if err != nil {
+		return nil, err
+	}
+	return signer.Public(), nil
 }
 
+func newJWTKey(public crypto.PublicKey) (*jwtKey, error) {
+	var method jwt.SigningMethod
+	switch k := public.(type) {
+	case ed25519.PublicKey:
+		method = jwt.SigningMethodEdDSA
+	case *rsa.PublicKey:
+		if k.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+		method = jwt.SigningMethodRS256
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, use Ed25519 or RSA", public)
+	}
+
+	thumbprint, err := (&jose.JSONWebKey{Key: public}).Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	return &jwtKey{kid: base64.RawURLEncoding.EncodeToString(thumbprint), method: method, public: public}, nil
+}
+
+// JWKS is the public key set other services verify our tokens with
+func (j *JWTService) JWKS() jose.JSONWebKeySet {
+	var set jose.JSONWebKeySet
+	// The signing key first, then the retired ones
+	for _, key := range append([]*jwtKey{j.signingKey}, j.verificationKeys()...) {
+		set.Keys = append(set.Keys, jose.JSONWebKey{Key: key.public, KeyID: key.kid, Algorithm: key.method.Alg(), Use: "sig"})
+	}
+	return set
+}
+
+func (j *JWTService) verificationKeys() []*jwtKey {
+	var keys []*jwtKey
+	for kid, key := range j.keys {
+		if kid != j.signingKey.kid {
+			keys = append(keys, key)
+		}
+	}
+	slices.SortFunc(keys, func(a, b *jwtKey) int { return strings.Compare(a.kid, b.kid) })
+	return keys
+}
+
+func (j *JWTService) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(j.signingKey.method, claims)
+	token.Header["kid"] = j.signingKey.kid
+	return token.SignedString(j.signer)
+}
+
+// parse verifies a token with the key its kid names, in the algorithm of that key only
+func (j *JWTService) parse(tokenString string, claims jwt.Claims, audience string) error {
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := j.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q", kid)
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.public, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg(), jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(j.issuer), jwt.WithAudience(audience), jwt.WithExpirationRequired())
+	return err
+}
+
+// Claims of an access token. SessionId is the refresh token family of the login, which revokes the token with it.
 type Claims struct {
-	Email string `json:"email"`
+	Email     string `json:"email"`
+	SessionId string `json:"sid"`
 	jwt.RegisteredClaims
 }
 
-func (j *JWTService) GenerateToken(email string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+func (j *JWTService) GenerateToken(email string, sessionId string, ttl time.Duration) (string, error) {
+	expirationTime := time.Now().Add(ttl)
 	claims := Claims{
-		Email: email,
+		Email:     email,
+		SessionId: sessionId,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Issuer:    j.issuer,
+			Audience:  jwt.ClaimStrings{j.audience},
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
-	if err != nil {
-		return "", err
-	}
-
-	return tokenString, nil
+	return j.sign(claims)
 }
 
+// ParseToken only accepts access tokens, tokens for a single purpose such as extend links have another audience
 func (j *JWTService) ParseToken(tokenString string) (*Claims, error) {
 	claims := &Claims{}
+	if err := j.parse(tokenString, claims, j.audience); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
 
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		// Ensure the signing method is exactly HS256
-		if token.Method != jwt.SigningMethodHS256 {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return jwtKey, nil
-	})
+const extendAudience = "file-extend"
 
-	if err != nil || !token.Valid {
-		return nil, err
+// ExtendClaims allow extending one file, only while it still has the expiry the link was emailed for
+type ExtendClaims struct {
+	FileId     string `json:"file_id"`
+	FileExpiry int64  `json:"file_expiry"`
+	jwt.RegisteredClaims
+}
+
+// GenerateExtendToken signs an extend link for a file, valid until the file expires
+func (j *JWTService) GenerateExtendToken(fileId string, expiry time.Time) (string, error) {
+	claims := ExtendClaims{
+		FileId:     fileId,
+		FileExpiry: expiry.Unix(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    j.issuer,
+			Audience:  jwt.ClaimStrings{extendAudience},
+			ExpiresAt: jwt.NewNumericDate(expiry),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
 	}
+	return j.sign(claims)
+}
 
+func (j *JWTService) ParseExtendToken(tokenString string) (*ExtendClaims, error) {
+	claims := &ExtendClaims{}
+	if err := j.parse(tokenString, claims, extendAudience); err != nil {
+		return nil, err
+	}
 	return claims, nil
 }
@@ -0,0 +1,209 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fileTransfer/internal/config"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func pemKey(t *testing.T, key crypto.Signer) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func ed25519Key(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func rsaKey(t *testing.T, bits int) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newTestJWTService(t *testing.T, signing crypto.Signer, verification ...crypto.Signer) *JWTService {
+	t.Helper()
+	cfg := config.JWTConfig{SigningKey: pemKey(t, signing), Issuer: "fileTransfer", Audience: "fileTransfer"}
+	for _, key := range verification {
+		cfg.VerificationKeys = append(cfg.VerificationKeys, pemKey(t, key))
+	}
+	j, err := NewJWTService(cfg)
+	if err != nil {
+		t.Fatalf("NewJWTService: %v", err)
+	}
+	return j
+}
+
+func TestNewJWTServiceKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.JWTConfig
+		wantErr bool
+	}{
+		{"no signing key", config.JWTConfig{}, true},
+		{"ephemeral key allowed", config.JWTConfig{EphemeralKey: true}, false},
+		{"Ed25519 key", config.JWTConfig{SigningKey: pemKey(t, ed25519Key(t))}, false},
+		{"RSA key", config.JWTConfig{SigningKey: pemKey(t, rsaKey(t, 2048))}, false},
+		{"short RSA key", config.JWTConfig{SigningKey: pemKey(t, rsaKey(t, 1024))}, true},
+		{"not a PEM", config.JWTConfig{SigningKey: "-----BEGIN nothing"}, true},
+		{"missing key file", config.JWTConfig{SigningKey: "/nonexistent/jwt.pem"}, true},
+		{"bad verification key", config.JWTConfig{SigningKey: pemKey(t, ed25519Key(t)), VerificationKeys: []string{"-----BEGIN nothing"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewJWTService(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseToken(t *testing.T) {
+	current := ed25519Key(t)
+	retired := rsaKey(t, 2048)
+	j := newTestJWTService(t, current, retired)
+	// The same keys with the roles swapped, as before the rotation
+	old := newTestJWTService(t, retired)
+	unknown := newTestJWTService(t, ed25519Key(t))
+
+	valid := func() jwt.RegisteredClaims {
+		return jwt.RegisteredClaims{
+			Issuer:    "fileTransfer",
+			Audience:  jwt.ClaimStrings{"fileTransfer"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		}
+	}
+	withClaims := func(edit func(*jwt.RegisteredClaims)) *Claims {
+		claims := &Claims{Email: "user@example.com", RegisteredClaims: valid()}
+		edit(&claims.RegisteredClaims)
+		return claims
+	}
+	mustSign := func(s *JWTService, claims jwt.Claims) string {
+		token, err := s.sign(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	// A token whose header claims another algorithm than that of the key its kid names
+	wrongAlg := func() string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, withClaims(func(*jwt.RegisteredClaims) {}))
+		token.Header["kid"] = j.signingKey.kid
+		signed, err := token.SignedString(retired)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	noneAlg := func() string {
+		token := jwt.NewWithClaims(jwt.SigningMethodNone, withClaims(func(*jwt.RegisteredClaims) {}))
+		token.Header["kid"] = j.signingKey.kid
+		signed, err := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	generated, err := j.GenerateToken("user@example.com", "session", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extend, err := j.GenerateExtendToken("file", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"generated token", generated, false},
+		{"signed with a retired key", mustSign(old, withClaims(func(*jwt.RegisteredClaims) {})), false},
+		{"unknown kid", mustSign(unknown, withClaims(func(*jwt.RegisteredClaims) {})), true},
+		{"algorithm of another key", wrongAlg(), true},
+		{"alg none", noneAlg(), true},
+		{"wrong audience", mustSign(j, withClaims(func(c *jwt.RegisteredClaims) { c.Audience = jwt.ClaimStrings{"other"} })), true},
+		{"extend token", extend, true},
+		{"wrong issuer", mustSign(j, withClaims(func(c *jwt.RegisteredClaims) { c.Issuer = "other" })), true},
+		{"expired", mustSign(j, withClaims(func(c *jwt.RegisteredClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute)) })), true},
+		{"no expiry", mustSign(j, withClaims(func(c *jwt.RegisteredClaims) { c.ExpiresAt = nil })), true},
+		{"garbage", "not.a.token", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := j.ParseToken(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && claims.Email != "user@example.com" {
+				t.Errorf("got email %q", claims.Email)
+			}
+		})
+	}
+}
+
+func TestParseExtendToken(t *testing.T) {
+	j := newTestJWTService(t, ed25519Key(t))
+	expiry := time.Now().Add(time.Hour)
+
+	extend, err := j.GenerateExtendToken("file", expiry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := j.ParseExtendToken(extend)
+	if err != nil {
+		t.Fatalf("ParseExtendToken: %v", err)
+	}
+	if claims.FileId != "file" || claims.FileExpiry != expiry.Unix() {
+		t.Errorf("got %+v", claims)
+	}
+
+	access, err := j.GenerateToken("user@example.com", "session", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.ParseExtendToken(access); err == nil {
+		t.Error("an access token was accepted as an extend token")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	j := newTestJWTService(t, ed25519Key(t), rsaKey(t, 2048))
+	set := j.JWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("got %d keys, want 2", len(set.Keys))
+	}
+	if set.Keys[0].KeyID != j.signingKey.kid || set.Keys[0].Algorithm != "EdDSA" {
+		t.Errorf("first key is %s %s, want the signing key", set.Keys[0].KeyID, set.Keys[0].Algorithm)
+	}
+	if set.Keys[1].Algorithm != "RS256" {
+		t.Errorf("second key is %s, want RS256", set.Keys[1].Algorithm)
+	}
+	for _, key := range set.Keys {
+		if !key.IsPublic() {
+			t.Errorf("key %s is not public", key.KeyID)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/repository"
+	"fmt"
+	"log"
+	"time"
+)
+
+// DownloaderLabel describes who downloaded a file for notification emails
+func DownloaderLabel(event *models.DownloadEvent) string {
+	if event.DownloadedBy != "" {
+		return event.DownloadedBy
+	}
+	return "an anonymous recipient (" + event.ClientIP + ")"
+}
+
+// FileDisplayName is the name shown to owners, encrypted files have no name the server can read
+func FileDisplayName(name string, encrypted bool) string {
+	if encrypted || name == "" {
+		return "an end-to-end encrypted file"
+	}
+	return name
+}
+
+// SendNotification queues a notification to an owner in the email outbox, unless their address is suppressed
+func SendNotification(outbox *EmailOutbox, guard *EmailGuard, userId string, to string, subject string, rendered *RenderedEmail) error {
+	suppressed, err := guard.Suppressed(to)
+	if err != nil || suppressed {
+		return err
+	}
+	msg := NotificationMessage(to, subject, rendered.Text, rendered.HTML)
+	_, err = outbox.Enqueue(userId, "", msg, ParseRecipients([]string{to}, nil, nil))
+	return err
+}
+
+// maxDigestLines bounds a digest for a file that was downloaded a lot, the rest are only counted
+const maxDigestLines = 50
+
+// SendDownloadDigests queues for each owner who asked for a daily digest a summary of their unreported downloads.
+// Downloads of owners whose address is suppressed are marked as reported without an email.
+func SendDownloadDigests(repo repository.DownloadEventDbRepo, outbox *EmailOutbox, guard *EmailGuard) {
+	entries, err := repo.GetPendingDigestEvents()
+	if err != nil {
+		log.Printf("Failed to get download digest events: %v", err)
+		return
+	}
+
+	byOwner := make(map[string][]models.DownloadDigestEntry)
+	var owners []string
+	for _, e := range entries {
+		if _, ok := byOwner[e.OwnerEmail]; !ok {
+			owners = append(owners, e.OwnerEmail)
+		}
+		byOwner[e.OwnerEmail] = append(byOwner[e.OwnerEmail], e)
+	}
+
+	for _, owner := range owners {
+		var lines, ids []string
+		for _, e := range byOwner[owner] {
+			if len(lines) < maxDigestLines {
+				lines = append(lines, fmt.Sprintf("%s was downloaded by %s at %s.",
+					FileDisplayName(e.FileName, false), DownloaderLabel(&e.Event), e.Event.DownloadedAt.Format(time.RFC1123)))
+			}
+			ids = append(ids, e.Event.ID)
+		}
+		if more := len(ids) - len(lines); more > 0 {
+			lines = append(lines, fmt.Sprintf("And %d more downloads.", more))
+		}
+
+		subject := fmt.Sprintf("Your files were downloaded %d times", len(ids))
+		rendered, err := RenderNotification(NotificationData{Title: "Daily download summary", Lines: lines})
+		if err != nil {
+			log.Printf("Failed to render download digest: %v", err)
+			continue
+		}
+		if err := SendNotification(outbox, guard, byOwner[owner][0].OwnerId, owner, subject, rendered); err != nil {
+			log.Printf("Failed to queue download digest to %s: %v", owner, err)
+			continue
+		}
+		if err := repo.MarkEventsNotified(ids); err != nil {
+			log.Printf("Failed to mark digest events notified: %v", err)
+		}
+	}
+}
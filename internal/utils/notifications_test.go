@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/repository"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type digestRepo struct {
+	repository.DownloadEventDbRepo
+	pending  []models.DownloadDigestEntry
+	notified []string
+}
+
+func (r *digestRepo) GetPendingDigestEvents() ([]models.DownloadDigestEntry, error) {
+	return r.pending, nil
+}
+
+func (r *digestRepo) MarkEventsNotified(ids []string) error {
+	r.notified = append(r.notified, ids...)
+	return nil
+}
+
+func TestSendDownloadDigests(t *testing.T) {
+	at := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	entry := func(id, owner, file, by string) models.DownloadDigestEntry {
+		return models.DownloadDigestEntry{Event: models.DownloadEvent{ID: id, DownloadedAt: at, DownloadedBy: by,
+			ClientIP: "203.0.113.7"}, FileName: file, OwnerId: strings.TrimSuffix(owner, "@example.com"), OwnerEmail: owner}
+	}
+	repo := &digestRepo{pending: []models.DownloadDigestEntry{
+		entry("1", "a@example.com", "report.pdf", "x@example.com"),
+		entry("2", "b@example.com", "photo.png", ""),
+		entry("3", "a@example.com", "", "y@example.com"),
+		entry("4", "bounced@example.com", "slides.pdf", ""),
+	}}
+	outbox := &memOutbox{emails: map[string]*models.OutboxEmail{}}
+	mailer := &scriptedMailer{}
+	guard := &EmailGuard{repo: &memAbuse{suppressions: []models.EmailSuppression{{Address: "bounced@example.com"}}}}
+
+	SendDownloadDigests(repo, NewEmailOutbox(outbox, mailer, nil, 3), guard)
+
+	// Digests go through the outbox, so a failed send is retried by its worker
+	queued := queuedMessages(t, outbox)
+	if len(queued) != 2 || mailer.sends != 0 {
+		t.Fatalf("queued %d digests and sent %d, want one queued per reachable owner", len(queued), mailer.sends)
+	}
+	a, b := queued["a@example.com"], queued["b@example.com"]
+	if a == nil || a.Subject != "Your files were downloaded 2 times" {
+		t.Fatalf("digest to a@example.com: %+v", a)
+	}
+	for _, want := range []string{"report.pdf was downloaded by x@example.com", "an end-to-end encrypted file was downloaded by y@example.com"} {
+		if !strings.Contains(a.Text, want) {
+			t.Errorf("digest %q lacks %q", a.Text, want)
+		}
+	}
+	if b == nil || !strings.Contains(b.Text, "an anonymous recipient (203.0.113.7)") {
+		t.Errorf("digest to b@example.com: %+v", b)
+	}
+	for _, e := range outbox.emails {
+		if e.UserId != "a" && e.UserId != "b" {
+			t.Errorf("queued %s for user %q", e.ID, e.UserId)
+		}
+	}
+	// The suppressed owner's download is reported without an email
+	if strings.Join(repo.notified, ",") != "1,3,2,4" {
+		t.Errorf("marked %v notified", repo.notified)
+	}
+}
+
+func TestSendDownloadDigestsBoundsLines(t *testing.T) {
+	repo := &digestRepo{}
+	for i := 0; i < maxDigestLines+7; i++ {
+		repo.pending = append(repo.pending, models.DownloadDigestEntry{Event: models.DownloadEvent{ID: strconv.Itoa(i),
+			DownloadedBy: "x@example.com"}, FileName: "report.pdf", OwnerId: "a", OwnerEmail: "a@example.com"})
+	}
+	outbox := &memOutbox{emails: map[string]*models.OutboxEmail{}}
+	guard := &EmailGuard{repo: &memAbuse{}}
+
+	SendDownloadDigests(repo, NewEmailOutbox(outbox, &scriptedMailer{}, nil, 3), guard)
+
+	digest := queuedMessages(t, outbox)["a@example.com"]
+	if digest == nil || digest.Subject != "Your files were downloaded 57 times" {
+		t.Fatalf("queued %+v", digest)
+	}
+	if n := strings.Count(digest.Text, "report.pdf was downloaded"); n != maxDigestLines ||
+		!strings.Contains(digest.Text, "And 7 more downloads.") {
+		t.Errorf("digest lists %d downloads: %q", n, digest.Text)
+	}
+	// The downloads left out are reported all the same
+	if len(repo.notified) != maxDigestLines+7 {
+		t.Errorf("marked %d of %d notified", len(repo.notified), maxDigestLines+7)
+	}
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// maxRateLimiterKeys is how many keys are kept before the ones with no recent hits are swept
+const maxRateLimiterKeys = 10000
+
+// RateLimiter allows each key, such as a client IP, limit hits per sliding window. It is kept in memory, so
+// every instance counts on its own.
+type RateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu   sync.Mutex
+	hits map[string][]time.Time
+}
+
+// NewRateLimiter returns a limiter allowing limit hits per window, a limit of 0 allows everything
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{limit: limit, window: window, hits: make(map[string][]time.Time)}
+}
+
+// Allow records a hit for key, unless the key already used up its limit
+func (l *RateLimiter) Allow(key string) bool {
+	if l.limit <= 0 {
+		return true
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.hits) >= maxRateLimiterKeys {
+		for k, hits := range l.hits {
+			if len(recentHits(hits, now.Add(-l.window))) == 0 {
+				delete(l.hits, k)
+			}
+		}
+	}
+
+	hits := recentHits(l.hits[key], now.Add(-l.window))
+	if len(hits) >= l.limit {
+		l.hits[key] = hits
+		return false
+	}
+	l.hits[key] = append(hits, now)
+	return true
+}
+
+// recentHits drops the hits before since, hits are in order
+func recentHits(hits []time.Time, since time.Time) []time.Time {
+	for i, t := range hits {
+		if t.After(since) {
+			return hits[i:]
+		}
+	}
+	return nil
+}
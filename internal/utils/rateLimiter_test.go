@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(2, time.Hour)
+	for i := 0; i < 2; i++ {
+		if !l.Allow("203.0.113.7") {
+			t.Fatalf("hit %d refused", i+1)
+		}
+	}
+	if l.Allow("203.0.113.7") {
+		t.Errorf("allowed a hit over the limit")
+	}
+	if !l.Allow("198.51.100.1") {
+		t.Errorf("another key is limited")
+	}
+
+	// Hits older than the window no longer count
+	for i := range l.hits["203.0.113.7"] {
+		l.hits["203.0.113.7"][i] = time.Now().Add(-time.Hour - time.Second)
+	}
+	if !l.Allow("203.0.113.7") {
+		t.Errorf("still limited after the window")
+	}
+
+	unlimited := NewRateLimiter(0, time.Hour)
+	for i := 0; i < 5; i++ {
+		if !unlimited.Allow("203.0.113.7") {
+			t.Fatalf("a limit of 0 refused hit %d", i+1)
+		}
+	}
+}
+
+func TestRateLimiterSweepsIdleKeys(t *testing.T) {
+	l := NewRateLimiter(1, time.Minute)
+	idle := []time.Time{time.Now().Add(-time.Hour)}
+	for i := 0; i < maxRateLimiterKeys; i++ {
+		l.hits[string(rune(i))] = idle
+	}
+	l.hits["busy"] = []time.Time{time.Now()}
+
+	if !l.Allow("new") {
+		t.Fatal("new key refused")
+	}
+	if len(l.hits) != 2 || l.Allow("busy") {
+		t.Errorf("kept %d keys after the sweep", len(l.hits))
+	}
+}
@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"slices"
+	"strings"
+)
+
+// Recipient statuses reported back to the sender
+const (
+	RecipientInvalid   = "invalid"
+	RecipientDuplicate = "duplicate"
+	RecipientQueued    = "queued"
+	RecipientSent      = "sent"
+	RecipientRejected  = "rejected"
+	RecipientFailed    = "failed"
+	// Suppressed addresses bounced, reported us as spam or unsubscribed, throttled ones got too many emails lately
+	RecipientSuppressed = "suppressed"
+	RecipientThrottled  = "throttled"
+)
+
+type RecipientStatus struct {
+	Address string `json:"address"`
+	Name    string `json:"name,omitempty"`
+	Field   string `json:"field"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Recipients are the parsed, de-duplicated addresses of a message along with the status of every input
+type Recipients struct {
+	To       []*mail.Address
+	Cc       []*mail.Address
+	Bcc      []*mail.Address
+	Statuses []RecipientStatus
+}
+
+// Count is the number of valid, unique recipients
+func (r *Recipients) Count() int {
+	return len(r.To) + len(r.Cc) + len(r.Bcc)
+}
+
+// ParseRecipients parses RFC 5322 addresses, with or without display names. An address appearing in more
+// than one field is kept in the first of To, Cc and Bcc so nobody receives the same email twice.
+// Entries that can't be parsed are reported as invalid instead of failing the whole list.
+func ParseRecipients(to, cc, bcc []string) *Recipients {
+	r := &Recipients{}
+	seen := make(map[string]bool)
+
+	add := func(field string, entries []string, dst *[]*mail.Address) {
+		for _, entry := range entries {
+			if strings.TrimSpace(entry) == "" {
+				continue
+			}
+			list, err := mail.ParseAddressList(entry)
+			if err != nil {
+				r.Statuses = append(r.Statuses, RecipientStatus{Address: entry, Field: field, Status: RecipientInvalid,
+					Error: err.Error()})
+				continue
+			}
+			for _, a := range list {
+				status := RecipientStatus{Address: a.Address, Name: a.Name, Field: field, Status: RecipientQueued}
+				key := strings.ToLower(a.Address)
+				if seen[key] {
+					status.Status = RecipientDuplicate
+				} else {
+					seen[key] = true
+					*dst = append(*dst, a)
+				}
+				r.Statuses = append(r.Statuses, status)
+			}
+		}
+	}
+
+	add("to", to, &r.To)
+	add("cc", cc, &r.Cc)
+	add("bcc", bcc, &r.Bcc)
+	return r
+}
+
+// Addresses lists every valid, unique recipient
+func (r *Recipients) Addresses() []string {
+	var list []string
+	for _, a := range slices.Concat(r.To, r.Cc, r.Bcc) {
+		list = append(list, a.Address)
+	}
+	return list
+}
+
+// Drop removes a recipient before sending, reporting it with status and reason
+func (r *Recipients) Drop(address string, status string, reason string) {
+	match := func(a *mail.Address) bool { return strings.EqualFold(a.Address, address) }
+	r.To = slices.DeleteFunc(r.To, match)
+	r.Cc = slices.DeleteFunc(r.Cc, match)
+	r.Bcc = slices.DeleteFunc(r.Bcc, match)
+	for i := range r.Statuses {
+		if s := &r.Statuses[i]; s.Status == RecipientQueued && strings.EqualFold(s.Address, address) {
+			s.Status, s.Error = status, reason
+		}
+	}
+}
+
+// SetResult records the outcome of sending to every valid recipient, sendErr may be a *RejectedRecipientsError
+// when only some of them were refused
+func (r *Recipients) SetResult(sendErr error) {
+	rejected := map[string]error{}
+	var e *RejectedRecipientsError
+	if errors.As(sendErr, &e) {
+		rejected = e.Rejected
+		sendErr = nil
+	}
+
+	for i := range r.Statuses {
+		s := &r.Statuses[i]
+		if s.Status != RecipientQueued {
+			continue
+		}
+		switch err, ok := rejected[strings.ToLower(s.Address)]; {
+		case ok:
+			s.Status, s.Error = RecipientRejected, err.Error()
+		case sendErr != nil:
+			s.Status, s.Error = RecipientFailed, sendErr.Error()
+		default:
+			s.Status = RecipientSent
+		}
+	}
+}
+
+// RejectedRecipientsError is returned when the email went out but some recipients were refused by the server
+type RejectedRecipientsError struct {
+	Rejected map[string]error // keyed by lower-cased address
+}
+
+func (e *RejectedRecipientsError) Error() string {
+	return fmt.Sprintf("%d recipients were rejected", len(e.Rejected))
+}
+
+// SentCount is the number of recipients the email went out to
+func SentCount(statuses []RecipientStatus) int {
+	n := 0
+	for _, s := range statuses {
+		if s.Status == RecipientSent {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestParseRecipients(t *testing.T) {
+	tests := []struct {
+		name     string
+		to       []string
+		cc       []string
+		bcc      []string
+		want     []string
+		statuses []RecipientStatus
+	}{
+		{
+			name:     "plain address",
+			to:       []string{"a@example.com"},
+			want:     []string{"a@example.com"},
+			statuses: []RecipientStatus{{Address: "a@example.com", Field: "to", Status: RecipientQueued}},
+		},
+		{
+			name:     "display name",
+			to:       []string{`"Doe, Jane" <jane@example.com>`},
+			want:     []string{"jane@example.com"},
+			statuses: []RecipientStatus{{Address: "jane@example.com", Name: "Doe, Jane", Field: "to", Status: RecipientQueued}},
+		},
+		{
+			name: "list in one entry",
+			to:   []string{"a@example.com, B <b@example.com>"},
+			want: []string{"a@example.com", "b@example.com"},
+			statuses: []RecipientStatus{
+				{Address: "a@example.com", Field: "to", Status: RecipientQueued},
+				{Address: "b@example.com", Name: "B", Field: "to", Status: RecipientQueued},
+			},
+		},
+		{
+			name: "blank entries are skipped",
+			to:   []string{"", "  ", "a@example.com"},
+			want: []string{"a@example.com"},
+			statuses: []RecipientStatus{
+				{Address: "a@example.com", Field: "to", Status: RecipientQueued},
+			},
+		},
+		{
+			name: "invalid entry does not fail the list",
+			to:   []string{"not an address", "a@example.com"},
+			want: []string{"a@example.com"},
+			statuses: []RecipientStatus{
+				{Address: "not an address", Field: "to", Status: RecipientInvalid, Error: "mail: no angle-addr"},
+				{Address: "a@example.com", Field: "to", Status: RecipientQueued},
+			},
+		},
+		{
+			name: "duplicates are dropped case-insensitively",
+			to:   []string{"a@example.com", "A@Example.com"},
+			want: []string{"a@example.com"},
+			statuses: []RecipientStatus{
+				{Address: "a@example.com", Field: "to", Status: RecipientQueued},
+				{Address: "A@Example.com", Field: "to", Status: RecipientDuplicate},
+			},
+		},
+		{
+			name: "an address is kept in the first field",
+			to:   []string{"b@example.com"},
+			cc:   []string{"a@example.com"},
+			bcc:  []string{"a@example.com", "b@example.com", "c@example.com"},
+			want: []string{"b@example.com", "a@example.com", "c@example.com"},
+			statuses: []RecipientStatus{
+				{Address: "b@example.com", Field: "to", Status: RecipientQueued},
+				{Address: "a@example.com", Field: "cc", Status: RecipientQueued},
+				{Address: "a@example.com", Field: "bcc", Status: RecipientDuplicate},
+				{Address: "b@example.com", Field: "bcc", Status: RecipientDuplicate},
+				{Address: "c@example.com", Field: "bcc", Status: RecipientQueued},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := ParseRecipients(tt.to, tt.cc, tt.bcc)
+			if got := r.Addresses(); !slices.Equal(got, tt.want) {
+				t.Errorf("got addresses %v, want %v", got, tt.want)
+			}
+			if r.Count() != len(tt.want) {
+				t.Errorf("got count %d, want %d", r.Count(), len(tt.want))
+			}
+			if !slices.Equal(r.Statuses, tt.statuses) {
+				t.Errorf("got statuses %+v, want %+v", r.Statuses, tt.statuses)
+			}
+		})
+	}
+}
+
+func TestRecipientsResult(t *testing.T) {
+	tests := []struct {
+		name    string
+		sendErr error
+		want    []string
+	}{
+		{"sent", nil, []string{RecipientSent, RecipientSuppressed, RecipientSent, RecipientDuplicate}},
+		{"failed", errors.New("connection refused"),
+			[]string{RecipientFailed, RecipientSuppressed, RecipientFailed, RecipientDuplicate}},
+		{"partly rejected", &RejectedRecipientsError{Rejected: map[string]error{"c@example.com": errors.New("550 no such user")}},
+			[]string{RecipientSent, RecipientSuppressed, RecipientRejected, RecipientDuplicate}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := ParseRecipients([]string{"a@example.com", "B@example.com"}, []string{"C@example.com", "a@example.com"}, nil)
+			r.Drop("b@example.com", RecipientSuppressed, "unsubscribed")
+			if got := r.Addresses(); !slices.Equal(got, []string{"a@example.com", "C@example.com"}) {
+				t.Fatalf("got addresses %v after dropping", got)
+			}
+
+			r.SetResult(tt.sendErr)
+			var got []string
+			for _, s := range r.Statuses {
+				got = append(got, s.Status)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got statuses %v, want %v", got, tt.want)
+			}
+			sent := len(slices.DeleteFunc(slices.Clone(tt.want), func(s string) bool { return s != RecipientSent }))
+			if n := SentCount(r.Statuses); n != sent {
+				t.Errorf("got SentCount %d, want %d", n, sent)
+			}
+		})
+	}
+}
@@ -2,27 +2,27 @@ package utils
 
 import (
 	"bytes"
+	_ "embed"
 	"html/template"
 )
 
-type EmailData struct {
-	DownloadLink string
-	LinkValidity string
-}
+//go:embed templates/preview.html
+var previewTemplateSource string
 
-func RenderEmailHTML(downloadLink, linkValidity string) (string, error) {
-	tmpl, err := template.ParseFiles("../internal/utils/templates/email_template.html")
-	if err != nil {
-		return "", err
-	}
+var previewTemplate = template.Must(template.New("preview").Parse(previewTemplateSource))
 
-	data := EmailData{
-		DownloadLink: downloadLink,
-		LinkValidity: linkValidity,
+// RenderPreviewHTML renders a syntax highlighted text or code file as a standalone page
+func RenderPreviewHTML(name string, src []byte) (string, error) {
+	data := struct {
+		Name string
+		Code template.HTML
+	}{
+		Name: name,
+		Code: HighlightCode(name, src),
 	}
 
 	var buf bytes.Buffer
-	if err := tmpl.Execute(&buf, data); err != nil {
+	if err := previewTemplate.Execute(&buf, data); err != nil {
 		return "", err
 	}
 
@@ -0,0 +1,15 @@
+package utils
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// retryBackoff doubles the wait after every failed attempt up to maxWait, with up to 20% jitter
+func retryBackoff(attempts int, base, maxWait time.Duration) time.Duration {
+	backoff := maxWait
+	if attempts < 20 {
+		backoff = min(base<<(attempts-1), maxWait)
+	}
+	return backoff + time.Duration(rand.Int64N(int64(backoff/5)+1))
+}
@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"database/sql"
+	"errors"
+	"fileTransfer/internal/config"
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/repository"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used")
+	ErrSessionRevoked      = errors.New("session was revoked")
+)
+
+// SessionTokens are issued on login and on every refresh
+type SessionTokens struct {
+	AccessToken  string
+	RefreshToken string
+	SessionId    string
+}
+
+// SessionService issues short-lived access tokens and rotating refresh tokens. Revoked logins are kept in an
+// in-memory deny list, so checking an access token needs no database round trip.
+type SessionService struct {
+	repo  repository.RefreshTokenDbRepo
+	users repository.UserDbRepo
+	jwt   *JWTService
+	cfg   config.SessionConfig
+
+	mu      sync.RWMutex
+	revoked map[string]time.Time // session id to when it was revoked
+}
+
+func NewSessionService(repo repository.RefreshTokenDbRepo, users repository.UserDbRepo, jwt *JWTService,
+	cfg config.SessionConfig) *SessionService {
+	return &SessionService{repo: repo, users: users, jwt: jwt, cfg: cfg, revoked: make(map[string]time.Time)}
+}
+
+// Start begins a new login for the user
+func (s *SessionService) Start(user *models.GoogleUser) (*SessionTokens, error) {
+	return s.issue(user, uuid.New().String())
+}
+
+func (s *SessionService) issue(user *models.GoogleUser, sessionId string) (*SessionTokens, error) {
+	refreshToken, hash, err := NewToken()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	err = s.repo.AddRefreshToken(models.NewRefreshToken(uuid.New().String(), sessionId, user.ID, hash, now,
+		now.Add(s.cfg.RefreshTTL)))
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := s.jwt.GenerateToken(user.Email, sessionId, s.cfg.AccessTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &SessionTokens{AccessToken: accessToken, RefreshToken: refreshToken, SessionId: sessionId}, nil
+}
+
+// Refresh uses up a refresh token and issues the next pair. Presenting a token that was already used revokes its
+// whole family, since either the user or whoever stole the token is now holding a dead one.
+func (s *SessionService) Refresh(refreshToken string) (*SessionTokens, error) {
+	token, err := s.repo.GetRefreshTokenByHash(HashToken(refreshToken))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if token.RevokedAt != nil {
+		return nil, ErrSessionRevoked
+	}
+	if now.After(token.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	used, err := s.repo.UseRefreshToken(token.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	if !used {
+		log.Printf("Refresh token reuse in session %s of user %s, revoking the session", token.FamilyId, token.UserId)
+		if err := s.Revoke(token.FamilyId); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	user, err := s.users.FindUserByID(token.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled {
+		return nil, ErrSessionRevoked
+	}
+	return s.issue(user, token.FamilyId)
+}
+
+// SessionOf returns the session a refresh token belongs to, used or not
+func (s *SessionService) SessionOf(refreshToken string) (string, error) {
+	token, err := s.repo.GetRefreshTokenByHash(HashToken(refreshToken))
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return "", err
+	}
+	return token.FamilyId, nil
+}
+
+// Revoke ends a login, its access tokens are denied right away on this instance
+func (s *SessionService) Revoke(sessionId string) error {
+	now := time.Now().UTC()
+	if err := s.repo.RevokeFamily(sessionId, now); err != nil {
+		return err
+	}
+	s.deny(now, sessionId)
+	return nil
+}
+
+// RevokeUser ends every login of a user
+func (s *SessionService) RevokeUser(userId string) error {
+	now := time.Now().UTC()
+	sessions, err := s.repo.RevokeUserFamilies(userId, now)
+	if err != nil {
+		return err
+	}
+	s.deny(now, sessions...)
+	return nil
+}
+
+func (s *SessionService) deny(at time.Time, sessionIds ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range sessionIds {
+		s.revoked[id] = at
+	}
+}
+
+// IsRevoked checks the deny list
+func (s *SessionService) IsRevoked(sessionId string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.revoked[sessionId]
+	return ok
+}
+
+// ReloadDenyList picks up sessions revoked by other instances. Only sessions revoked within an access token
+// lifetime are kept, older ones have no access tokens left that could still be valid.
+func (s *SessionService) ReloadDenyList() {
+	since := time.Now().UTC().Add(-s.cfg.AccessTTL)
+	sessions, err := s.repo.GetRevokedFamilies(since)
+	if err != nil {
+		log.Printf("Failed to load revoked sessions: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	for id, at := range s.revoked {
+		if at.Before(since) {
+			delete(s.revoked, id)
+		}
+	}
+	s.mu.Unlock()
+	s.deny(time.Now().UTC(), sessions...)
+}
+
+// DeleteExpired forgets refresh tokens that expired a while ago
+func (s *SessionService) DeleteExpired() {
+	n, err := s.repo.DeleteExpiredRefreshTokens(time.Now().UTC().Add(-24 * time.Hour))
+	if err != nil {
+		log.Printf("Failed to delete expired refresh tokens: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("Deleted %d expired refresh tokens", n)
+	}
+}
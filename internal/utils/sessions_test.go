@@ -0,0 +1,250 @@
+package utils
+
+import (
+	"database/sql"
+	"errors"
+	"fileTransfer/internal/config"
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/repository"
+	"testing"
+	"time"
+)
+
+// memRefreshTokens is an in-memory RefreshTokenDbRepo
+type memRefreshTokens struct {
+	tokens []*models.RefreshToken
+}
+
+func (m *memRefreshTokens) AddRefreshToken(token *models.RefreshToken) error {
+	m.tokens = append(m.tokens, token)
+	return nil
+}
+
+func (m *memRefreshTokens) GetRefreshTokenByHash(hash string) (*models.RefreshToken, error) {
+	for _, t := range m.tokens {
+		if t.TokenHash == hash {
+			copied := *t
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *memRefreshTokens) UseRefreshToken(id string, at time.Time) (bool, error) {
+	for _, t := range m.tokens {
+		if t.ID == id && t.UsedAt == nil && t.RevokedAt == nil {
+			t.UsedAt = &at
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *memRefreshTokens) RevokeFamily(familyId string, at time.Time) error {
+	for _, t := range m.tokens {
+		if t.FamilyId == familyId && t.RevokedAt == nil {
+			t.RevokedAt = &at
+		}
+	}
+	return nil
+}
+
+func (m *memRefreshTokens) RevokeUserFamilies(userId string, at time.Time) ([]string, error) {
+	var families []string
+	for _, t := range m.tokens {
+		if t.UserId == userId && t.RevokedAt == nil {
+			t.RevokedAt = &at
+			families = append(families, t.FamilyId)
+		}
+	}
+	return families, nil
+}
+
+func (m *memRefreshTokens) GetRevokedFamilies(since time.Time) ([]string, error) {
+	var families []string
+	for _, t := range m.tokens {
+		if t.RevokedAt != nil && !t.RevokedAt.Before(since) {
+			families = append(families, t.FamilyId)
+		}
+	}
+	return families, nil
+}
+
+func (m *memRefreshTokens) DeleteExpiredRefreshTokens(before time.Time) (int64, error) {
+	return 0, nil
+}
+
+// sessionUsers finds a single user by id, every other method is unused
+type sessionUsers struct {
+	repository.UserDbRepo
+	user *models.GoogleUser
+}
+
+func (u *sessionUsers) FindUserByID(id string) (*models.GoogleUser, error) {
+	if id != u.user.ID {
+		return nil, sql.ErrNoRows
+	}
+	return u.user, nil
+}
+
+func newTestSessions(t *testing.T) (*SessionService, *memRefreshTokens, *models.GoogleUser) {
+	t.Helper()
+	tokens := &memRefreshTokens{}
+	user := &models.GoogleUser{ID: "user", Email: "user@example.com"}
+	cfg := config.SessionConfig{AccessTTL: time.Minute, RefreshTTL: time.Hour}
+	return NewSessionService(tokens, &sessionUsers{user: user}, newTestJWTService(t, ed25519Key(t)), cfg), tokens, user
+}
+
+func TestSessionRefresh(t *testing.T) {
+	tests := []struct {
+		name string
+		// prepare returns the refresh token to present, after a login and whatever else the case needs
+		prepare func(t *testing.T, s *SessionService, tokens *memRefreshTokens, user *models.GoogleUser, login *SessionTokens) string
+		wantErr error
+		// wantRevoked is whether the login's session ends up on the deny list
+		wantRevoked bool
+	}{
+		{
+			name: "rotates",
+			prepare: func(t *testing.T, s *SessionService, _ *memRefreshTokens, _ *models.GoogleUser, login *SessionTokens) string {
+				return login.RefreshToken
+			},
+		},
+		{
+			name: "rotated token",
+			prepare: func(t *testing.T, s *SessionService, _ *memRefreshTokens, _ *models.GoogleUser, login *SessionTokens) string {
+				next, err := s.Refresh(login.RefreshToken)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return next.RefreshToken
+			},
+		},
+		{
+			name: "reused token revokes the session",
+			prepare: func(t *testing.T, s *SessionService, _ *memRefreshTokens, _ *models.GoogleUser, login *SessionTokens) string {
+				if _, err := s.Refresh(login.RefreshToken); err != nil {
+					t.Fatal(err)
+				}
+				return login.RefreshToken
+			},
+			wantErr:     ErrRefreshTokenReused,
+			wantRevoked: true,
+		},
+		{
+			name: "unknown token",
+			prepare: func(t *testing.T, s *SessionService, _ *memRefreshTokens, _ *models.GoogleUser, _ *SessionTokens) string {
+				return "unknown"
+			},
+			wantErr: ErrInvalidRefreshToken,
+		},
+		{
+			name: "expired token",
+			prepare: func(t *testing.T, s *SessionService, tokens *memRefreshTokens, _ *models.GoogleUser, login *SessionTokens) string {
+				tokens.tokens[0].ExpiresAt = time.Now().UTC().Add(-time.Minute)
+				return login.RefreshToken
+			},
+			wantErr: ErrInvalidRefreshToken,
+		},
+		{
+			name: "revoked session",
+			prepare: func(t *testing.T, s *SessionService, _ *memRefreshTokens, _ *models.GoogleUser, login *SessionTokens) string {
+				if err := s.Revoke(login.SessionId); err != nil {
+					t.Fatal(err)
+				}
+				return login.RefreshToken
+			},
+			wantErr:     ErrSessionRevoked,
+			wantRevoked: true,
+		},
+		{
+			name: "disabled user",
+			prepare: func(t *testing.T, s *SessionService, _ *memRefreshTokens, user *models.GoogleUser, login *SessionTokens) string {
+				user.Disabled = true
+				return login.RefreshToken
+			},
+			wantErr: ErrSessionRevoked,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, tokens, user := newTestSessions(t)
+			login, err := s.Start(user)
+			if err != nil {
+				t.Fatalf("Start: %v", err)
+			}
+
+			next, err := s.Refresh(tt.prepare(t, s, tokens, user, login))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if s.IsRevoked(login.SessionId) != tt.wantRevoked {
+				t.Errorf("session revoked: %v, want %v", s.IsRevoked(login.SessionId), tt.wantRevoked)
+			}
+			if err != nil {
+				return
+			}
+			if next.SessionId != login.SessionId {
+				t.Errorf("refresh started session %s, want %s", next.SessionId, login.SessionId)
+			}
+			if next.RefreshToken == login.RefreshToken {
+				t.Error("refresh token was not rotated")
+			}
+			claims, err := s.jwt.ParseToken(next.AccessToken)
+			if err != nil {
+				t.Fatalf("access token: %v", err)
+			}
+			if claims.SessionId != login.SessionId || claims.Email != user.Email {
+				t.Errorf("got claims %+v", claims)
+			}
+		})
+	}
+}
+
+// A reused token revokes the whole family, so the thief's rotated token is dead as well
+func TestSessionReuseRevokesFamily(t *testing.T) {
+	s, _, user := newTestSessions(t)
+	login, err := s.Start(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := s.Start(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stolen, err := s.Refresh(login.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Refresh(login.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("got %v, want %v", err, ErrRefreshTokenReused)
+	}
+	if _, err := s.Refresh(stolen.RefreshToken); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("rotated token: got %v, want %v", err, ErrSessionRevoked)
+	}
+	if _, err := s.Refresh(other.RefreshToken); err != nil {
+		t.Errorf("another login of the user was revoked: %v", err)
+	}
+}
+
+// Sessions revoked on another instance are denied once the deny list is reloaded
+func TestReloadDenyList(t *testing.T) {
+	s, tokens, user := newTestSessions(t)
+	login, err := s.Start(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := NewSessionService(tokens, &sessionUsers{user: user}, s.jwt, s.cfg)
+	if err := other.Revoke(login.SessionId); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.IsRevoked(login.SessionId) {
+		t.Fatal("revoked before reloading")
+	}
+	s.ReloadDenyList()
+	if !s.IsRevoked(login.SessionId) {
+		t.Error("not revoked after reloading")
+	}
+}
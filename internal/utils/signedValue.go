@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrInvalidSealedValue = errors.New("invalid or expired value")
+
+type sealedValue struct {
+	ExpiresAt int64           `json:"exp"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// SealValue encodes v with an expiry and signs it, for values such as cookies that the client holds
+// but must not be able to forge or use after ttl
+func SealValue(secret []byte, v any, ttl time.Duration) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(sealedValue{ExpiresAt: time.Now().Add(ttl).Unix(), Data: data})
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(signValue(secret, encoded)), nil
+}
+
+// OpenValue checks the signature and expiry of a sealed value and decodes it into v
+func OpenValue(secret []byte, sealed string, v any) error {
+	encoded, sig, ok := strings.Cut(sealed, ".")
+	if !ok {
+		return ErrInvalidSealedValue
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, signValue(secret, encoded)) {
+		return ErrInvalidSealedValue
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ErrInvalidSealedValue
+	}
+	var sv sealedValue
+	if err := json.Unmarshal(payload, &sv); err != nil || time.Now().Unix() > sv.ExpiresAt {
+		return ErrInvalidSealedValue
+	}
+	return json.Unmarshal(sv.Data, v)
+}
+
+func signValue(secret []byte, encoded string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(encoded))
+	return h.Sum(nil)
+}
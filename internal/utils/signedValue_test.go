@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type sealedFlow struct {
+	State string `json:"state"`
+	Nonce string `json:"nonce"`
+}
+
+func TestSealValue(t *testing.T) {
+	secret := []byte("cookie secret")
+	flow := sealedFlow{State: "state", Nonce: "nonce"}
+	seal := func(secret []byte, v any, ttl time.Duration) string {
+		sealed, err := SealValue(secret, v, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sealed
+	}
+	sealed := seal(secret, flow, time.Minute)
+	payload, sig, _ := strings.Cut(sealed, ".")
+	other, _, _ := strings.Cut(seal(secret, sealedFlow{State: "other"}, time.Minute), ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"exp":9999999999,"data":{"state":"state"}}`))
+
+	tests := []struct {
+		name    string
+		sealed  string
+		secret  []byte
+		wantErr bool
+	}{
+		{"valid", sealed, secret, false},
+		{"another secret", sealed, []byte("other secret"), true},
+		{"expired", seal(secret, flow, -time.Second), secret, true},
+		{"payload of another value", other + "." + sig, secret, true},
+		{"forged payload", forged + "." + sig, secret, true},
+		{"no signature", payload, secret, true},
+		{"empty signature", payload + ".", secret, true},
+		{"signature not base64", payload + ".!!!", secret, true},
+		{"empty", "", secret, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got sealedFlow
+			err := OpenValue(tt.secret, tt.sealed, &got)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidSealedValue) {
+					t.Errorf("got %v, want %v", err, ErrInvalidSealedValue)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("OpenValue: %v", err)
+			}
+			if got != flow {
+				t.Errorf("got %+v, want %+v", got, flow)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/repository"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	ThumbnailStatusPending     = "pending"
+	ThumbnailStatusDone        = "done"
+	ThumbnailStatusUnsupported = "unsupported"
+	ThumbnailStatusFailed      = "failed"
+
+	thumbnailMaxSide      = 256
+	thumbnailMaxSource    = 50 * 1024 * 1024
+	thumbnailMaxMegapixel = 50 * 1000 * 1000
+)
+
+var errNoPreview = errors.New("no preview available")
+
+var thumbnailExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".pdf": true,
+}
+
+// ThumbnailKey is the storage key of a file's thumbnail, stored next to the file itself
+func ThumbnailKey(s3Key string) string {
+	return s3Key + ".thumb.jpg"
+}
+
+// GenerateThumbnails creates thumbnails for a batch of files that do not have one yet
+func (a *AwsS3) GenerateThumbnails(repo repository.FileDbRepo, batchSize int) {
+	files, err := repo.GetFilesPendingThumbnail(batchSize)
+	if err != nil {
+		log.Printf("Failed to get files pending thumbnails: %v", err)
+		return
+	}
+
+	for _, file := range files {
+		status, key := a.generateThumbnail(&file)
+		if err := repo.SetThumbnail(file.ID, key, status); err != nil {
+			log.Printf("Failed to save thumbnail for %s: %v", file.S3Key, err)
+		}
+	}
+}
+
+func (a *AwsS3) generateThumbnail(file *models.File) (status string, key string) {
+	ext := strings.ToLower(filepath.Ext(file.Name))
+	if file.Encrypted || !thumbnailExtensions[ext] || file.Size > thumbnailMaxSource {
+		return ThumbnailStatusUnsupported, ""
+	}
+
+	resp, err := a.DownloadFile(file.S3Key)
+	if err != nil {
+		log.Printf("Thumbnail: failed to read %s: %v", file.S3Key, err)
+		return ThumbnailStatusFailed, ""
+	}
+	src, err := io.ReadAll(io.LimitReader(resp.Body, thumbnailMaxSource))
+	resp.Body.Close()
+	if err != nil {
+		log.Printf("Thumbnail: failed to read %s: %v", file.S3Key, err)
+		return ThumbnailStatusFailed, ""
+	}
+
+	// PDFs are previewed by their first embedded JPEG, those without one that decodes get no preview
+	if ext == ".pdf" {
+		src, err = firstEmbeddedJPEG(src)
+		if err != nil {
+			return ThumbnailStatusUnsupported, ""
+		}
+	}
+
+	thumb, err := MakeThumbnail(bytes.NewReader(src))
+	if err != nil && ext == ".pdf" {
+		return ThumbnailStatusUnsupported, ""
+	}
+	if err != nil {
+		log.Printf("Thumbnail: failed to render %s: %v", file.S3Key, err)
+		return ThumbnailStatusFailed, ""
+	}
+
+	key = ThumbnailKey(file.S3Key)
+	if _, err := a.UploadObject(key, bytes.NewReader(thumb), "image/jpeg"); err != nil {
+		log.Printf("Thumbnail: failed to store %s: %v", key, err)
+		return ThumbnailStatusFailed, ""
+	}
+
+	return ThumbnailStatusDone, key
+}
+
+// MakeThumbnail decodes a JPEG, PNG, GIF or WebP image and returns a JPEG scaled to fit 256x256
+func MakeThumbnail(r io.ReadSeeker) ([]byte, error) {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > thumbnailMaxMegapixel {
+		return nil, fmt.Errorf("image dimensions %dx%d not supported", cfg.Width, cfg.Height)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > thumbnailMaxSide || h > thumbnailMaxSide {
+		if w >= h {
+			w, h = thumbnailMaxSide, max(1, h*thumbnailMaxSide/b.Dx())
+		} else {
+			w, h = max(1, w*thumbnailMaxSide/b.Dy()), thumbnailMaxSide
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	// Transparent areas become white rather than black in the JPEG
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// firstEmbeddedJPEG returns the first JPEG stream in a PDF, which may not be page one. There is no pure-Go PDF
+// renderer, but scanned documents store each page as a JPEG, so there it usually is; otherwise errNoPreview.
+func firstEmbeddedJPEG(pdf []byte) ([]byte, error) {
+	rest := pdf
+	for {
+		i := bytes.Index(rest, []byte("/DCTDecode"))
+		if i < 0 {
+			return nil, errNoPreview
+		}
+		rest = rest[i:]
+
+		// The stream keyword must follow the dictionary the filter belongs to
+		end := bytes.Index(rest, []byte(">>"))
+		start := bytes.Index(rest, []byte("stream"))
+		if end < 0 || start < 0 || start < end {
+			rest = rest[len("/DCTDecode"):]
+			continue
+		}
+		data := rest[start+len("stream"):]
+		data = bytes.TrimPrefix(data, []byte("\r"))
+		data = bytes.TrimPrefix(data, []byte("\n"))
+
+		stop := bytes.Index(data, []byte("endstream"))
+		if stop < 0 {
+			return nil, errNoPreview
+		}
+		img := bytes.TrimRight(data[:stop], "\r\n")
+		if bytes.HasPrefix(img, []byte{0xFF, 0xD8}) {
+			return img, nil
+		}
+		rest = data[stop:]
+	}
+}
@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func encodePNG(t *testing.T, w, h int, fill color.Color) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, fill)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeJPEG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestMakeThumbnail(t *testing.T) {
+	tests := []struct {
+		name          string
+		src           []byte
+		width, height int
+		wantErr       bool
+	}{
+		{"wide image", encodePNG(t, 1024, 512, color.Black), 256, 128, false},
+		{"tall image", encodePNG(t, 300, 600, color.Black), 128, 256, false},
+		{"small image keeps its size", encodePNG(t, 40, 20, color.Black), 40, 20, false},
+		{"thin image keeps a pixel", encodePNG(t, 2000, 1, color.Black), 256, 1, false},
+		{"jpeg", encodeJPEG(t, 512, 512), 256, 256, false},
+		{"not an image", []byte("%PDF-1.4"), 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			thumb, err := MakeThumbnail(bytes.NewReader(tt.src))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("MakeThumbnail: %v", err)
+			}
+			img, err := jpeg.Decode(bytes.NewReader(thumb))
+			if err != nil {
+				t.Fatalf("thumbnail is not a JPEG: %v", err)
+			}
+			if b := img.Bounds(); b.Dx() != tt.width || b.Dy() != tt.height {
+				t.Errorf("got %dx%d, want %dx%d", b.Dx(), b.Dy(), tt.width, tt.height)
+			}
+		})
+	}
+}
+
+func TestMakeThumbnailTransparentIsWhite(t *testing.T) {
+	thumb, err := MakeThumbnail(bytes.NewReader(encodePNG(t, 64, 64, color.Transparent)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(thumb))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, g, b, _ := img.At(32, 32).RGBA(); r < 0xf000 || g < 0xf000 || b < 0xf000 {
+		t.Errorf("transparent pixel became %v, want white", img.At(32, 32))
+	}
+}
+
+func TestFirstEmbeddedJPEG(t *testing.T) {
+	photo := encodeJPEG(t, 8, 8)
+	object := func(filter string, data []byte) string {
+		return "<< /Type /XObject /Subtype /Image /Filter " + filter + " >>\nstream\r\n" + string(data) + "\r\nendstream\n"
+	}
+
+	tests := []struct {
+		name    string
+		pdf     string
+		want    []byte
+		wantErr error
+	}{
+		{"embedded JPEG", "%PDF-1.4\n" + object("/FlateDecode", []byte("text")) + object("/DCTDecode", photo), photo, nil},
+		{"DCTDecode stream that is not a JPEG is skipped", "%PDF-1.4\n" + object("/DCTDecode", []byte("junk")) +
+			object("/DCTDecode", photo), photo, nil},
+		{"no images", "%PDF-1.4\n" + object("/FlateDecode", []byte("text")), nil, errNoPreview},
+		{"filter outside a dictionary", "%PDF-1.4\n/DCTDecode stream\n" + string(photo), nil, errNoPreview},
+		{"stream without an end", "%PDF-1.4\n<< /Filter /DCTDecode >>\nstream\n" + string(photo), nil, errNoPreview},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := firstEmbeddedJPEG([]byte(tt.pdf))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("got %d bytes, want the %d byte JPEG", len(got), len(tt.want))
+			}
+		})
+	}
+}
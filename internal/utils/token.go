@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fileTransfer/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// NewToken returns a random URL-safe token along with the hash to store in place of it
+func NewToken() (token string, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the hex SHA-256 of a token, used to look tokens up without storing them
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewApiToken creates an API token for a user, returning the token, which is only shown once, and the record to store
+func NewApiToken(userId string, name string, scopes []string, expiresAt *time.Time) (string, *models.ApiToken, error) {
+	secret, _, err := NewToken()
+	if err != nil {
+		return "", nil, err
+	}
+	token := models.ApiTokenPrefix + secret
+	apiToken := models.NewApiToken(uuid.New().String(), userId, name, token[:len(models.ApiTokenPrefix)+8],
+		HashToken(token), scopes, time.Now().UTC(), expiresAt)
+	return token, apiToken, nil
+}
@@ -0,0 +1,223 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fileTransfer/internal/models"
+	"fileTransfer/internal/repository"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	webhookTimeout      = 10 * time.Second
+	webhookLease        = 2 * time.Minute
+	webhookMaxAttempts  = 10
+	webhookBaseBackoff  = 30 * time.Second
+	webhookMaxBackoff   = 6 * time.Hour
+	webhookMaxErrorText = 1000
+)
+
+var errPrivateAddress = errors.New("webhook target resolves to a private address")
+
+// WebhookService writes lifecycle events to the webhook outbox and delivers them
+type WebhookService struct {
+	repo   repository.WebhookDbRepo
+	client *http.Client
+}
+
+// NewWebhookService returns a service whose HTTP client refuses to connect to private addresses unless allowPrivate is set
+func NewWebhookService(repo repository.WebhookDbRepo, allowPrivate bool) *WebhookService {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() ||
+				ip.IsLinkLocalMulticast() {
+				return errPrivateAddress
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &WebhookService{
+		repo: repo,
+		client: &http.Client{
+			Timeout:   webhookTimeout,
+			Transport: transport,
+			// Redirects could point the signed payload somewhere else
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}
+}
+
+type webhookPayload struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"createdAt"`
+	Data      any       `json:"data"`
+}
+
+// Publish queues an event for every endpoint of the user, and every global endpoint, subscribed to it
+func (w *WebhookService) Publish(eventType string, userId string, data any) {
+	endpoints, err := w.repo.GetSubscribedEndpoints(userId, eventType)
+	if err != nil {
+		log.Printf("Failed to find webhook endpoints for %s: %v", eventType, err)
+		return
+	}
+	if len(endpoints) == 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+	payload := webhookPayload{ID: uuid.New().String(), Type: eventType, CreatedAt: now, Data: data}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Failed to encode webhook payload for %s: %v", eventType, err)
+		return
+	}
+
+	for _, endpoint := range endpoints {
+		delivery := models.NewWebhookDelivery(uuid.New().String(), endpoint.ID, payload.ID, eventType, string(body), now)
+		if err := w.repo.AddDelivery(delivery); err != nil {
+			log.Printf("Failed to queue webhook delivery: %v", err)
+		}
+	}
+}
+
+// FileEventData is the data of file.* events, encrypted files never expose more than their key and size
+func FileEventData(file *models.File) map[string]any {
+	data := map[string]any{
+		"id":         file.ID,
+		"key":        file.S3Key,
+		"name":       FileDisplayName(file.Name, file.Encrypted),
+		"size":       file.Size,
+		"encrypted":  file.Encrypted,
+		"expiresAt":  file.ExpirationDate,
+		"uploadedAt": file.UploadedAt,
+	}
+	if file.Checksum != "" {
+		data["checksum_sha256"] = file.Checksum
+	}
+	return data
+}
+
+// DeliverPending sends a batch of due deliveries from the outbox
+func (w *WebhookService) DeliverPending(batchSize int) {
+	now := time.Now().UTC()
+	deliveries, err := w.repo.GetDueDeliveries(now, batchSize)
+	if err != nil {
+		log.Printf("Failed to get due webhook deliveries: %v", err)
+		return
+	}
+
+	for _, delivery := range deliveries {
+		claimed, err := w.repo.ClaimDelivery(delivery.ID, now, now.Add(webhookLease))
+		if err != nil {
+			log.Printf("Failed to claim webhook delivery: %v", err)
+			continue
+		}
+		if claimed {
+			w.deliver(&delivery)
+		}
+	}
+}
+
+func (w *WebhookService) deliver(delivery *models.WebhookDelivery) {
+	endpoint, err := w.repo.GetEndpoint(delivery.EndpointId)
+	if err != nil {
+		// The endpoint was deleted, nothing left to deliver to
+		w.record(delivery, &models.WebhookAttempt{Error: "endpoint no longer exists"}, models.DeliveryFailed)
+		return
+	}
+
+	attempt := &models.WebhookAttempt{}
+	start := time.Now()
+	statusCode, err := w.send(endpoint, delivery)
+	attempt.DurationMs = time.Since(start).Milliseconds()
+	attempt.StatusCode = statusCode
+
+	status := models.DeliveryDelivered
+	if err == nil && (statusCode < 200 || statusCode >= 300) {
+		err = fmt.Errorf("endpoint responded with %d", statusCode)
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+		if len(attempt.Error) > webhookMaxErrorText {
+			attempt.Error = attempt.Error[:webhookMaxErrorText]
+		}
+		status = models.DeliveryPending
+		if delivery.Attempts+1 >= webhookMaxAttempts {
+			status = models.DeliveryFailed
+		}
+	}
+
+	w.record(delivery, attempt, status)
+}
+
+func (w *WebhookService) record(delivery *models.WebhookDelivery, attempt *models.WebhookAttempt, status string) {
+	attempt.ID = uuid.New().String()
+	attempt.DeliveryId = delivery.ID
+	attempt.AttemptedAt = time.Now().UTC()
+
+	next := attempt.AttemptedAt
+	if status == models.DeliveryPending {
+		next = next.Add(retryBackoff(delivery.Attempts+1, webhookBaseBackoff, webhookMaxBackoff))
+	}
+	if err := w.repo.RecordAttempt(attempt, status, next); err != nil {
+		log.Printf("Failed to record webhook attempt: %v", err)
+	}
+}
+
+func (w *WebhookService) send(endpoint *models.WebhookEndpoint, delivery *models.WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, endpoint.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "fileTransfer-Webhooks/1.0")
+	req.Header.Set("X-Webhook-Id", delivery.EventId)
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", SignWebhook(endpoint.Secret, timestamp, []byte(delivery.Payload)))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	return resp.StatusCode, nil
+}
+
+// SignWebhook returns "sha256=<hex HMAC-SHA256 of timestamp.body>", receivers should also reject stale timestamps
+func SignWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
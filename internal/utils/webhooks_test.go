@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"errors"
+	"fileTransfer/internal/models"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSignWebhook(t *testing.T) {
+	const body = `{"event":"file.uploaded"}`
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      string
+		want      string
+	}{
+		{"payload", "whsec_test", "1700000000", body,
+			"sha256=8ded1c616fa9aa2f0d2054591d3d7f0cfe933a8586a39d9923833a431b3345c9"},
+		{"empty body", "whsec_test", "1700000000", "",
+			"sha256=5967f3c560522fa40cf2876ebc3c3a08551dd6959aaade3b413460591895bdcc"},
+		{"timestamp is signed", "whsec_test", "1700000001", body,
+			"sha256=66e7298475adb92944c8e6796fca9f6ef8ab9ab0ed81daf0c6976257fea4458e"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SignWebhook(tt.secret, tt.timestamp, []byte(tt.body)); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if SignWebhook("other", "1700000000", []byte(body)) == tests[0].want {
+		t.Error("the signature does not depend on the secret")
+	}
+}
+
+func TestWebhookSend(t *testing.T) {
+	var got *http.Request
+	var gotBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	endpoint := &models.WebhookEndpoint{URL: server.URL, Secret: "whsec_test"}
+	delivery := &models.WebhookDelivery{EventId: "event", EventType: models.EventFileUploaded, Payload: `{"id":"event"}`}
+
+	status, err := NewWebhookService(nil, true).send(endpoint, delivery)
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if status != http.StatusAccepted {
+		t.Errorf("got status %d", status)
+	}
+	if string(gotBody) != delivery.Payload {
+		t.Errorf("got body %s", gotBody)
+	}
+	timestamp := got.Header.Get("X-Webhook-Timestamp")
+	if want := SignWebhook("whsec_test", timestamp, gotBody); got.Header.Get("X-Webhook-Signature") != want {
+		t.Errorf("got signature %s, want %s", got.Header.Get("X-Webhook-Signature"), want)
+	}
+	if got.Header.Get("X-Webhook-Id") != "event" || got.Header.Get("X-Webhook-Event") != models.EventFileUploaded {
+		t.Errorf("got headers %v", got.Header)
+	}
+
+	// The test server listens on loopback, which webhooks may not reach by default
+	if _, err := NewWebhookService(nil, false).send(endpoint, delivery); !errors.Is(err, errPrivateAddress) {
+		t.Errorf("got %v, want %v", err, errPrivateAddress)
+	}
+}